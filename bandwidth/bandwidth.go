@@ -0,0 +1,107 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+// Package bandwidth limits the rate of data transfers
+package bandwidth
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// maxChunk is the most we read at once, so a limited transfer is smooth
+const maxChunk = 32 * 1024
+
+// Limiter is a token bucket limiting the bytes per second read through its
+// readers (in total). A nil Limiter is unlimited.
+type Limiter struct {
+	mtx    sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter returns a Limiter for bytesPerSecond, or nil (unlimited) if it
+// isn't positive
+func NewLimiter(bytesPerSecond int64) *Limiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	rate := float64(bytesPerSecond)
+	// Allow bursts of up to a tenth of a second
+	burst := rate / 10
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// chunk returns how much to read at once
+func (l *Limiter) chunk() int {
+	n := int(l.burst)
+	if n > maxChunk {
+		n = maxChunk
+	}
+	return n
+}
+
+// wait takes n tokens, blocking until they're available
+func (l *Limiter) wait(n int) {
+	l.mtx.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mtx.Unlock()
+	time.Sleep(delay)
+}
+
+// Reader returns r limited by the Limiter, or r if the Limiter is nil
+func (l *Limiter) Reader(r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &reader{Reader: r, limiter: l}
+}
+
+// ReadSeeker returns rs limited by the Limiter, or rs if the Limiter is nil
+func (l *Limiter) ReadSeeker(rs io.ReadSeeker) io.ReadSeeker {
+	if l == nil {
+		return rs
+	}
+	return &readSeeker{reader: reader{Reader: rs, limiter: l}, seeker: rs}
+}
+
+type reader struct {
+	io.Reader
+	limiter *Limiter
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if chunk := r.limiter.chunk(); len(p) > chunk {
+		p = p[:chunk]
+	}
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		r.limiter.wait(n)
+	}
+	return n, err
+}
+
+type readSeeker struct {
+	reader
+	seeker io.Seeker
+}
+
+func (r *readSeeker) Seek(offset int64, whence int) (int64, error) {
+	return r.seeker.Seek(offset, whence)
+}
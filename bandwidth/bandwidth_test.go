@@ -0,0 +1,51 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package bandwidth
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiter(t *testing.T) {
+	const rate = 1024 * 1024
+	const size = 384 * 1024
+	limiter := NewLimiter(rate)
+
+	start := time.Now()
+	n, err := io.Copy(io.Discard, limiter.Reader(bytes.NewReader(make([]byte, size))))
+	elapsed := time.Since(start)
+	require.NoError(t, err)
+	require.EqualValues(t, size, n)
+
+	// The first burst (a tenth of a second) is free
+	expected := time.Duration(size-rate/10) * time.Second / rate
+	assert.GreaterOrEqual(t, elapsed, expected*9/10)
+	assert.Less(t, elapsed, expected*3)
+}
+
+func TestLimiterReadSeeker(t *testing.T) {
+	limiter := NewLimiter(1024 * 1024)
+	rs := limiter.ReadSeeker(bytes.NewReader([]byte("data")))
+	data, err := io.ReadAll(rs)
+	require.NoError(t, err)
+	assert.Equal(t, "data", string(data))
+	_, err = rs.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	data, err = io.ReadAll(rs)
+	require.NoError(t, err)
+	assert.Equal(t, "data", string(data))
+}
+
+func TestLimiterUnlimited(t *testing.T) {
+	limiter := NewLimiter(0)
+	assert.Nil(t, limiter)
+	r := bytes.NewReader(nil)
+	assert.Equal(t, io.Reader(r), limiter.Reader(r))
+}
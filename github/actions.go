@@ -5,6 +5,7 @@ package github
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -14,10 +15,14 @@ import (
 	"os"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
+
+	"golang.org/x/sync/errgroup"
 )
 
-// CreateRelease creates a release for a tag
+// CreateRelease creates a release for a tag. If Github rejects it, the error
+// is an *ErrValidation with the reasons (see IsAlreadyExists).
 func CreateRelease(token string, repo string, tag string, name string) error {
 	params := ReleaseCreate{
 		TagName: tag,
@@ -39,15 +44,59 @@ func CreateRelease(token string, repo string, tag string, name string) error {
 		return fmt.Errorf("while submitting %v, %v", string(payload), err)
 	}
 	if resp.StatusCode != http.StatusCreated {
-		if resp.StatusCode == 422 {
-			return fmt.Errorf("github returned %v (this is probably because the release already exists)",
-				resp.Status)
+		if resp.StatusCode == http.StatusUnprocessableEntity {
+			return validationError(resp)
 		}
 		return fmt.Errorf("github returned %v", resp.Status)
 	}
 	return nil
 }
 
+// DeleteRelease deletes a release (but not its tag) by ID
+func DeleteRelease(token string, repo string, id int) error {
+	uri := fmt.Sprintf("/repos/keybase/%s/releases/%d", repo, id)
+	resp, err := DoAuthRequest("DELETE", githubAPIURL+uri, "", token, nil, nil)
+	if resp != nil {
+		defer func() { _ = resp.Body.Close() }()
+	}
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("github returned %v", resp.Status)
+	}
+	return nil
+}
+
+// PruneDraftReleases deletes draft releases created more than olderThan ago,
+// like those left behind by failed builds. Published releases are never
+// deleted. If dryRun, it only logs what it would delete.
+func PruneDraftReleases(repo string, olderThan time.Duration, dryRun bool, token string) error {
+	releases, err := ListReleases("keybase", repo, token)
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-olderThan)
+	errs := []string{}
+	for _, release := range releases {
+		if !release.Draft || release.Published != nil || release.Created == nil || !release.Created.Before(cutoff) {
+			continue
+		}
+		if dryRun {
+			log.Printf("DRYRUN: Would delete draft release %s (%d), created %s", release.Name, release.ID, release.Created.Format(time.RFC3339))
+			continue
+		}
+		log.Printf("Deleting draft release %s (%d), created %s", release.Name, release.ID, release.Created.Format(time.RFC3339))
+		if err := DeleteRelease(token, repo, release.ID); err != nil {
+			errs = append(errs, fmt.Sprintf("%s (%d): %v", release.Name, release.ID, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("could not delete draft releases: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
 // Upload uploads a file to a tagged repo
 func Upload(token string, repo string, tag string, name string, file string) error {
 	release, err := ReleaseOfTag("keybase", repo, tag, token)
@@ -61,6 +110,7 @@ func Upload(token string, repo string, tag string, name string, file string) err
 	if err != nil {
 		return err
 	}
+	defer func() { _ = osfile.Close() }()
 	resp, err := DoAuthRequest("POST", url, "application/octet-stream", token, nil, osfile)
 	if resp != nil {
 		defer func() { _ = resp.Body.Close() }()
@@ -69,9 +119,8 @@ func Upload(token string, repo string, tag string, name string, file string) err
 		return err
 	}
 	if resp.StatusCode != http.StatusCreated {
-		if resp.StatusCode == 422 {
-			return fmt.Errorf("github returned %v (this is probably because the release already exists)",
-				resp.Status)
+		if resp.StatusCode == http.StatusUnprocessableEntity {
+			return validationError(resp)
 		}
 		return fmt.Errorf("github returned %v", resp.Status)
 	}
@@ -86,8 +135,9 @@ func DownloadSource(token string, repo string, tag string) error {
 	return Download(token, url, name)
 }
 
-// DownloadAsset downloads an asset from Github that matches name
-func DownloadAsset(token string, repo string, tag string, name string) error {
+// DownloadAsset downloads an asset from Github that matches name, trying up to
+// attempts times
+func DownloadAsset(token string, repo string, tag string, name string, attempts int) error {
 	release, err := ReleaseOfTag("keybase", repo, tag, token)
 	if err != nil {
 		return err
@@ -105,11 +155,48 @@ func DownloadAsset(token string, repo string, tag string, name string) error {
 	}
 
 	url := githubAPIURL + fmt.Sprintf(assetDownloadURI, "keybase", repo, assetID)
-	return Download(token, url, name)
+	return DownloadWithAttempts(token, url, name, attempts)
 }
 
+// DefaultDownloadAttempts is the default number of times to try a download
+const DefaultDownloadAttempts = 3
+
+// downloadRetryDelay is how long to wait before retrying a download
+var downloadRetryDelay = 5 * time.Second
+
 // Download from Github
 func Download(token string, url string, name string) error {
+	return DownloadWithAttempts(token, url, name, DefaultDownloadAttempts)
+}
+
+// DownloadWithAttempts downloads from Github, trying up to attempts times if
+// the download is truncated (or fails while copying). The partial file is
+// removed before each retry.
+func DownloadWithAttempts(token string, url string, name string, attempts int) error {
+	if attempts < 1 {
+		attempts = 1
+	}
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		var retry bool
+		retry, err = download(token, url, name)
+		if err == nil || !retry {
+			return err
+		}
+		if removeErr := os.Remove(name); removeErr != nil && !os.IsNotExist(removeErr) {
+			return fmt.Errorf("could not remove partial download %s, %v", name, removeErr)
+		}
+		if attempt < attempts {
+			log.Printf("Download attempt %d of %d failed, retrying: %v", attempt, attempts, err)
+			time.Sleep(downloadRetryDelay)
+		}
+	}
+	return fmt.Errorf("download failed after %d attempts, %v", attempts, err)
+}
+
+// download downloads url to name, returning whether a failure should be
+// retried
+func download(token string, url string, name string) (bool, error) {
 	resp, err := DoAuthRequest("GET", url, "", token, map[string]string{
 		"Accept": "application/octet-stream",
 	}, nil)
@@ -117,64 +204,107 @@ func Download(token string, url string, name string) error {
 		defer func() { _ = resp.Body.Close() }()
 	}
 	if err != nil {
-		return fmt.Errorf("could not fetch releases, %v", err)
+		return false, fmt.Errorf("could not fetch releases, %v", err)
 	}
 
 	contentLength, err := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("github did not respond with 200 OK but with %v", resp.Status)
+		return false, fmt.Errorf("github did not respond with 200 OK but with %v", resp.Status)
 	}
 
 	out, err := os.Create(name)
 	if err != nil {
-		return fmt.Errorf("could not create file %s", name)
+		return false, fmt.Errorf("could not create file %s", name)
 	}
 	defer func() { _ = out.Close() }()
 
-	n, err := io.Copy(out, resp.Body)
+	n, err := io.Copy(out, bandwidthLimiter.Reader(resp.Body))
 	if n != contentLength {
-		return fmt.Errorf("downloaded data did not match content length %d != %d", contentLength, n)
+		return true, fmt.Errorf("downloaded data did not match content length %d != %d", contentLength, n)
+	}
+	if err != nil {
+		return true, err
 	}
-	return err
+	return false, nil
 }
 
-// LatestCommit returns a latest commit for all statuses matching state and contexts
-func LatestCommit(token string, repo string, contexts []string) (*Commit, error) {
+const (
+	// DefaultStatusConcurrency is the default number of commits to check statuses
+	// for at once
+	DefaultStatusConcurrency = 4
+	// maxStatusConcurrency caps concurrent status requests to respect rate limits
+	maxStatusConcurrency = 10
+)
+
+// LatestCommit returns a latest commit for all statuses matching state and contexts.
+// Statuses are fetched for up to concurrency commits at a time.
+func LatestCommit(token string, repo string, contexts []string, concurrency int) (*Commit, error) {
 	commits, err := Commits("keybase", repo, token)
 	if err != nil {
 		return nil, err
 	}
 
-	for _, commit := range commits {
-		log.Printf("Checking %s", commit.SHA)
-		statuses, err := getStatuses(token, "keybase", repo, commit.SHA)
-		if err != nil {
-			return nil, err
+	if concurrency < 1 {
+		concurrency = 1
+	} else if concurrency > maxStatusConcurrency {
+		concurrency = maxStatusConcurrency
+	}
+
+	// Check commits in batches, newest first, so we return the newest passing
+	// commit even though statuses within a batch are fetched in parallel.
+	for start := 0; start < len(commits); start += concurrency {
+		end := start + concurrency
+		if end > len(commits) {
+			end = len(commits)
 		}
-		matching := map[string]Status{}
-		for _, status := range statuses {
-			if stringInSlice(status.Context, contexts) {
-				switch status.State {
-				case "failure":
-					log.Printf("%s (failure)", status.Context)
-				case "success":
-					log.Printf("%s (success)", status.Context)
-					matching[status.Context] = status
-				}
-			}
+		batch := commits[start:end]
+		batchStatuses := make([][]Status, len(batch))
+		batchErrs := make([]error, len(batch))
+		var g errgroup.Group
+		for i, commit := range batch {
+			i, commit := i, commit
+			g.Go(func() error {
+				batchStatuses[i], batchErrs[i] = getStatuses(token, "keybase", repo, commit.SHA)
+				return nil
+			})
 		}
-		// If we match all contexts then we've found the commit
-		if len(contexts) == len(matching) {
-			return &commit, nil
+		_ = g.Wait()
+
+		// An error for an older commit doesn't matter if a newer one passed
+		for i, commit := range batch {
+			if batchErrs[i] != nil {
+				return nil, batchErrs[i]
+			}
+			log.Printf("Checking %s", commit.SHA)
+			if matchesAllContexts(batchStatuses[i], contexts) {
+				return &batch[i], nil
+			}
 		}
 	}
 	return nil, nil
 }
 
+func matchesAllContexts(statuses []Status, contexts []string) bool {
+	matching := map[string]Status{}
+	for _, status := range statuses {
+		if stringInSlice(status.Context, contexts) {
+			switch status.State {
+			case "failure":
+				log.Printf("%s (failure)", status.Context)
+			case "success":
+				log.Printf("%s (success)", status.Context)
+				matching[status.Context] = status
+			}
+		}
+	}
+	// If we match all contexts then we've found the commit
+	return len(contexts) == len(matching)
+}
+
 func stringInSlice(str string, list []string) bool {
 	for _, s := range list {
 		if s == str {
@@ -198,13 +328,64 @@ func CIStatuses(token string, repo string, commit string) error {
 	return nil
 }
 
+// ParseCIRequirement parses the repo and CI contexts from a requirement like
+// client:ci/linux,ci/darwin
+func ParseCIRequirement(requirement string) (repo string, contexts []string, err error) {
+	parts := strings.SplitN(requirement, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", nil, fmt.Errorf("invalid CI requirement %q, should be repo:context1,context2", requirement)
+	}
+	for _, name := range strings.Split(parts[1], ",") {
+		if name != "" {
+			contexts = append(contexts, name)
+		}
+	}
+	if len(contexts) == 0 {
+		return "", nil, fmt.Errorf("no contexts in CI requirement %q", requirement)
+	}
+	return parts[0], contexts, nil
+}
+
+// CheckCI returns an error if commit in repo hasn't passed all the CI
+// contexts (without waiting for them, see WaitForCI)
+func CheckCI(token string, repo string, commit string, contexts []string) error {
+	statuses, err := overallStatus(context.Background(), token, "keybase", repo, commit)
+	if err != nil {
+		return err
+	}
+	states := map[string]string{}
+	for _, status := range statuses.Statuses {
+		states[status.Context] = status.State
+	}
+	failing := []string{}
+	for _, name := range contexts {
+		state, ok := states[name]
+		if !ok {
+			state = "no status"
+		}
+		if state != "success" {
+			failing = append(failing, fmt.Sprintf("%s (%s)", name, state))
+		}
+	}
+	if len(failing) > 0 {
+		return fmt.Errorf("CI hasn't passed for %s in %s: %s", commit, repo, strings.Join(failing, ", "))
+	}
+	return nil
+}
+
 // WaitForCI waits for commit in repo to pass CI contexts
 func WaitForCI(token string, repo string, commit string, contexts []string, delay time.Duration, timeout time.Duration) error {
+	return WaitForCIWithContext(context.Background(), token, repo, commit, contexts, delay, timeout)
+}
+
+// WaitForCIWithContext waits for commit in repo to pass CI contexts, or until
+// ctx is done
+func WaitForCIWithContext(ctx context.Context, token string, repo string, commit string, contexts []string, delay time.Duration, timeout time.Duration) error {
 	start := time.Now()
 	re := regexp.MustCompile("(.*)(/label=.*)")
 	for time.Since(start) < timeout {
 		log.Printf("Checking status for %s, %q (%s)", repo, contexts, commit)
-		statuses, err := overallStatus(token, "keybase", repo, commit)
+		statuses, err := overallStatus(ctx, token, "keybase", repo, commit)
 		if err != nil {
 			return err
 		}
@@ -254,7 +435,11 @@ func WaitForCI(token string, repo string, commit string, contexts []string, dela
 		}
 
 		log.Printf("Waiting %s", delay)
-		time.Sleep(delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return fmt.Errorf("Stopped waiting for CI: %v", ctx.Err())
+		}
 	}
 	return fmt.Errorf("Timed out")
 }
@@ -0,0 +1,386 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package github
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testServer starts a stub Github API server and points the package at it
+func testServer(t *testing.T, handler http.Handler) *httptest.Server {
+	server := httptest.NewServer(handler)
+	previous := githubAPIURL
+	githubAPIURL = server.URL
+	t.Cleanup(func() {
+		githubAPIURL = previous
+		server.Close()
+	})
+	return server
+}
+
+func writeJSON(t *testing.T, w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	require.NoError(t, json.NewEncoder(w).Encode(v))
+}
+
+func TestLatestCommit(t *testing.T) {
+	statuses := map[string][]Status{
+		"c1": {{Context: "ci", State: "pending"}},
+		"c2": {{Context: "ci", State: "failure"}},
+		"c3": {{Context: "ci", State: "success"}, {Context: "other", State: "failure"}},
+		"c4": {{Context: "ci", State: "success"}},
+		"c5": {},
+	}
+	fail := ""
+	testServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/repos/keybase/client/statuses/"+fail:
+			http.Error(w, "boom", http.StatusInternalServerError)
+		case r.URL.Path == "/repos/keybase/client/commits":
+			writeJSON(t, w, []Commit{{SHA: "c1"}, {SHA: "c2"}, {SHA: "c3"}, {SHA: "c4"}, {SHA: "c5"}})
+		case strings.HasPrefix(r.URL.Path, "/repos/keybase/client/statuses/"):
+			writeJSON(t, w, statuses[strings.TrimPrefix(r.URL.Path, "/repos/keybase/client/statuses/")])
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	for _, concurrency := range []int{0, 1, 2, 4, 100} {
+		commit, err := LatestCommit("token", "client", []string{"ci"}, concurrency)
+		require.NoError(t, err)
+		require.NotNil(t, commit)
+		assert.Equal(t, "c3", commit.SHA, "concurrency %d", concurrency)
+	}
+
+	commit, err := LatestCommit("token", "client", []string{"ci", "missing"}, 2)
+	require.NoError(t, err)
+	assert.Nil(t, commit)
+
+	// An error for an older commit in the batch doesn't hide a newer one that
+	// passed, but one for a newer commit fails
+	fail = "c4"
+	commit, err = LatestCommit("token", "client", []string{"ci"}, 4)
+	require.NoError(t, err)
+	require.NotNil(t, commit)
+	assert.Equal(t, "c3", commit.SHA)
+	fail = "c2"
+	_, err = LatestCommit("token", "client", []string{"ci"}, 4)
+	require.Error(t, err)
+}
+
+func TestCheckCI(t *testing.T) {
+	statuses := map[string]Statuses{
+		"green":   {State: "success", Statuses: []Status{{Context: "ci/linux", State: "success"}, {Context: "ci/darwin", State: "success"}}},
+		"red":     {State: "failure", Statuses: []Status{{Context: "ci/linux", State: "success"}, {Context: "ci/darwin", State: "failure"}}},
+		"partial": {State: "pending", Statuses: []Status{{Context: "ci/linux", State: "success"}}},
+	}
+	testServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		commit := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/repos/keybase/client/commits/"), "/status")
+		writeJSON(t, w, statuses[commit])
+	}))
+
+	repo, contexts, err := ParseCIRequirement("client:ci/linux,ci/darwin")
+	require.NoError(t, err)
+	assert.Equal(t, "client", repo)
+	assert.Equal(t, []string{"ci/linux", "ci/darwin"}, contexts)
+	for _, invalid := range []string{"", "client", "client:", ":ci/linux", "client:,"} {
+		_, _, err := ParseCIRequirement(invalid)
+		require.Error(t, err, invalid)
+	}
+
+	require.NoError(t, CheckCI("token", repo, "green", contexts))
+	require.NoError(t, CheckCI("token", repo, "partial", []string{"ci/linux"}))
+	err = CheckCI("token", repo, "red", contexts)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "ci/darwin (failure)")
+	err = CheckCI("token", repo, "partial", contexts)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "ci/darwin (no status)")
+}
+
+func TestDownloadRetriesTruncated(t *testing.T) {
+	previous := downloadRetryDelay
+	downloadRetryDelay = 0
+	t.Cleanup(func() { downloadRetryDelay = previous })
+
+	requests := 0
+	server := testServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Length", "10")
+		if requests == 1 {
+			// Truncated, the connection is closed after the short body
+			_, _ = w.Write([]byte("01234"))
+			return
+		}
+		_, _ = w.Write([]byte("0123456789"))
+	}))
+	name := filepath.Join(t.TempDir(), "asset.tgz")
+
+	require.NoError(t, DownloadWithAttempts("token", server.URL+"/asset", name, 3))
+	assert.Equal(t, 2, requests)
+	data, err := os.ReadFile(name)
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", string(data))
+
+	requests = 0
+	err = DownloadWithAttempts("token", server.URL+"/asset", name, 1)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "10 != 5")
+	_, err = os.Stat(name)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestAllAssets(t *testing.T) {
+	releases := make([]Release, releasesPerPage+1)
+	for i := range releases {
+		releases[i] = Release{ID: i, Assets: []Asset{{Name: fmt.Sprintf("asset%d", i), Size: 10, Downloads: 2}}}
+	}
+	testServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/repos/keybase/client/releases", r.URL.Path)
+		page := 1
+		if p := r.URL.Query().Get("page"); p != "" {
+			var err error
+			page, err = strconv.Atoi(p)
+			require.NoError(t, err)
+		}
+		start := (page - 1) * releasesPerPage
+		end := start + releasesPerPage
+		if start > len(releases) {
+			start = len(releases)
+		}
+		if end > len(releases) {
+			end = len(releases)
+		} else {
+			w.Header().Set("Link", fmt.Sprintf(`<http://%s%s?page=%d>; rel="next"`, r.Host, r.URL.Path, page+1))
+		}
+		writeJSON(t, w, releases[start:end])
+	}))
+
+	assets, err := AllAssets("keybase", "client", "token")
+	require.NoError(t, err)
+	require.Len(t, assets, releasesPerPage+1)
+	assert.Equal(t, "asset0", assets[0].Name)
+	assert.Equal(t, fmt.Sprintf("asset%d", releasesPerPage), assets[releasesPerPage].Name)
+	assert.Equal(t, uint64(10), assets[0].Size)
+	assert.Equal(t, uint64(2), assets[0].Downloads)
+}
+
+func TestListReleasesPages(t *testing.T) {
+	server := testServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/repos/keybase/client/releases", r.URL.Path)
+		switch r.URL.Query().Get("page") {
+		case "":
+			assert.Equal(t, strconv.Itoa(releasesPerPage), r.URL.Query().Get("per_page"))
+			w.Header().Set("Link", fmt.Sprintf(`<http://%s/repos/keybase/client/releases?page=2>; rel="next", <http://%s/repos/keybase/client/releases?page=2>; rel="last"`, r.Host, r.Host))
+			writeJSON(t, w, []Release{{ID: 1}, {ID: 2}, {ID: 3}})
+		case "2":
+			w.Header().Set("Link", fmt.Sprintf(`<http://%s/repos/keybase/client/releases?page=1>; rel="first", <http://%s/repos/keybase/client/releases?page=1>; rel="prev"`, r.Host, r.Host))
+			writeJSON(t, w, []Release{{ID: 4}, {ID: 5}})
+		default:
+			t.Errorf("Unexpected page %s", r.URL.Query().Get("page"))
+		}
+	}))
+
+	releases, err := ListReleases("keybase", "client", "token")
+	require.NoError(t, err)
+	require.Len(t, releases, 5)
+	assert.Equal(t, 5, releases[4].ID)
+
+	assert.Equal(t, server.URL+"/a?page=3", nextPageURL(`<`+server.URL+`/a?page=1>; rel="prev", <`+server.URL+`/a?page=3>; rel="next"`))
+	assert.Empty(t, nextPageURL(""))
+	assert.Empty(t, nextPageURL(`<`+server.URL+`/a?page=1>; rel="last"`))
+}
+
+func TestVerifyReleaseChecksums(t *testing.T) {
+	files := map[int]string{1: "", 2: "binary", 3: "other", 4: "extra"}
+	assets := []Asset{{ID: 1, Name: "SHA256SUMS"}, {ID: 2, Name: "keybase.tgz"}, {ID: 3, Name: "keybase.zip"}}
+	testServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/repos/keybase/client/releases":
+			writeJSON(t, w, []Release{{TagName: "v1.0.0", Assets: assets}})
+		case strings.HasPrefix(r.URL.Path, "/repos/keybase/client/releases/assets/"):
+			id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/repos/keybase/client/releases/assets/"))
+			require.NoError(t, err)
+			_, _ = w.Write([]byte(files[id]))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	digest := func(s string) string {
+		sum := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+
+	files[1] = fmt.Sprintf("%s  keybase.tgz\n%s *keybase.zip\n", digest("binary"), digest("other"))
+	require.NoError(t, VerifyReleaseChecksums("client", "1.0.0", "token"))
+
+	files[1] = fmt.Sprintf("%s  keybase.tgz\n%s  keybase.zip\n%s  keybase.deb\n", digest("corrupt"), digest("other"), digest("deb"))
+	assets = append(assets, Asset{ID: 4, Name: "keybase.rpm"})
+	err := VerifyReleaseChecksums("client", "1.0.0", "token")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "keybase.tgz has digest "+digest("binary"))
+	assert.Contains(t, err.Error(), "keybase.deb is in SHA256SUMS but not in the release")
+	assert.Contains(t, err.Error(), "keybase.rpm is in the release but not in SHA256SUMS")
+	assert.NotContains(t, err.Error(), "keybase.zip")
+}
+
+func TestAssetDownloadCounts(t *testing.T) {
+	testServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/repos/keybase/client/releases", r.URL.Path)
+		writeJSON(t, w, []Release{
+			{TagName: "v1.0.1", Assets: []Asset{{Name: "keybase.tgz", Downloads: 1}}},
+			{TagName: "v1.0.0", Assets: []Asset{{Name: "keybase.tgz", Downloads: 12}, {Name: "keybase.zip", Downloads: 3}, {Name: "keybase.deb", Downloads: 5}}},
+		})
+	}))
+
+	counts, err := AssetDownloadCounts("client", "1.0.0", "token")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"keybase.tgz": 12, "keybase.zip": 3, "keybase.deb": 5}, counts)
+
+	path := filepath.Join(t.TempDir(), "counts.json")
+	require.NoError(t, WriteDownloadCounts(path, map[string]int{"keybase.tgz": 10, "keybase.zip": 3, "keybase.rpm": 4}))
+	since, err := ReadDownloadCounts(path)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"keybase.tgz": 2, "keybase.zip": 0, "keybase.deb": 5}, DownloadCountDeltas(counts, since))
+}
+
+func TestLatestTag(t *testing.T) {
+	testServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/keybase/client/tags":
+			writeJSON(t, w, []Tag{{Name: "v1.0.3"}, {Name: "v1.0.2"}})
+		case "/repos/keybase/client/releases":
+			writeJSON(t, w, []Release{
+				{TagName: "v1.0.3", Draft: true},
+				{TagName: "v1.0.2", Prerelease: true},
+				{TagName: "v1.0.1"},
+				{TagName: "v1.0.0"},
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	for _, test := range []struct {
+		filter   *ReleaseFilter
+		expected string
+	}{
+		{nil, "v1.0.3"},
+		{&ReleaseFilter{}, "v1.0.1"},
+		{&ReleaseFilter{Prereleases: true}, "v1.0.2"},
+		{&ReleaseFilter{Prereleases: true, Drafts: true}, "v1.0.3"},
+	} {
+		tag, err := LatestTag("keybase", "client", "token", test.filter)
+		require.NoError(t, err)
+		require.NotNil(t, tag)
+		assert.Equal(t, test.expected, tag.Name)
+	}
+}
+
+func TestCreateReleaseValidation(t *testing.T) {
+	var body string
+	testServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/repos/keybase/client/releases" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		_, _ = w.Write([]byte(body))
+	}))
+
+	body = `{"message":"Validation Failed","errors":[{"resource":"Release","code":"already_exists","field":"tag_name"}]}`
+	err := CreateRelease("token", "client", "v1.0.0", "v1.0.0")
+	require.Error(t, err)
+	assert.True(t, IsAlreadyExists(err))
+	assert.Contains(t, err.Error(), "Release.tag_name already_exists")
+
+	body = `{"message":"Validation Failed","errors":[{"resource":"Release","code":"invalid","field":"tag_name"}]}`
+	err = CreateRelease("token", "client", "v1 0", "v1 0")
+	require.Error(t, err)
+	assert.False(t, IsAlreadyExists(err))
+	assert.Contains(t, err.Error(), "Validation Failed")
+	assert.Contains(t, err.Error(), "Release.tag_name invalid")
+
+	body = `not json`
+	err = CreateRelease("token", "client", "v1.0.0", "v1.0.0")
+	require.Error(t, err)
+	assert.False(t, IsAlreadyExists(err))
+	assert.Contains(t, err.Error(), "422")
+}
+
+func TestVerifyTagCommit(t *testing.T) {
+	const commit = "cd6f696e58a9e7bdc68bdd6b3f2e0b0d1cf0a4d2"
+	testServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		object := func(sha, typ string) map[string]interface{} {
+			return map[string]interface{}{"object": map[string]string{"sha": sha, "type": typ}}
+		}
+		switch r.URL.Path {
+		case "/repos/keybase/client/git/ref/tags/v1.0.14":
+			writeJSON(t, w, object(commit, "commit"))
+		case "/repos/keybase/client/git/ref/tags/v1.0.15":
+			// Annotated tag
+			writeJSON(t, w, object("aaaa", "tag"))
+		case "/repos/keybase/client/git/tags/aaaa":
+			writeJSON(t, w, object(commit, "commit"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	require.NoError(t, VerifyTagCommit("client", "v1.0.14", commit, "token"))
+	require.NoError(t, VerifyTagCommit("client", "v1.0.14", "cd6f696", "token"))
+	require.NoError(t, VerifyTagCommit("client", "v1.0.15", commit, "token"))
+
+	err := VerifyTagCommit("client", "v1.0.14", "abcdef0", "token")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), commit)
+	assert.Contains(t, err.Error(), "abcdef0")
+
+	require.Error(t, VerifyTagCommit("client", "v1.0.16", commit, "token"))
+	require.Error(t, VerifyTagCommit("client", "v1.0.14", "cd6", "token"))
+}
+
+func TestPruneDraftReleases(t *testing.T) {
+	old := time.Now().Add(-30 * 24 * time.Hour)
+	recent := time.Now().Add(-time.Hour)
+	releases := []Release{
+		{ID: 1, Name: "old draft", Draft: true, Created: &old},
+		{ID: 2, Name: "recent draft", Draft: true, Created: &recent},
+		{ID: 3, Name: "old published", Created: &old, Published: &old},
+		{ID: 4, Name: "old draft 2", Draft: true, Created: &old},
+	}
+	var deleted []string
+	testServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/repos/keybase/client/releases":
+			writeJSON(t, w, releases)
+		case r.Method == "DELETE" && strings.HasPrefix(r.URL.Path, "/repos/keybase/client/releases/"):
+			deleted = append(deleted, strings.TrimPrefix(r.URL.Path, "/repos/keybase/client/releases/"))
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	require.NoError(t, PruneDraftReleases("client", 7*24*time.Hour, true, "token"))
+	assert.Empty(t, deleted)
+
+	require.NoError(t, PruneDraftReleases("client", 7*24*time.Hour, false, "token"))
+	assert.Equal(t, []string{"1", "4"}, deleted)
+}
@@ -4,6 +4,7 @@ package github
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,12 +12,22 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-)
+	"strings"
 
-const (
-	githubAPIURL = "https://api.github.com"
+	"github.com/keybase/release/bandwidth"
 )
 
+var githubAPIURL = "https://api.github.com"
+
+// bandwidthLimiter limits the rate of file uploads and downloads
+var bandwidthLimiter *bandwidth.Limiter
+
+// SetMaxBandwidth limits the bytes per second of file uploads and downloads
+// (0 for unlimited)
+func SetMaxBandwidth(bytesPerSecond int64) {
+	bandwidthLimiter = bandwidth.NewLimiter(bytesPerSecond)
+}
+
 func githubURL(host string) (u *url.URL, err error) {
 	u, err = url.Parse(host)
 	if err != nil {
@@ -68,6 +79,9 @@ func NewAuthRequest(method, url, bodyType, token string, headers map[string]stri
 		if err != nil {
 			return nil, err
 		}
+		// The caller closes the file, which is rewound if the request is
+		// retried (see DoAuthRequest)
+		body = io.NopCloser(bandwidthLimiter.Reader(body))
 	}
 
 	req, err := http.NewRequest(method, url, body)
@@ -91,14 +105,32 @@ func NewAuthRequest(method, url, bodyType, token string, headers map[string]stri
 	return req, nil
 }
 
-// DoAuthRequest does an authenticated request to Github
+// DoAuthRequest does an authenticated request to Github. The token can be
+// multiple tokens, separated by TokenSeparator. If it's rate limited (with
+// every token), it's retried once the limit resets (see SetRateLimitRetries).
 func DoAuthRequest(method, url, bodyType, token string, headers map[string]string, body io.Reader) (*http.Response, error) {
+	return DoAuthRequestWithContext(context.Background(), method, url, bodyType, token, headers, body)
+}
+
+// DoAuthRequestWithContext is DoAuthRequest with a context for the request
+// and any waits to retry it
+func DoAuthRequestWithContext(ctx context.Context, method, url, bodyType, token string, headers map[string]string, body io.Reader) (*http.Response, error) {
+	rewind := bodyRewinder(body)
+	return retryRateLimited(ctx, rewind, func() (*http.Response, error) {
+		if list := splitTokens(token); len(list) > 1 {
+			return doWithTokens(ctx, method, url, bodyType, list, headers, body, rewind)
+		}
+		return doAuthRequest(ctx, method, url, bodyType, token, headers, body)
+	})
+}
+
+func doAuthRequest(ctx context.Context, method, url, bodyType, token string, headers map[string]string, body io.Reader) (*http.Response, error) {
 	req, err := NewAuthRequest(method, url, bodyType, token, headers, body)
 	if err != nil {
 		return nil, err
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
 	if err != nil {
 		return nil, err
 	}
@@ -108,7 +140,12 @@ func DoAuthRequest(method, url, bodyType, token string, headers map[string]strin
 
 // Get does a GET request to the Github API
 func Get(token string, url string, v interface{}) error {
-	resp, err := DoAuthRequest("GET", url, "", token, nil, nil)
+	return GetWithContext(context.Background(), token, url, v)
+}
+
+// GetWithContext is Get with a context for the request
+func GetWithContext(ctx context.Context, token string, url string, v interface{}) error {
+	resp, err := DoAuthRequestWithContext(ctx, "GET", url, "", token, nil, nil)
 	if resp != nil {
 		defer func() { _ = resp.Body.Close() }()
 	}
@@ -118,6 +155,40 @@ func Get(token string, url string, v interface{}) error {
 	return get(resp, url, v)
 }
 
+// getPage does a GET request to the Github API for a page of a list, and
+// returns the URL of the next page from the Link header ("" if it's the last)
+func getPage(token string, url string, v interface{}) (next string, err error) {
+	resp, err := DoAuthRequest("GET", url, "", token, nil, nil)
+	if resp != nil {
+		defer func() { _ = resp.Body.Close() }()
+	}
+	if err != nil {
+		return "", fmt.Errorf("Error in http Get %v", err)
+	}
+	if err := get(resp, url, v); err != nil {
+		return "", err
+	}
+	return nextPageURL(resp.Header.Get("Link")), nil
+}
+
+// nextPageURL returns the rel="next" URL in a Link header, like
+// <https://api.github.com/repositories/1/releases?page=2>; rel="next", ...
+func nextPageURL(link string) string {
+	for _, part := range strings.Split(link, ",") {
+		segments := strings.Split(part, ";")
+		target := strings.TrimSpace(segments[0])
+		if len(segments) < 2 || !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+			continue
+		}
+		for _, param := range segments[1:] {
+			if strings.TrimSpace(param) == `rel="next"` {
+				return strings.TrimSuffix(strings.TrimPrefix(target, "<"), ">")
+			}
+		}
+	}
+	return ""
+}
+
 func get(resp *http.Response, url, v interface{}) error {
 	if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("%s responded with %v", url, resp.Status)
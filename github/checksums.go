@@ -0,0 +1,123 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package github
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/keybase/release/update"
+)
+
+// checksumsAssetName is the asset listing the SHA256 of a release's assets
+const checksumsAssetName = "SHA256SUMS"
+
+// VerifyReleaseChecksums downloads the assets of a release and checks them
+// against its SHA256SUMS asset. It's an error if any don't match, or are
+// missing from the release or SHA256SUMS.
+func VerifyReleaseChecksums(repo string, version string, token string) error {
+	release, err := ReleaseOfTag("keybase", repo, fmt.Sprintf("v%s", version), token)
+	if err != nil {
+		return err
+	}
+	dir, err := os.MkdirTemp("", "verify-checksums")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	assets := map[string]Asset{}
+	for _, asset := range release.Assets {
+		assets[asset.Name] = asset
+	}
+	sumsAsset, ok := assets[checksumsAssetName]
+	if !ok {
+		return fmt.Errorf("no %s asset in release %s", checksumsAssetName, release.TagName)
+	}
+	sumsPath, err := downloadReleaseAsset(token, repo, sumsAsset, dir)
+	if err != nil {
+		return err
+	}
+	sumsData, err := os.ReadFile(sumsPath)
+	if err != nil {
+		return err
+	}
+	sums, err := parseChecksums(sumsData)
+	if err != nil {
+		return err
+	}
+
+	problems := []string{}
+	names := []string{}
+	for name := range sums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		asset, ok := assets[name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s is in %s but not in the release", name, checksumsAssetName))
+			continue
+		}
+		path, err := downloadReleaseAsset(token, repo, asset, dir)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", name, err))
+			continue
+		}
+		actual, err := update.Digest(path, update.DigestSHA256)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", name, err))
+			continue
+		}
+		if actual != sums[name] {
+			problems = append(problems, fmt.Sprintf("%s has digest %s, expected %s", name, actual, sums[name]))
+			continue
+		}
+		log.Printf("%s OK", name)
+	}
+	for _, asset := range release.Assets {
+		if _, ok := sums[asset.Name]; !ok && asset.Name != checksumsAssetName {
+			problems = append(problems, fmt.Sprintf("%s is in the release but not in %s", asset.Name, checksumsAssetName))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("checksums didn't verify: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// downloadReleaseAsset downloads an asset into dir, returning its path
+func downloadReleaseAsset(token string, repo string, asset Asset, dir string) (string, error) {
+	url := githubAPIURL + fmt.Sprintf(assetDownloadURI, "keybase", repo, asset.ID)
+	path := filepath.Join(dir, filepath.Base(asset.Name))
+	if err := Download(token, url, path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// parseChecksums parses sha256sum output ("<digest>  <name>" lines, where the
+// name may be prefixed with * for binary mode)
+func parseChecksums(data []byte) (map[string]string, error) {
+	sums := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid %s line: %s", checksumsAssetName, line)
+		}
+		sums[strings.TrimPrefix(fields[1], "*")] = strings.ToLower(fields[0])
+	}
+	return sums, scanner.Err()
+}
@@ -0,0 +1,57 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package github
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// AssetDownloadCounts returns the download count of each asset (by name) of a
+// release
+func AssetDownloadCounts(repo string, version string, token string) (map[string]int, error) {
+	release, err := ReleaseOfTag("keybase", repo, fmt.Sprintf("v%s", version), token)
+	if err != nil {
+		return nil, err
+	}
+	counts := map[string]int{}
+	for _, asset := range release.Assets {
+		counts[asset.Name] = int(asset.Downloads)
+	}
+	return counts, nil
+}
+
+// DownloadCountDeltas returns the change in each asset's download count since
+// a previous snapshot. Assets that weren't in the snapshot count from 0.
+func DownloadCountDeltas(counts map[string]int, since map[string]int) map[string]int {
+	deltas := map[string]int{}
+	for name, count := range counts {
+		deltas[name] = count - since[name]
+	}
+	return deltas
+}
+
+// ReadDownloadCounts reads a snapshot of download counts (JSON)
+func ReadDownloadCounts(path string) (map[string]int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	counts := map[string]int{}
+	if err := json.Unmarshal(data, &counts); err != nil {
+		return nil, fmt.Errorf("invalid download counts in %s: %s", path, err)
+	}
+	return counts, nil
+}
+
+// WriteDownloadCounts saves a snapshot of download counts (JSON), for
+// comparing with later
+func WriteDownloadCounts(path string, counts map[string]int) error {
+	data, err := json.MarshalIndent(counts, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
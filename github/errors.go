@@ -1,6 +1,13 @@
 package github
 
-import "fmt"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
 
 // ErrNotFound is error type for not found in API
 type ErrNotFound struct {
@@ -12,3 +19,74 @@ type ErrNotFound struct {
 func (e ErrNotFound) Error() string {
 	return fmt.Sprintf("%s not found with %s: %s", e.Name, e.Key, e.Value)
 }
+
+// ValidationFieldError is a field error in a 422 (Unprocessable Entity)
+// response from the API
+type ValidationFieldError struct {
+	Resource string `json:"resource"`
+	Field    string `json:"field"`
+	Code     string `json:"code"`
+	Message  string `json:"message,omitempty"`
+}
+
+func (e ValidationFieldError) String() string {
+	if e.Code == "custom" && e.Message != "" {
+		return fmt.Sprintf("%s.%s: %s", e.Resource, e.Field, e.Message)
+	}
+	return fmt.Sprintf("%s.%s %s", e.Resource, e.Field, e.Code)
+}
+
+// ErrValidation is error type for a 422 (Unprocessable Entity) response from
+// the API, with the reasons it gave
+type ErrValidation struct {
+	Status  string                 `json:"-"`
+	Message string                 `json:"message"`
+	Errors  []ValidationFieldError `json:"errors"`
+}
+
+func (e ErrValidation) Error() string {
+	reasons := []string{}
+	for _, fieldErr := range e.Errors {
+		reasons = append(reasons, fieldErr.String())
+	}
+	msg := fmt.Sprintf("github returned %v", e.Status)
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if len(reasons) > 0 {
+		msg += " (" + strings.Join(reasons, ", ") + ")"
+	}
+	return msg
+}
+
+// AlreadyExists returns true if the validation failed only because the
+// resource already exists
+func (e ErrValidation) AlreadyExists() bool {
+	if len(e.Errors) == 0 {
+		return false
+	}
+	for _, fieldErr := range e.Errors {
+		if fieldErr.Code != "already_exists" {
+			return false
+		}
+	}
+	return true
+}
+
+// IsAlreadyExists returns true if err is a validation error because the
+// resource already exists
+func IsAlreadyExists(err error) bool {
+	var validationErr *ErrValidation
+	return errors.As(err, &validationErr) && validationErr.AlreadyExists()
+}
+
+// validationError returns the error for a 422 response, with the reasons from
+// its body if it can be parsed
+func validationError(resp *http.Response) error {
+	validationErr := &ErrValidation{Status: resp.Status}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err == nil {
+		_ = json.Unmarshal(body, validationErr)
+	}
+	return validationErr
+}
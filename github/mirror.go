@@ -0,0 +1,63 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package github
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/keybase/release/update"
+)
+
+// MirrorRelease creates a Github release for tag in repo (if there isn't one),
+// and uploads the files at keys that the Github release doesn't have, getting
+// each with download (like from S3). All files are attempted and any errors
+// are combined.
+func MirrorRelease(token string, repo string, tag string, keys []string, download func(key string, path string) error) error {
+	existing := map[string]bool{}
+	release, err := ReleaseOfTag("keybase", repo, tag, token)
+	var notFound *ErrNotFound
+	switch {
+	case errors.As(err, &notFound):
+		log.Printf("Creating Github release %s", tag)
+		if err := CreateRelease(token, repo, tag, tag); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	default:
+		for _, asset := range release.Assets {
+			existing[asset.Name] = true
+		}
+	}
+
+	dir, err := os.MkdirTemp("", "mirror-to-github")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	errs := []error{}
+	for _, key := range keys {
+		name := path.Base(key)
+		if existing[name] {
+			log.Printf("Skipping %s, already in the Github release", name)
+			continue
+		}
+		localPath := filepath.Join(dir, name)
+		if err := download(key, localPath); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		log.Printf("Uploading %s to %s", name, tag)
+		if err := Upload(token, repo, tag, name, localPath); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", name, err))
+		}
+	}
+	return update.CombineErrors(errs...)
+}
@@ -0,0 +1,68 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package github
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMirrorRelease(t *testing.T) {
+	var releases []Release
+	uploads := map[string]string{}
+	testServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/repos/keybase/client/releases":
+			writeJSON(t, w, releases)
+		case r.Method == "POST" && r.URL.Path == "/repos/keybase/client/releases":
+			releases = append(releases, Release{TagName: "v1.0.15", UploadURL: fmt.Sprintf("http://%s/upload{?name,label}", r.Host)})
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == "POST" && r.URL.Path == "/upload":
+			data, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			uploads[r.URL.Query().Get("name")] = string(data)
+			w.WriteHeader(http.StatusCreated)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	files := map[string]string{
+		"darwin/Keybase-1.0.15.dmg":         "dmg",
+		"darwin-updates/Keybase-1.0.15.zip": "zip",
+	}
+	downloaded := []string{}
+	download := func(key string, path string) error {
+		downloaded = append(downloaded, key)
+		data, ok := files[key]
+		if !ok {
+			return fmt.Errorf("Error getting %s: NoSuchKey", key)
+		}
+		return os.WriteFile(path, []byte(data), 0644)
+	}
+	keys := []string{"darwin/Keybase-1.0.15.dmg", "darwin-updates/Keybase-1.0.15.zip"}
+
+	// The Github release is created
+	require.NoError(t, MirrorRelease("token", "client", "v1.0.15", keys, download))
+	require.Len(t, releases, 1)
+	assert.Equal(t, map[string]string{"Keybase-1.0.15.dmg": "dmg", "Keybase-1.0.15.zip": "zip"}, uploads)
+
+	// Files already in the Github release are skipped, and a failed download
+	// doesn't stop the others
+	releases[0].Assets = []Asset{{Name: "Keybase-1.0.15.dmg"}}
+	uploads = map[string]string{}
+	downloaded = nil
+	keys = append(keys, "darwin-support/update-darwin-prod-1.0.15.json")
+	err := MirrorRelease("token", "client", "v1.0.15", keys, download)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "darwin-support/update-darwin-prod-1.0.15.json")
+	require.Len(t, releases, 1)
+	assert.Equal(t, []string{"darwin-updates/Keybase-1.0.15.zip", "darwin-support/update-darwin-prod-1.0.15.json"}, downloaded)
+	assert.Equal(t, map[string]string{"Keybase-1.0.15.zip": "zip"}, uploads)
+}
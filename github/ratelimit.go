@@ -0,0 +1,109 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package github
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// DefaultRateLimitRetries is how many times a rate limited request is retried
+// (after waiting for the limit to reset) by default
+const DefaultRateLimitRetries = 3
+
+// defaultRateLimitWait is how long to wait when a rate limit response doesn't
+// say when to retry, as Github recommends
+const defaultRateLimitWait = time.Minute
+
+// maxRateLimitWait caps how long to wait for a rate limit to reset
+const maxRateLimitWait = time.Hour
+
+var rateLimitRetries = DefaultRateLimitRetries
+
+// sleep waits between rate limited attempts, returning ctx's error if it's
+// done first (replaced in tests)
+var sleep = func(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SetRateLimitRetries sets how many times a rate limited request is retried
+// (0 to fail right away)
+func SetRateLimitRetries(retries int) {
+	rateLimitRetries = retries
+}
+
+// rateLimitWait returns how long to wait before retrying a rate limited
+// response, from its Retry-After or X-RateLimit-Reset header
+func rateLimitWait(resp *http.Response, now time.Time) time.Duration {
+	wait := defaultRateLimitWait
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		wait = time.Duration(seconds) * time.Second
+	} else if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		// The reset time is only to the second, so wait a second longer
+		wait = time.Unix(reset, 0).Sub(now) + time.Second
+	}
+	if wait < 0 {
+		return 0
+	}
+	if wait > maxRateLimitWait {
+		return maxRateLimitWait
+	}
+	return wait
+}
+
+// bodyRewinder returns a func that seeks a request body back to where it
+// started, so the request can be retried. The func returns false if the body
+// can't be re-read, like if it isn't an io.Seeker.
+func bodyRewinder(body io.Reader) func() bool {
+	if body == nil {
+		return func() bool { return true }
+	}
+	seeker, ok := body.(io.Seeker)
+	if !ok {
+		return func() bool { return false }
+	}
+	start, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return func() bool { return false }
+	}
+	return func() bool {
+		_, err := seeker.Seek(start, io.SeekStart)
+		return err == nil
+	}
+}
+
+// retryRateLimited does a request, and if it's rate limited, waits for the
+// limit to reset (or ctx to be done) and tries again, up to rateLimitRetries
+// times. The body is rewound before each retry, and the request isn't retried
+// if it can't be.
+func retryRateLimited(ctx context.Context, rewind func() bool, do func() (*http.Response, error)) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := do()
+		if err != nil || attempt >= rateLimitRetries || !isRateLimited(resp) {
+			return resp, err
+		}
+		if !rewind() {
+			log.Printf("Rate limited by Github, not retrying since the request body can't be re-read")
+			return resp, nil
+		}
+		wait := rateLimitWait(resp, time.Now())
+		_ = resp.Body.Close()
+		log.Printf("Rate limited by Github, waiting %s to retry (%d of %d)", wait, attempt+1, rateLimitRetries)
+		if err := sleep(ctx, wait); err != nil {
+			return nil, fmt.Errorf("Stopped waiting for Github rate limit: %v", err)
+		}
+	}
+}
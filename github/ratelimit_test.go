@@ -0,0 +1,146 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package github
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testSleep records waits instead of sleeping
+func testSleep(t *testing.T) *[]time.Duration {
+	waits := []time.Duration{}
+	previous := sleep
+	sleep = func(ctx context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return ctx.Err()
+	}
+	t.Cleanup(func() { sleep = previous })
+	return &waits
+}
+
+func TestRateLimitRetry(t *testing.T) {
+	waits := testSleep(t)
+	requests := 0
+	testServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("Retry-After", "30")
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		writeJSON(t, w, []Tag{{Name: "v1.0.0"}})
+	}))
+
+	var tags []Tag
+	require.NoError(t, Get("token", githubAPIURL+"/repos/keybase/client/tags", &tags))
+	assert.Equal(t, []Tag{{Name: "v1.0.0"}}, tags)
+	assert.Equal(t, 2, requests)
+	assert.Equal(t, []time.Duration{30 * time.Second}, *waits)
+}
+
+func TestRateLimitRetriesExhausted(t *testing.T) {
+	waits := testSleep(t)
+	requests := 0
+	testServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+
+	var tags []Tag
+	err := Get("token", githubAPIURL+"/repos/keybase/client/tags", &tags)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "429")
+	assert.Equal(t, DefaultRateLimitRetries+1, requests)
+	assert.Len(t, *waits, DefaultRateLimitRetries)
+}
+
+func TestRateLimitWait(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	wait := func(headers map[string]string) time.Duration {
+		resp := &http.Response{Header: http.Header{}}
+		for k, v := range headers {
+			resp.Header.Set(k, v)
+		}
+		return rateLimitWait(resp, now)
+	}
+
+	assert.Equal(t, 5*time.Second, wait(map[string]string{"Retry-After": "5"}))
+	assert.Equal(t, 11*time.Second, wait(map[string]string{"X-RateLimit-Reset": strconv.FormatInt(now.Unix()+10, 10)}))
+	// Retry-After wins
+	assert.Equal(t, 5*time.Second, wait(map[string]string{"Retry-After": "5", "X-RateLimit-Reset": strconv.FormatInt(now.Unix()+10, 10)}))
+	assert.Equal(t, time.Duration(0), wait(map[string]string{"X-RateLimit-Reset": strconv.FormatInt(now.Unix()-10, 10)}))
+	assert.Equal(t, maxRateLimitWait, wait(map[string]string{"X-RateLimit-Reset": strconv.FormatInt(now.Unix()+86400, 10)}))
+	assert.Equal(t, defaultRateLimitWait, wait(nil))
+}
+
+func TestRateLimitRetryBody(t *testing.T) {
+	waits := testSleep(t)
+	bodies := []string{}
+	testServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		bodies = append(bodies, string(data))
+		if len(bodies)%2 == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	// A file upload is retried from the start of the file
+	path := filepath.Join(t.TempDir(), "asset.zip")
+	require.NoError(t, os.WriteFile(path, []byte("zip data"), 0600))
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer func() { _ = f.Close() }()
+	resp, err := DoAuthRequest("POST", githubAPIURL+"/upload", "application/octet-stream", "token", nil, f)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	resp, err = DoAuthRequest("POST", githubAPIURL+"/releases", "application/json", "token", nil, bytes.NewReader([]byte(`{}`)))
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, []string{"zip data", "zip data", "{}", "{}"}, bodies)
+	assert.Len(t, *waits, 2)
+
+	// A body that can't be re-read isn't retried
+	bodies = nil
+	resp, err = DoAuthRequest("POST", githubAPIURL+"/releases", "application/json", "token", nil, io.MultiReader(strings.NewReader("{}")))
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Len(t, bodies, 1)
+}
+
+func TestRateLimitWaitContext(t *testing.T) {
+	testServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	var tags []Tag
+	err := GetWithContext(ctx, "token", githubAPIURL+"/repos/keybase/client/tags", &tags)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "context deadline exceeded")
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
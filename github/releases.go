@@ -4,6 +4,8 @@ package github
 
 import (
 	"fmt"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -64,6 +66,42 @@ func Releases(user, repo, token string) (releases []Release, err error) {
 	return
 }
 
+// releasesPerPage is the page size when listing all releases (the API's max)
+const releasesPerPage = 100
+
+// ListReleases returns all releases for a repo, following the Link header to
+// each next page
+func ListReleases(user, repo, token string) ([]Release, error) {
+	u, err := githubURL(githubAPIURL)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = fmt.Sprintf(releaseListPath, user, repo)
+	u.RawQuery = url.Values{"per_page": {strconv.Itoa(releasesPerPage)}}.Encode()
+	all := []Release{}
+	for next := u.String(); next != ""; {
+		var releases []Release
+		if next, err = getPage(token, next, &releases); err != nil {
+			return nil, err
+		}
+		all = append(all, releases...)
+	}
+	return all, nil
+}
+
+// AllAssets returns the assets of all releases for a repo
+func AllAssets(user, repo, token string) ([]Asset, error) {
+	releases, err := ListReleases(user, repo, token)
+	if err != nil {
+		return nil, err
+	}
+	assets := []Asset{}
+	for _, release := range releases {
+		assets = append(assets, release.Assets...)
+	}
+	return assets, nil
+}
+
 // LatestRelease returns latest release for repo
 func LatestRelease(user, repo, token string) (release *Release, err error) {
 	u, err := githubURL(githubAPIURL)
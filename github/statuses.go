@@ -3,7 +3,10 @@
 
 package github
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+)
 
 // Status defines a git commit on Github
 type Status struct {
@@ -40,14 +43,14 @@ func getStatuses(token, user, repo, sha string) ([]Status, error) {
 // Instead of all the statuses, it gives an overall status
 // if all have passed, plus a list of the most recent results
 // for each context.
-func overallStatus(token, user, repo, sha string) (Statuses, error) {
+func overallStatus(ctx context.Context, token, user, repo, sha string) (Statuses, error) {
 	url, err := githubURL(githubAPIURL)
 	if err != nil {
 		return Statuses{}, err
 	}
 	url.Path = fmt.Sprintf(statusListPath, user, repo, sha)
 	var statuses Statuses
-	if err = Get(token, url.String(), &statuses); err != nil {
+	if err = GetWithContext(ctx, token, url.String(), &statuses); err != nil {
 		return Statuses{}, err
 	}
 	return statuses, nil
@@ -3,12 +3,21 @@
 
 package github
 
-import "fmt"
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
 
 const (
 	tagListPath = "/repos/%s/%s/tags"
+	tagRefPath  = "/repos/%s/%s/git/ref/tags/%s"
+	tagObjPath  = "/repos/%s/%s/git/tags/%s"
 )
 
+// maxTagDepth limits how many annotated tags we follow to find a commit
+const maxTagDepth = 5
+
 // Tag is a Github API Tag type
 type Tag struct {
 	Name string `json:"name"`
@@ -28,8 +37,19 @@ func Tags(user, repo, token string) (tags []Tag, err error) {
 	return
 }
 
-// LatestTag returns latest tag for a repo
-func LatestTag(user, repo, token string) (tag *Tag, err error) {
+// ReleaseFilter selects which releases' tags LatestTag considers
+type ReleaseFilter struct {
+	Prereleases bool
+	Drafts      bool
+}
+
+// LatestTag returns latest tag for a repo. If filter is nil, this is the
+// latest tag whether or not it has a release, otherwise it's the tag of the
+// latest release, including prereleases or drafts only if the filter does.
+func LatestTag(user, repo, token string, filter *ReleaseFilter) (tag *Tag, err error) {
+	if filter != nil {
+		return latestReleaseTag(user, repo, token, *filter)
+	}
 	tags, err := Tags(user, repo, token)
 	if err != nil {
 		return
@@ -39,3 +59,67 @@ func LatestTag(user, repo, token string) (tag *Tag, err error) {
 	}
 	return
 }
+
+func latestReleaseTag(user, repo, token string, filter ReleaseFilter) (*Tag, error) {
+	releases, err := ListReleases(user, repo, token)
+	if err != nil {
+		return nil, err
+	}
+	// Releases are listed newest first
+	for _, release := range releases {
+		if (release.Prerelease && !filter.Prereleases) || (release.Draft && !filter.Drafts) {
+			continue
+		}
+		return &Tag{Name: release.TagName}, nil
+	}
+	return nil, nil
+}
+
+// gitObject is a Github API git object reference (for a ref or annotated tag)
+type gitObject struct {
+	Object struct {
+		SHA  string `json:"sha"`
+		Type string `json:"type"`
+	} `json:"object"`
+}
+
+// TagCommit returns the SHA of the commit a tag points to, following
+// annotated tags
+func TagCommit(user, repo, tag, token string) (string, error) {
+	var ref gitObject
+	if err := Get(token, githubAPIURL+fmt.Sprintf(tagRefPath, user, repo, url.PathEscape(tag)), &ref); err != nil {
+		return "", err
+	}
+	for i := 0; i < maxTagDepth; i++ {
+		switch ref.Object.Type {
+		case "commit":
+			return ref.Object.SHA, nil
+		case "tag":
+			sha := ref.Object.SHA
+			ref = gitObject{}
+			if err := Get(token, githubAPIURL+fmt.Sprintf(tagObjPath, user, repo, sha), &ref); err != nil {
+				return "", err
+			}
+		default:
+			return "", fmt.Errorf("tag %s points to a %s, not a commit", tag, ref.Object.Type)
+		}
+	}
+	return "", fmt.Errorf("tag %s is nested too deeply", tag)
+}
+
+// VerifyTagCommit checks that a tag in a keybase repo points to the expected
+// commit, which can be a short or full SHA. This catches tags that were moved
+// after we built from them.
+func VerifyTagCommit(repo, tag, expectedSHA, token string) error {
+	if len(expectedSHA) < 7 {
+		return fmt.Errorf("expected commit %q is too short", expectedSHA)
+	}
+	sha, err := TagCommit("keybase", repo, tag, token)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(sha, strings.ToLower(expectedSHA)) {
+		return fmt.Errorf("tag %s points to %s, expected %s", tag, sha, expectedSHA)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package github
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// TokenSeparator separates multiple tokens in a token string. Requests with
+// multiple tokens use the one with the most requests remaining, and fail over
+// to the next when one is rate limited.
+const TokenSeparator = ","
+
+// tokenPool tracks the requests remaining (from X-RateLimit-Remaining) for
+// each token we've used
+type tokenPool struct {
+	sync.Mutex
+	remaining map[string]int
+}
+
+var tokens = &tokenPool{remaining: map[string]int{}}
+
+// splitTokens returns the tokens in a token string
+func splitTokens(token string) []string {
+	list := []string{}
+	for _, t := range strings.Split(token, TokenSeparator) {
+		if t = strings.TrimSpace(t); t != "" {
+			list = append(list, t)
+		}
+	}
+	if len(list) == 0 {
+		return []string{token}
+	}
+	return list
+}
+
+// freshest returns the tokens ordered by requests remaining, most first.
+// Tokens we haven't used yet are assumed to be fresh.
+func (p *tokenPool) freshest(list []string) []string {
+	p.Lock()
+	defer p.Unlock()
+	remaining := func(token string) int {
+		if n, ok := p.remaining[token]; ok {
+			return n
+		}
+		return math.MaxInt32
+	}
+	ordered := append([]string{}, list...)
+	sort.SliceStable(ordered, func(i, j int) bool { return remaining(ordered[i]) > remaining(ordered[j]) })
+	return ordered
+}
+
+// update records the requests remaining for a token from a response
+func (p *tokenPool) update(token string, resp *http.Response) {
+	n, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+	p.Lock()
+	defer p.Unlock()
+	p.remaining[token] = n
+}
+
+// isRateLimited returns true if a response is a rate limit error, either for
+// the primary limit (no requests remaining) or a secondary one (Retry-After)
+func isRateLimited(resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusForbidden:
+		return resp.Header.Get("X-RateLimit-Remaining") == "0" || resp.Header.Get("Retry-After") != ""
+	default:
+		return false
+	}
+}
+
+// doWithTokens does a request with each of multiple tokens until one isn't
+// rate limited. The body is rewound before each retry, and the request isn't
+// retried if it can't be.
+func doWithTokens(ctx context.Context, method, url, bodyType string, list []string, headers map[string]string, body io.Reader, rewind func() bool) (*http.Response, error) {
+	ordered := tokens.freshest(list)
+	for i, token := range ordered {
+		resp, err := doAuthRequest(ctx, method, url, bodyType, token, headers, body)
+		if err != nil {
+			return nil, err
+		}
+		tokens.update(token, resp)
+		if !isRateLimited(resp) || i == len(ordered)-1 || !rewind() {
+			return resp, nil
+		}
+		_ = resp.Body.Close()
+		log.Printf("Token %d of %d is rate limited, trying the next", i+1, len(ordered))
+	}
+	return nil, fmt.Errorf("no tokens")
+}
@@ -0,0 +1,49 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package github
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenFailover(t *testing.T) {
+	previous := tokens
+	tokens = &tokenPool{remaining: map[string]int{}}
+	t.Cleanup(func() { tokens = previous })
+
+	used := []string{}
+	testServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		used = append(used, auth)
+		switch auth {
+		case "token t1":
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.WriteHeader(http.StatusForbidden)
+		case "token t2":
+			w.Header().Set("X-RateLimit-Remaining", "10")
+			writeJSON(t, w, []Tag{{Name: "v1.0.0"}})
+		}
+	}))
+
+	var tags []Tag
+	require.NoError(t, Get("t1,t2", githubAPIURL+"/repos/keybase/client/tags", &tags))
+	assert.Equal(t, []Tag{{Name: "v1.0.0"}}, tags)
+	assert.Equal(t, []string{"token t1", "token t2"}, used)
+
+	// The rate limited token is tried last
+	used = nil
+	require.NoError(t, Get("t1,t2", githubAPIURL+"/repos/keybase/client/tags", &tags))
+	assert.Equal(t, []string{"token t2"}, used)
+
+	// A single token isn't retried (without rate limit retries)
+	SetRateLimitRetries(0)
+	t.Cleanup(func() { SetRateLimitRetries(DefaultRateLimitRetries) })
+	used = nil
+	require.Error(t, Get("t1", githubAPIURL+"/repos/keybase/client/tags", &tags))
+	assert.Equal(t, []string{"token t1"}, used)
+}
@@ -7,6 +7,7 @@ require (
 	github.com/aws/aws-sdk-go v1.8.15-0.20170419235817-538c13abafdd
 	github.com/blang/semver v3.1.0+incompatible
 	github.com/stretchr/testify v1.8.4
+	golang.org/x/sync v0.3.0
 	gopkg.in/alecthomas/kingpin.v2 v2.2.3
 )
 
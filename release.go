@@ -4,11 +4,17 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"fmt"
 	"log"
 	"os"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"text/tabwriter"
+	"time"
 
 	gh "github.com/keybase/release/github"
 	"github.com/keybase/release/update"
@@ -17,8 +23,13 @@ import (
 	"gopkg.in/alecthomas/kingpin.v2"
 )
 
+// githubToken returns the --github-token tokens, or GITHUB_TOKEN, which can
+// be multiple (comma-separated) tokens to fail over between
 func githubToken(required bool) string {
-	token := os.Getenv("GITHUB_TOKEN")
+	token := strings.Join(*appGithubTokens, gh.TokenSeparator)
+	if token == "" {
+		token = os.Getenv("GITHUB_TOKEN")
+	}
 	if token == "" && required {
 		log.Fatal("No GITHUB_TOKEN set")
 	}
@@ -26,22 +37,178 @@ func githubToken(required bool) string {
 }
 
 func keybaseToken(required bool) string {
-	token := os.Getenv("KEYBASE_TOKEN")
-	if token == "" && required {
-		log.Fatal("No KEYBASE_TOKEN set")
+	token, err := update.ReadToken(*appKeybaseToken)
+	if err != nil {
+		if required {
+			log.Fatal(err)
+		}
+		return ""
 	}
 	return token
 }
 
+// outputFlag adds the --output flag for report commands
+func outputFlag(cmd *kingpin.CmdClause) *string {
+	return cmd.Flag("output", "Output format (table, json)").Default(update.OutputTable).Enum(update.Outputs...)
+}
+
+// destPrefixFlag adds the --dest-prefix flag for promotion commands
+func destPrefixFlag(cmd *kingpin.CmdClause) *string {
+	return cmd.Flag("dest-prefix", "Prefix for the update JSON keys to promote to (for a bucket with a different layout)").String()
+}
+
+// twoPhaseFlag adds the --two-phase flag for promotion commands
+func twoPhaseFlag(cmd *kingpin.CmdClause) *bool {
+	return cmd.Flag("two-phase", "Stage and validate the update JSON (asset, digest and signature) before promoting it").Bool()
+}
+
+// verifyFlag adds the --verify flag for promotion commands
+func verifyFlag(cmd *kingpin.CmdClause) *bool {
+	return cmd.Flag("verify", "Check that all of the release's files exist before promoting it").Bool()
+}
+
+// verifyCopyFlag adds the --verify-copy flag for promotion commands
+func verifyCopyFlag(cmd *kingpin.CmdClause) *bool {
+	return cmd.Flag("verify-copy", "Check that the promoted update JSON matches its source after copying it").Bool()
+}
+
+// requireCIFlag adds the --require-ci flag for promotion commands
+func requireCIFlag(cmd *kingpin.CmdClause) *string {
+	return cmd.Flag("require-ci", "Only promote if CI passed for the release's commit, like client:ci/linux,ci/darwin").String()
+}
+
+// timeoutFlag adds the --timeout flag for promotion commands
+func timeoutFlag(cmd *kingpin.CmdClause) *time.Duration {
+	return cmd.Flag("timeout", "Give up on S3 requests after this long, e.g. 10m (0 for none)").Duration()
+}
+
+// withTimeout returns a context that's canceled after timeout, if set
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// deadlineGrace is how long a command has to stop on its own once the
+// --deadline passes, before it's stopped
+const deadlineGrace = 10 * time.Second
+
+// newClient returns an S3 client whose requests are made with ctx, so they're
+// canceled at the --deadline
+func newClient(ctx context.Context) *update.Client {
+	return newClientWithOptions(ctx, update.ClientOptions{})
+}
+
+// newClientWithOptions is newClient with options for promoting releases. The
+// ACL is always --acl.
+func newClientWithOptions(ctx context.Context, opts update.ClientOptions) *update.Client {
+	opts.ACL = *appACL
+	client, err := update.NewClientWithOptions(opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return client.WithContext(ctx)
+}
+
+// requireCI only allows promoting releases whose commit passed CI, for a
+// requirement (see --require-ci), if set
+func requireCI(requirement string) update.PromotionCheck {
+	if requirement == "" {
+		return nil
+	}
+	repo, contexts, err := gh.ParseCIRequirement(requirement)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return func(release update.Release) error {
+		if release.Commit == "" {
+			return fmt.Errorf("No commit in release %s", release.Name)
+		}
+		return gh.CheckCI(githubToken(false), repo, release.Commit, contexts)
+	}
+}
+
 func tag(version string) string {
 	return fmt.Sprintf("v%s", version)
 }
 
+// promoteARelease promotes a release (by version), and for prod (without a
+// dest prefix) copies it to latest and tells the API server
+func promoteARelease(client *update.Client, releaseName string, bucketName string, platform string, env string, destPrefix string, dryRun bool) {
+	release, err := client.PromoteARelease(releaseName, bucketName, platform, env, dryRun)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if env != update.EnvProd || destPrefix != "" {
+		log.Printf("Not copying latest or notifying API server for %s env (dest prefix %q)", env, destPrefix)
+		return
+	}
+	err = client.CopyLatest(bucketName, platform, dryRun)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if release == nil {
+		log.Fatal("No release found")
+	} else {
+		_, err := update.KBWebPromote(keybaseToken(!dryRun), release.Version, platform, dryRun)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// mirrorToGithub creates a Github release (if there isn't one) for a release
+// published to S3, and uploads its files that the Github release doesn't
+// have (see github.MirrorRelease)
+func mirrorToGithub(bucketName string, platformName string, releaseVersion string, repo string, token string) error {
+	platform, err := update.PlatformNamed(platformName)
+	if err != nil {
+		return err
+	}
+	keys, err := platform.Files(releaseVersion)
+	if err != nil {
+		return err
+	}
+	return gh.MirrorRelease(token, repo, tag(releaseVersion), keys, func(key string, path string) error {
+		return update.DownloadObject(bucketName, key, path)
+	})
+}
+
+// confirm asks a yes/no question on stdin
+func confirm(question string) bool {
+	fmt.Fprintf(os.Stdout, "%s (y/n) ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
 var (
-	app               = kingpin.New("release", "Release tool for build and release scripts")
-	latestVersionCmd  = app.Command("latest-version", "Get latest version of a Github repo")
-	latestVersionUser = latestVersionCmd.Flag("user", "Github user").Required().String()
-	latestVersionRepo = latestVersionCmd.Flag("repo", "Repository name").Required().String()
+	app                 = kingpin.New("release", "Release tool for build and release scripts")
+	appDeadline         = app.Flag("deadline", "Maximum duration for the whole command, e.g. 30m (0 for none). S3 requests are canceled at the deadline; commands without S3 requests are stopped shortly after.").Duration()
+	appS3Concurrency    = app.Flag("s3-concurrency", "Maximum S3 requests at once (0 for unlimited)").Int()
+	appMaxRPS           = app.Flag("max-rps", "Maximum S3 requests per second (0 for unlimited)").Float64()
+	appS3MaxAttempts    = app.Flag("s3-max-attempts", "Maximum attempts for S3 copies and uploads with transient errors").Default(strconv.Itoa(update.DefaultRetry.MaxAttempts)).Int()
+	appGithubRetries    = app.Flag("github-rate-limit-retries", "Times to retry a Github request that's rate limited, after waiting for the limit to reset").Default(strconv.Itoa(gh.DefaultRateLimitRetries)).Int()
+	appMaxBandwidth     = app.Flag("max-bandwidth", "Maximum bytes per second to upload or download (0 for unlimited)").Int64()
+	appGithubTokens     = app.Flag("github-token", "Github token (repeatable, to fail over when one is rate limited); defaults to GITHUB_TOKEN").Strings()
+	appKeybaseToken     = app.Flag("keybase-token", "Keybase admin token: env:NAME, file:/path or the token").Default("env:KEYBASE_TOKEN").String()
+	appACL              = app.Flag("acl", "Canned ACL for objects written to S3").Default("public-read").Enum(update.ACLs...)
+	appMetricsFile      = app.Flag("metrics-file", "File to write Prometheus textfile metrics to (for node_exporter)").String()
+	appUpdateJSONName   = app.Flag("update-json-name", "Template for channels' update JSON names, with .Platform, .Env and .Channel").Default(update.DefaultUpdateJSONNameTemplate).String()
+	appTimezone         = app.Flag("timezone", "Timezone for dates and the promotion cutoff hour (UTC, Local or an IANA name)").Default(update.DefaultTimezone).String()
+	appNotifyURL        = app.Flag("notify-url", "URL (like a Slack webhook) to post JSON to after each promotion").String()
+	latestVersionCmd    = app.Command("latest-version", "Get latest version of a Github repo")
+	latestVersionUser   = latestVersionCmd.Flag("user", "Github user").Required().String()
+	latestVersionRepo   = latestVersionCmd.Flag("repo", "Repository name").Required().String()
+	latestVersionPre    = latestVersionCmd.Flag("include-prereleases", "Latest release, including prereleases").Bool()
+	latestVersionStable = latestVersionCmd.Flag("stable-only", "Latest release, excluding prereleases").Bool()
+
+	s3LatestVersionCmd        = app.Command("s3-latest-version", "Get the version of the current update in a bucket")
+	s3LatestVersionBucketName = s3LatestVersionCmd.Flag("bucket-name", "Bucket name to use").Required().String()
+	s3LatestVersionPlatform   = s3LatestVersionCmd.Flag("platform", "Platform (darwin, darwin-arm64, linux, windows)").Required().String()
+	s3LatestVersionChannel    = s3LatestVersionCmd.Flag("channel", "Channel (like test-v2); defaults to the platform's public channel").String()
+	s3LatestVersionEnv        = s3LatestVersionCmd.Flag("env", "Environment").Default(update.EnvProd).Enum(update.Envs...)
 
 	platformCmd = app.Command("platform", "Get the OS platform name")
 
@@ -50,9 +217,10 @@ var (
 	urlRepo    = urlCmd.Flag("repo", "Repository name").Required().String()
 	urlVersion = urlCmd.Flag("version", "Version").Required().String()
 
-	createCmd     = app.Command("create", "Create a Github release")
-	createRepo    = createCmd.Flag("repo", "Repository name").Required().String()
-	createVersion = createCmd.Flag("version", "Version").Required().String()
+	createCmd        = app.Command("create", "Create a Github release")
+	createRepo       = createCmd.Flag("repo", "Repository name").Required().String()
+	createVersion    = createCmd.Flag("version", "Version").Required().String()
+	createIdempotent = createCmd.Flag("idempotent", "Succeed if the release already exists").Bool()
 
 	uploadCmd     = app.Command("upload", "Upload a file to a Github release")
 	uploadRepo    = uploadCmd.Flag("repo", "Repository name").Required().String()
@@ -60,51 +228,255 @@ var (
 	uploadSrc     = uploadCmd.Flag("src", "Source file").Required().ExistingFile()
 	uploadDest    = uploadCmd.Flag("dest", "Destination file").String()
 
-	downloadCmd     = app.Command("download", "Download a file from a Github release")
-	downloadRepo    = downloadCmd.Flag("repo", "Repository name").Required().String()
-	downloadVersion = downloadCmd.Flag("version", "Version").Required().String()
-	downloadSrc     = downloadCmd.Flag("src", "Source file").Required().ExistingFile()
+	downloadCmd      = app.Command("download", "Download a file from a Github release")
+	downloadRepo     = downloadCmd.Flag("repo", "Repository name").Required().String()
+	downloadVersion  = downloadCmd.Flag("version", "Version").Required().String()
+	downloadSrc      = downloadCmd.Flag("src", "Source file").Required().ExistingFile()
+	downloadAttempts = downloadCmd.Flag("attempts", "Number of times to try the download").Default(strconv.Itoa(gh.DefaultDownloadAttempts)).Int()
 
 	updateJSONCmd         = app.Command("update-json", "Generate update.json file for updater")
 	updateJSONVersion     = updateJSONCmd.Flag("version", "Version").Required().String()
 	updateJSONSrc         = updateJSONCmd.Flag("src", "Source file").ExistingFile()
-	updateJSONURI         = updateJSONCmd.Flag("uri", "URI for location of files").URL()
+	updateJSONURI         = updateJSONCmd.Flag("uri", "URI for location of files (overrides --bucket-name and --prefix)").URL()
+	updateJSONBucketName  = updateJSONCmd.Flag("bucket-name", "Bucket name for location of files").String()
+	updateJSONPrefix      = updateJSONCmd.Flag("prefix", "Prefix in bucket for location of files, e.g. darwin-updates/").String()
 	updateJSONSignature   = updateJSONCmd.Flag("signature", "Signature file").ExistingFile()
 	updateJSONDescription = updateJSONCmd.Flag("description", "Description file").ExistingFile()
 	updateJSONProps       = updateJSONCmd.Flag("prop", "Properties to include").Strings()
+	updateJSONNoDigest    = updateJSONCmd.Flag("no-digest", "Use a placeholder digest instead of hashing --src, to preview the JSON").Bool()
+	updateJSONOS          = updateJSONCmd.Flag("os", "OS the asset is for").Enum(update.PlatformTypeDarwin, update.PlatformTypeLinux, update.PlatformTypeWindows)
+	updateJSONArch        = updateJSONCmd.Flag("arch", "Arch the asset is for").Enum(update.ArchAmd64, update.ArchArm64)
+	updateJSONPublishedAt = updateJSONCmd.Flag("published-at", "Published time (RFC3339), overriding the date in the version or --src modification time").String()
+	updateJSONRollout     = updateJSONCmd.Flag("rollout", "Percentage (0-100) of users to roll the update out to").Default(strconv.Itoa(update.FullRollout)).Int()
+	updateJSONDigest      = updateJSONCmd.Flag("digest", "Digest algorithm for the asset (sha512 adds a SHA-512 digest to the SHA-256 one)").Default(update.DigestSHA256).Enum(update.DigestAlgorithms...)
+
+	updateJSONManifestCmd         = app.Command("update-json-manifest", "Generate update.json files for all platforms in a manifest")
+	updateJSONManifestPath        = updateJSONManifestCmd.Flag("manifest", "Manifest (JSON) describing each platform's update").Required().ExistingFile()
+	updateJSONManifestEnv         = updateJSONManifestCmd.Flag("env", "Environment").Default(update.EnvProd).Enum(update.Envs...)
+	updateJSONManifestDestDir     = updateJSONManifestCmd.Flag("dest-dir", "Directory to write to").String()
+	updateJSONManifestBucketName  = updateJSONManifestCmd.Flag("bucket-name", "Bucket name to upload to").String()
+	updateJSONManifestConcurrency = updateJSONManifestCmd.Flag("concurrency", "Number of files to hash at once").Default(strconv.Itoa(update.DefaultDigestConcurrency)).Int()
 
 	indexHTMLCmd        = app.Command("index-html", "Generate index.html for s3 bucket")
-	indexHTMLBucketName = indexHTMLCmd.Flag("bucket-name", "Bucket name to index").Required().String()
-	indexHTMLPrefixes   = indexHTMLCmd.Flag("prefixes", "Prefixes to include (comma-separated)").Required().String()
+	indexHTMLBucketName = indexHTMLCmd.Flag("bucket-name", "Bucket name to index (required unless --from-dir)").String()
+	indexHTMLPrefixes   = indexHTMLCmd.Flag("prefixes", "Prefixes to include (comma-separated, required unless --from-dir or --all-prefixes)").String()
+	indexHTMLAll        = indexHTMLCmd.Flag("all-prefixes", "Include the prefixes of all platforms (unless --prefixes)").Bool()
+	indexHTMLFromDir    = indexHTMLCmd.Flag("from-dir", "Index a local directory instead of a bucket (writes to --dest only)").ExistingDir()
 	indexHTMLSuffix     = indexHTMLCmd.Flag("suffix", "Suffix of files").String()
-	indexHTMLDest       = indexHTMLCmd.Flag("dest", "Write to file").String()
+	indexHTMLDest       = indexHTMLCmd.Flag("dest", "Write to file (without --dest or --upload, writes to stdout)").String()
 	indexHTMLUpload     = indexHTMLCmd.Flag("upload", "Upload to S3").String()
+	indexHTMLJSONDest   = indexHTMLCmd.Flag("json-dest", "Write JSON index to file").String()
+	indexHTMLJSONUpload = indexHTMLCmd.Flag("json-upload", "Upload JSON index to S3").String()
+	indexHTMLSignCmd    = indexHTMLCmd.Flag("sign-command", "Shell command to sign the JSON index (data on stdin, signature on stdout)").String()
+	indexHTMLSignKey    = indexHTMLCmd.Flag("sign-key", "Ed25519 private key (PEM) to sign the JSON index").ExistingFile()
+	indexHTMLChannels   = indexHTMLCmd.Flag("channels", "Show which channels releases are promoted to").Bool()
+	indexHTMLDryRun     = indexHTMLCmd.Flag("dry-run", "Generate (and write to --dest) without uploading").Bool()
+	indexHTMLStrict     = indexHTMLCmd.Flag("strict", "Fail if any file's version can't be parsed").Bool()
+	indexHTMLMarkLatest = indexHTMLCmd.Flag("mark-latest", "Mark the current (or newest) release of each prefix as latest").Bool()
+	indexHTMLLimit      = indexHTMLCmd.Flag("limit", "Most releases to include per prefix (0 for all)").Default(strconv.Itoa(update.DefaultHTMLLimit)).Int()
+	indexHTMLSinceDays  = indexHTMLCmd.Flag("since-days", "Only include releases from the last this many days (0 for all)").Int()
+	indexHTMLOrder      = indexHTMLCmd.Flag("order", "Order releases newest (desc) or oldest (asc) first").Default(update.OrderDesc).Enum(update.OrderDesc, update.OrderAsc)
+	indexHTMLGroupBy    = indexHTMLCmd.Flag("group-by", "Group sections by prefix or version").Default(update.GroupByPrefix).Enum(update.GroupByPrefix, update.GroupByVersion)
 
 	parseVersionCmd    = app.Command("version-parse", "Parse a sematic version string")
 	parseVersionString = parseVersionCmd.Arg("version", "Semantic version to parse").Required().String()
 
+	bumpVersionCmd    = app.Command("version-bump", "Print the next semantic version after a version")
+	bumpVersionString = bumpVersionCmd.Arg("version", "Semantic version to bump").Required().String()
+	bumpVersionType   = bumpVersionCmd.Arg("bump", "What to bump (major, minor, patch, prerelease, build)").Required().Enum(version.BumpTypes...)
+
+	compareVersionCmd    = app.Command("version-compare", "Print -1, 0 or 1 if a semantic version is less than, equal to or greater than another")
+	compareVersionA      = compareVersionCmd.Arg("a", "Semantic version").Required().String()
+	compareVersionB      = compareVersionCmd.Arg("b", "Semantic version to compare to").Required().String()
+	compareVersionAssert = compareVersionCmd.Flag("assert", "Exit with an error unless a is lt, le, eq, ge or gt b").Enum("lt", "le", "eq", "ge", "gt")
+
 	promoteReleasesCmd        = app.Command("promote-releases", "Promote releases")
 	promoteReleasesBucketName = promoteReleasesCmd.Flag("bucket-name", "Bucket name to use").Required().String()
-	promoteReleasesPlatform   = promoteReleasesCmd.Flag("platform", "Platform (darwin, linux, windows)").Required().String()
+	promoteReleasesPlatform   = promoteReleasesCmd.Flag("platform", "Platform(s), comma-separated (darwin, linux, windows)").Required().String()
+	promoteReleasesParallel   = promoteReleasesCmd.Flag("parallel", "Promote platforms concurrently").Bool()
+	promoteReleasesEnv        = promoteReleasesCmd.Flag("env", "Environment").Default(update.EnvProd).Enum(update.Envs...)
+	promoteReleasesDestPrefix = destPrefixFlag(promoteReleasesCmd)
+	promoteReleasesTwoPhase   = twoPhaseFlag(promoteReleasesCmd)
+	promoteReleasesVerify     = verifyFlag(promoteReleasesCmd)
+	promoteReleasesVerifyCopy = verifyCopyFlag(promoteReleasesCmd)
+	promoteReleasesRequireCI  = requireCIFlag(promoteReleasesCmd)
+	promoteReleasesForce      = promoteReleasesCmd.Flag("force", "Promote even if the release is unchanged or older than the current one").Bool()
+	promoteReleasesTimeout    = timeoutFlag(promoteReleasesCmd)
+	promoteReleasesDryRun     = promoteReleasesCmd.Flag("dry-run", "Announce what would be done without doing it").Bool()
 
 	promoteAReleaseCmd        = app.Command("promote-a-release", "Promote a specific release")
 	releaseToPromote          = promoteAReleaseCmd.Flag("release", "Specific release to promote to public").Required().String()
 	promoteAReleaseBucketName = promoteAReleaseCmd.Flag("bucket-name", "Bucket name to use").Required().String()
-	promoteAReleasePlatform   = promoteAReleaseCmd.Flag("platform", "Platform (darwin, linux, windows)").Required().String()
+	promoteAReleasePlatform   = promoteAReleaseCmd.Flag("platform", "Platform (darwin, darwin-arm64, deb, rpm, windows)").Required().String()
 	promoteAReleaseDryRun     = promoteAReleaseCmd.Flag("dry-run", "Announce what would be done without doing it").Bool()
+	promoteAReleaseEnv        = promoteAReleaseCmd.Flag("env", "Environment").Default(update.EnvProd).Enum(update.Envs...)
+	promoteAReleaseDestPrefix = destPrefixFlag(promoteAReleaseCmd)
+	promoteAReleaseTwoPhase   = twoPhaseFlag(promoteAReleaseCmd)
+	promoteAReleaseVerify     = verifyFlag(promoteAReleaseCmd)
+	promoteAReleaseVerifyCopy = verifyCopyFlag(promoteAReleaseCmd)
+	promoteAReleaseRequireCI  = requireCIFlag(promoteAReleaseCmd)
+	promoteAReleaseTimeout    = timeoutFlag(promoteAReleaseCmd)
+
+	promoteByCommitCmd        = app.Command("promote-by-commit", "Promote the release built from a commit")
+	promoteByCommitCommit     = promoteByCommitCmd.Flag("commit", "Commit (short or full SHA) of the release").Required().String()
+	promoteByCommitBucketName = promoteByCommitCmd.Flag("bucket-name", "Bucket name to use").Required().String()
+	promoteByCommitPlatform   = promoteByCommitCmd.Flag("platform", "Platform (darwin, darwin-arm64, windows)").Required().String()
+	promoteByCommitDryRun     = promoteByCommitCmd.Flag("dry-run", "Announce what would be done without doing it").Bool()
+	promoteByCommitEnv        = promoteByCommitCmd.Flag("env", "Environment").Default(update.EnvProd).Enum(update.Envs...)
+	promoteByCommitDestPrefix = destPrefixFlag(promoteByCommitCmd)
+	promoteByCommitTwoPhase   = twoPhaseFlag(promoteByCommitCmd)
+	promoteByCommitVerify     = verifyFlag(promoteByCommitCmd)
+	promoteByCommitVerifyCopy = verifyCopyFlag(promoteByCommitCmd)
+	promoteByCommitRequireCI  = requireCIFlag(promoteByCommitCmd)
+	promoteByCommitTimeout    = timeoutFlag(promoteByCommitCmd)
+
+	copyLatestCmd        = app.Command("copy-latest", "Copy the promoted release to the fixed latest path (e.g. Keybase.dmg)")
+	copyLatestBucketName = copyLatestCmd.Flag("bucket-name", "Bucket name to use").Required().String()
+	copyLatestPlatform   = copyLatestCmd.Flag("platform", "Platform (darwin, darwin-arm64, linux, deb, rpm, windows). darwin includes darwin-arm64.").Required().String()
+	copyLatestDryRun     = copyLatestCmd.Flag("dry-run", "Announce what would be done without doing it").Bool()
+	copyLatestTimeout    = timeoutFlag(copyLatestCmd)
 
 	brokenReleaseCmd          = app.Command("broken-release", "Mark a release as broken")
 	brokenReleaseName         = brokenReleaseCmd.Flag("release", "Release to mark as broken").Required().String()
 	brokenReleaseBucketName   = brokenReleaseCmd.Flag("bucket-name", "Bucket name to use").Required().String()
-	brokenReleasePlatformName = brokenReleaseCmd.Flag("platform", "Platform (darwin, linux, windows)").Required().String()
+	brokenReleasePlatformName = brokenReleaseCmd.Flag("platform", "Platform (darwin, darwin-arm64, linux, deb, rpm, windows). darwin is only Intel.").Required().String()
+
+	deleteReleaseCmd        = app.Command("delete-release", "Permanently delete a release's files (see broken-release to keep them)")
+	deleteReleaseName       = deleteReleaseCmd.Flag("release", "Release to delete").Required().String()
+	deleteReleaseBucketName = deleteReleaseCmd.Flag("bucket-name", "Bucket name to use").Required().String()
+	deleteReleasePlatform   = deleteReleaseCmd.Flag("platform", "Platform (darwin, darwin-arm64, linux, deb, rpm, windows). darwin is only Intel.").Required().String()
+	deleteReleaseConfirm    = deleteReleaseCmd.Flag("confirm", "Confirm deleting the release, which can't be undone").Bool()
+	deleteReleaseForce      = deleteReleaseCmd.Flag("force", "Delete the release even if it's the current update for a channel").Bool()
+
+	renameReleaseCmd        = app.Command("rename-release", "Move a misnamed release's files to the right version")
+	renameReleaseBucketName = renameReleaseCmd.Flag("bucket-name", "Bucket name to use").Required().String()
+	renameReleasePlatform   = renameReleaseCmd.Flag("platform", "Platform (darwin, darwin-arm64, windows)").Required().String()
+	renameReleaseOld        = renameReleaseCmd.Flag("old-version", "Version the release was uploaded as").Required().String()
+	renameReleaseNew        = renameReleaseCmd.Flag("new-version", "Version to rename the release to").Required().String()
+	renameReleaseConfirm    = renameReleaseCmd.Flag("confirm", "Rename (otherwise only shows what would be renamed)").Bool()
+
+	listBrokenCmd        = app.Command("list-broken", "List the releases marked as broken")
+	listBrokenBucketName = listBrokenCmd.Flag("bucket-name", "Bucket name to use").Required().String()
+	listBrokenOutput     = outputFlag(listBrokenCmd)
+
+	copyEnvCmd        = app.Command("copy-env", "Copy a channel's update JSON from one environment to another, like staging to prod")
+	copyEnvBucketName = copyEnvCmd.Flag("bucket-name", "Bucket name to use").Required().String()
+	copyEnvPlatform   = copyEnvCmd.Flag("platform", "Platform (darwin, darwin-arm64, linux, windows)").Required().String()
+	copyEnvChannel    = copyEnvCmd.Flag("channel", "Channel (empty for the default)").String()
+	copyEnvFrom       = copyEnvCmd.Flag("from", "Environment to copy from").Default(update.EnvStaging).Enum(update.Envs...)
+	copyEnvTo         = copyEnvCmd.Flag("to", "Environment to copy to").Default(update.EnvProd).Enum(update.Envs...)
+	copyEnvTwoPhase   = twoPhaseFlag(copyEnvCmd)
+	copyEnvVerifyCopy = verifyCopyFlag(copyEnvCmd)
 
 	promoteTestReleasesCmd        = app.Command("promote-test-releases", "Promote test releases")
 	promoteTestReleasesBucketName = promoteTestReleasesCmd.Flag("bucket-name", "Bucket name to use").Required().String()
 	promoteTestReleasesPlatform   = promoteTestReleasesCmd.Flag("platform", "Platform (darwin, linux, windows)").Required().String()
 	promoteTestReleasesRelease    = promoteTestReleasesCmd.Flag("release", "Specific release to promote to test").String()
+	promoteTestReleasesEnv        = promoteTestReleasesCmd.Flag("env", "Environment").Default(update.EnvProd).Enum(update.Envs...)
+	promoteTestReleasesDestPrefix = destPrefixFlag(promoteTestReleasesCmd)
+	promoteTestReleasesTimeout    = timeoutFlag(promoteTestReleasesCmd)
 
 	updatesReportCmd        = app.Command("updates-report", "Summary of updates/releases")
 	updatesReportBucketName = updatesReportCmd.Flag("bucket-name", "Bucket name to use").Required().String()
+	updatesReportEnv        = updatesReportCmd.Flag("env", "Environment").Default(update.EnvProd).Enum(update.Envs...)
+	updatesReportFormat     = updatesReportCmd.Flag("format", "Output format (table, json)").Default(update.OutputTable).Enum(update.Outputs...)
+
+	checkLockstepCmd        = app.Command("check-lockstep", "Check that all platforms have the same promoted version")
+	checkLockstepBucketName = checkLockstepCmd.Flag("bucket-name", "Bucket name to use").Required().String()
+	checkLockstepVersion    = checkLockstepCmd.Flag("version", "Expected version (defaults to the version most platforms are at)").String()
+	checkLockstepOutput     = outputFlag(checkLockstepCmd)
+
+	warmCDNCmd        = app.Command("warm-cdn", "Download the promoted assets through the CDN so they're cached")
+	warmCDNBucketName = warmCDNCmd.Flag("bucket-name", "Bucket name to use").Required().String()
+	warmCDNPlatform   = warmCDNCmd.Flag("platform", "Platform(s), comma-separated (darwin, darwin-arm64, linux, windows); defaults to all").String()
+	warmCDNRegions    = warmCDNCmd.Flag("resolver", "DNS resolver to warm the nearest edge of (repeatable); defaults to the system resolver").Strings()
+
+	reverifyCmd         = app.Command("reverify", "Check that promoted assets still match the digests in their update JSON")
+	reverifyBucketName  = reverifyCmd.Flag("bucket-name", "Bucket name to use").Required().String()
+	reverifyPlatform    = reverifyCmd.Flag("platform", "Platform (darwin, darwin-arm64, linux, windows)").Required().String()
+	reverifyConcurrency = reverifyCmd.Flag("concurrency", "Assets to download at once").Default(strconv.Itoa(update.DefaultDigestConcurrency)).Int()
+	reverifyOutput      = outputFlag(reverifyCmd)
+
+	verifyUpdateCmd        = app.Command("verify-update", "Check that an update's asset matches its digest (and signature)")
+	verifyUpdateJSON       = verifyUpdateCmd.Flag("json", "Update JSON file (otherwise the current update in --bucket-name)").ExistingFile()
+	verifyUpdateBucketName = verifyUpdateCmd.Flag("bucket-name", "Bucket name to use").String()
+	verifyUpdatePlatform   = verifyUpdateCmd.Flag("platform", "Platform (darwin, darwin-arm64, linux, windows)").String()
+	verifyUpdateChannel    = verifyUpdateCmd.Flag("channel", "Channel (the platform's public channel if not specified)").String()
+	verifyUpdateEnv        = verifyUpdateCmd.Flag("env", "Environment").Default(update.EnvProd).Enum(update.Envs...)
+	verifyUpdateAsset      = verifyUpdateCmd.Flag("asset", "Asset file to check (otherwise the asset URL is downloaded)").ExistingFile()
+	verifyUpdateVerify     = verifyUpdateCmd.Flag("verify", "Also check the asset's signature with this ed25519 public key (PEM)").ExistingFile()
+
+	combinedManifestCmd        = app.Command("combined-manifest", "Generate a manifest of every platform's current update")
+	combinedManifestBucketName = combinedManifestCmd.Flag("bucket-name", "Bucket name to use").Required().String()
+	combinedManifestEnv        = combinedManifestCmd.Flag("env", "Environment").Default(update.EnvProd).Enum(update.Envs...)
+	combinedManifestChannel    = combinedManifestCmd.Flag("channel", "Channel (each platform's public channel if not specified)").String()
+	combinedManifestDest       = combinedManifestCmd.Flag("dest", "Write to file (without --dest or --upload, writes to stdout)").String()
+	combinedManifestUpload     = combinedManifestCmd.Flag("upload", "Upload to S3").String()
+
+	verifyReplicationCmd      = app.Command("verify-replication", "Verify a replica bucket's update JSON matches the primary's")
+	verifyReplicationPrimary  = verifyReplicationCmd.Flag("bucket-name", "Primary bucket name").Required().String()
+	verifyReplicationReplica  = verifyReplicationCmd.Flag("replica-bucket-name", "Replica bucket name (can be in another region)").Required().String()
+	verifyReplicationPlatform = verifyReplicationCmd.Flag("platform", "Platform (darwin, darwin-arm64, linux, windows)").Required().String()
+	verifyReplicationChannel  = verifyReplicationCmd.Flag("channel", "Channel, like v2 (empty for none, like linux's public channel)").String()
+	verifyReplicationEnv      = verifyReplicationCmd.Flag("env", "Environment").Default(update.EnvProd).Enum(update.Envs...)
+
+	diffUpdateCmd        = app.Command("diff-update", "Show what changed in the update JSON between two versions")
+	diffUpdateBucketName = diffUpdateCmd.Flag("bucket-name", "Bucket name to use").Required().String()
+	diffUpdatePlatform   = diffUpdateCmd.Flag("platform", "Platform (darwin, darwin-arm64, windows)").Required().String()
+	diffUpdateEnv        = diffUpdateCmd.Flag("env", "Environment").Default(update.EnvProd).Enum(update.Envs...)
+	diffUpdateA          = diffUpdateCmd.Flag("version-a", "Version to diff from (like the previous version)").Required().String()
+	diffUpdateB          = diffUpdateCmd.Flag("version-b", "Version to diff to (like the current version)").Required().String()
+
+	presignCmd        = app.Command("presign", "Print a presigned (time-limited) download URL for an object in a private bucket")
+	presignBucketName = presignCmd.Flag("bucket-name", "Bucket name to use").Required().String()
+	presignKey        = presignCmd.Flag("key", "Key of the object").Required().String()
+	presignTTL        = presignCmd.Flag("ttl", "How long the URL is valid for (at most 168h)").Default("24h").Duration()
+
+	verifyReleaseCmd        = app.Command("verify-release", "Check that all of a release's files exist")
+	verifyReleaseBucketName = verifyReleaseCmd.Flag("bucket-name", "Bucket name to use").Required().String()
+	verifyReleasePlatform   = verifyReleaseCmd.Flag("platform", "Platform (darwin, darwin-arm64, windows)").Required().String()
+	verifyReleaseVersion    = verifyReleaseCmd.Flag("version", "Version of the release").Required().String()
+
+	findDuplicatesCmd        = app.Command("find-duplicates", "Find versions uploaded more than once at a prefix")
+	findDuplicatesBucketName = findDuplicatesCmd.Flag("bucket-name", "Bucket name to use").Required().String()
+	findDuplicatesPrefix     = findDuplicatesCmd.Flag("prefix", "Prefix (like darwin/)").Required().String()
+	findDuplicatesSuffix     = findDuplicatesCmd.Flag("suffix", "Suffix of files").String()
+	findDuplicatesOutput     = outputFlag(findDuplicatesCmd)
+
+	checkLatestCmd        = app.Command("check-latest", "Check that the latest download for each platform matches the promoted release")
+	checkLatestBucketName = checkLatestCmd.Flag("bucket-name", "Bucket name to use").Required().String()
+	checkLatestOutput     = outputFlag(checkLatestCmd)
+
+	historyCmd        = app.Command("history", "Timeline of promoted versions for a platform")
+	historyBucketName = historyCmd.Flag("bucket-name", "Bucket name to use").Required().String()
+	historyPlatform   = historyCmd.Flag("platform", "Platform (darwin, darwin-arm64, windows)").Required().String()
+	historyChannel    = historyCmd.Flag("channel", "Channel").Default("v2").String()
+	historyOutput     = outputFlag(historyCmd)
+
+	waitForPublishedCmd      = app.Command("wait-for-published", "Wait until the public update JSON reports a version")
+	waitForPublishedURL      = waitForPublishedCmd.Flag("url", "URL of the public update JSON").Required().String()
+	waitForPublishedVersion  = waitForPublishedCmd.Flag("version", "Version to wait for").Required().String()
+	waitForPublishedTimeout  = waitForPublishedCmd.Flag("timeout", "How long to wait").Default("10m").Duration()
+	waitForPublishedInterval = waitForPublishedCmd.Flag("interval", "Delay between checks").Default("15s").Duration()
+
+	reconstructSupportCmd        = app.Command("reconstruct-support", "Restore a missing versioned update JSON from a channel pointing to it")
+	reconstructSupportBucketName = reconstructSupportCmd.Flag("bucket-name", "Bucket name to use").Required().String()
+	reconstructSupportPlatform   = reconstructSupportCmd.Flag("platform", "Platform (darwin, darwin-arm64, windows)").Required().String()
+	reconstructSupportEnv        = reconstructSupportCmd.Flag("env", "Environment").Default(update.EnvProd).Enum(update.Envs...)
+	reconstructSupportVersion    = reconstructSupportCmd.Flag("version", "Version of the update JSON").Required().String()
+
+	backupUpdatesCmd        = app.Command("backup-updates", "Save all update JSONs in a bucket to a directory")
+	backupUpdatesBucketName = backupUpdatesCmd.Flag("bucket-name", "Bucket name to use").Required().String()
+	backupUpdatesDestDir    = backupUpdatesCmd.Flag("dest-dir", "Directory to save to").Required().String()
+
+	restoreUpdatesCmd        = app.Command("restore-updates", "Upload update JSONs saved by backup-updates")
+	restoreUpdatesBucketName = restoreUpdatesCmd.Flag("bucket-name", "Bucket name to use").Required().String()
+	restoreUpdatesSrcDir     = restoreUpdatesCmd.Flag("src-dir", "Directory to restore from").Required().ExistingDir()
+	restoreUpdatesYes        = restoreUpdatesCmd.Flag("yes", "Don't ask for confirmation").Bool()
+
+	validateNamesCmd        = app.Command("validate-names", "Check which object names in a bucket have a parsable version")
+	validateNamesBucketName = validateNamesCmd.Flag("bucket-name", "Bucket name to use").Required().String()
+	validateNamesPrefix     = validateNamesCmd.Flag("prefix", "Prefix (directory) to check").Required().String()
 
 	saveLogCmd        = app.Command("save-log", "Save log")
 	saveLogBucketName = saveLogCmd.Flag("bucket-name", "Bucket name to use").Required().String()
@@ -112,9 +484,29 @@ var (
 	saveLogNoErr      = saveLogCmd.Flag("noerr", "No error status on failure").Bool()
 	saveLogMaxSize    = saveLogCmd.Flag("maxsize", "Max size, (default 102400)").Default("102400").Int64()
 
-	latestCommitCmd      = app.Command("latest-commit", "Latests commit we can use to safely build from")
-	latestCommitRepo     = latestCommitCmd.Flag("repo", "Repository name").Required().String()
-	latestCommitContexts = latestCommitCmd.Flag("context", "Context to check for success").Required().Strings()
+	mirrorToGithubCmd        = app.Command("mirror-to-github", "Create a Github release with the files of a release published to S3")
+	mirrorToGithubBucketName = mirrorToGithubCmd.Flag("bucket-name", "Bucket name to use").Required().String()
+	mirrorToGithubPlatform   = mirrorToGithubCmd.Flag("platform", "Platform (darwin, darwin-arm64, windows)").Required().String()
+	mirrorToGithubVersion    = mirrorToGithubCmd.Flag("version", "Version").Required().String()
+	mirrorToGithubRepo       = mirrorToGithubCmd.Flag("repo", "Repository name").Required().String()
+
+	verifyChecksumsCmd     = app.Command("verify-checksums", "Verify a Github release's assets against its SHA256SUMS asset")
+	verifyChecksumsRepo    = verifyChecksumsCmd.Flag("repo", "Repository name").Required().String()
+	verifyChecksumsVersion = verifyChecksumsCmd.Flag("version", "Version").Required().String()
+
+	downloadCountsCmd       = app.Command("download-counts", "Show the download counts of a Github release's assets")
+	downloadCountsRepo      = downloadCountsCmd.Flag("repo", "Repository name").Required().String()
+	downloadCountsVersion   = downloadCountsCmd.Flag("version", "Version").Required().String()
+	downloadCountsSinceFile = downloadCountsCmd.Flag("since-file", "Snapshot of counts from a previous run, to show the change since").ExistingFile()
+	downloadCountsSaveFile  = downloadCountsCmd.Flag("save-file", "Save a snapshot of the counts (for --since-file)").String()
+
+	listAssetsCmd  = app.Command("list-assets", "List the assets of all Github releases, with sizes")
+	listAssetsRepo = listAssetsCmd.Flag("repo", "Repository name").Required().String()
+
+	latestCommitCmd         = app.Command("latest-commit", "Latests commit we can use to safely build from")
+	latestCommitRepo        = latestCommitCmd.Flag("repo", "Repository name").Required().String()
+	latestCommitContexts    = latestCommitCmd.Flag("context", "Context to check for success").Required().Strings()
+	latestCommitConcurrency = latestCommitCmd.Flag("concurrency", "Number of commits to check statuses for at once").Default(strconv.Itoa(gh.DefaultStatusConcurrency)).Int()
 
 	waitForCICmd      = app.Command("wait-ci", "Waits on a the latest commit being successful in CI")
 	waitForCIRepo     = waitForCICmd.Flag("repo", "Repository name").Required().String()
@@ -134,27 +526,116 @@ var (
 	setBuildInTestingEnable     = setBuildInTestingCmd.Flag("enable", "Enroll the build in smoketesting (boolish string)").Required().String()
 	setBuildInTestingMaxTesters = setBuildInTestingCmd.Flag("max-testers", "Max number of testers for this build").Required().Int()
 
+	pruneDraftsCmd       = app.Command("prune-drafts", "Delete draft Github releases older than a duration (logs what would be deleted unless --confirm)")
+	pruneDraftsRepo      = pruneDraftsCmd.Flag("repo", "Repository name").Required().String()
+	pruneDraftsOlderThan = pruneDraftsCmd.Flag("older-than", "Delete drafts created longer ago than this").Default("168h").Duration()
+	pruneDraftsConfirm   = pruneDraftsCmd.Flag("confirm", "Delete the drafts").Bool()
+
+	verifyTagCmd     = app.Command("verify-tag", "Verify a release's tag points to the commit we built")
+	verifyTagRepo    = verifyTagCmd.Flag("repo", "Repository name").Required().String()
+	verifyTagVersion = verifyTagCmd.Flag("version", "Version").Required().String()
+	verifyTagCommit  = verifyTagCmd.Flag("commit", "Expected commit (short or full SHA)").Required().String()
+
 	ciStatusesCmd    = app.Command("ci-statuses", "List statuses for CI")
 	ciStatusesRepo   = ciStatusesCmd.Flag("repo", "Repository name").Required().String()
 	ciStatusesCommit = ciStatusesCmd.Flag("commit", "Commit").Required().String()
 
+	findUnannouncedCmd        = app.Command("find-unannounced", "List recent releases in a bucket that were never announced to the API server")
+	findUnannouncedBucketName = findUnannouncedCmd.Flag("bucket-name", "Bucket name to use").Required().String()
+	findUnannouncedPlatform   = findUnannouncedCmd.Flag("platform", "Platform (darwin, darwin-arm64, linux, windows), or all if not specified").String()
+	findUnannouncedLimit      = findUnannouncedCmd.Flag("limit", "Number of recent releases to check per platform (0 for all)").Default(strconv.Itoa(update.DefaultUnannouncedLimit)).Int()
+	findUnannouncedOutput     = outputFlag(findUnannouncedCmd)
+
+	inTestingCmd      = app.Command("in-testing", "List the builds enrolled in smoketesting")
+	inTestingPlatform = inTestingCmd.Flag("platform", "Platform (darwin, linux, windows)").Required().String()
+
+	checkCACmd      = app.Command("check-ca", "Check the validity of the CA pinned for the API server")
+	checkCAWarnDays = checkCACmd.Flag("warn-days", "Warn if the CA expires within this many days").Default("90").Int()
+	checkCAFailDays = checkCACmd.Flag("fail-days", "Fail if the CA expires within this many days (by default, only if it's expired)").Int()
+
 	getWinBuildNumberCmd      = app.Command("winbuildnumber", "Atomically retrieve and increment build number for given version")
 	getWinBuildNumberVersion  = getWinBuildNumberCmd.Flag("version", "Major version, e.g. 1.0.30").Required().String()
-	getWinBuildNumberBotID    = getWinBuildNumberCmd.Flag("botid", "bot ID").Default("1").String()
-	getWinBuildNumberPlatform = getWinBuildNumberCmd.Flag("platform", "platform").Default("1").String()
+	getWinBuildNumberBotID    = getWinBuildNumberCmd.Flag("bot-id", "Build bot ID").Default("1").Int()
+	getWinBuildNumberPlatform = getWinBuildNumberCmd.Flag("platform-id", "Platform ID").Default("1").Int()
+	// Deprecated (hidden) names for --bot-id and --platform-id
+	getWinBuildNumberBotIDOld    = getWinBuildNumberCmd.Flag("botid", "Build bot ID").Hidden().Int()
+	getWinBuildNumberPlatformOld = getWinBuildNumberCmd.Flag("platform", "Platform ID").Hidden().Int()
 )
 
 func main() {
-	switch kingpin.MustParse(app.Parse(os.Args[1:])) {
+	command := kingpin.MustParse(app.Parse(os.Args[1:]))
+	update.SetThrottle(update.Throttle{Concurrency: *appS3Concurrency, MaxRPS: *appMaxRPS})
+	update.SetRetry(update.Retry{MaxAttempts: *appS3MaxAttempts, Backoff: update.DefaultRetry.Backoff})
+	update.SetNotifyURL(*appNotifyURL)
+	update.SetMetricsFile(*appMetricsFile)
+	update.SetMaxBandwidth(*appMaxBandwidth)
+	gh.SetMaxBandwidth(*appMaxBandwidth)
+	gh.SetRateLimitRetries(*appGithubRetries)
+	if err := update.SetTimezone(*appTimezone); err != nil {
+		log.Fatal(err)
+	}
+	if err := update.SetUpdateJSONNameTemplate(*appUpdateJSONName); err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if *appDeadline > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), *appDeadline)
+	}
+
+	start := time.Now()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		run(ctx, command)
+	}()
+
+	select {
+	case <-done:
+		cancel()
+		update.RecordCommand(command, time.Since(start))
+	case <-ctx.Done():
+		// S3 commands and wait-for-ci use ctx, so they stop on their own with
+		// an error. Other commands (Github and Keybase API requests) don't, so
+		// they're stopped if they're still running after a grace period.
+		select {
+		case <-done:
+			update.RecordCommand(command, time.Since(start))
+		case <-time.After(deadlineGrace):
+			log.Fatalf("Exceeded deadline of %s", *appDeadline)
+		}
+	}
+}
+
+func run(ctx context.Context, command string) {
+	switch command {
 	case latestVersionCmd.FullCommand():
-		tag, err := gh.LatestTag(*latestVersionUser, *latestVersionRepo, githubToken(false))
+		var filter *gh.ReleaseFilter
+		switch {
+		case *latestVersionPre && *latestVersionStable:
+			log.Fatal("Only one of --include-prereleases and --stable-only can be specified")
+		case *latestVersionPre:
+			filter = &gh.ReleaseFilter{Prereleases: true}
+		case *latestVersionStable:
+			filter = &gh.ReleaseFilter{}
+		}
+		tag, err := gh.LatestTag(*latestVersionUser, *latestVersionRepo, githubToken(false), filter)
 		if err != nil {
 			log.Fatal(err)
 		}
+		if tag == nil {
+			log.Fatal("No matching tag found")
+		}
 		if strings.HasPrefix(tag.Name, "v") {
 			version := tag.Name[1:]
 			fmt.Printf("%s", version)
 		}
+	case s3LatestVersionCmd.FullCommand():
+		latestVersion, err := newClient(ctx).LatestVersion(*s3LatestVersionBucketName, *s3LatestVersionPlatform, *s3LatestVersionChannel, *s3LatestVersionEnv)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%s", latestVersion)
 	case platformCmd.FullCommand():
 		fmt.Printf("%s", runtime.GOOS)
 
@@ -169,7 +650,9 @@ func main() {
 		}
 	case createCmd.FullCommand():
 		err := gh.CreateRelease(githubToken(true), *createRepo, tag(*createVersion), tag(*createVersion))
-		if err != nil {
+		if *createIdempotent && gh.IsAlreadyExists(err) {
+			log.Printf("Release %s already exists", tag(*createVersion))
+		} else if err != nil {
 			log.Fatal(err)
 		}
 	case uploadCmd.FullCommand():
@@ -181,24 +664,174 @@ func main() {
 		if err != nil {
 			log.Fatal(err)
 		}
+	case mirrorToGithubCmd.FullCommand():
+		if err := mirrorToGithub(*mirrorToGithubBucketName, *mirrorToGithubPlatform, *mirrorToGithubVersion, *mirrorToGithubRepo, githubToken(true)); err != nil {
+			log.Fatal(err)
+		}
+	case verifyChecksumsCmd.FullCommand():
+		if err := gh.VerifyReleaseChecksums(*verifyChecksumsRepo, *verifyChecksumsVersion, githubToken(false)); err != nil {
+			log.Fatal(err)
+		}
+	case downloadCountsCmd.FullCommand():
+		counts, err := gh.AssetDownloadCounts(*downloadCountsRepo, *downloadCountsVersion, githubToken(false))
+		if err != nil {
+			log.Fatal(err)
+		}
+		var deltas map[string]int
+		if *downloadCountsSinceFile != "" {
+			since, err := gh.ReadDownloadCounts(*downloadCountsSinceFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			deltas = gh.DownloadCountDeltas(counts, since)
+		}
+		names := []string{}
+		for name := range counts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		w := tabwriter.NewWriter(os.Stdout, 5, 0, 3, ' ', 0)
+		if deltas != nil {
+			fmt.Fprintln(w, "Name\tDownloads\tChange")
+		} else {
+			fmt.Fprintln(w, "Name\tDownloads")
+		}
+		for _, name := range names {
+			if deltas != nil {
+				fmt.Fprintf(w, "%s\t%d\t%+d\n", name, counts[name], deltas[name])
+			} else {
+				fmt.Fprintf(w, "%s\t%d\n", name, counts[name])
+			}
+		}
+		if err := w.Flush(); err != nil {
+			log.Fatal(err)
+		}
+		if *downloadCountsSaveFile != "" {
+			if err := gh.WriteDownloadCounts(*downloadCountsSaveFile, counts); err != nil {
+				log.Fatal(err)
+			}
+		}
+	case listAssetsCmd.FullCommand():
+		assets, err := gh.AllAssets("keybase", *listAssetsRepo, githubToken(false))
+		if err != nil {
+			log.Fatal(err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 5, 0, 3, ' ', 0)
+		fmt.Fprintln(w, "Name\tSize\tDownloads")
+		var totalSize, totalDownloads uint64
+		for _, asset := range assets {
+			fmt.Fprintf(w, "%s\t%d\t%d\n", asset.Name, asset.Size, asset.Downloads)
+			totalSize += asset.Size
+			totalDownloads += asset.Downloads
+		}
+		fmt.Fprintf(w, "Total (%d)\t%d\t%d\n", len(assets), totalSize, totalDownloads)
+		if err := w.Flush(); err != nil {
+			log.Fatal(err)
+		}
 	case downloadCmd.FullCommand():
 		defaultSrc := fmt.Sprintf("keybase-%s-%s.tgz", *downloadVersion, runtime.GOOS)
 		if *downloadSrc == "" {
 			downloadSrc = &defaultSrc
 		}
 		log.Printf("Downloading %s (%s)", *downloadSrc, tag(*downloadVersion))
-		err := gh.DownloadAsset(githubToken(false), *downloadRepo, tag(*downloadVersion), *downloadSrc)
+		err := gh.DownloadAsset(githubToken(false), *downloadRepo, tag(*downloadVersion), *downloadSrc, *downloadAttempts)
 		if err != nil {
 			log.Fatal(err)
 		}
 	case updateJSONCmd.FullCommand():
-		out, err := update.EncodeJSON(*updateJSONVersion, tag(*updateJSONVersion), *updateJSONDescription, *updateJSONProps, *updateJSONSrc, *updateJSONURI, *updateJSONSignature)
+		var uri fmt.Stringer
+		if *updateJSONURI != nil {
+			uri = *updateJSONURI
+		} else if *updateJSONBucketName != "" {
+			bucketURL, err := update.BucketURL(*updateJSONBucketName, *updateJSONPrefix)
+			if err != nil {
+				log.Fatal(err)
+			}
+			uri = bucketURL
+		}
+		var publishedAt time.Time
+		if *updateJSONPublishedAt != "" {
+			var err error
+			publishedAt, err = time.Parse(time.RFC3339, *updateJSONPublishedAt)
+			if err != nil {
+				log.Fatalf("Invalid --published-at: %s", err)
+			}
+		}
+		encode := update.EncodeJSON
+		if *updateJSONNoDigest {
+			encode = update.PreviewJSON
+		}
+		out, err := encode(*updateJSONVersion, tag(*updateJSONVersion), *updateJSONDescription, *updateJSONProps, *updateJSONSrc, uri, *updateJSONSignature, update.EncodeOptions{
+			OS:              *updateJSONOS,
+			Arch:            *updateJSONArch,
+			PublishedAt:     publishedAt,
+			DigestAlgorithm: *updateJSONDigest,
+			RolloutPercent:  updateJSONRollout,
+		})
 		if err != nil {
 			log.Fatal(err)
 		}
 		fmt.Fprintf(os.Stdout, "%s\n", out)
+	case updateJSONManifestCmd.FullCommand():
+		if *updateJSONManifestDestDir == "" && *updateJSONManifestBucketName == "" {
+			log.Fatal("Specify --dest-dir and/or --bucket-name")
+		}
+		manifest, err := update.ReadManifest(*updateJSONManifestPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = newClient(ctx).WriteManifestJSON(*manifest, *updateJSONManifestEnv, *updateJSONManifestDestDir, *updateJSONManifestBucketName, *updateJSONManifestConcurrency)
+		if err != nil {
+			log.Fatal(err)
+		}
 	case indexHTMLCmd.FullCommand():
-		err := update.WriteHTML(*indexHTMLBucketName, *indexHTMLPrefixes, *indexHTMLSuffix, *indexHTMLDest, *indexHTMLUpload)
+		if *indexHTMLFromDir != "" {
+			if *indexHTMLDest == "" {
+				log.Fatal("--dest is required with --from-dir")
+			}
+			if err := update.WriteHTMLFromDir(*indexHTMLFromDir, *indexHTMLSuffix, *indexHTMLDest); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+		prefixes := *indexHTMLPrefixes
+		if prefixes == "" && *indexHTMLAll {
+			prefixes = strings.Join(update.AllPrefixes(), ",")
+		}
+		if *indexHTMLBucketName == "" || prefixes == "" {
+			log.Fatal("--bucket-name and --prefixes (or --all-prefixes) are required")
+		}
+		var signer update.Signer
+		switch {
+		case *indexHTMLSignCmd != "" && *indexHTMLSignKey != "":
+			log.Fatal("Specify only one of --sign-command or --sign-key")
+		case *indexHTMLSignCmd != "":
+			signer = update.CommandSigner{Command: *indexHTMLSignCmd}
+		case *indexHTMLSignKey != "":
+			keySigner, err := update.NewKeySigner(*indexHTMLSignKey)
+			if err != nil {
+				log.Fatal(err)
+			}
+			signer = keySigner
+		}
+		var since time.Time
+		if *indexHTMLSinceDays > 0 {
+			since = time.Now().AddDate(0, 0, -*indexHTMLSinceDays)
+		}
+		err := newClient(ctx).WriteHTML(*indexHTMLBucketName, prefixes, *indexHTMLSuffix, *indexHTMLDest, *indexHTMLUpload, update.WriteHTMLOptions{
+			GroupBy:        *indexHTMLGroupBy,
+			DryRun:         *indexHTMLDryRun,
+			JSONOutPath:    *indexHTMLJSONDest,
+			JSONUploadDest: *indexHTMLJSONUpload,
+			Signer:         signer,
+			ShowChannels:   *indexHTMLChannels,
+			Strict:         *indexHTMLStrict,
+			Order:          *indexHTMLOrder,
+			Since:          since,
+			MarkLatest:     *indexHTMLMarkLatest,
+			Limit:          *indexHTMLLimit,
+			Writer:         os.Stdout,
+		})
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -211,60 +844,401 @@ func main() {
 		log.Printf("%s\n", versionShort)
 		log.Printf("%s\n", date)
 		log.Printf("%s\n", commit)
-	case promoteReleasesCmd.FullCommand():
-		const dryRun bool = false
-		release, err := update.PromoteReleases(*promoteReleasesBucketName, *promoteReleasesPlatform)
+	case bumpVersionCmd.FullCommand():
+		next, err := version.Bump(*bumpVersionString, *bumpVersionType)
 		if err != nil {
 			log.Fatal(err)
 		}
-		err = update.CopyLatest(*promoteReleasesBucketName, *promoteReleasesPlatform, dryRun)
+		fmt.Println(next)
+	case compareVersionCmd.FullCommand():
+		result, err := version.Compare(*compareVersionA, *compareVersionB)
 		if err != nil {
 			log.Fatal(err)
 		}
-		if release == nil {
-			log.Print("Not notifying API server of release")
-		} else {
-			releaseTime, err := update.KBWebPromote(keybaseToken(true), release.Version, *promoteReleasesPlatform, dryRun)
+		fmt.Println(result)
+		ok := map[string]bool{
+			"":   true,
+			"lt": result < 0,
+			"le": result <= 0,
+			"eq": result == 0,
+			"ge": result >= 0,
+			"gt": result > 0,
+		}[*compareVersionAssert]
+		if !ok {
+			log.Fatalf("%s is not %s %s", *compareVersionA, *compareVersionAssert, *compareVersionB)
+		}
+	case promoteReleasesCmd.FullCommand():
+		dryRun := *promoteReleasesDryRun
+		ctx, cancel := withTimeout(ctx, *promoteReleasesTimeout)
+		defer cancel()
+		client := newClientWithOptions(ctx, update.ClientOptions{
+			DestPrefix:     *promoteReleasesDestPrefix,
+			TwoPhase:       *promoteReleasesTwoPhase,
+			VerifyComplete: *promoteReleasesVerify,
+			VerifyCopy:     *promoteReleasesVerifyCopy,
+			PromotionCheck: requireCI(*promoteReleasesRequireCI),
+		})
+		platforms := strings.Split(*promoteReleasesPlatform, ",")
+		err := client.ForPlatforms(platforms, *promoteReleasesParallel, func(client *update.Client, platform string) error {
+			release, err := client.PromoteReleases(*promoteReleasesBucketName, platform, *promoteReleasesEnv, *promoteReleasesForce, dryRun)
 			if err != nil {
-				log.Fatal(err)
+				return err
+			}
+			if *promoteReleasesEnv != update.EnvProd || *promoteReleasesDestPrefix != "" {
+				log.Printf("Not copying latest or notifying API server for %s env (dest prefix %q)", *promoteReleasesEnv, *promoteReleasesDestPrefix)
+				return nil
+			}
+			err = client.CopyLatest(*promoteReleasesBucketName, platform, dryRun)
+			if err != nil {
+				return err
+			}
+			if release == nil {
+				log.Printf("Not notifying API server of %s release", platform)
+				return nil
+			}
+			releaseTime, err := update.KBWebPromote(keybaseToken(!dryRun), release.Version, platform, dryRun)
+			if err != nil {
+				return err
 			}
-			log.Printf("Release time set to %v for build %v", releaseTime, release.Version)
+			log.Printf("Release time set to %v for %s build %v", releaseTime, platform, release.Version)
+			return nil
+		})
+		if err != nil {
+			log.Fatal(err)
 		}
 	case promoteAReleaseCmd.FullCommand():
-		release, err := update.PromoteARelease(*releaseToPromote, *promoteAReleaseBucketName, *promoteAReleasePlatform, *promoteAReleaseDryRun)
+		ctx, cancel := withTimeout(ctx, *promoteAReleaseTimeout)
+		defer cancel()
+		client := newClientWithOptions(ctx, update.ClientOptions{
+			DestPrefix:     *promoteAReleaseDestPrefix,
+			TwoPhase:       *promoteAReleaseTwoPhase,
+			VerifyComplete: *promoteAReleaseVerify,
+			VerifyCopy:     *promoteAReleaseVerifyCopy,
+			PromotionCheck: requireCI(*promoteAReleaseRequireCI),
+		})
+		promoteARelease(client, *releaseToPromote, *promoteAReleaseBucketName, *promoteAReleasePlatform, *promoteAReleaseEnv, *promoteAReleaseDestPrefix, *promoteAReleaseDryRun)
+	case promoteByCommitCmd.FullCommand():
+		ctx, cancel := withTimeout(ctx, *promoteByCommitTimeout)
+		defer cancel()
+		client := newClientWithOptions(ctx, update.ClientOptions{
+			DestPrefix:     *promoteByCommitDestPrefix,
+			TwoPhase:       *promoteByCommitTwoPhase,
+			VerifyComplete: *promoteByCommitVerify,
+			VerifyCopy:     *promoteByCommitVerifyCopy,
+			PromotionCheck: requireCI(*promoteByCommitRequireCI),
+		})
+		release, err := client.FindReleaseByCommit(*promoteByCommitBucketName, *promoteByCommitPlatform, *promoteByCommitCommit)
 		if err != nil {
 			log.Fatal(err)
 		}
-		err = update.CopyLatest(*promoteAReleaseBucketName, *promoteAReleasePlatform, *promoteAReleaseDryRun)
+		log.Printf("Found release %s for commit %s", release.Name, *promoteByCommitCommit)
+		promoteARelease(client, release.Version, *promoteByCommitBucketName, *promoteByCommitPlatform, *promoteByCommitEnv, *promoteByCommitDestPrefix, *promoteByCommitDryRun)
+	case copyLatestCmd.FullCommand():
+		ctx, cancel := withTimeout(ctx, *copyLatestTimeout)
+		defer cancel()
+		err := newClient(ctx).CopyLatest(*copyLatestBucketName, *copyLatestPlatform, *copyLatestDryRun)
 		if err != nil {
 			log.Fatal(err)
 		}
-		if release == nil {
-			log.Fatal("No release found")
-		} else {
-			_, err := update.KBWebPromote(keybaseToken(!*promoteAReleaseDryRun), release.Version, *promoteAReleasePlatform, *promoteAReleaseDryRun)
+	case combinedManifestCmd.FullCommand():
+		if *combinedManifestDest == "" && *combinedManifestUpload == "" {
+			data, err := newClient(ctx).GenerateCombinedManifest(*combinedManifestBucketName, *combinedManifestEnv, *combinedManifestChannel)
 			if err != nil {
 				log.Fatal(err)
 			}
+			fmt.Fprintf(os.Stdout, "%s\n", data)
+			return
+		}
+		err := newClient(ctx).WriteCombinedManifest(*combinedManifestBucketName, *combinedManifestEnv, *combinedManifestChannel, *combinedManifestDest, *combinedManifestUpload)
+		if err != nil {
+			log.Fatal(err)
+		}
+	case verifyReplicationCmd.FullCommand():
+		err := update.VerifyReplicationWithContext(ctx, *verifyReplicationPrimary, *verifyReplicationReplica, *verifyReplicationPlatform, *verifyReplicationChannel, *verifyReplicationEnv)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("%s is replicated to %s", *verifyReplicationPrimary, *verifyReplicationReplica)
+	case copyEnvCmd.FullCommand():
+		client := newClientWithOptions(ctx, update.ClientOptions{
+			TwoPhase:   *copyEnvTwoPhase,
+			VerifyCopy: *copyEnvVerifyCopy,
+		})
+		err := client.CopyAcrossEnv(*copyEnvBucketName, *copyEnvChannel, *copyEnvPlatform, *copyEnvFrom, *copyEnvTo)
+		if err != nil {
+			log.Fatal(err)
 		}
 	case promoteTestReleasesCmd.FullCommand():
-		err := update.PromoteTestReleases(*promoteTestReleasesBucketName, *promoteTestReleasesPlatform, *promoteTestReleasesRelease)
+		ctx, cancel := withTimeout(ctx, *promoteTestReleasesTimeout)
+		defer cancel()
+		client := newClientWithOptions(ctx, update.ClientOptions{DestPrefix: *promoteTestReleasesDestPrefix})
+		err := client.PromoteTestReleases(*promoteTestReleasesBucketName, *promoteTestReleasesPlatform, *promoteTestReleasesEnv, *promoteTestReleasesRelease)
 		if err != nil {
 			log.Fatal(err)
 		}
 	case updatesReportCmd.FullCommand():
-		err := update.Report(*updatesReportBucketName, os.Stdout)
+		entries, err := newClient(ctx).ReportEntries(*updatesReportBucketName, *updatesReportEnv)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *updatesReportFormat == update.OutputJSON {
+			err = update.WriteJSON(os.Stdout, entries)
+		} else {
+			err = update.WriteReport(entries, os.Stdout)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	case checkLockstepCmd.FullCommand():
+		mismatches, err := newClient(ctx).CheckLockstep(*checkLockstepBucketName, *checkLockstepVersion)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *checkLockstepOutput == update.OutputJSON {
+			if err := update.WriteJSON(os.Stdout, mismatches); err != nil {
+				log.Fatal(err)
+			}
+		} else {
+			for _, mismatch := range mismatches {
+				fmt.Fprintf(os.Stdout, "%s\n", mismatch)
+			}
+		}
+		if len(mismatches) > 0 {
+			log.Fatalf("%d platform(s) not in lockstep", len(mismatches))
+		}
+	case warmCDNCmd.FullCommand():
+		urls, err := newClient(ctx).PromotedAssetURLs(*warmCDNBucketName, *warmCDNPlatform)
 		if err != nil {
 			log.Fatal(err)
 		}
+		if err := update.WarmCDN(urls, *warmCDNRegions...); err != nil {
+			log.Printf("Warning: Not all assets were warmed: %s", err)
+		}
+	case verifyUpdateCmd.FullCommand():
+		opts := update.VerifyOptions{LocalPath: *verifyUpdateAsset}
+		if *verifyUpdateVerify != "" {
+			key, err := update.ReadPublicKey(*verifyUpdateVerify)
+			if err != nil {
+				log.Fatal(err)
+			}
+			opts.PublicKey = key
+		}
+		if *verifyUpdateJSON != "" {
+			f, err := os.Open(*verifyUpdateJSON)
+			if err != nil {
+				log.Fatal(err)
+			}
+			upd, err := update.DecodeJSON(f)
+			_ = f.Close()
+			if err != nil {
+				log.Fatalf("Invalid update JSON %s: %s", *verifyUpdateJSON, err)
+			}
+			if err := update.VerifyUpdate(upd, opts); err != nil {
+				log.Fatal(err)
+			}
+			log.Printf("Verified %s", *verifyUpdateJSON)
+			return
+		}
+		if *verifyUpdateBucketName == "" || *verifyUpdatePlatform == "" {
+			log.Fatal("Specify --json, or --bucket-name and --platform")
+		}
+		err := newClient(ctx).VerifyCurrentUpdate(*verifyUpdateBucketName, *verifyUpdatePlatform, *verifyUpdateChannel, *verifyUpdateEnv, opts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Verified current %s update", *verifyUpdatePlatform)
+	case reverifyCmd.FullCommand():
+		mismatches, err := newClient(ctx).ReverifyDigests(*reverifyBucketName, *reverifyPlatform, *reverifyConcurrency)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *reverifyOutput == update.OutputJSON {
+			if err := update.WriteJSON(os.Stdout, mismatches); err != nil {
+				log.Fatal(err)
+			}
+		} else {
+			for _, mismatch := range mismatches {
+				fmt.Fprintf(os.Stdout, "%s\n", mismatch)
+			}
+		}
+		if len(mismatches) > 0 {
+			log.Fatalf("%d asset(s) that don't match their digest", len(mismatches))
+		}
+	case diffUpdateCmd.FullCommand():
+		diff, err := newClient(ctx).DiffUpdateJSON(*diffUpdateBucketName, *diffUpdatePlatform, *diffUpdateEnv, *diffUpdateA, *diffUpdateB)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if diff == "" {
+			log.Printf("No differences")
+		}
+		fmt.Print(diff)
+	case presignCmd.FullCommand():
+		presignedURL, err := update.PresignAsset(*presignBucketName, *presignKey, *presignTTL)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%s\n", presignedURL)
+	case verifyReleaseCmd.FullCommand():
+		missing, err := newClient(ctx).VerifyReleaseComplete(*verifyReleaseBucketName, *verifyReleasePlatform, *verifyReleaseVersion)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, key := range missing {
+			fmt.Fprintf(os.Stdout, "%s\n", key)
+		}
+		if len(missing) > 0 {
+			log.Fatalf("%d file(s) missing for %s", len(missing), *verifyReleaseVersion)
+		}
+	case findDuplicatesCmd.FullCommand():
+		duplicates, err := newClient(ctx).FindDuplicateVersions(*findDuplicatesBucketName, *findDuplicatesPrefix, *findDuplicatesSuffix)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *findDuplicatesOutput == update.OutputJSON {
+			if err := update.WriteJSON(os.Stdout, duplicates); err != nil {
+				log.Fatal(err)
+			}
+		} else {
+			versions := []string{}
+			for ver := range duplicates {
+				versions = append(versions, ver)
+			}
+			sort.Strings(versions)
+			for _, ver := range versions {
+				fmt.Fprintf(os.Stdout, "%s\n", ver)
+				for _, release := range duplicates[ver] {
+					fmt.Fprintf(os.Stdout, "  %s (%s, modified %s)\n", release.Key, release.SizeString, release.LastModified.Format(time.RFC3339))
+				}
+			}
+		}
+		if len(duplicates) > 0 {
+			log.Fatalf("%d version(s) uploaded more than once", len(duplicates))
+		}
+	case checkLatestCmd.FullCommand():
+		mismatches, err := newClient(ctx).CheckLatestConsistency(*checkLatestBucketName)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *checkLatestOutput == update.OutputJSON {
+			if err := update.WriteJSON(os.Stdout, mismatches); err != nil {
+				log.Fatal(err)
+			}
+		} else {
+			for _, mismatch := range mismatches {
+				fmt.Fprintf(os.Stdout, "%s\n", mismatch)
+			}
+		}
+		if len(mismatches) > 0 {
+			log.Fatalf("%d platform(s) with a latest download that doesn't match", len(mismatches))
+		}
+	case historyCmd.FullCommand():
+		events, err := newClient(ctx).PromotionHistory(*historyBucketName, *historyPlatform, *historyChannel)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *historyOutput == update.OutputJSON {
+			if err := update.WriteJSON(os.Stdout, events); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+		for _, event := range events {
+			current := ""
+			if event.Current {
+				current = " (current)"
+			}
+			fmt.Fprintf(os.Stdout, "%s\t%s%s\n", event.Time.Format(time.UnixDate), event.Version, current)
+		}
+	case waitForPublishedCmd.FullCommand():
+		err := update.WaitForPublished(*waitForPublishedURL, *waitForPublishedVersion, *waitForPublishedTimeout, *waitForPublishedInterval)
+		if err != nil {
+			log.Fatal(err)
+		}
+	case reconstructSupportCmd.FullCommand():
+		err := newClient(ctx).ReconstructSupportJSON(*reconstructSupportBucketName, *reconstructSupportPlatform, *reconstructSupportEnv, *reconstructSupportVersion)
+		if err != nil {
+			log.Fatal(err)
+		}
+	case backupUpdatesCmd.FullCommand():
+		if err := newClient(ctx).BackupUpdateJSONs(*backupUpdatesBucketName, *backupUpdatesDestDir); err != nil {
+			log.Fatal(err)
+		}
+	case restoreUpdatesCmd.FullCommand():
+		names, err := update.BackupNames(*restoreUpdatesSrcDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(names) == 0 {
+			log.Fatalf("No update JSONs in %s", *restoreUpdatesSrcDir)
+		}
+		for _, name := range names {
+			fmt.Fprintf(os.Stdout, "%s\n", name)
+		}
+		if !*restoreUpdatesYes && !confirm(fmt.Sprintf("Restore %d update JSON(s) to %s?", len(names), *restoreUpdatesBucketName)) {
+			log.Fatal("Not restoring")
+		}
+		if err := newClient(ctx).RestoreUpdateJSONs(*restoreUpdatesBucketName, *restoreUpdatesSrcDir, names); err != nil {
+			log.Fatal(err)
+		}
+	case validateNamesCmd.FullCommand():
+		parsed, failed, err := newClient(ctx).ValidateNames(*validateNamesBucketName, *validateNamesPrefix)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, name := range failed {
+			fmt.Fprintf(os.Stdout, "Failed: %s\n", name)
+		}
+		fmt.Fprintf(os.Stdout, "%d parsed, %d failed\n", len(parsed), len(failed))
 	case brokenReleaseCmd.FullCommand():
-		_, err := update.ReleaseBroken(*brokenReleaseName, *brokenReleaseBucketName, *brokenReleasePlatformName)
+		_, err := newClient(ctx).ReleaseBroken(*brokenReleaseName, *brokenReleaseBucketName, *brokenReleasePlatformName)
+		if err != nil {
+			log.Fatal(err)
+		}
+	case deleteReleaseCmd.FullCommand():
+		if !*deleteReleaseConfirm {
+			log.Fatal("Not deleting without --confirm")
+		}
+		if err := newClient(ctx).DeleteRelease(*deleteReleaseName, *deleteReleaseBucketName, *deleteReleasePlatform, *deleteReleaseForce); err != nil {
+			log.Fatal(err)
+		}
+	case renameReleaseCmd.FullCommand():
+		if !*renameReleaseConfirm {
+			renames, err := update.ReleaseRenames(*renameReleasePlatform, *renameReleaseOld, *renameReleaseNew)
+			if err != nil {
+				log.Fatal(err)
+			}
+			for _, rename := range renames {
+				log.Printf("Would move %s to %s", rename.From, rename.To)
+			}
+			log.Printf("Not renaming without --confirm")
+			return
+		}
+		if err := newClient(ctx).RenameRelease(*renameReleaseBucketName, *renameReleasePlatform, *renameReleaseOld, *renameReleaseNew); err != nil {
+			log.Fatal(err)
+		}
+	case listBrokenCmd.FullCommand():
+		releases, err := newClient(ctx).ListBroken(*listBrokenBucketName)
 		if err != nil {
 			log.Fatal(err)
 		}
+		if *listBrokenOutput == update.OutputJSON {
+			if err := update.WriteJSON(os.Stdout, releases); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tPATH\tMARKED BROKEN")
+		for _, release := range releases {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", release.Version, strings.TrimPrefix(release.Key, update.BrokenPrefix), release.LastModified.Format(time.RFC3339))
+		}
+		if err := w.Flush(); err != nil {
+			log.Fatal(err)
+		}
 	case saveLogCmd.FullCommand():
 
-		url, err := update.SaveLog(*saveLogBucketName, *saveLogPath, *saveLogMaxSize)
+		url, err := newClient(ctx).SaveLog(*saveLogBucketName, *saveLogPath, *saveLogMaxSize)
 		if err != nil {
 			if *saveLogNoErr {
 				log.Printf("%s", err)
@@ -274,13 +1248,13 @@ func main() {
 		}
 		fmt.Fprintf(os.Stdout, "%s\n", url)
 	case latestCommitCmd.FullCommand():
-		commit, err := gh.LatestCommit(githubToken(true), *latestCommitRepo, *latestCommitContexts)
+		commit, err := gh.LatestCommit(githubToken(true), *latestCommitRepo, *latestCommitContexts, *latestCommitConcurrency)
 		if err != nil {
 			log.Fatal(err)
 		}
 		fmt.Printf("%s", commit.SHA)
 	case waitForCICmd.FullCommand():
-		err := gh.WaitForCI(githubToken(true), *waitForCIRepo, *waitForCICommit, *waitForCIContexts, *waitForCIDelay, *waitForCITimeout)
+		err := gh.WaitForCIWithContext(ctx, githubToken(true), *waitForCIRepo, *waitForCICommit, *waitForCIContexts, *waitForCIDelay, *waitForCITimeout)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -294,16 +1268,90 @@ func main() {
 		if err != nil {
 			log.Fatal(err)
 		}
+	case pruneDraftsCmd.FullCommand():
+		if err := gh.PruneDraftReleases(*pruneDraftsRepo, *pruneDraftsOlderThan, !*pruneDraftsConfirm, githubToken(true)); err != nil {
+			log.Fatal(err)
+		}
+	case verifyTagCmd.FullCommand():
+		if err := gh.VerifyTagCommit(*verifyTagRepo, tag(*verifyTagVersion), *verifyTagCommit, githubToken(false)); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Tag %s points to %s", tag(*verifyTagVersion), *verifyTagCommit)
 	case ciStatusesCmd.FullCommand():
 		err := gh.CIStatuses(githubToken(true), *ciStatusesRepo, *ciStatusesCommit)
 		if err != nil {
 			log.Fatal(err)
 		}
+	case inTestingCmd.FullCommand():
+		builds, err := update.ListInTesting(keybaseToken(true), *inTestingPlatform)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(builds) == 0 {
+			fmt.Printf("No %s builds in testing\n", *inTestingPlatform)
+			return
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tMAX TESTERS\tIN TESTING")
+		for _, build := range builds {
+			fmt.Fprintf(w, "%s\t%d\t%t\n", build.VersionA, build.MaxTesters, build.InTesting)
+		}
+		if err := w.Flush(); err != nil {
+			log.Fatal(err)
+		}
+	case findUnannouncedCmd.FullCommand():
+		releases, err := newClient(ctx).FindUnannounced(keybaseToken(true), *findUnannouncedBucketName, *findUnannouncedPlatform, *findUnannouncedLimit)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *findUnannouncedOutput == update.OutputJSON {
+			if err := update.WriteJSON(os.Stdout, releases); err != nil {
+				log.Fatal(err)
+			}
+		} else {
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "VERSION\tPATH\tDATE")
+			for _, release := range releases {
+				fmt.Fprintf(w, "%s\t%s\t%s\n", release.Version, release.Key, release.Date.Format(time.RFC3339))
+			}
+			if err := w.Flush(); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if len(releases) > 0 {
+			log.Fatalf("Found %d unannounced release(s)", len(releases))
+		}
+	case checkCACmd.FullCommand():
+		notBefore, notAfter, err := update.CACertInfo()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Not before: %s\nNot after:  %s\n", notBefore.Format(time.RFC3339), notAfter.Format(time.RFC3339))
+		now := time.Now()
+		if now.Before(notBefore) {
+			log.Fatalf("CA is not valid until %s", notBefore.Format(time.RFC3339))
+		}
+		if now.After(notAfter) {
+			log.Fatalf("CA expired at %s", notAfter.Format(time.RFC3339))
+		}
+		remaining := notAfter.Sub(now)
+		if remaining < time.Duration(*checkCAFailDays)*24*time.Hour {
+			log.Fatalf("CA expires in %d day(s), at %s", int(remaining.Hours()/24), notAfter.Format(time.RFC3339))
+		}
+		if remaining < time.Duration(*checkCAWarnDays)*24*time.Hour {
+			log.Printf("WARNING: CA expires in %d day(s), at %s", int(remaining.Hours()/24), notAfter.Format(time.RFC3339))
+		}
 	case getWinBuildNumberCmd.FullCommand():
-		err := winbuild.GetNextBuildNumber(keybaseToken(true), *getWinBuildNumberVersion, *getWinBuildNumberBotID, *getWinBuildNumberPlatform)
+		botID, platformID := *getWinBuildNumberBotID, *getWinBuildNumberPlatform
+		if *getWinBuildNumberBotIDOld != 0 {
+			botID = *getWinBuildNumberBotIDOld
+		}
+		if *getWinBuildNumberPlatformOld != 0 {
+			platformID = *getWinBuildNumberPlatformOld
+		}
+		err := winbuild.GetNextBuildNumber(keybaseToken(true), *getWinBuildNumberVersion, botID, platformID)
 		if err != nil {
 			log.Fatal(err)
 		}
 	}
-
 }
@@ -0,0 +1,123 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package update
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// BackupUpdateJSONs downloads the (channel) update JSONs in a bucket, named by
+// the update JSON name template, to destDir, using the name as the file path
+func BackupUpdateJSONs(bucketName string, destDir string) error {
+	client, err := NewClient()
+	if err != nil {
+		return err
+	}
+	return client.BackupUpdateJSONs(bucketName, destDir)
+}
+
+// BackupUpdateJSONs downloads the update JSONs for the Client
+func (c *Client) BackupUpdateJSONs(bucketName string, destDir string) error {
+	objs, err := c.listObjectsRecursive(bucketName, c.destPrefix+updateJSONNamePrefix(updateJSONPlatformNames(), Envs))
+	if err != nil {
+		return err
+	}
+	count := 0
+	for _, obj := range objs {
+		key := aws.StringValue(obj.Key)
+		name := strings.TrimPrefix(key, c.destPrefix)
+		if !isUpdateJSONName(name) {
+			continue
+		}
+		resp, err := c.svc.GetObject(&s3.GetObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return fmt.Errorf("Error getting %s: %s", key, err)
+		}
+		data, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("Error reading %s: %s", key, err)
+		}
+		path := filepath.Join(destDir, filepath.FromSlash(name))
+		c.logf("Saving %s", path)
+		if err := writeFile(path, data); err != nil {
+			return err
+		}
+		count++
+	}
+	c.logf("Saved %d update JSON(s) to %s", count, destDir)
+	return nil
+}
+
+// BackupNames returns the update JSON names in a backup directory
+func BackupNames(srcDir string) ([]string, error) {
+	names := []string{}
+	err := filepath.WalkDir(srcDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return err
+		}
+		if name := filepath.ToSlash(rel); isUpdateJSONName(name) {
+			names = append(names, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// RestoreUpdateJSONs uploads update JSONs (see BackupNames) from a backup
+// directory to the bucket. All of them are checked to be valid update JSON
+// before any are uploaded.
+func RestoreUpdateJSONs(bucketName string, srcDir string, names []string) error {
+	client, err := NewClient()
+	if err != nil {
+		return err
+	}
+	return client.RestoreUpdateJSONs(bucketName, srcDir, names)
+}
+
+// RestoreUpdateJSONs uploads update JSONs from a backup for the Client
+func (c *Client) RestoreUpdateJSONs(bucketName string, srcDir string, names []string) error {
+	datas := make([][]byte, len(names))
+	for i, name := range names {
+		if !isUpdateJSONName(name) {
+			return fmt.Errorf("Not an update JSON: %s", name)
+		}
+		data, err := os.ReadFile(filepath.Join(srcDir, filepath.FromSlash(name)))
+		if err != nil {
+			return err
+		}
+		if _, err := DecodeJSON(bytes.NewReader(data)); err != nil {
+			return fmt.Errorf("Invalid update JSON %s: %s", name, err)
+		}
+		datas[i] = data
+	}
+	for i, name := range names {
+		c.logf("Restoring %s", name)
+		if err := c.putObject(bucketName, c.destPrefix+name, datas[i], "application/json"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
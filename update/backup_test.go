@@ -0,0 +1,79 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package update
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackupRestoreUpdateJSONs(t *testing.T) {
+	svc := newFakeS3()
+	svc.add("update-darwin-prod-v2.json", `{"version": "1.0.15"}`)
+	svc.add("update-windows-prod-test.json", `{"version": "1.0.16"}`)
+	svc.add("darwin-support/update-darwin-prod-1.0.15.json", `{"version": "1.0.15"}`)
+	svc.add("Keybase.dmg", "dmg")
+	client := newTestClient(svc)
+	dir := t.TempDir()
+
+	require.NoError(t, client.BackupUpdateJSONs(testBucket, dir))
+	names, err := BackupNames(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"update-darwin-prod-v2.json", "update-windows-prod-test.json"}, names)
+	data, err := os.ReadFile(filepath.Join(dir, "update-darwin-prod-v2.json"))
+	require.NoError(t, err)
+	assert.Equal(t, `{"version": "1.0.15"}`, string(data))
+
+	svc.add("update-darwin-prod-v2.json", `{"version": "1.0.17"}`)
+	require.NoError(t, client.RestoreUpdateJSONs(testBucket, dir, names))
+	assert.Equal(t, `{"version": "1.0.15"}`, string(svc.objects["update-darwin-prod-v2.json"].body))
+	require.Len(t, svc.puts, 2)
+	assert.Equal(t, "public-read", aws.StringValue(svc.puts[0].ACL))
+
+	// Nothing is uploaded if any are invalid
+	svc.puts = nil
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "update-linux-prod.json"), []byte("<html>"), 0644))
+	names, err = BackupNames(dir)
+	require.NoError(t, err)
+	err = client.RestoreUpdateJSONs(testBucket, dir, names)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "update-linux-prod.json")
+	assert.Empty(t, svc.puts)
+}
+
+func TestBackupRestoreUpdateJSONsNameTemplate(t *testing.T) {
+	t.Cleanup(func() { require.NoError(t, SetUpdateJSONNameTemplate(DefaultUpdateJSONNameTemplate)) })
+	require.NoError(t, SetUpdateJSONNameTemplate(`{{or .Channel "stable"}}/{{.Platform}}.json`))
+
+	svc := newFakeS3()
+	svc.add("v2/darwin.json", `{"version": "1.0.15"}`)
+	svc.add("stable/linux.json", `{"version": "1.0.16"}`)
+	svc.add("update-darwin-prod-v2.json", `{"version": "1.0.14"}`)
+	svc.add("darwin-support/update-darwin-prod-1.0.15.json", `{"version": "1.0.15"}`)
+	svc.add("v2/notes.json", `{}`)
+	client := newTestClient(svc)
+	dir := t.TempDir()
+
+	require.NoError(t, client.BackupUpdateJSONs(testBucket, dir))
+	names, err := BackupNames(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"stable/linux.json", "v2/darwin.json"}, names)
+	data, err := os.ReadFile(filepath.Join(dir, "v2", "darwin.json"))
+	require.NoError(t, err)
+	assert.Equal(t, `{"version": "1.0.15"}`, string(data))
+
+	svc.add("v2/darwin.json", `{"version": "1.0.17"}`)
+	require.NoError(t, client.RestoreUpdateJSONs(testBucket, dir, names))
+	assert.Equal(t, `{"version": "1.0.15"}`, string(svc.objects["v2/darwin.json"].body))
+	require.Len(t, svc.puts, 2)
+
+	err = client.RestoreUpdateJSONs(testBucket, dir, []string{"update-darwin-prod-v2.json"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Not an update JSON")
+}
@@ -0,0 +1,47 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package update
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// BrokenPrefix is where ReleaseBroken moves a release's files to, under
+// their original keys
+const BrokenPrefix = "broken/"
+
+// ListBroken returns the releases (files) that were marked broken, parsed
+// from their names. Their LastModified is when they were marked broken, and
+// their original key is their Key without BrokenPrefix.
+func ListBroken(bucketName string) ([]Release, error) {
+	client, err := NewClient()
+	if err != nil {
+		return nil, err
+	}
+	return client.ListBroken(bucketName)
+}
+
+// ListBroken returns the releases marked broken for the Client
+func (c *Client) ListBroken(bucketName string) ([]Release, error) {
+	objs, err := c.listObjectsRecursive(bucketName, BrokenPrefix)
+	if err != nil {
+		return nil, err
+	}
+	releases := []Release{}
+	for _, obj := range objs {
+		dir, name := path.Split(strings.TrimPrefix(*obj.Key, BrokenPrefix))
+		if name == "" || name == "index.html" {
+			continue
+		}
+		prefix := BrokenPrefix + dir
+		urlString, _ := urlStringForKey(*obj.Key, bucketName, prefix)
+		releases = append(releases, newRelease(name, *obj.Key, prefix, urlString, aws.Int64Value(obj.Size), aws.TimeValue(obj.LastModified)))
+	}
+	sort.Sort(ByRelease(releases))
+	return releases, nil
+}
@@ -0,0 +1,97 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package update
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// updateCache remembers the update JSON (and its ETag) last fetched for each
+// key, so it's only fetched again if it changed
+type updateCache struct {
+	mtx     sync.Mutex
+	entries map[string]cachedUpdate
+}
+
+type cachedUpdate struct {
+	etag   string
+	update *Update
+}
+
+func newUpdateCache() *updateCache {
+	return &updateCache{entries: map[string]cachedUpdate{}}
+}
+
+func (u *updateCache) get(key string) (cachedUpdate, bool) {
+	if u == nil {
+		return cachedUpdate{}, false
+	}
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+	entry, ok := u.entries[key]
+	return entry, ok
+}
+
+func (u *updateCache) set(key string, etag string, upd *Update) {
+	if u == nil || etag == "" {
+		return
+	}
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+	u.entries[key] = cachedUpdate{etag: etag, update: upd}
+}
+
+func (u *updateCache) clear() {
+	if u == nil {
+		return
+	}
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+	u.entries = map[string]cachedUpdate{}
+}
+
+// ClearUpdateCache forgets the update JSON the Client has fetched, so it's
+// fetched in full next time
+func (c *Client) ClearUpdateCache() {
+	c.updates.clear()
+}
+
+// getUpdate fetches and decodes the update JSON at key. If it's unchanged
+// since the Client last fetched it (by ETag), the cached update is returned.
+func (c *Client) getUpdate(bucketName string, key string) (*Update, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(key),
+	}
+	cached, ok := c.updates.get(key)
+	if ok {
+		input.IfNoneMatch = aws.String(cached.etag)
+	}
+	resp, err := c.svc.GetObject(input)
+	if ok && isNotModified(err) {
+		return cached.update, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	upd, err := DecodeJSON(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	c.updates.set(key, aws.StringValue(resp.ETag), upd)
+	return upd, nil
+}
+
+// isNotModified returns true if err is from a conditional request for an
+// object that hasn't changed
+func isNotModified(err error) bool {
+	reqErr, ok := err.(awserr.RequestFailure)
+	return ok && reqErr.StatusCode() == http.StatusNotModified
+}
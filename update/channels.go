@@ -0,0 +1,248 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package update
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const (
+	channelLabelPublic = "public"
+	channelLabelTest   = "test"
+)
+
+// updatePlatformName returns the platform name used for a platform's update
+// JSON (deb and rpm share the linux update)
+func updatePlatformName(platform Platform) string {
+	switch platform.Name {
+	case platformLinuxDeb.Name, platformLinuxRPM.Name:
+		return PlatformTypeLinux
+	default:
+		return platform.Name
+	}
+}
+
+// platformForPrefix returns the platform a prefix belongs to, for example
+// darwin-arm64-updates/ is darwin-arm64
+func platformForPrefix(prefix string) (platform Platform, ok bool) {
+	for _, p := range platformsAll {
+		name := strings.TrimSuffix(p.Prefix, "/")
+		if strings.HasPrefix(prefix, name) && len(name) > len(strings.TrimSuffix(platform.Prefix, "/")) {
+			platform = p
+			ok = true
+		}
+	}
+	return platform, ok
+}
+
+// ListChannels returns the channels a platform has update JSON for in env,
+// found by listing the bucket. The public channel for linux is "".
+func ListChannels(bucketName string, platformName string, env string) ([]string, error) {
+	client, err := NewClient()
+	if err != nil {
+		return nil, err
+	}
+	return client.ListChannels(bucketName, platformName, env)
+}
+
+// ListChannels returns the channels a platform has update JSON for, for the
+// Client
+func (c *Client) ListChannels(bucketName string, platformName string, env string) ([]string, error) {
+	objs, err := c.listObjectsRecursive(bucketName, c.destPrefix+updateJSONNamePrefix([]string{platformName}, []string{env}))
+	if err != nil {
+		return nil, err
+	}
+	channels := []string{}
+	for _, obj := range objs {
+		name := strings.TrimPrefix(aws.StringValue(obj.Key), c.destPrefix)
+		// Skips keys that only share the prefix, like update-darwin-prod-v2.json.bak
+		if channel, ok := updateJSONChannel(name, platformName, env); ok {
+			channels = append(channels, channel)
+		}
+	}
+	sort.Strings(channels)
+	return channels, nil
+}
+
+// updateJSONPlatformNames are the platform names that have update JSON
+func updateJSONPlatformNames() []string {
+	names := []string{}
+	for _, platform := range platformsAll {
+		name := updatePlatformName(platform)
+		if len(names) == 0 || names[len(names)-1] != name {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// updateJSONNamePrefix returns the prefix all update JSON names for the
+// platforms and envs share under the name template, for listing them
+func updateJSONNamePrefix(platformNames []string, envs []string) string {
+	prefix := ""
+	first := true
+	for _, platformName := range platformNames {
+		for _, env := range envs {
+			for _, channel := range []string{"", "0", "1"} {
+				name := updateJSONName(channel, platformName, env)
+				if first {
+					prefix, first = name, false
+				}
+				for !strings.HasPrefix(name, prefix) {
+					prefix = prefix[:len(prefix)-1]
+				}
+			}
+		}
+	}
+	return prefix
+}
+
+// updateJSONChannel returns the channel name is the update JSON of for a
+// platform and env, if it's one under the name template. The channel is
+// what's between the parts of the name that don't depend on it.
+func updateJSONChannel(name string, platformName string, env string) (string, bool) {
+	if name == updateJSONName("", platformName, env) {
+		return "", true
+	}
+	a, b := updateJSONName("0", platformName, env), updateJSONName("1", platformName, env)
+	prefixLen := 0
+	for prefixLen < len(a) && prefixLen < len(b) && a[prefixLen] == b[prefixLen] {
+		prefixLen++
+	}
+	suffixLen := 0
+	for suffixLen < len(a)-prefixLen && suffixLen < len(b)-prefixLen && a[len(a)-1-suffixLen] == b[len(b)-1-suffixLen] {
+		suffixLen++
+	}
+	prefix, suffix := a[:prefixLen], a[len(a)-suffixLen:]
+	if len(name) <= len(prefix)+len(suffix) || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+		return "", false
+	}
+	channel := name[len(prefix) : len(name)-len(suffix)]
+	if strings.Contains(channel, "/") || updateJSONName(channel, platformName, env) != name {
+		return "", false
+	}
+	return channel, true
+}
+
+// isUpdateJSONName returns true for (channel) update JSON names under the name
+// template, like update-darwin-prod-v2.json
+func isUpdateJSONName(name string) bool {
+	for _, platformName := range updateJSONPlatformNames() {
+		for _, env := range Envs {
+			if _, ok := updateJSONChannel(name, platformName, env); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// knownChannels returns the public and test channels for a platform
+func knownChannels(platformName string) []string {
+	channels := []string{}
+	for _, pcs := range [][]platformChannel{testChannels, publicChannels} {
+		for _, pc := range pcs {
+			if pc.platform == platformName {
+				channels = append(channels, pc.channel)
+			}
+		}
+	}
+	return channels
+}
+
+// loadChannels sets Channels for releases in sections (keyed by prefix) that
+// are the current update for a public or test channel
+func (c *Client) loadChannels(bucketName string, sections []Section) {
+	labeled := []struct {
+		label    string
+		channels []platformChannel
+	}{
+		{label: channelLabelPublic, channels: publicChannels},
+		{label: channelLabelTest, channels: testChannels},
+	}
+	// Current versions by update platform name and label
+	current := map[string]map[string]string{}
+	currentVersions := func(platformName string) map[string]string {
+		if versions, ok := current[platformName]; ok {
+			return versions
+		}
+		versions := map[string]string{}
+		for _, l := range labeled {
+			for _, pc := range l.channels {
+				if pc.platform != platformName {
+					continue
+				}
+				currentUpdate, path, err := c.CurrentUpdate(bucketName, pc.channel, pc.platform, "prod")
+				if err != nil {
+					c.logf("No current update at %s: %s", path, err)
+					continue
+				}
+				versions[l.label] = currentUpdate.Version
+			}
+		}
+		current[platformName] = versions
+		return versions
+	}
+
+	for _, section := range sections {
+		platform, ok := platformForPrefix(section.Header)
+		if !ok {
+			continue
+		}
+		versions := currentVersions(updatePlatformName(platform))
+		for i := range section.Releases {
+			release := &section.Releases[i]
+			for _, l := range labeled {
+				if version, ok := versions[l.label]; ok && release.Version != "" && release.Version == version {
+					release.Channels = append(release.Channels, l.label)
+				}
+			}
+		}
+	}
+}
+
+// markLatest sets Latest for one release in each section (keyed by prefix):
+// the platform's current public update if it's in the section, or else the
+// newest by date
+func (c *Client) markLatest(bucketName string, sections []Section) {
+	current := map[string]string{}
+	for _, section := range sections {
+		if len(section.Releases) == 0 {
+			continue
+		}
+		var version string
+		if platform, ok := platformForPrefix(section.Header); ok {
+			platformName := updatePlatformName(platform)
+			if v, ok := current[platformName]; ok {
+				version = v
+			} else {
+				v, err := c.LatestVersion(bucketName, platformName, "", EnvProd)
+				if err != nil {
+					c.logf("No current version for %s, marking the newest release latest: %s", platformName, err)
+				}
+				version = v
+				current[platformName] = v
+			}
+		}
+		latest := -1
+		for i, release := range section.Releases {
+			if version != "" && release.Version == version {
+				latest = i
+				break
+			}
+		}
+		if latest < 0 {
+			latest = 0
+			for i, release := range section.Releases {
+				if release.Date.After(section.Releases[latest].Date) {
+					latest = i
+				}
+			}
+		}
+		section.Releases[latest].Latest = true
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package update
+
+import (
+	"fmt"
+
+	"github.com/blang/semver"
+)
+
+type platformChannel struct {
+	platform string
+	channel  string
+}
+
+// publicChannels are the channels each platform's public update is promoted to
+var publicChannels = []platformChannel{
+	{platform: PlatformTypeDarwin, channel: defaultChannel},
+	{platform: PlatformTypeDarwinArm64, channel: defaultChannel},
+	{platform: PlatformTypeLinux, channel: ""},
+	{platform: PlatformTypeWindows, channel: defaultChannel},
+}
+
+// testChannels are the channels each platform's test update is promoted to
+var testChannels = []platformChannel{
+	{platform: PlatformTypeDarwin, channel: "test-v2"},
+	{platform: PlatformTypeDarwinArm64, channel: "test-v2"},
+	{platform: PlatformTypeLinux, channel: "test"},
+	{platform: PlatformTypeWindows, channel: "test"},
+}
+
+// publicChannel returns the channel a platform's public update is promoted to
+func publicChannel(platformName string) (string, bool) {
+	for _, pc := range publicChannels {
+		if pc.platform == platformName {
+			return pc.channel, true
+		}
+	}
+	return "", false
+}
+
+// LatestVersion returns the version of a platform's current update in a
+// channel, or its public channel if channel is empty
+func LatestVersion(bucketName string, platformName string, channel string, env string) (string, error) {
+	client, err := NewClient()
+	if err != nil {
+		return "", err
+	}
+	return client.LatestVersion(bucketName, platformName, channel, env)
+}
+
+// LatestVersion returns the version of a current update for the Client
+func (c *Client) LatestVersion(bucketName string, platformName string, channel string, env string) (string, error) {
+	if channel == "" {
+		var found bool
+		if channel, found = publicChannel(platformName); !found {
+			return "", fmt.Errorf("Invalid platform %s", platformName)
+		}
+	}
+	currentUpdate, path, err := c.CurrentUpdate(bucketName, channel, platformName, env)
+	if err != nil {
+		return "", fmt.Errorf("Error getting current update at %s: %s", path, err)
+	}
+	if currentUpdate == nil || currentUpdate.Version == "" {
+		return "", fmt.Errorf("No current update at %s", path)
+	}
+	return currentUpdate.Version, nil
+}
+
+// CheckLockstep checks that all platforms have the same promoted version.
+// If version is empty, the version promoted on most platforms is expected.
+// It returns a description for each platform that doesn't match.
+func CheckLockstep(bucketName string, version string) ([]string, error) {
+	client, err := NewClient()
+	if err != nil {
+		return nil, err
+	}
+	return client.CheckLockstep(bucketName, version)
+}
+
+// CheckLockstep checks that all platforms have the same promoted version for
+// the Client
+func (c *Client) CheckLockstep(bucketName string, version string) ([]string, error) {
+	mismatches := []string{}
+	promoted := map[string]semver.Version{}
+	for _, pc := range publicChannels {
+		currentUpdate, path, err := c.CurrentUpdate(bucketName, pc.channel, pc.platform, "prod")
+		if err != nil {
+			mismatches = append(mismatches, fmt.Sprintf("%s: Error getting current update at %s: %s", pc.platform, path, err))
+			continue
+		}
+		ver, err := semver.Make(currentUpdate.Version)
+		if err != nil {
+			mismatches = append(mismatches, fmt.Sprintf("%s: Invalid version at %s: %s", pc.platform, path, err))
+			continue
+		}
+		promoted[pc.platform] = ver
+	}
+
+	var expected semver.Version
+	if version != "" {
+		var err error
+		expected, err = semver.Make(version)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		expected = majorityVersion(promoted)
+	}
+	c.logf("Expecting version %s", expected)
+
+	for _, pc := range publicChannels {
+		ver, ok := promoted[pc.platform]
+		if !ok {
+			continue
+		}
+		if !versionMatches(expected, ver) {
+			mismatches = append(mismatches, fmt.Sprintf("%s: %s (expected %s)", pc.platform, ver, expected))
+		}
+	}
+	return mismatches, nil
+}
+
+func shortVersion(v semver.Version) semver.Version {
+	return semver.Version{Major: v.Major, Minor: v.Minor, Patch: v.Patch}
+}
+
+// versionMatches returns true if actual is the expected version. If expected
+// has no pre-release or build, only major.minor.patch is compared, since each
+// platform has its own build of a version.
+func versionMatches(expected semver.Version, actual semver.Version) bool {
+	if len(expected.Pre) == 0 && len(expected.Build) == 0 {
+		return shortVersion(expected).Equals(shortVersion(actual))
+	}
+	return expected.Equals(actual)
+}
+
+// majorityVersion returns the (major.minor.patch) version most platforms are
+// at, preferring the newer version on a tie.
+func majorityVersion(versions map[string]semver.Version) semver.Version {
+	counts := map[string]int{}
+	var majority semver.Version
+	majorityCount := 0
+	for _, ver := range versions {
+		short := shortVersion(ver)
+		counts[short.String()]++
+		count := counts[short.String()]
+		if count > majorityCount || (count == majorityCount && short.GT(majority)) {
+			majority = short
+			majorityCount = count
+		}
+	}
+	return majority
+}
@@ -0,0 +1,95 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package update
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// CombinedManifest is the current update of every platform, keyed by os and
+// arch (like darwin-arm64), for updaters that want a single document
+type CombinedManifest struct {
+	Platforms map[string]CombinedPlatform `json:"platforms"`
+}
+
+// CombinedPlatform is a platform's current update in a CombinedManifest
+type CombinedPlatform struct {
+	Version     string     `json:"version"`
+	URL         string     `json:"url,omitempty"`
+	Digest      string     `json:"digest,omitempty"`
+	PublishedAt *time.Time `json:"publishedAt,omitempty"`
+}
+
+// GenerateCombinedManifest returns a CombinedManifest (JSON) of each
+// platform's current update in a channel, or each platform's public channel
+// if channel is empty. Platforms without a current update are left out, but
+// any other error fetching an update fails, so the manifest isn't incomplete.
+func GenerateCombinedManifest(bucketName string, env string, channel string) ([]byte, error) {
+	client, err := NewClient()
+	if err != nil {
+		return nil, err
+	}
+	return client.GenerateCombinedManifest(bucketName, env, channel)
+}
+
+// GenerateCombinedManifest returns a CombinedManifest for the Client
+func (c *Client) GenerateCombinedManifest(bucketName string, env string, channel string) ([]byte, error) {
+	manifest := CombinedManifest{Platforms: map[string]CombinedPlatform{}}
+	for _, platform := range platformsAll {
+		key := fmt.Sprintf("%s-%s", platform.OS, platform.Arch)
+		if _, ok := manifest.Platforms[key]; ok {
+			// Like linux deb and rpm, which share an update
+			continue
+		}
+		platformName := updatePlatformName(platform)
+		platformChannel := channel
+		if platformChannel == "" {
+			platformChannel, _ = publicChannel(platformName)
+		}
+		currentUpdate, path, err := c.CurrentUpdate(bucketName, platformChannel, platformName, env)
+		if isNotFound(err) {
+			c.logf("Leaving out %s, no current update at %s", key, path)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Error getting current update at %s: %s", path, err)
+		}
+		entry := CombinedPlatform{Version: currentUpdate.Version}
+		if currentUpdate.Asset != nil {
+			entry.URL = currentUpdate.Asset.URL
+			entry.Digest = currentUpdate.Asset.Digest
+		}
+		if currentUpdate.PublishedAt != nil {
+			published := FromTime(*currentUpdate.PublishedAt).UTC()
+			entry.PublishedAt = &published
+		}
+		manifest.Platforms[key] = entry
+	}
+	if len(manifest.Platforms) == 0 {
+		return nil, fmt.Errorf("No current updates for %s", env)
+	}
+	return json.MarshalIndent(manifest, "", "  ")
+}
+
+// WriteCombinedManifest generates a combined manifest (see
+// GenerateCombinedManifest) and writes it to outPath and/or uploads it to
+// uploadDest in the bucket
+func WriteCombinedManifest(bucketName string, env string, channel string, outPath string, uploadDest string) error {
+	client, err := NewClient()
+	if err != nil {
+		return err
+	}
+	return client.WriteCombinedManifest(bucketName, env, channel, outPath, uploadDest)
+}
+
+// WriteCombinedManifest writes a combined manifest for the Client
+func (c *Client) WriteCombinedManifest(bucketName string, env string, channel string, outPath string, uploadDest string) error {
+	data, err := c.GenerateCombinedManifest(bucketName, env, channel)
+	if err != nil {
+		return err
+	}
+	return c.publish(bucketName, data, "application/json", outPath, uploadDest, nil, false)
+}
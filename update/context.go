@@ -0,0 +1,114 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package update
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// WithContext returns a copy of the Client whose S3 requests are made with
+// ctx, so they fail promptly (with an error wrapping ctx.Err()) once it's
+// canceled or past its deadline
+func (c *Client) WithContext(ctx context.Context) *Client {
+	client := *c
+	client.svc = &contextS3{S3API: c.svc, ctx: ctx}
+	client.ctx = ctx
+	return &client
+}
+
+// context returns the Client's context, or the background context if it has
+// none
+func (c *Client) context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// contextS3 is an S3 service that makes its requests with a context
+type contextS3 struct {
+	s3iface.S3API
+	ctx context.Context
+}
+
+// canceled returns an error wrapping the context's error, if it's done
+func (s *contextS3) canceled() error {
+	if err := s.ctx.Err(); err != nil {
+		return fmt.Errorf("S3 request canceled: %w", err)
+	}
+	return nil
+}
+
+// wrap returns err, or the context's error if that's why the request failed
+func (s *contextS3) wrap(err error) error {
+	if err == nil {
+		return nil
+	}
+	if ctxErr := s.canceled(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+func (s *contextS3) ListObjects(input *s3.ListObjectsInput) (*s3.ListObjectsOutput, error) {
+	if err := s.canceled(); err != nil {
+		return nil, err
+	}
+	output, err := s.S3API.ListObjectsWithContext(s.ctx, input)
+	return output, s.wrap(err)
+}
+
+func (s *contextS3) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	if err := s.canceled(); err != nil {
+		return nil, err
+	}
+	output, err := s.S3API.GetObjectWithContext(s.ctx, input)
+	return output, s.wrap(err)
+}
+
+func (s *contextS3) HeadObject(input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	if err := s.canceled(); err != nil {
+		return nil, err
+	}
+	output, err := s.S3API.HeadObjectWithContext(s.ctx, input)
+	return output, s.wrap(err)
+}
+
+func (s *contextS3) PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	return s.PutObjectWithContext(s.ctx, input)
+}
+
+func (s *contextS3) PutObjectWithContext(ctx aws.Context, input *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, error) {
+	if err := s.canceled(); err != nil {
+		return nil, err
+	}
+	output, err := s.S3API.PutObjectWithContext(ctx, input, opts...)
+	return output, s.wrap(err)
+}
+
+func (s *contextS3) CopyObject(input *s3.CopyObjectInput) (*s3.CopyObjectOutput, error) {
+	return s.CopyObjectWithContext(s.ctx, input)
+}
+
+func (s *contextS3) CopyObjectWithContext(ctx aws.Context, input *s3.CopyObjectInput, opts ...request.Option) (*s3.CopyObjectOutput, error) {
+	if err := s.canceled(); err != nil {
+		return nil, err
+	}
+	output, err := s.S3API.CopyObjectWithContext(ctx, input, opts...)
+	return output, s.wrap(err)
+}
+
+func (s *contextS3) DeleteObject(input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	if err := s.canceled(); err != nil {
+		return nil, err
+	}
+	output, err := s.S3API.DeleteObjectWithContext(s.ctx, input)
+	return output, s.wrap(err)
+}
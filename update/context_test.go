@@ -0,0 +1,52 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package update
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// hangingS3 is a fake S3 whose copies block until their context is done
+type hangingS3 struct {
+	*fakeS3
+}
+
+func (h hangingS3) CopyObjectWithContext(ctx aws.Context, input *s3.CopyObjectInput, opts ...request.Option) (*s3.CopyObjectOutput, error) {
+	<-ctx.Done()
+	return h.fakeS3.CopyObjectWithContext(ctx, input, opts...)
+}
+
+func TestClientWithContext(t *testing.T) {
+	svc := newFakeS3()
+	svc.add("update-darwin-prod-v2.json", testUpdateJSON("1.0.15-20160401013917+abcdef0"))
+	svc.add("darwin-updates/Keybase-1.0.15-20160401013917+abcdef0.zip", "zip")
+	svc.add("darwin/Keybase-1.0.15-20160401013917+abcdef0.dmg", "new dmg")
+
+	// Nothing is requested with a canceled context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := newTestClient(svc).WithContext(ctx).CopyLatest(testBucket, PlatformTypeDarwin, false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "S3 request canceled: context canceled")
+	assert.Empty(t, svc.copies)
+
+	// A hung request returns at the deadline
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = (&Client{svc: hangingS3{svc}}).WithContext(ctx).CopyLatest(testBucket, PlatformTypeDarwin, false)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), err.Error())
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.Equal(t, "S3 request canceled: context deadline exceeded", err.Error())
+}
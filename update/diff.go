@@ -0,0 +1,126 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package update
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// DiffUpdateJSON returns the differences, field by field, between the
+// versioned update JSONs (in the platform's support prefix) for two
+// versions, or "" if there are none
+func DiffUpdateJSON(bucketName string, platformName string, env string, versionA string, versionB string) (string, error) {
+	client, err := NewClient()
+	if err != nil {
+		return "", err
+	}
+	return client.DiffUpdateJSON(bucketName, platformName, env, versionA, versionB)
+}
+
+// DiffUpdateJSON returns the differences between two versions' update JSON
+// for the Client
+func (c *Client) DiffUpdateJSON(bucketName string, platformName string, env string, versionA string, versionB string) (string, error) {
+	platform, err := supportPlatform(platformName)
+	if err != nil {
+		return "", err
+	}
+	updA, err := c.versionedUpdate(bucketName, platform, env, versionA)
+	if err != nil {
+		return "", err
+	}
+	updB, err := c.versionedUpdate(bucketName, platform, env, versionB)
+	if err != nil {
+		return "", err
+	}
+	return diffUpdates(updA, updB), nil
+}
+
+// versionedUpdate gets the versioned update JSON for a version
+func (c *Client) versionedUpdate(bucketName string, platform Platform, env string, version string) (*Update, error) {
+	key := versionedUpdateJSONKey(platform, env, version)
+	resp, err := c.svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("No %s update JSON for version %s at %s: %s", platform.Name, version, key, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	upd, err := DecodeJSON(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid update JSON at %s: %s", key, err)
+	}
+	return upd, nil
+}
+
+// updateFields returns an update's fields by name, with props as props.<name>
+// and asset fields as asset.<field>
+func updateFields(upd *Update) map[string]string {
+	fields := map[string]string{
+		"version":     upd.Version,
+		"name":        upd.Name,
+		"description": upd.Description,
+		"type":        strconv.Itoa(int(upd.Type)),
+	}
+	if upd.Instructions != nil {
+		fields["instructions"] = *upd.Instructions
+	}
+	if upd.PublishedAt != nil {
+		fields["publishedAt"] = strconv.FormatInt(int64(*upd.PublishedAt), 10)
+	}
+	for _, prop := range upd.Props {
+		fields["props."+prop.Name] = prop.Value
+	}
+	if upd.Asset != nil {
+		fields["asset.name"] = upd.Asset.Name
+		fields["asset.url"] = upd.Asset.URL
+		fields["asset.digest"] = upd.Asset.Digest
+		fields["asset.signature"] = upd.Asset.Signature
+		fields["asset.localPath"] = upd.Asset.LocalPath
+		fields["asset.os"] = upd.Asset.OS
+		fields["asset.arch"] = upd.Asset.Arch
+	}
+	return fields
+}
+
+// diffUpdates describes the fields that differ between two updates
+func diffUpdates(a *Update, b *Update) string {
+	if a.Equal(b) {
+		return ""
+	}
+	fieldsA, fieldsB := updateFields(a), updateFields(b)
+	names := []string{}
+	for name := range fieldsA {
+		names = append(names, name)
+	}
+	for name := range fieldsB {
+		if _, ok := fieldsA[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var diff strings.Builder
+	for _, name := range names {
+		valueA, okA := fieldsA[name]
+		valueB, okB := fieldsB[name]
+		if okA && okB && valueA == valueB {
+			continue
+		}
+		fmt.Fprintf(&diff, "%s:\n", name)
+		if okA {
+			fmt.Fprintf(&diff, "  - %s\n", valueA)
+		}
+		if okB {
+			fmt.Fprintf(&diff, "  + %s\n", valueB)
+		}
+	}
+	return diff.String()
+}
@@ -0,0 +1,84 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package update
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// DefaultDigestConcurrency is the default number of files to hash at once
+const DefaultDigestConcurrency = 4
+
+// DigestAll returns the (sha256) digest for each path, hashing up to
+// DefaultDigestConcurrency files at once
+func DigestAll(paths []string) (map[string]string, error) {
+	return DigestAllWithConcurrency(paths, DefaultDigestConcurrency)
+}
+
+// DigestAllWithConcurrency returns the digest for each path, hashing up to
+// concurrency files at once. All paths are attempted, and any errors are
+// combined, with the digests of the paths that could be hashed.
+func DigestAllWithConcurrency(paths []string, concurrency int) (map[string]string, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	digests := make([]string, len(paths))
+	errs := make([]error, len(paths))
+	var g errgroup.Group
+	g.SetLimit(concurrency)
+	for i, p := range paths {
+		i, p := i, p
+		g.Go(func() error {
+			d, err := Digest(p, DigestSHA256)
+			if err != nil {
+				errs[i] = fmt.Errorf("%s: %s", p, err)
+				return nil
+			}
+			digests[i] = d
+			return nil
+		})
+	}
+	_ = g.Wait()
+
+	result := map[string]string{}
+	for i, p := range paths {
+		if errs[i] == nil {
+			result[p] = digests[i]
+		}
+	}
+	return result, CombineErrors(errs...)
+}
+
+// Digest returns the hex digest of a file with an algorithm (DigestSHA256 or
+// DigestSHA512)
+func Digest(p string, algorithm string) (digest string, err error) {
+	var hasher hash.Hash
+	switch algorithm {
+	case DigestSHA256:
+		hasher = sha256.New()
+	case DigestSHA512:
+		hasher = sha512.New()
+	default:
+		return "", fmt.Errorf("Invalid digest algorithm %q", algorithm)
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return
+	}
+	defer func() { _ = f.Close() }()
+	if _, ioerr := io.Copy(hasher, f); ioerr != nil {
+		err = ioerr
+		return
+	}
+	digest = hex.EncodeToString(hasher.Sum(nil))
+	return
+}
@@ -0,0 +1,75 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package update
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDigestAll(t *testing.T) {
+	dir := t.TempDir()
+	hello := filepath.Join(dir, "hello")
+	empty := filepath.Join(dir, "empty")
+	require.NoError(t, os.WriteFile(hello, []byte("hello"), 0644))
+	require.NoError(t, os.WriteFile(empty, nil, 0644))
+
+	for _, concurrency := range []int{0, 1, 4} {
+		digests, err := DigestAllWithConcurrency([]string{hello, empty}, concurrency)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{
+			hello: "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+			empty: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		}, digests)
+	}
+
+	missing := filepath.Join(dir, "missing")
+	digests, err := DigestAll([]string{hello, missing})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), missing)
+	assert.Contains(t, digests, hello)
+	assert.NotContains(t, digests, missing)
+}
+
+func TestDigestAlgorithms(t *testing.T) {
+	abc := filepath.Join(t.TempDir(), "abc")
+	require.NoError(t, os.WriteFile(abc, []byte("abc"), 0644))
+
+	// The FIPS 180-2 test vectors for "abc"
+	d, err := Digest(abc, DigestSHA256)
+	require.NoError(t, err)
+	assert.Equal(t, "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad", d)
+	d, err = Digest(abc, DigestSHA512)
+	require.NoError(t, err)
+	assert.Equal(t, "ddaf35a193617abacc417349ae20413112e6fa4e89a97ea20a9eeee64b55d39a2192992a274fc1a836ba3c23a3feebbd454d4423643ce80e2a9ac94fa54ca49f", d)
+
+	_, err = Digest(abc, "md5")
+	require.Error(t, err)
+}
+
+func BenchmarkDigestAll(b *testing.B) {
+	dir := b.TempDir()
+	paths := []string{}
+	data := bytes.Repeat([]byte("keybase"), 1<<20)
+	for i := 0; i < 8; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("file%d", i))
+		require.NoError(b, os.WriteFile(path, data, 0644))
+		paths = append(paths, path)
+	}
+	for _, concurrency := range []int{1, DefaultDigestConcurrency} {
+		b.Run(fmt.Sprintf("concurrency=%d", concurrency), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := DigestAllWithConcurrency(paths, concurrency); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package update
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// WriteHTMLFromDir creates an html file for releases in a local directory
+// instead of a bucket, for testing the template without S3. Each directory
+// with releases is a section, and links are relative to dir.
+func WriteHTMLFromDir(dir string, suffix string, outPath string) error {
+	var sections []Section
+	indexes := map[string]int{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name := info.Name()
+		if info.IsDir() || name == "index.html" || !strings.HasSuffix(name, suffix) {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		prefix := strings.TrimSuffix(key, name)
+		i, ok := indexes[prefix]
+		if !ok {
+			i = len(sections)
+			indexes[prefix] = i
+			sections = append(sections, Section{Header: prefix})
+		}
+		release := newRelease(name, key, prefix, escapeKey(key), info.Size(), info.ModTime())
+		sections[i].Releases = append(sections[i].Releases, release)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, section := range sections {
+		sort.Sort(ByRelease(section.Releases))
+	}
+
+	var buf bytes.Buffer
+	if err := WriteHTMLForLinks(filepath.Base(dir), sections, &buf); err != nil {
+		return err
+	}
+	return writeFile(outPath, buf.Bytes())
+}
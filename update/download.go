@@ -0,0 +1,57 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package update
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// DownloadObject downloads the object at key in a bucket to path. The file is
+// only written (replacing any at path) once the download is complete.
+func DownloadObject(bucketName string, key string, path string) error {
+	client, err := NewClient()
+	if err != nil {
+		return err
+	}
+	return client.DownloadObject(bucketName, key, path)
+}
+
+// DownloadObject downloads an object to path for the Client
+func (c *Client) DownloadObject(bucketName string, key string, path string) error {
+	resp, err := c.svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("Error getting %s: %s", key, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if err := makeParentDirs(path); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	c.logf("Downloading %s to %s", key, path)
+	n, err := io.Copy(tmp, c.bandwidth.Reader(resp.Body))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("Error downloading %s: %s", key, err)
+	}
+	if resp.ContentLength != nil && n != *resp.ContentLength {
+		return fmt.Errorf("Downloaded %d bytes of %s, expected %d", n, key, *resp.ContentLength)
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,127 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package update
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/blang/semver"
+	"github.com/keybase/release/version"
+)
+
+// PromotionEvent is a version that was published (and possibly promoted) for
+// a platform
+type PromotionEvent struct {
+	Version string    `json:"version"`
+	Time    time.Time `json:"time"`
+	// Current is true if this is the version the channel currently points to
+	Current bool `json:"current"`
+}
+
+// PromotionHistory returns a best-effort timeline of the versions promoted for
+// a platform and channel, oldest first.
+//
+// Promotions aren't recorded, so this is derived from the versioned update
+// JSONs in the platform's support prefix, excluding any newer than the
+// version the channel currently points to.
+func PromotionHistory(bucketName string, platformName string, channel string) ([]PromotionEvent, error) {
+	client, err := NewClient()
+	if err != nil {
+		return nil, err
+	}
+	return client.PromotionHistory(bucketName, platformName, channel)
+}
+
+// PromotionHistory returns a timeline of promoted versions for the Client
+func (c *Client) PromotionHistory(bucketName string, platformName string, channel string) ([]PromotionEvent, error) {
+	platform, err := supportPlatform(platformName)
+	if err != nil {
+		return nil, err
+	}
+	env := "prod"
+
+	var current *semver.Version
+	currentUpdate, path, err := c.CurrentUpdate(bucketName, channel, platform.Name, env)
+	if err != nil {
+		c.logf("Error getting current update at %s, including all versions: %s", path, err)
+	} else {
+		ver, err := semver.Make(currentUpdate.Version)
+		if err != nil {
+			return nil, err
+		}
+		current = &ver
+	}
+
+	objs, err := c.listAllObjects(bucketName, platform.PrefixSupport)
+	if err != nil {
+		return nil, err
+	}
+
+	keyPrefix := strings.TrimSuffix(versionedUpdateJSONKey(platform, env, ""), ".json")
+	events := []PromotionEvent{}
+	for _, obj := range objs {
+		key := aws.StringValue(obj.Key)
+		versionString := strings.TrimSuffix(strings.TrimPrefix(key, keyPrefix), ".json")
+		// Skips other files in the support prefix, and other platforms' and
+		// envs' update JSON
+		if versionString == "" || versionedUpdateJSONKey(platform, env, versionString) != key {
+			continue
+		}
+		event := PromotionEvent{Version: versionString}
+		if current != nil {
+			ver, err := semver.Make(versionString)
+			if err != nil {
+				c.logf("Skipping invalid version %s: %s", versionString, err)
+				continue
+			}
+			if ver.GT(*current) {
+				continue
+			}
+			event.Current = ver.Equals(*current)
+		}
+		event.Time = inTimezone(c.publishedAt(bucketName, obj, versionString))
+		events = append(events, event)
+	}
+
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time.Before(events[j].Time) })
+	return events, nil
+}
+
+// publishedAt returns when a versioned update JSON was published, from the
+// date in the version, the JSON's publishedAt, or the object's modified time
+func (c *Client) publishedAt(bucketName string, obj *s3.Object, versionString string) time.Time {
+	if _, _, date, _, err := version.Parse(versionString); err == nil && !date.IsZero() {
+		return date
+	}
+	resp, err := c.svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    obj.Key,
+	})
+	if err == nil {
+		defer func() { _ = resp.Body.Close() }()
+		upd, err := DecodeJSON(resp.Body)
+		if err == nil && upd.PublishedAt != nil {
+			return FromTime(*upd.PublishedAt)
+		}
+	}
+	return aws.TimeValue(obj.LastModified)
+}
+
+// supportPlatform returns the single platform for name, which must have a
+// support prefix (for versioned update JSON)
+func supportPlatform(name string) (Platform, error) {
+	platform, err := PlatformNamed(name)
+	if err != nil {
+		return Platform{}, err
+	}
+	if platform.PrefixSupport == "" {
+		return Platform{}, fmt.Errorf("Unsupported for this platform: %s", name)
+	}
+	return platform, nil
+}
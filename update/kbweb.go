@@ -8,16 +8,18 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"strings"
 	"time"
 )
 
-const (
-	kbwebAPIUrl = "https://api-0.core.keybaseapi.com"
-)
+var kbwebAPIUrl = "https://api-0.core.keybaseapi.com"
 
 const apiCa = `-----BEGIN CERTIFICATE-----
 MIIGIzCCBAugAwIBAgIJAPzhpcIBaOeNMA0GCSqGSIb3DQEBCwUAMIGMMQswCQYD
@@ -55,6 +57,9 @@ XNpVqc8i9YuN5ASXKwR0nna/vFyr2sFYhV/Q+QIBUh6bwZEFF9f3qtgxi908ZSEC
 ip88muP7dUJ5jR/XrBLdYqrnMFym5dyHN7AjBdTwjSkTtFKHjAxb
 -----END CERTIFICATE-----`
 
+// kbwebCA is the CA (PEM) for the API server
+var kbwebCA = apiCa
+
 type kbwebClient struct {
 	http *http.Client
 }
@@ -66,6 +71,7 @@ type APIResponseWrapper interface {
 type AppResponseBase struct {
 	Status struct {
 		Code int
+		Name string
 		Desc string
 	}
 }
@@ -77,7 +83,7 @@ func (s *AppResponseBase) StatusCode() int {
 // newKbwebClient constructs a Client
 func newKbwebClient() (*kbwebClient, error) {
 	certPool := x509.NewCertPool()
-	ok := certPool.AppendCertsFromPEM([]byte(apiCa))
+	ok := certPool.AppendCertsFromPEM([]byte(kbwebCA))
 	if !ok {
 		return nil, fmt.Errorf("Could not read CA for keybase.io")
 	}
@@ -89,12 +95,41 @@ func newKbwebClient() (*kbwebClient, error) {
 	return &kbwebClient{http: client}, nil
 }
 
+// CACertInfo returns the validity window of the CA pinned for the API server
+func CACertInfo() (notBefore, notAfter time.Time, err error) {
+	block, _ := pem.Decode([]byte(kbwebCA))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return time.Time{}, time.Time{}, fmt.Errorf("Could not decode CA for keybase.io")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("Could not parse CA for keybase.io: %s", err)
+	}
+	return cert.NotBefore, cert.NotAfter, nil
+}
+
 func (client *kbwebClient) post(keybaseToken string, path string, data []byte, response APIResponseWrapper) error {
 	req, err := http.NewRequest("POST", kbwebAPIUrl+path, bytes.NewBuffer(data))
 	if err != nil {
 		return fmt.Errorf("newrequest failed, %v", err)
 	}
 	req.Header.Add("content-type", "application/json")
+	if err := client.do(keybaseToken, req, response); err != nil {
+		return err
+	}
+	fmt.Printf("Success.\n")
+	return nil
+}
+
+func (client *kbwebClient) get(keybaseToken string, path string, params url.Values, response APIResponseWrapper) error {
+	req, err := http.NewRequest("GET", kbwebAPIUrl+path+"?"+params.Encode(), nil)
+	if err != nil {
+		return fmt.Errorf("newrequest failed, %v", err)
+	}
+	return client.do(keybaseToken, req, response)
+}
+
+func (client *kbwebClient) do(keybaseToken string, req *http.Request, response APIResponseWrapper) error {
 	req.Header.Add("x-keybase-admin-token", keybaseToken)
 	resp, err := client.http.Do(req)
 	if err != nil {
@@ -107,21 +142,80 @@ func (client *kbwebClient) post(keybaseToken string, path string, data []byte, r
 		return fmt.Errorf("body err, %v", err)
 	}
 
-	if response == nil {
-		response = new(AppResponseBase)
-	}
-	if err := json.Unmarshal(body, &response); err != nil {
+	var status AppResponseBase
+	if err := json.Unmarshal(body, &status); err != nil {
+		if resp.StatusCode == http.StatusNotFound {
+			return fmt.Errorf("API endpoint %s not found (it may not be deployed yet)", req.URL.Path)
+		}
 		return fmt.Errorf("json reply err, %v", err)
 	}
-
-	if response.StatusCode() != 0 {
-		return fmt.Errorf("Server returned failure, %s", body)
+	if response != nil {
+		if err := json.Unmarshal(body, &response); err != nil {
+			return fmt.Errorf("json reply err, %v", err)
+		}
 	}
 
-	fmt.Printf("Success.\n")
+	if status.StatusCode() != 0 {
+		return &kbwebStatusError{Name: status.Status.Name, Desc: status.Status.Desc, Code: status.Status.Code, Body: string(body)}
+	}
 	return nil
 }
 
+// kbwebStatusError is a failure status returned by the API server
+type kbwebStatusError struct {
+	Code int
+	Name string
+	Desc string
+	Body string
+}
+
+func (e *kbwebStatusError) Error() string {
+	return fmt.Sprintf("Server returned failure, %s", e.Body)
+}
+
+// isAlreadyExists returns true if err is the API server saying what we're
+// adding already exists
+func isAlreadyExists(err error) bool {
+	statusErr, ok := err.(*kbwebStatusError)
+	if !ok {
+		return false
+	}
+	return strings.HasSuffix(strings.ToUpper(statusErr.Name), "EXISTS") ||
+		strings.Contains(strings.ToLower(statusErr.Desc), "already exists")
+}
+
+// ReadToken returns a token from source, which is env:NAME for an environment
+// variable, file:/path for a file, or otherwise the token itself. This is so
+// admin tokens can be kept out of command lines (and logs).
+func ReadToken(source string) (string, error) {
+	var token string
+	switch {
+	case strings.HasPrefix(source, "env:"):
+		name := strings.TrimPrefix(source, "env:")
+		token = os.Getenv(name)
+		if strings.TrimSpace(token) == "" {
+			return "", fmt.Errorf("No token in environment variable %s", name)
+		}
+	case strings.HasPrefix(source, "file:"):
+		path := strings.TrimPrefix(source, "file:")
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		token = string(data)
+		if strings.TrimSpace(token) == "" {
+			return "", fmt.Errorf("No token in file %s", path)
+		}
+	default:
+		token = source
+	}
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return "", fmt.Errorf("No token specified")
+	}
+	return token, nil
+}
+
 type announceBuildArgs struct {
 	VersionA string `json:"version_a"`
 	VersionB string `json:"version_b"`
@@ -129,7 +223,8 @@ type announceBuildArgs struct {
 }
 
 // AnnounceBuild tells the API server about the existence of a new build.
-// It does not enroll it in smoke testing.
+// It does not enroll it in smoke testing. Announcing a build that was
+// already announced succeeds.
 func AnnounceBuild(keybaseToken string, buildA string, buildB string, platform string) error {
 	client, err := newKbwebClient()
 	if err != nil {
@@ -145,7 +240,12 @@ func AnnounceBuild(keybaseToken string, buildA string, buildB string, platform s
 		return fmt.Errorf("json marshal err, %v", err)
 	}
 	var data = jsonStr
-	return client.post(keybaseToken, "/_/api/1.0/pkg/add_build.json", data, nil)
+	err = client.post(keybaseToken, "/_/api/1.0/pkg/add_build.json", data, nil)
+	if isAlreadyExists(err) {
+		log.Printf("Build %s (%s) was already announced: %s", buildA, platform, err)
+		return nil
+	}
+	return err
 }
 
 type promoteBuildArgs struct {
@@ -213,3 +313,66 @@ func SetBuildInTesting(keybaseToken string, buildA string, platform string, inTe
 	var data = jsonStr
 	return client.post(keybaseToken, "/_/api/1.0/pkg/set_in_testing.json", data, nil)
 }
+
+// TestingBuild is a build's smoke testing enrollment
+type TestingBuild struct {
+	VersionA   string `json:"version_a"`
+	Platform   string `json:"platform"`
+	InTesting  bool   `json:"in_testing"`
+	MaxTesters int    `json:"max_testers"`
+}
+
+type listInTestingResponse struct {
+	AppResponseBase
+	Builds []TestingBuild `json:"builds"`
+}
+
+// ListInTesting asks the API server which builds for a platform are enrolled
+// in smoke testing.
+func ListInTesting(keybaseToken string, platform string) ([]TestingBuild, error) {
+	client, err := newKbwebClient()
+	if err != nil {
+		return nil, fmt.Errorf("client create failed, %v", err)
+	}
+	var response listInTestingResponse
+	params := url.Values{}
+	params.Set("platform", platform)
+	if err := client.get(keybaseToken, "/_/api/1.0/pkg/get_in_testing.json", params, &response); err != nil {
+		return nil, err
+	}
+	if response.Builds == nil {
+		return []TestingBuild{}, nil
+	}
+	return response.Builds, nil
+}
+
+// AnnouncedBuild is a build the API server was told about (see AnnounceBuild)
+type AnnouncedBuild struct {
+	VersionA string `json:"version_a"`
+	VersionB string `json:"version_b"`
+	Platform string `json:"platform"`
+}
+
+type listBuildsResponse struct {
+	AppResponseBase
+	Builds []AnnouncedBuild `json:"builds"`
+}
+
+// ListAnnouncedBuilds asks the API server which builds for a platform were
+// announced.
+func ListAnnouncedBuilds(keybaseToken string, platform string) ([]AnnouncedBuild, error) {
+	client, err := newKbwebClient()
+	if err != nil {
+		return nil, fmt.Errorf("client create failed, %v", err)
+	}
+	var response listBuildsResponse
+	params := url.Values{}
+	params.Set("platform", platform)
+	if err := client.get(keybaseToken, "/_/api/1.0/pkg/get_builds.json", params, &response); err != nil {
+		return nil, err
+	}
+	if response.Builds == nil {
+		return []AnnouncedBuild{}, nil
+	}
+	return response.Builds, nil
+}
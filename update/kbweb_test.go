@@ -0,0 +1,113 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package update
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testKbwebServer starts a stub API server and points the package at it
+func testKbwebServer(t *testing.T, handler http.Handler) *httptest.Server {
+	server := httptest.NewTLSServer(handler)
+	previousURL, previousCA := kbwebAPIUrl, kbwebCA
+	kbwebAPIUrl = server.URL
+	kbwebCA = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+	t.Cleanup(func() {
+		kbwebAPIUrl, kbwebCA = previousURL, previousCA
+		server.Close()
+	})
+	return server
+}
+
+func TestAnnounceBuildDuplicate(t *testing.T) {
+	reply := `{"status": {"code": 0, "name": "OK"}}`
+	testKbwebServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/_/api/1.0/pkg/add_build.json", r.URL.Path)
+		assert.Equal(t, "token", r.Header.Get("x-keybase-admin-token"))
+		_, _ = w.Write([]byte(reply))
+	}))
+
+	require.NoError(t, AnnounceBuild("token", "1.0.0-1", "1.0.0-2", "darwin"))
+
+	reply = `{"status": {"code": 701, "name": "BUILD_EXISTS", "desc": "build already exists"}}`
+	require.NoError(t, AnnounceBuild("token", "1.0.0-1", "1.0.0-2", "darwin"))
+
+	reply = `{"status": {"code": 100, "name": "INPUT_ERROR", "desc": "bad platform"}}`
+	err := AnnounceBuild("token", "1.0.0-1", "1.0.0-2", "darwin")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "bad platform")
+}
+
+func TestReadToken(t *testing.T) {
+	t.Setenv("TEST_KEYBASE_TOKEN", " envtoken\n")
+	token, err := ReadToken("env:TEST_KEYBASE_TOKEN")
+	require.NoError(t, err)
+	assert.Equal(t, "envtoken", token)
+
+	path := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(path, []byte("filetoken\n"), 0600))
+	token, err = ReadToken("file:" + path)
+	require.NoError(t, err)
+	assert.Equal(t, "filetoken", token)
+
+	token, err = ReadToken("literal")
+	require.NoError(t, err)
+	assert.Equal(t, "literal", token)
+
+	_, err = ReadToken("env:TEST_KEYBASE_TOKEN_UNSET")
+	require.Error(t, err)
+	require.NoError(t, os.WriteFile(path, []byte(" \n"), 0600))
+	_, err = ReadToken("file:" + path)
+	require.Error(t, err)
+	_, err = ReadToken("file:" + filepath.Join(t.TempDir(), "missing"))
+	require.Error(t, err)
+	_, err = ReadToken("")
+	require.Error(t, err)
+}
+
+func TestListInTesting(t *testing.T) {
+	reply := `{"status": {"code": 0, "name": "OK"}, "builds": [{"version_a": "1.0.0-1", "platform": "darwin", "in_testing": true, "max_testers": 5}]}`
+	testKbwebServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "GET", r.Method)
+		assert.Equal(t, "/_/api/1.0/pkg/get_in_testing.json", r.URL.Path)
+		assert.Equal(t, "darwin", r.URL.Query().Get("platform"))
+		assert.Equal(t, "token", r.Header.Get("x-keybase-admin-token"))
+		_, _ = w.Write([]byte(reply))
+	}))
+
+	builds, err := ListInTesting("token", "darwin")
+	require.NoError(t, err)
+	assert.Equal(t, []TestingBuild{{VersionA: "1.0.0-1", Platform: "darwin", InTesting: true, MaxTesters: 5}}, builds)
+
+	reply = `{"status": {"code": 0, "name": "OK"}}`
+	builds, err = ListInTesting("token", "darwin")
+	require.NoError(t, err)
+	assert.Empty(t, builds)
+
+	reply = `{"status": {"code": 100, "name": "INPUT_ERROR", "desc": "bad platform"}}`
+	_, err = ListInTesting("token", "darwin")
+	require.Error(t, err)
+}
+
+func TestCACertInfo(t *testing.T) {
+	notBefore, notAfter, err := CACertInfo()
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2023, time.December, 31, 19, 3, 19, 0, time.UTC), notBefore.UTC())
+	assert.Equal(t, time.Date(6023, time.December, 31, 19, 3, 19, 0, time.UTC), notAfter.UTC())
+
+	previous := kbwebCA
+	kbwebCA = "not a cert"
+	defer func() { kbwebCA = previous }()
+	_, _, err = CACertInfo()
+	require.Error(t, err)
+}
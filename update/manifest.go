@@ -0,0 +1,149 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package update
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Manifest describes a release's update for each platform, for generating
+// all its update JSON at once
+type Manifest struct {
+	Version string `json:"version"`
+	// Name defaults to the version tag (v1.2.3)
+	Name string `json:"name,omitempty"`
+	// Description is the path to a description file
+	Description string                      `json:"description,omitempty"`
+	Platforms   map[string]ManifestPlatform `json:"platforms"`
+}
+
+// ManifestPlatform describes the update for a platform
+type ManifestPlatform struct {
+	// Src, URI and Signature are as for EncodeJSON
+	Src       string `json:"src"`
+	URI       string `json:"uri"`
+	Signature string `json:"signature,omitempty"`
+	// Props are name:value properties, like dokan product codes for windows
+	Props []string `json:"props,omitempty"`
+	// OS and Arch are the platform the asset is for (optional)
+	OS   string `json:"os,omitempty"`
+	Arch string `json:"arch,omitempty"`
+}
+
+// ReadManifest reads a (JSON) manifest
+func ReadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("Invalid manifest %s: %s", path, err)
+	}
+	if manifest.Version == "" {
+		return nil, fmt.Errorf("No version in manifest %s", path)
+	}
+	if len(manifest.Platforms) == 0 {
+		return nil, fmt.Errorf("No platforms in manifest %s", path)
+	}
+	return &manifest, nil
+}
+
+// EncodeJSON returns update JSON for a platform in the manifest
+func (m Manifest) EncodeJSON(platformName string) ([]byte, error) {
+	return m.encodeJSON(platformName, nil)
+}
+
+func (m Manifest) encodeJSON(platformName string, digests map[string]string) ([]byte, error) {
+	platform, ok := m.Platforms[platformName]
+	if !ok {
+		return nil, fmt.Errorf("No platform %s in manifest", platformName)
+	}
+	name := m.Name
+	if name == "" {
+		name = fmt.Sprintf("v%s", m.Version)
+	}
+	var uri fmt.Stringer
+	if platform.URI != "" {
+		u, err := url.Parse(platform.URI)
+		if err != nil {
+			return nil, err
+		}
+		uri = u
+	}
+	return encodeJSON(m.Version, name, m.Description, platform.Props, platform.Src, uri, platform.Signature, EncodeOptions{OS: platform.OS, Arch: platform.Arch}, digests)
+}
+
+// WriteManifestJSON generates update JSON (update-<platform>-<env>.json) for
+// each platform in the manifest, writing it to destDir and/or uploading it to
+// bucketName, if set. All platforms are attempted and any errors are combined.
+// Sources are hashed up to concurrency at a time.
+func WriteManifestJSON(manifest Manifest, env string, destDir string, bucketName string, concurrency int) error {
+	if bucketName == "" {
+		return writeManifestJSON(nil, manifest, env, destDir, "", concurrency)
+	}
+	client, err := NewClient()
+	if err != nil {
+		return err
+	}
+	return client.WriteManifestJSON(manifest, env, destDir, bucketName, concurrency)
+}
+
+// WriteManifestJSON generates update JSON for the manifest for the Client
+func (c *Client) WriteManifestJSON(manifest Manifest, env string, destDir string, bucketName string, concurrency int) error {
+	if bucketName == "" {
+		return writeManifestJSON(nil, manifest, env, destDir, "", concurrency)
+	}
+	return writeManifestJSON(c, manifest, env, destDir, bucketName, concurrency)
+}
+
+// writeManifestJSON generates update JSON for the manifest, uploading it with
+// client, if set
+func writeManifestJSON(client *Client, manifest Manifest, env string, destDir string, bucketName string, concurrency int) error {
+	platformNames := []string{}
+	for platformName := range manifest.Platforms {
+		platformNames = append(platformNames, platformName)
+	}
+	sort.Strings(platformNames)
+
+	srcs := []string{}
+	for _, platformName := range platformNames {
+		if src := manifest.Platforms[platformName].Src; src != "" {
+			srcs = append(srcs, src)
+		}
+	}
+	// Sources we couldn't hash are retried (and their errors combined) below
+	digests, _ := DigestAllWithConcurrency(srcs, concurrency)
+
+	errs := []error{}
+	for _, platformName := range platformNames {
+		data, err := manifest.encodeJSON(platformName, digests)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", platformName, err))
+			continue
+		}
+		name := updateJSONName("", platformName, env)
+		if destDir != "" {
+			path := filepath.Join(destDir, name)
+			log.Printf("Writing %s", path)
+			if err := writeFile(path, data); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %s", platformName, err))
+				continue
+			}
+		}
+		if client != nil {
+			log.Printf("Uploading %s", name)
+			if err := client.putObject(bucketName, name, data, "application/json"); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %s", platformName, err))
+			}
+		}
+	}
+	return CombineErrors(errs...)
+}
@@ -0,0 +1,199 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package update
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricsFile is where to write metrics, if set
+var metricsFile string
+
+// SetMetricsFile sets a file to write metrics to (in the Prometheus textfile
+// format, for node_exporter's textfile collector). It's rewritten after each
+// promotion, copy or upload, so it's up to date even if the command fails.
+// Metrics are off if empty.
+func SetMetricsFile(path string) {
+	metricsFile = path
+}
+
+// promotionLabels identifies a promotion's metrics
+type promotionLabels struct {
+	platform string
+	channel  string
+}
+
+// metricsRecorder collects metrics for a run
+type metricsRecorder struct {
+	sync.Mutex
+	promotionSuccess  map[promotionLabels]bool
+	promotionDuration map[promotionLabels]time.Duration
+	bytesCopied       int64
+	bytesUploaded     int64
+	commands          map[string]commandRun
+	now               func() time.Time
+}
+
+// commandRun is a successful run of a command
+type commandRun struct {
+	duration time.Duration
+	finished time.Time
+}
+
+func newMetricsRecorder() *metricsRecorder {
+	return &metricsRecorder{
+		promotionSuccess:  map[promotionLabels]bool{},
+		promotionDuration: map[promotionLabels]time.Duration{},
+		commands:          map[string]commandRun{},
+		now:               time.Now,
+	}
+}
+
+var metrics = newMetricsRecorder()
+
+// promotion records a promotion (or copy to latest)
+func (m *metricsRecorder) promotion(platform string, channel string, duration time.Duration, success bool) {
+	m.Lock()
+	labels := promotionLabels{platform: platform, channel: channel}
+	m.promotionSuccess[labels] = success
+	m.promotionDuration[labels] = duration
+	m.Unlock()
+	m.flush()
+}
+
+// copied records bytes copied within S3
+func (m *metricsRecorder) copied(n int64) {
+	m.Lock()
+	m.bytesCopied += n
+	m.Unlock()
+	m.flush()
+}
+
+// uploaded records bytes uploaded to S3
+func (m *metricsRecorder) uploaded(n int64) {
+	m.Lock()
+	m.bytesUploaded += n
+	m.Unlock()
+	m.flush()
+}
+
+// RecordCommand records that a command completed successfully, and how long
+// it took, writing the metrics file if there is one
+func RecordCommand(command string, duration time.Duration) {
+	metrics.Lock()
+	metrics.commands[command] = commandRun{duration: duration, finished: metrics.now()}
+	metrics.Unlock()
+	metrics.flush()
+}
+
+// flush writes the metrics file, if there is one. It's best effort, failures
+// are only logged.
+func (m *metricsRecorder) flush() {
+	if metricsFile == "" {
+		return
+	}
+	if err := m.writeFile(metricsFile); err != nil {
+		log.Printf("Error writing metrics to %s: %s", metricsFile, err)
+	}
+}
+
+// writeFile writes the metrics to path atomically, so the collector never
+// reads a partial file
+func (m *metricsRecorder) writeFile(path string) error {
+	var buf bytes.Buffer
+	if err := m.write(&buf); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".metrics")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// write writes the metrics in the Prometheus text format
+func (m *metricsRecorder) write(w io.Writer) error {
+	m.Lock()
+	defer m.Unlock()
+
+	promotions := []promotionLabels{}
+	for labels := range m.promotionSuccess {
+		promotions = append(promotions, labels)
+	}
+	sort.Slice(promotions, func(i, j int) bool {
+		if promotions[i].platform == promotions[j].platform {
+			return promotions[i].channel < promotions[j].channel
+		}
+		return promotions[i].platform < promotions[j].platform
+	})
+	commands := []string{}
+	for command := range m.commands {
+		commands = append(commands, command)
+	}
+	sort.Strings(commands)
+
+	var b strings.Builder
+	if len(promotions) > 0 {
+		writeMetricHeader(&b, "release_promotion_success", "gauge", "Whether the last promotion for a platform and channel succeeded")
+		for _, labels := range promotions {
+			value := 0
+			if m.promotionSuccess[labels] {
+				value = 1
+			}
+			fmt.Fprintf(&b, "release_promotion_success{platform=%s,channel=%s} %d\n", quoteLabel(labels.platform), quoteLabel(labels.channel), value)
+		}
+		writeMetricHeader(&b, "release_promotion_duration_seconds", "gauge", "How long the last promotion for a platform and channel took")
+		for _, labels := range promotions {
+			fmt.Fprintf(&b, "release_promotion_duration_seconds{platform=%s,channel=%s} %g\n", quoteLabel(labels.platform), quoteLabel(labels.channel), m.promotionDuration[labels].Seconds())
+		}
+	}
+	writeMetricHeader(&b, "release_bytes_copied", "gauge", "Bytes copied within S3 by the last run")
+	fmt.Fprintf(&b, "release_bytes_copied %d\n", m.bytesCopied)
+	writeMetricHeader(&b, "release_bytes_uploaded", "gauge", "Bytes uploaded to S3 by the last run")
+	fmt.Fprintf(&b, "release_bytes_uploaded %d\n", m.bytesUploaded)
+	if len(commands) > 0 {
+		writeMetricHeader(&b, "release_command_duration_seconds", "gauge", "How long the last successful run of a command took")
+		for _, command := range commands {
+			fmt.Fprintf(&b, "release_command_duration_seconds{command=%s} %g\n", quoteLabel(command), m.commands[command].duration.Seconds())
+		}
+		writeMetricHeader(&b, "release_command_last_success_timestamp_seconds", "gauge", "When a command last completed successfully")
+		for _, command := range commands {
+			fmt.Fprintf(&b, "release_command_last_success_timestamp_seconds{command=%s} %d\n", quoteLabel(command), m.commands[command].finished.Unix())
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeMetricHeader(b *strings.Builder, name string, metricType string, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+// quoteLabel quotes a label value, escaping as the text format requires
+func quoteLabel(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	value = strings.ReplaceAll(value, "\n", `\n`)
+	return `"` + value + `"`
+}
@@ -0,0 +1,72 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package update
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsWrite(t *testing.T) {
+	m := newMetricsRecorder()
+	m.now = func() time.Time { return time.Unix(1460000000, 0) }
+	m.promotion("windows", "v2", 1500*time.Millisecond, false)
+	m.promotion("darwin", "v2", 2*time.Second, true)
+	m.promotion("darwin", "latest", 250*time.Millisecond, true)
+	m.copied(100)
+	m.copied(23)
+	m.uploaded(5)
+	m.commands[`promote-"releases"`] = commandRun{duration: 3 * time.Second, finished: m.now()}
+
+	var buf bytes.Buffer
+	require.NoError(t, m.write(&buf))
+	assert.Equal(t, `# HELP release_promotion_success Whether the last promotion for a platform and channel succeeded
+# TYPE release_promotion_success gauge
+release_promotion_success{platform="darwin",channel="latest"} 1
+release_promotion_success{platform="darwin",channel="v2"} 1
+release_promotion_success{platform="windows",channel="v2"} 0
+# HELP release_promotion_duration_seconds How long the last promotion for a platform and channel took
+# TYPE release_promotion_duration_seconds gauge
+release_promotion_duration_seconds{platform="darwin",channel="latest"} 0.25
+release_promotion_duration_seconds{platform="darwin",channel="v2"} 2
+release_promotion_duration_seconds{platform="windows",channel="v2"} 1.5
+# HELP release_bytes_copied Bytes copied within S3 by the last run
+# TYPE release_bytes_copied gauge
+release_bytes_copied 123
+# HELP release_bytes_uploaded Bytes uploaded to S3 by the last run
+# TYPE release_bytes_uploaded gauge
+release_bytes_uploaded 5
+# HELP release_command_duration_seconds How long the last successful run of a command took
+# TYPE release_command_duration_seconds gauge
+release_command_duration_seconds{command="promote-\"releases\""} 3
+# HELP release_command_last_success_timestamp_seconds When a command last completed successfully
+# TYPE release_command_last_success_timestamp_seconds gauge
+release_command_last_success_timestamp_seconds{command="promote-\"releases\""} 1460000000
+`, buf.String())
+}
+
+func TestMetricsFile(t *testing.T) {
+	previous, previousFile := metrics, metricsFile
+	t.Cleanup(func() { metrics, metricsFile = previous, previousFile })
+	metrics = newMetricsRecorder()
+	path := filepath.Join(t.TempDir(), "release.prom")
+	SetMetricsFile(path)
+
+	svc := newFakeS3()
+	client := newTestClient(svc)
+	require.NoError(t, client.putObject(testBucket, "index.html", []byte("html"), "text/html"))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "release_bytes_uploaded 4\n")
+	files, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+}
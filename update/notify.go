@@ -0,0 +1,60 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package update
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Notification is posted (as JSON) to the notify URL after a promotion
+type Notification struct {
+	Platform string `json:"platform"`
+	Channel  string `json:"channel"`
+	Version  string `json:"version"`
+	URL      string `json:"url"`
+}
+
+// notifyURL is where to post notifications, if set
+var notifyURL string
+
+// notifyTimeout is how long to wait for the notify URL to respond
+const notifyTimeout = 10 * time.Second
+
+// SetNotifyURL sets a URL (like a Slack webhook) to post a Notification to
+// after each successful promotion. Notifications are off if empty.
+func SetNotifyURL(u string) {
+	notifyURL = u
+}
+
+// notify posts a notification, if there is a notify URL. It's best effort,
+// failures are only logged.
+func (c *Client) notify(n Notification) {
+	if notifyURL == "" {
+		return
+	}
+	if err := postNotification(notifyURL, n); err != nil {
+		c.logf("Error notifying %s: %s", notifyURL, err)
+	}
+}
+
+func postNotification(u string, n Notification) error {
+	data, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	client := http.Client{Timeout: notifyTimeout}
+	resp, err := client.Post(u, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("Server returned %s", resp.Status)
+	}
+	return nil
+}
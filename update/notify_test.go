@@ -0,0 +1,64 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package update
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testNotifyServer(t *testing.T, status int) *[]Notification {
+	notifications := []Notification{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		var n Notification
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&n))
+		notifications = append(notifications, n)
+		w.WriteHeader(status)
+	}))
+	SetNotifyURL(server.URL)
+	t.Cleanup(func() {
+		SetNotifyURL("")
+		server.Close()
+	})
+	return &notifications
+}
+
+func TestNotifyOnPromote(t *testing.T) {
+	notifications := testNotifyServer(t, http.StatusOK)
+	svc := newFakeS3()
+	svc.add("darwin/Keybase-1.0.15-20160401013917+abcdef0.dmg", "dmg")
+	svc.add("darwin-support/update-darwin-prod-1.0.15-20160401013917+abcdef0.json", `{"version": "1.0.15-20160401013917+abcdef0"}`)
+	client := newTestClient(svc)
+	platform, err := supportPlatform(PlatformTypeDarwin)
+	require.NoError(t, err)
+
+	_, err = client.PromoteRelease(testBucket, 0, 0, "v2", platform, EnvProd, PromoteOptions{})
+	require.NoError(t, err)
+	require.Len(t, *notifications, 1)
+	assert.Equal(t, Notification{
+		Platform: "darwin",
+		Channel:  "v2",
+		Version:  "1.0.15-20160401013917+abcdef0",
+		URL:      "https://s3.amazonaws.com/test.keybase.io/darwin/Keybase-1.0.15-20160401013917%2Babcdef0.dmg",
+	}, (*notifications)[0])
+}
+
+func TestNotifyFailureDoesntFailPromote(t *testing.T) {
+	notifications := testNotifyServer(t, http.StatusInternalServerError)
+	svc := newFakeS3()
+	svc.add("darwin/Keybase-1.0.15-20160401013917+abcdef0.dmg", "dmg")
+	svc.add("darwin-support/update-darwin-prod-1.0.15-20160401013917+abcdef0.json", `{"version": "1.0.15-20160401013917+abcdef0"}`)
+	client := newTestClient(svc)
+
+	release, err := client.promoteAReleaseToProd("1.0.15-20160401013917+abcdef0", testBucket, platformDarwin, EnvProd, "v2", false)
+	require.NoError(t, err)
+	require.NotNil(t, release)
+	assert.Len(t, *notifications, 1)
+}
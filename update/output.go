@@ -0,0 +1,26 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package update
+
+import (
+	"encoding/json"
+	"io"
+)
+
+const (
+	// OutputTable is human readable (table) output for report commands
+	OutputTable = "table"
+	// OutputJSON is machine readable (JSON) output for report commands
+	OutputJSON = "json"
+)
+
+// Outputs are the output formats for report commands
+var Outputs = []string{OutputTable, OutputJSON}
+
+// WriteJSON writes a result as (indented) JSON
+func WriteJSON(writer io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
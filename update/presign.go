@@ -0,0 +1,37 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package update
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// MaxPresignTTL is the longest S3 allows a presigned URL to be valid for
+const MaxPresignTTL = 7 * 24 * time.Hour
+
+// PresignAsset returns a presigned (time-limited) download URL for a key in a
+// bucket, for buckets that aren't public-read
+func PresignAsset(bucketName string, key string, ttl time.Duration) (string, error) {
+	client, err := NewClient()
+	if err != nil {
+		return "", err
+	}
+	return client.PresignAsset(bucketName, key, ttl)
+}
+
+// PresignAsset returns a presigned download URL for the Client
+func (c *Client) PresignAsset(bucketName string, key string, ttl time.Duration) (string, error) {
+	if ttl <= 0 || ttl > MaxPresignTTL {
+		return "", fmt.Errorf("Invalid TTL %s, must be positive and at most %s", ttl, MaxPresignTTL)
+	}
+	req, _ := c.svc.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(key),
+	})
+	return req.Presign(ttl)
+}
@@ -7,11 +7,17 @@ import "time"
 
 // Asset describes a downloadable file.
 type Asset struct {
-	Name      string `codec:"name" json:"name"`
-	URL       string `codec:"url" json:"url"`
-	Digest    string `codec:"digest" json:"digest"`
-	Signature string `codec:"signature" json:"signature"`
-	LocalPath string `codec:"localPath" json:"localPath"`
+	Name   string `codec:"name" json:"name"`
+	URL    string `codec:"url" json:"url"`
+	Digest string `codec:"digest" json:"digest"`
+	// DigestSHA512 is the SHA-512 digest, if requested (Digest is SHA-256)
+	DigestSHA512 string `codec:"digestSHA512,omitempty" json:"digestSHA512,omitempty"`
+	Signature    string `codec:"signature" json:"signature"`
+	LocalPath    string `codec:"localPath" json:"localPath"`
+	// OS and Arch are the platform the asset is for, if specified (see
+	// PlatformsForOS and PlatformsForArch)
+	OS   string `codec:"os,omitempty" json:"os,omitempty"`
+	Arch string `codec:"arch,omitempty" json:"arch,omitempty"`
 }
 
 // Type is the type of update
@@ -42,6 +48,9 @@ type Update struct {
 	PublishedAt  *Time      `codec:"publishedAt,omitempty" json:"publishedAt,omitempty"`
 	Props        []Property `codec:"props" json:"props,omitempty"`
 	Asset        *Asset     `codec:"asset,omitempty" json:"asset,omitempty"`
+	// RolloutPercent is the percentage (0-100) of users to get the update (see
+	// ShouldRollout). It's only set for a staged rollout; nil is everyone.
+	RolloutPercent *int `codec:"rolloutPercent,omitempty" json:"rolloutPercent,omitempty"`
 }
 
 // Time as millis
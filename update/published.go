@@ -0,0 +1,50 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package update
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// WaitForPublished polls the public update JSON at url until it reports
+// expectedVersion, so we know a promotion has propagated (through any cache)
+// before announcing it.
+func WaitForPublished(url string, expectedVersion string, timeout time.Duration, interval time.Duration) error {
+	start := time.Now()
+	for {
+		version, err := publishedVersion(url)
+		if err != nil {
+			log.Printf("Error checking %s: %s", url, err)
+		} else if version == expectedVersion {
+			log.Printf("Published %s at %s", version, url)
+			return nil
+		} else {
+			log.Printf("Found %s at %s, waiting for %s", version, url, expectedVersion)
+		}
+		if time.Since(start)+interval > timeout {
+			return fmt.Errorf("Timed out waiting for %s at %s", expectedVersion, url)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// publishedVersion returns the version of the update JSON at url
+func publishedVersion(url string) (string, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Server returned %s", resp.Status)
+	}
+	upd, err := DecodeJSON(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return upd.Version, nil
+}
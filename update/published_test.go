@@ -0,0 +1,35 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package update
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWaitForPublished(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		version := "1.0.14"
+		if requests > 2 {
+			version = "1.0.15"
+		}
+		fmt.Fprintf(w, `{"version": %q}`, version)
+	}))
+	defer server.Close()
+
+	require.NoError(t, WaitForPublished(server.URL, "1.0.15", time.Second, time.Millisecond))
+	assert.Equal(t, 3, requests)
+
+	err := WaitForPublished(server.URL, "1.0.16", 5*time.Millisecond, time.Millisecond)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Timed out")
+}
@@ -0,0 +1,67 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package update
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// ReconstructSupportJSON restores a missing versioned update JSON (in the
+// platform's support prefix) from a channel that still points to version, so
+// it can be promoted again.
+func ReconstructSupportJSON(bucketName string, platformName string, env string, version string) error {
+	client, err := NewClient()
+	if err != nil {
+		return err
+	}
+	return client.ReconstructSupportJSON(bucketName, platformName, env, version)
+}
+
+// ReconstructSupportJSON restores a versioned update JSON for the Client
+func (c *Client) ReconstructSupportJSON(bucketName string, platformName string, env string, version string) error {
+	platform, err := supportPlatform(platformName)
+	if err != nil {
+		return err
+	}
+	key := versionedUpdateJSONKey(platform, env, version)
+	if _, err := c.svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(key),
+	}); err == nil {
+		return fmt.Errorf("%s already exists", key)
+	}
+
+	channels := []string{}
+	for _, pcs := range [][]platformChannel{publicChannels, testChannels} {
+		for _, pc := range pcs {
+			if pc.platform == platform.Name {
+				channels = append(channels, pc.channel)
+			}
+		}
+	}
+	for _, channel := range channels {
+		currentUpdate, path, err := c.CurrentUpdate(bucketName, channel, platform.Name, env)
+		if err != nil {
+			c.logf("Error getting current update at %s: %s", path, err)
+			continue
+		}
+		if currentUpdate.Version != version {
+			c.logf("%s is at %s", path, currentUpdate.Version)
+			continue
+		}
+		c.logf("Copying %s to %s", path, key)
+		_, err = c.copyObject(&s3.CopyObjectInput{
+			Bucket:       aws.String(bucketName),
+			CopySource:   aws.String(copySource(bucketName, path)),
+			Key:          aws.String(key),
+			CacheControl: aws.String(defaultCacheControl),
+			ACL:          aws.String(c.cannedACL()),
+		})
+		return err
+	}
+	return fmt.Errorf("No channel for %s points to %s", platform.Name, version)
+}
@@ -0,0 +1,175 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package update
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Rename is an object to move, from one key to another
+type Rename struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// ReleaseRenames returns the objects (from Platform.Files) to move to rename
+// a release from oldVersion to newVersion
+func ReleaseRenames(platformName string, oldVersion string, newVersion string) ([]Rename, error) {
+	platform, err := PlatformNamed(platformName)
+	if err != nil {
+		return nil, err
+	}
+	if oldVersion == newVersion {
+		return nil, fmt.Errorf("Old and new versions are the same: %s", oldVersion)
+	}
+	from, err := platform.Files(oldVersion)
+	if err != nil {
+		return nil, err
+	}
+	to, err := platform.Files(newVersion)
+	if err != nil {
+		return nil, err
+	}
+	renames := []Rename{}
+	for i := range from {
+		renames = append(renames, Rename{From: from[i], To: to[i]})
+	}
+	return renames, nil
+}
+
+// RenameRelease moves a release's files for a misnamed build, from oldVersion
+// to newVersion. Its versioned update JSON is rewritten with newVersion and
+// the moved asset. It refuses to if any of the new keys exist, or if the
+// update JSON can't be rewritten.
+func RenameRelease(bucketName string, platformName string, oldVersion string, newVersion string) error {
+	client, err := NewClient()
+	if err != nil {
+		return err
+	}
+	return client.RenameRelease(bucketName, platformName, oldVersion, newVersion)
+}
+
+// RenameRelease moves a release's files for the Client
+func (c *Client) RenameRelease(bucketName string, platformName string, oldVersion string, newVersion string) error {
+	renames, err := ReleaseRenames(platformName, oldVersion, newVersion)
+	if err != nil {
+		return err
+	}
+
+	moves := []Rename{}
+	for _, rename := range renames {
+		exists, err := c.objectExists(bucketName, rename.To)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("%s already exists", rename.To)
+		}
+		exists, err = c.objectExists(bucketName, rename.From)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			c.logf("No %s, skipping", rename.From)
+			continue
+		}
+		moves = append(moves, rename)
+	}
+	if len(moves) == 0 {
+		return fmt.Errorf("No files for %s release %s", platformName, oldVersion)
+	}
+
+	platform, err := PlatformNamed(platformName)
+	if err != nil {
+		return err
+	}
+	jsonKey := versionedUpdateJSONKey(platform, EnvProd, oldVersion)
+	var updateJSON []byte
+	for _, move := range moves {
+		if move.From == jsonKey {
+			if updateJSON, err = c.renamedUpdateJSON(bucketName, move.From, oldVersion, newVersion, renames); err != nil {
+				return fmt.Errorf("Can't rewrite %s: %s", move.From, err)
+			}
+		}
+	}
+
+	// Copy everything before deleting anything, so a failed copy doesn't
+	// lose files
+	for _, move := range moves {
+		if move.From == jsonKey {
+			c.logf("Writing %s for %s", move.To, newVersion)
+			if err := c.putObject(bucketName, move.To, updateJSON, "application/json"); err != nil {
+				return err
+			}
+			continue
+		}
+		c.logf("Copying %s to %s", move.From, move.To)
+		_, err := c.copyObject(&s3.CopyObjectInput{
+			Bucket:       aws.String(bucketName),
+			CopySource:   aws.String(copySource(bucketName, move.From)),
+			Key:          aws.String(move.To),
+			CacheControl: aws.String(defaultCacheControl),
+			ACL:          aws.String(c.cannedACL()),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, move := range moves {
+		c.logf("Deleting %s", move.From)
+		_, err := c.svc.DeleteObject(&s3.DeleteObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String(move.From),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renamedUpdateJSON returns the update JSON at key for oldVersion, rewritten
+// for newVersion, with its asset's name and URL for where it's moved to
+func (c *Client) renamedUpdateJSON(bucketName string, key string, oldVersion string, newVersion string, renames []Rename) ([]byte, error) {
+	upd, err := c.getUpdate(bucketName, key)
+	if err != nil {
+		return nil, err
+	}
+	if upd.Version != oldVersion {
+		return nil, fmt.Errorf("It's for version %s, not %s", upd.Version, oldVersion)
+	}
+	renamed := *upd
+	renamed.Version = newVersion
+	renamed.Name = strings.Replace(upd.Name, oldVersion, newVersion, 1)
+	if upd.Asset != nil {
+		assetKey, err := keyForURL(bucketName, upd.Asset.URL)
+		if err != nil {
+			return nil, err
+		}
+		newAssetKey := ""
+		for _, rename := range renames {
+			if rename.From == assetKey {
+				newAssetKey = rename.To
+			}
+		}
+		if newAssetKey == "" {
+			return nil, fmt.Errorf("Its asset %s isn't one of the release's files", assetKey)
+		}
+		asset := *upd.Asset
+		asset.Name = path.Base(newAssetKey)
+		asset.URL = strings.Replace(upd.Asset.URL, url.QueryEscape(path.Base(assetKey)), url.QueryEscape(asset.Name), 1)
+		if key, err := keyForURL(bucketName, asset.URL); err != nil || key != newAssetKey {
+			return nil, fmt.Errorf("Couldn't make a URL for %s from %s", newAssetKey, upd.Asset.URL)
+		}
+		renamed.Asset = &asset
+	}
+	return json.MarshalIndent(renamed, "", "  ")
+}
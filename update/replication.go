@@ -0,0 +1,83 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package update
+
+import (
+	"context"
+	"fmt"
+)
+
+// VerifyReplication checks that a channel's update JSON in a replica bucket
+// (which can be in another region) refers to the same version as in the
+// primary bucket, and that its asset exists in both
+func VerifyReplication(primaryBucket string, replicaBucket string, platformName string, channel string, env string) error {
+	return VerifyReplicationWithContext(context.Background(), primaryBucket, replicaBucket, platformName, channel, env)
+}
+
+// VerifyReplicationWithContext is VerifyReplication with a context for its S3
+// requests
+func VerifyReplicationWithContext(ctx context.Context, primaryBucket string, replicaBucket string, platformName string, channel string, env string) error {
+	primary, err := NewClient()
+	if err != nil {
+		return err
+	}
+	replica, err := NewClientForBucket(replicaBucket)
+	if err != nil {
+		return err
+	}
+	return primary.WithContext(ctx).VerifyReplication(replica.WithContext(ctx), primaryBucket, replicaBucket, platformName, channel, env)
+}
+
+// VerifyReplication checks the replica (using the replica Client) matches
+// the primary for the Client
+func (c *Client) VerifyReplication(replica *Client, primaryBucket string, replicaBucket string, platformName string, channel string, env string) error {
+	primaryUpdate, primaryPath, err := c.CurrentUpdate(primaryBucket, channel, platformName, env)
+	if err != nil {
+		return fmt.Errorf("Error getting update at %s in %s: %s", primaryPath, primaryBucket, err)
+	}
+	replicaUpdate, replicaPath, err := replica.CurrentUpdate(replicaBucket, channel, platformName, env)
+	if err != nil {
+		return fmt.Errorf("Error getting update at %s in %s: %s", replicaPath, replicaBucket, err)
+	}
+	if primaryUpdate.Version != replicaUpdate.Version {
+		return fmt.Errorf("Update at %s is %s in %s but %s in %s", primaryPath, primaryUpdate.Version, primaryBucket, replicaUpdate.Version, replicaBucket)
+	}
+	if primaryUpdate.Asset == nil {
+		return nil
+	}
+	if replicaUpdate.Asset == nil {
+		return fmt.Errorf("Update at %s has no asset in %s", replicaPath, replicaBucket)
+	}
+
+	// The replica's update JSON is a copy, so its asset URL can be for either
+	// bucket
+	assetKey, err := keyForURL(primaryBucket, primaryUpdate.Asset.URL)
+	if err != nil {
+		return fmt.Errorf("Invalid asset in update JSON at %s: %s", primaryPath, err)
+	}
+	replicaAssetKey, err := keyForURL(replicaBucket, replicaUpdate.Asset.URL)
+	if err != nil {
+		replicaAssetKey, err = keyForURL(primaryBucket, replicaUpdate.Asset.URL)
+	}
+	if err != nil {
+		return fmt.Errorf("Invalid asset in update JSON at %s in %s: %s", replicaPath, replicaBucket, err)
+	}
+	if assetKey != replicaAssetKey {
+		return fmt.Errorf("Update at %s has asset %s in %s but %s in %s", primaryPath, assetKey, primaryBucket, replicaAssetKey, replicaBucket)
+	}
+
+	for _, b := range []struct {
+		client *Client
+		bucket string
+	}{{c, primaryBucket}, {replica, replicaBucket}} {
+		exists, err := b.client.objectExists(b.bucket, assetKey)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("Asset %s doesn't exist in %s", assetKey, b.bucket)
+		}
+	}
+	return nil
+}
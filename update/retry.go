@@ -0,0 +1,122 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package update
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// s3MaxRetries is how many times an S3 request with a transient error is
+// retried
+const s3MaxRetries = 3
+
+// transientErrorCodes are the S3 (and SDK) error codes worth retrying. Other
+// errors, like AccessDenied, NoSuchBucket or InvalidArgument, won't go away
+// by retrying.
+var transientErrorCodes = map[string]bool{
+	"RequestTimeout":      true,
+	"SlowDown":            true,
+	"InternalError":       true,
+	"ServiceUnavailable":  true,
+	"Throttling":          true,
+	"ThrottlingException": true,
+	// RequestError is the SDK's code for a failure to send the request, like a
+	// connection reset
+	"RequestError": true,
+}
+
+// isRetryable returns true if an S3 error is transient, so retrying might
+// succeed
+func isRetryable(err error) bool {
+	if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() >= 500 {
+		return true
+	}
+	if awsErr, ok := err.(awserr.Error); ok {
+		return transientErrorCodes[awsErr.Code()]
+	}
+	return false
+}
+
+// s3Retryer retries only transient errors, so misconfigurations fail fast
+// instead of backing off
+type s3Retryer struct {
+	client.DefaultRetryer
+}
+
+// ShouldRetry returns true if the request's error is transient
+func (r s3Retryer) ShouldRetry(req *request.Request) bool {
+	return isRetryable(req.Error)
+}
+
+// s3RetryConfig configures an S3 service to retry with s3Retryer
+func s3RetryConfig() *aws.Config {
+	return request.WithRetryer(aws.NewConfig(), s3Retryer{client.DefaultRetryer{NumMaxRetries: s3MaxRetries}})
+}
+
+// noSDKRetries turns off the SDK's retries for a request, for copies and puts
+// that withRetry retries instead, so they aren't retried by both
+func noSDKRetries(r *request.Request) {
+	r.Retryer = client.DefaultRetryer{NumMaxRetries: 0}
+}
+
+// Retry is how the copies and puts a Client makes are retried on transient
+// errors (instead of the SDK's retries), so a promotion survives S3 being
+// briefly flaky
+type Retry struct {
+	// MaxAttempts is the most times a request is made (0 or 1 for no retries)
+	MaxAttempts int
+	// Backoff is the delay before the first retry, which doubles after each
+	Backoff time.Duration
+}
+
+// DefaultRetry is the Retry for Clients created by NewClient, unless set
+var DefaultRetry = Retry{MaxAttempts: 5, Backoff: time.Second}
+
+// retry applies to Clients created by NewClient
+var retry = DefaultRetry
+
+// SetRetry sets how Clients created by NewClient after this retry copies and
+// puts
+func SetRetry(r Retry) {
+	retry = r
+}
+
+// withRetry calls f until it succeeds, fails with an error that isn't
+// transient, or runs out of attempts, logging each retry. It stops waiting to
+// retry once ctx is done.
+func (c *Client) withRetry(ctx context.Context, desc string, f func() error) error {
+	backoff := c.retry.Backoff
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || attempt >= c.retry.MaxAttempts || !isRetryable(err) {
+			return err
+		}
+		c.logf("Error %s (attempt %d of %d), retrying in %s: %s", desc, attempt, c.retry.MaxAttempts, backoff, err)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("S3 request canceled: %w", ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// copyObject copies an object, retrying transient errors
+func (c *Client) copyObject(input *s3.CopyObjectInput) (*s3.CopyObjectOutput, error) {
+	ctx := c.context()
+	var output *s3.CopyObjectOutput
+	err := c.withRetry(ctx, "copying "+aws.StringValue(input.CopySource), func() (err error) {
+		output, err = c.svc.CopyObjectWithContext(ctx, input, noSDKRetries)
+		return err
+	})
+	return output, err
+}
@@ -0,0 +1,146 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package update
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsRetryable(t *testing.T) {
+	for _, test := range []struct {
+		err       error
+		retryable bool
+	}{
+		{awserr.NewRequestFailure(awserr.New("RequestTimeout", "timeout", nil), 400, "id"), true},
+		{awserr.NewRequestFailure(awserr.New("SlowDown", "slow down", nil), 503, "id"), true},
+		{awserr.NewRequestFailure(awserr.New("InternalError", "internal", nil), 500, "id"), true},
+		{awserr.NewRequestFailure(awserr.New("Unknown", "bad gateway", nil), 502, "id"), true},
+		{awserr.New("RequestError", "connection reset", nil), true},
+		{awserr.NewRequestFailure(awserr.New("AccessDenied", "denied", nil), 403, "id"), false},
+		{awserr.NewRequestFailure(awserr.New(s3.ErrCodeNoSuchBucket, "no bucket", nil), 404, "id"), false},
+		{awserr.NewRequestFailure(awserr.New(s3.ErrCodeNoSuchKey, "no key", nil), 404, "id"), false},
+		{awserr.NewRequestFailure(awserr.New("InvalidArgument", "invalid ACL", nil), 400, "id"), false},
+		{awserr.New("InvalidAccessKeyId", "bad key", nil), false},
+		{errors.New("not an aws error"), false},
+		{nil, false},
+	} {
+		assert.Equal(t, test.retryable, isRetryable(test.err), "%v", test.err)
+	}
+}
+
+func TestS3RetryerShouldRetry(t *testing.T) {
+	retryer := s3Retryer{}
+	assert.True(t, retryer.ShouldRetry(&request.Request{Error: awserr.NewRequestFailure(awserr.New("SlowDown", "slow down", nil), 503, "id")}))
+	assert.False(t, retryer.ShouldRetry(&request.Request{Error: awserr.NewRequestFailure(awserr.New("AccessDenied", "denied", nil), 403, "id")}))
+}
+
+// flakyS3 is a fake S3 whose copies and puts fail with err a number of times
+// before succeeding
+type flakyS3 struct {
+	*fakeS3
+	failures   int
+	err        error
+	attempts   int
+	sdkRetries int
+}
+
+func (f *flakyS3) fail() error {
+	f.attempts++
+	if f.attempts <= f.failures {
+		return f.err
+	}
+	return nil
+}
+
+func (f *flakyS3) CopyObjectWithContext(ctx aws.Context, input *s3.CopyObjectInput, opts ...request.Option) (*s3.CopyObjectOutput, error) {
+	f.checkNoSDKRetries(opts)
+	if err := f.fail(); err != nil {
+		return nil, err
+	}
+	return f.fakeS3.CopyObjectWithContext(ctx, input, opts...)
+}
+
+func (f *flakyS3) PutObjectWithContext(ctx aws.Context, input *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, error) {
+	f.checkNoSDKRetries(opts)
+	if err := f.fail(); err != nil {
+		// Consume the body, like a failed upload would
+		_, _ = io.Copy(io.Discard, input.Body)
+		return nil, err
+	}
+	return f.fakeS3.PutObjectWithContext(ctx, input, opts...)
+}
+
+// checkNoSDKRetries records whether the request's options turn off the SDK's
+// retries
+func (f *flakyS3) checkNoSDKRetries(opts []request.Option) {
+	req := &request.Request{Retryer: client.DefaultRetryer{NumMaxRetries: s3MaxRetries}}
+	req.ApplyOptions(opts...)
+	f.sdkRetries = req.MaxRetries()
+}
+
+func TestClientRetry(t *testing.T) {
+	slowDown := awserr.NewRequestFailure(awserr.New("SlowDown", "slow down", nil), 503, "id")
+	newFlakyClient := func(failures int, err error) (*Client, *flakyS3) {
+		svc := &flakyS3{fakeS3: newFakeS3(), failures: failures, err: err}
+		svc.add("update-darwin-prod-1.0.0.json", "{}")
+		return &Client{svc: svc, retry: Retry{MaxAttempts: 5, Backoff: time.Millisecond}}, svc
+	}
+
+	// Fails twice then succeeds
+	client, svc := newFlakyClient(2, slowDown)
+	require.NoError(t, client.copyUpdateJSONKey(testBucket, "update-darwin-prod-1.0.0.json", "update-darwin-prod-v2.json"))
+	assert.Equal(t, 3, svc.attempts)
+	assert.Equal(t, "{}", string(svc.objects["update-darwin-prod-v2.json"].body))
+	// Only the Client retries, not the SDK too
+	assert.Equal(t, 0, svc.sdkRetries)
+
+	client, svc = newFlakyClient(2, slowDown)
+	require.NoError(t, client.putObject(testBucket, "index.html", []byte("index"), "text/html"))
+	assert.Equal(t, 3, svc.attempts)
+	assert.Equal(t, "index", string(svc.objects["index.html"].body))
+
+	// Gives up after MaxAttempts
+	client, svc = newFlakyClient(10, slowDown)
+	require.Error(t, client.putObject(testBucket, "index.html", []byte("index"), "text/html"))
+	assert.Equal(t, 5, svc.attempts)
+
+	// Doesn't retry errors that aren't transient
+	client, svc = newFlakyClient(2, awserr.NewRequestFailure(awserr.New("AccessDenied", "denied", nil), 403, "id"))
+	require.Error(t, client.copyUpdateJSONKey(testBucket, "update-darwin-prod-1.0.0.json", "update-darwin-prod-v2.json"))
+	assert.Equal(t, 1, svc.attempts)
+
+	// The zero Retry doesn't retry
+	client, svc = newFlakyClient(2, slowDown)
+	client.retry = Retry{}
+	require.Error(t, client.putObject(testBucket, "index.html", []byte("index"), "text/html"))
+	assert.Equal(t, 1, svc.attempts)
+}
+
+func TestClientRetryContext(t *testing.T) {
+	slowDown := awserr.NewRequestFailure(awserr.New("SlowDown", "slow down", nil), 503, "id")
+	svc := &flakyS3{fakeS3: newFakeS3(), failures: 2, err: slowDown}
+	client := &Client{svc: svc, retry: Retry{MaxAttempts: 5, Backoff: time.Hour}}
+
+	// The backoff is cut short at the deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := client.WithContext(ctx).putObject(testBucket, "index.html", []byte("index"), "text/html")
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), err.Error())
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.Equal(t, 1, svc.attempts)
+}
@@ -0,0 +1,120 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package update
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"golang.org/x/sync/errgroup"
+)
+
+// ReverifyDigests downloads the asset of each version promoted (to a public
+// or test channel) for a platform, and checks it still matches the digest in
+// its update JSON, hashing up to DefaultDigestConcurrency assets at once. It
+// returns a description for each asset that doesn't match.
+func ReverifyDigests(bucketName string, platformName string) ([]string, error) {
+	return ReverifyDigestsWithConcurrency(bucketName, platformName, DefaultDigestConcurrency)
+}
+
+// ReverifyDigestsWithConcurrency is ReverifyDigests, downloading up to
+// concurrency assets at once
+func ReverifyDigestsWithConcurrency(bucketName string, platformName string, concurrency int) ([]string, error) {
+	client, err := NewClient()
+	if err != nil {
+		return nil, err
+	}
+	return client.ReverifyDigests(bucketName, platformName, concurrency)
+}
+
+// ReverifyDigests checks promoted assets' digests for the Client
+func (c *Client) ReverifyDigests(bucketName string, platformName string, concurrency int) ([]string, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	channels := []string{}
+	for _, pcs := range [][]platformChannel{publicChannels, testChannels} {
+		for _, pc := range pcs {
+			if pc.platform == platformName {
+				channels = append(channels, pc.channel)
+			}
+		}
+	}
+	if len(channels) == 0 {
+		return nil, fmt.Errorf("Unsupported for this platform: %s", platformName)
+	}
+
+	// Channels can point to the same asset, which only needs checking once
+	assets := []*Asset{}
+	paths := map[string]string{}
+	for _, channel := range channels {
+		upd, path, err := c.CurrentUpdate(bucketName, channel, platformName, "prod")
+		if err != nil {
+			return nil, err
+		}
+		if upd == nil || upd.Asset == nil {
+			return nil, fmt.Errorf("No asset in update JSON at %s", path)
+		}
+		if _, ok := paths[upd.Asset.URL]; ok {
+			continue
+		}
+		paths[upd.Asset.URL] = path
+		assets = append(assets, upd.Asset)
+	}
+
+	results := make([]string, len(assets))
+	var g errgroup.Group
+	g.SetLimit(concurrency)
+	for i, asset := range assets {
+		i, asset := i, asset
+		g.Go(func() error {
+			path := paths[asset.URL]
+			key, err := keyForURL(bucketName, asset.URL)
+			if err != nil {
+				results[i] = fmt.Sprintf("%s: Invalid asset: %s", path, err)
+				return nil
+			}
+			c.logf("Verifying %s", key)
+			assetDigest, err := c.objectDigest(bucketName, key)
+			if err != nil {
+				results[i] = fmt.Sprintf("%s: Error getting %s: %s", path, key, err)
+				return nil
+			}
+			if assetDigest != asset.Digest {
+				results[i] = fmt.Sprintf("%s: Digest of %s (%s) doesn't match update JSON (%s)", path, key, assetDigest, asset.Digest)
+			}
+			return nil
+		})
+	}
+	_ = g.Wait()
+
+	mismatches := []string{}
+	for _, result := range results {
+		if result != "" {
+			mismatches = append(mismatches, result)
+		}
+	}
+	return mismatches, nil
+}
+
+// objectDigest returns the (sha256) digest of an object, streaming it
+func (c *Client) objectDigest(bucketName string, key string) (string, error) {
+	resp, err := c.svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, c.bandwidth.Reader(resp.Body)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
@@ -5,9 +5,11 @@ package update
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"log"
+	"net/url"
 	"os"
 	"path/filepath"
 	"sort"
@@ -17,32 +19,71 @@ import (
 
 	"github.com/alecthomas/template"
 	"github.com/blang/semver"
+	"github.com/keybase/release/bandwidth"
 	"github.com/keybase/release/version"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"golang.org/x/sync/errgroup"
 )
 
 const defaultCacheControl = "max-age=60"
 
 const defaultChannel = "v2"
 
+// defaultRegion is the region of our buckets
+const defaultRegion = "us-east-1"
+
+const (
+	// EnvProd is the production environment
+	EnvProd = "prod"
+	// EnvStaging is an environment for exercising promotion separately from prod
+	EnvStaging = "staging"
+)
+
+// Envs are the valid environments for update JSON
+var Envs = []string{EnvProd, EnvStaging}
+
+func isValidEnv(env string) bool {
+	for _, e := range Envs {
+		if e == env {
+			return true
+		}
+	}
+	return false
+}
+
 // Section defines a set of releases
 type Section struct {
-	Header   string
-	Releases []Release
+	Header   string    `json:"header"`
+	Releases []Release `json:"releases"`
 }
 
 // Release defines a release bundle
 type Release struct {
-	Name       string
-	Key        string
-	URL        string
-	Version    string
-	DateString string
-	Date       time.Time
-	Commit     string
+	Name       string    `json:"name"`
+	Key        string    `json:"key"`
+	Prefix     string    `json:"prefix"`
+	URL        string    `json:"url"`
+	Version    string    `json:"version"`
+	DateString string    `json:"-"`
+	Date       time.Time `json:"date"`
+	Commit     string    `json:"commit"`
+	// Build is the fourth version component (like 12345 in 6.1.0.12345), if any
+	Build int `json:"build,omitempty"`
+	// Size is in bytes, and SizeString is human readable (for the html)
+	Size         int64     `json:"size"`
+	SizeString   string    `json:"-"`
+	LastModified time.Time `json:"lastModified"`
+	// Channels are the channels (public, test) this release is promoted to,
+	// if loaded
+	Channels []string `json:"channels,omitempty"`
+	// Latest is set for the current (or newest) release of its section, if
+	// marked
+	Latest bool `json:"latest,omitempty"`
 }
 
 // ByRelease defines how to sort releases
@@ -57,31 +98,245 @@ func (s ByRelease) Swap(i, j int) {
 }
 
 func (s ByRelease) Less(i, j int) bool {
-	// Reverse date order
+	// Reverse date order, then reverse build order (for four-part versions)
+	if s[i].Date.Equal(s[j].Date) {
+		return s[j].Build < s[i].Build
+	}
 	return s[j].Date.Before(s[i].Date)
 }
 
 // Client is an S3 client
 type Client struct {
-	svc *s3.S3
+	svc s3iface.S3API
+	// logger is used instead of the standard logger, if set
+	logger *log.Logger
+	// destPrefix namespaces the (channel) update JSON keys, for buckets with
+	// a different layout
+	destPrefix string
+	// twoPhase stages and validates update JSON before promoting it
+	twoPhase bool
+	// acl is the canned ACL for objects we write (default public-read)
+	acl string
+	// verify checks a release's files all exist before promoting it
+	verify bool
+	// verifyCopy checks promoted update JSON matches its source
+	verifyCopy bool
+	// check, if set, decides whether a release can be promoted
+	check PromotionCheck
+	// updates caches the current update JSON, if set
+	updates *updateCache
+	// bandwidth limits the rate of object transfers (nil for unlimited)
+	bandwidth *bandwidth.Limiter
+	// retry is how copies and puts are retried (the zero value doesn't)
+	retry Retry
+	// ctx is the context for S3 requests (see WithContext), if set
+	ctx context.Context
+}
+
+// ClientOptions are how a Client promotes releases and writes objects (see
+// NewClientWithOptions). The zero value is the default for each.
+type ClientOptions struct {
+	// DestPrefix is a prefix for the update JSON keys promoted to (and the
+	// current update is read from), like "preprod/". The default is none.
+	DestPrefix string
+	// TwoPhase promotes in two phases: staging the update JSON, validating
+	// it, and only then committing it to the channel's key. The default is to
+	// copy directly.
+	TwoPhase bool
+	// ACL is the canned ACL (one of ACLs) objects are written with, for
+	// buckets that aren't public. The default is public-read.
+	ACL string
+	// VerifyComplete checks that all of a release's files exist (see
+	// VerifyReleaseComplete) before promoting it
+	VerifyComplete bool
+	// VerifyCopy checks that a promoted update JSON matches its source after
+	// copying it, copying it again if it doesn't. The default is not to,
+	// which saves the extra GETs.
+	VerifyCopy bool
+	// PromotionCheck, if set, is run on a release before promoting it
+	PromotionCheck PromotionCheck
+}
+
+// ACLs are the canned ACLs objects can be written with
+var ACLs = []string{
+	s3.ObjectCannedACLPublicRead,
+	s3.ObjectCannedACLPrivate,
+	s3.ObjectCannedACLBucketOwnerFullControl,
+	s3.ObjectCannedACLBucketOwnerRead,
+	s3.ObjectCannedACLAuthenticatedRead,
+	s3.ObjectCannedACLAwsExecRead,
+}
+
+// checkACL returns an error if cannedACL isn't one of ACLs
+func checkACL(cannedACL string) error {
+	for _, a := range ACLs {
+		if a == cannedACL {
+			return nil
+		}
+	}
+	return fmt.Errorf("Invalid ACL %q, must be one of %s", cannedACL, strings.Join(ACLs, ", "))
+}
+
+// Config overrides where Clients connect to S3, like a MinIO or localstack
+// server for integration tests. The zero Config is AWS in us-east-1.
+type Config struct {
+	// Endpoint is the URL of an S3 compatible server, accessed with path
+	// style (endpoint/bucket/key) requests
+	Endpoint string
+	// Region defaults to us-east-1
+	Region string
 }
 
-// NewClient constructs a Client
+const (
+	// EnvS3Endpoint is the environment variable for Config.Endpoint
+	EnvS3Endpoint = "KEYBASE_S3_ENDPOINT"
+	// EnvS3Region is the environment variable for Config.Region
+	EnvS3Region = "KEYBASE_S3_REGION"
+)
+
+// ConfigFromEnv returns the Config from KEYBASE_S3_ENDPOINT and
+// KEYBASE_S3_REGION, which are unset by default
+func ConfigFromEnv() Config {
+	return Config{
+		Endpoint: strings.TrimSpace(os.Getenv(EnvS3Endpoint)),
+		Region:   strings.TrimSpace(os.Getenv(EnvS3Region)),
+	}
+}
+
+func (cfg Config) awsConfig() (*aws.Config, error) {
+	region := cfg.Region
+	if region == "" {
+		region = defaultRegion
+	}
+	awsCfg := &aws.Config{Region: aws.String(region)}
+	if cfg.Endpoint != "" {
+		u, err := url.Parse(cfg.Endpoint)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("Invalid S3 endpoint %q", cfg.Endpoint)
+		}
+		awsCfg.Endpoint = aws.String(cfg.Endpoint)
+		awsCfg.S3ForcePathStyle = aws.Bool(true)
+	}
+	return awsCfg, nil
+}
+
+// NewClient constructs a Client, with any Config from the environment
 func NewClient() (*Client, error) {
-	sess, err := session.NewSession(&aws.Config{Region: aws.String("us-east-1")})
+	return NewClientWithConfig(ConfigFromEnv())
+}
+
+// NewClientWithConfig constructs a Client for an S3 endpoint and region
+func NewClientWithConfig(cfg Config) (*Client, error) {
+	awsCfg, err := cfg.awsConfig()
+	if err != nil {
+		return nil, err
+	}
+	sess, err := session.NewSession(awsCfg)
 	if err != nil {
 		return nil, err
 	}
-	svc := s3.New(sess)
-	return &Client{svc: svc}, nil
+	svc := newThrottledS3(s3.New(sess, s3RetryConfig()), throttle)
+	return &Client{svc: svc, updates: newUpdateCache(), bandwidth: bandwidthLimiter, retry: retry}, nil
 }
 
-func convertEastern(t time.Time) time.Time {
-	locationNewYork, err := time.LoadLocation("America/New_York")
+// NewClientWithOptions constructs a Client (like NewClient) with options for
+// how it promotes releases and writes objects
+func NewClientWithOptions(opts ClientOptions) (*Client, error) {
+	if opts.ACL != "" {
+		if err := checkACL(opts.ACL); err != nil {
+			return nil, err
+		}
+	}
+	client, err := NewClient()
 	if err != nil {
-		log.Printf("Couldn't load location: %s", err)
+		return nil, err
 	}
-	return t.In(locationNewYork)
+	client.destPrefix = opts.DestPrefix
+	client.twoPhase = opts.TwoPhase
+	client.acl = opts.ACL
+	client.verify = opts.VerifyComplete
+	client.verifyCopy = opts.VerifyCopy
+	client.check = opts.PromotionCheck
+	return client, nil
+}
+
+// NewClientForBucket returns a Client for the region a bucket is in, like a
+// replica in another region. With a custom endpoint, the configured region is
+// used instead.
+func NewClientForBucket(bucketName string) (*Client, error) {
+	cfg := ConfigFromEnv()
+	if cfg.Endpoint != "" {
+		return NewClientWithConfig(cfg)
+	}
+	awsCfg, err := cfg.awsConfig()
+	if err != nil {
+		return nil, err
+	}
+	sess, err := session.NewSession(awsCfg)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Region, err = s3manager.GetBucketRegion(aws.BackgroundContext(), sess, bucketName, aws.StringValue(awsCfg.Region))
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't get region of bucket %s: %s", bucketName, err)
+	}
+	return NewClientWithConfig(cfg)
+}
+
+func (c *Client) logf(format string, args ...interface{}) {
+	if c.logger == nil {
+		log.Printf(format, args...)
+		return
+	}
+	c.logger.Printf(format, args...)
+}
+
+// withLogPrefix returns a copy of the Client that prefixes its log lines
+func (c *Client) withLogPrefix(prefix string) *Client {
+	client := *c
+	client.logger = log.New(log.Writer(), prefix, log.Flags()|log.Lmsgprefix)
+	return &client
+}
+
+// cannedACL is the ACL to write objects with
+func (c *Client) cannedACL() string {
+	if c.acl == "" {
+		return s3.ObjectCannedACLPublicRead
+	}
+	return c.acl
+}
+
+// DefaultTimezone is the timezone dates are shown (and promotion cutoffs
+// compared) in by default
+const DefaultTimezone = "America/New_York"
+
+// timezone is the location for dates, or nil for DefaultTimezone
+var timezone *time.Location
+
+// SetTimezone sets the timezone for dates in releases and reports, and for
+// the promotion cutoff hour: UTC, Local or an IANA name (like
+// America/New_York, the default).
+func SetTimezone(name string) error {
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return fmt.Errorf("Invalid timezone %s: %s", name, err)
+	}
+	timezone = location
+	return nil
+}
+
+// inTimezone converts a time to the timezone
+func inTimezone(t time.Time) time.Time {
+	location := timezone
+	if location == nil {
+		var err error
+		location, err = time.LoadLocation(DefaultTimezone)
+		if err != nil {
+			log.Printf("Couldn't load location: %s", err)
+			location = time.UTC
+		}
+	}
+	return t.In(location)
 }
 
 func loadReleases(objects []*s3.Object, bucketName string, prefix string, suffix string, truncate int) []Release {
@@ -92,21 +347,7 @@ func loadReleases(objects []*s3.Object, bucketName string, prefix string, suffix
 			if name == "index.html" {
 				continue
 			}
-			version, _, date, commit, err := version.Parse(name)
-			if err != nil {
-				log.Printf("Couldn't get version from name: %s\n", name)
-			}
-			date = convertEastern(date)
-			releases = append(releases,
-				Release{
-					Name:       name,
-					Key:        *obj.Key,
-					URL:        urlString,
-					Version:    version,
-					Date:       date,
-					DateString: date.Format("Mon Jan _2 15:04:05 MST 2006"),
-					Commit:     commit,
-				})
+			releases = append(releases, newRelease(name, *obj.Key, prefix, urlString, aws.Int64Value(obj.Size), aws.TimeValue(obj.LastModified)))
 		}
 	}
 	// TODO: Should also sanity check that version sort is same as time sort
@@ -118,19 +359,149 @@ func loadReleases(objects []*s3.Object, bucketName string, prefix string, suffix
 	return releases
 }
 
+// releasesSince returns the releases dated after since
+func releasesSince(releases []Release, since time.Time) []Release {
+	recent := []Release{}
+	for _, release := range releases {
+		date := release.Date
+		if date.IsZero() {
+			date = release.LastModified
+		}
+		if date.After(since) {
+			recent = append(recent, release)
+		}
+	}
+	return recent
+}
+
+// newRelease returns a Release for a file, getting the version, date and
+// commit from its name
+func newRelease(name string, key string, prefix string, urlString string, size int64, lastModified time.Time) Release {
+	build := version.ParseBuild(name)
+	version, _, date, commit, err := version.Parse(name)
+	if err != nil {
+		log.Printf("Couldn't get version from name: %s\n", name)
+	}
+	date = inTimezone(date)
+	return Release{
+		Name:         name,
+		Key:          key,
+		Prefix:       prefix,
+		URL:          urlString,
+		Version:      version,
+		Date:         date,
+		DateString:   date.Format("Mon Jan _2 15:04:05 MST 2006"),
+		Commit:       commit,
+		Build:        build,
+		Size:         size,
+		SizeString:   humanSize(size),
+		LastModified: lastModified,
+	}
+}
+
+// humanSize formats a size in bytes, for example "182 MB"
+func humanSize(size int64) string {
+	const unit = 1000
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	value := float64(size)
+	units := []string{"KB", "MB", "GB", "TB"}
+	i := -1
+	for value >= unit && i < len(units)-1 {
+		value /= unit
+		i++
+	}
+	if value < 10 {
+		return fmt.Sprintf("%.1f %s", value, units[i])
+	}
+	return fmt.Sprintf("%.0f %s", value, units[i])
+}
+
+const (
+	// GroupByPrefix groups the index into a section per prefix (platform)
+	GroupByPrefix = "prefix"
+	// GroupByVersion groups the index into a section per version
+	GroupByVersion = "version"
+)
+
+const (
+	// OrderDesc orders the index newest first
+	OrderDesc = "desc"
+	// OrderAsc orders the index oldest first
+	OrderAsc = "asc"
+)
+
+// WriteHTMLOptions are options for generating the index html
+type WriteHTMLOptions struct {
+	// GroupBy is GroupByPrefix (default) or GroupByVersion
+	GroupBy string
+	// Order is OrderDesc (default) or OrderAsc
+	Order string
+	// DryRun generates (and writes to outPath) but doesn't upload
+	DryRun bool
+	// JSONOutPath and JSONUploadDest are where to write and upload a JSON index
+	JSONOutPath    string
+	JSONUploadDest string
+	// Signer, if set, signs the JSON index
+	Signer Signer
+	// ShowChannels looks up which channels each release is promoted to
+	ShowChannels bool
+	// Writer, if set, gets the html when there's no outPath or uploadDest
+	Writer io.Writer
+	// Strict fails if any file's version can't be parsed, instead of
+	// indexing it without one
+	Strict bool
+	// Since, if set, only includes releases dated after it (or modified
+	// after it, if their date can't be parsed)
+	Since time.Time
+	// MarkLatest marks the current (or newest) release of each prefix
+	MarkLatest bool
+	// Limit is the most releases to include per prefix (0 for all)
+	Limit int
+}
+
+// DefaultHTMLLimit is the default number of releases per prefix in an index
+const DefaultHTMLLimit = 50
+
 // WriteHTML creates an html file for releases
-func WriteHTML(bucketName string, prefixes string, suffix string, outPath string, uploadDest string) error {
-	var sections []Section
-	for _, prefix := range strings.Split(prefixes, ",") {
+func WriteHTML(bucketName string, prefixes string, suffix string, outPath string, uploadDest string, opts WriteHTMLOptions) error {
+	return WriteHTMLWithContext(context.Background(), bucketName, prefixes, suffix, outPath, uploadDest, opts)
+}
 
-		objs, listErr := listAllObjects(bucketName, prefix)
-		if listErr != nil {
-			return listErr
-		}
+// WriteHTMLWithContext is WriteHTML with a context for its S3 requests
+func WriteHTMLWithContext(ctx context.Context, bucketName string, prefixes string, suffix string, outPath string, uploadDest string, opts WriteHTMLOptions) error {
+	client, err := NewClient()
+	if err != nil {
+		return err
+	}
+	return client.WithContext(ctx).WriteHTML(bucketName, prefixes, suffix, outPath, uploadDest, opts)
+}
 
-		releases := loadReleases(objs, bucketName, prefix, suffix, 50)
+// WriteHTML creates an html file for releases for the Client
+func (c *Client) WriteHTML(bucketName string, prefixes string, suffix string, outPath string, uploadDest string, opts WriteHTMLOptions) error {
+	var sections []Section
+	unparsed := []string{}
+	prefixList := strings.Split(prefixes, ",")
+	prefixObjs, err := c.listPrefixes(bucketName, prefixList, maxListConcurrency)
+	if err != nil {
+		return err
+	}
+	for i, prefix := range prefixList {
+		releases := loadReleases(prefixObjs[i], bucketName, prefix, suffix, 0)
+		for _, release := range releases {
+			if release.Version == "" {
+				unparsed = append(unparsed, release.Key)
+			}
+		}
+		if !opts.Since.IsZero() {
+			releases = releasesSince(releases, opts.Since)
+		}
+		if opts.Limit > 0 && len(releases) > opts.Limit {
+			releases = releases[0:opts.Limit]
+		}
 		if len(releases) > 0 {
-			log.Printf("Found %d release(s) at %s\n", len(releases), prefix)
+			c.logf("Found %d release(s) at %s\n", len(releases), prefix)
 			// for _, release := range releases {
 			// 	log.Printf(" %s %s %s\n", release.Name, release.Version, release.DateString)
 			// }
@@ -141,46 +512,142 @@ func WriteHTML(bucketName string, prefixes string, suffix string, outPath string
 		})
 	}
 
+	if opts.Strict && len(unparsed) > 0 {
+		return fmt.Errorf("Couldn't get version from %d name(s): %s", len(unparsed), strings.Join(unparsed, ", "))
+	}
+
+	if opts.ShowChannels {
+		c.loadChannels(bucketName, sections)
+	}
+	if opts.MarkLatest {
+		c.markLatest(bucketName, sections)
+	}
+
+	if opts.GroupBy == GroupByVersion {
+		sections = sectionsByVersion(sections)
+	}
+	switch opts.Order {
+	case "", OrderDesc:
+	case OrderAsc:
+		sections = reverseSections(sections, opts.GroupBy == GroupByVersion)
+	default:
+		return fmt.Errorf("Invalid order: %s", opts.Order)
+	}
+
 	var buf bytes.Buffer
-	err := WriteHTMLForLinks(bucketName, sections, &buf)
+	switch opts.GroupBy {
+	case "", GroupByPrefix:
+		err = WriteHTMLForLinks(bucketName, sections, &buf)
+	case GroupByVersion:
+		err = WriteHTMLForVersions(bucketName, sections, &buf)
+	default:
+		err = fmt.Errorf("Invalid group by: %s", opts.GroupBy)
+	}
 	if err != nil {
 		return err
 	}
-	if outPath != "" {
-		err = makeParentDirs(outPath)
-		if err != nil {
+	if outPath == "" && uploadDest == "" && opts.Writer != nil {
+		if _, err := opts.Writer.Write(buf.Bytes()); err != nil {
 			return err
 		}
-		err = os.WriteFile(outPath, buf.Bytes(), 0644)
+	}
+	err = c.publish(bucketName, buf.Bytes(), "text/html", outPath, uploadDest, nil, opts.DryRun)
+	if err != nil {
+		return err
+	}
+
+	if opts.JSONOutPath != "" || opts.JSONUploadDest != "" {
+		var jsonBuf bytes.Buffer
+		if err := WriteJSONForLinks(sections, &jsonBuf); err != nil {
+			return err
+		}
+		err = c.publish(bucketName, jsonBuf.Bytes(), "application/json", opts.JSONOutPath, opts.JSONUploadDest, opts.Signer, opts.DryRun)
 		if err != nil {
 			return err
 		}
 	}
 
-	if uploadDest != "" {
-		client, err := NewClient()
+	return nil
+}
+
+// publish writes data to outPath and uploads it to uploadDest, if either are
+// set. If signer is set, a detached signature is written and uploaded
+// alongside (with a .sig extension).
+func (c *Client) publish(bucketName string, data []byte, contentType string, outPath string, uploadDest string, signer Signer, dryRun bool) error {
+	var sig []byte
+	if signer != nil && (outPath != "" || uploadDest != "") {
+		var err error
+		sig, err = signer.Sign(data)
 		if err != nil {
-			return err
+			return fmt.Errorf("Error signing: %s", err)
 		}
+	}
 
-		log.Printf("Uploading to %s", uploadDest)
-		_, err = client.svc.PutObject(&s3.PutObjectInput{
-			Bucket:        aws.String(bucketName),
-			Key:           aws.String(uploadDest),
-			CacheControl:  aws.String(defaultCacheControl),
-			ACL:           aws.String("public-read"),
-			Body:          bytes.NewReader(buf.Bytes()),
-			ContentLength: aws.Int64(int64(buf.Len())),
-			ContentType:   aws.String("text/html"),
-		})
-		if err != nil {
+	if outPath != "" {
+		if err := writeFile(outPath, data); err != nil {
 			return err
 		}
+		if sig != nil {
+			if err := writeFile(outPath+signatureExt, sig); err != nil {
+				return err
+			}
+		}
 	}
 
+	if uploadDest == "" {
+		return nil
+	}
+	if dryRun {
+		c.logf("DRYRUN: Would upload %d bytes to %s", len(data), urlStringNoEscape(bucketName, uploadDest))
+		return nil
+	}
+	c.logf("Uploading to %s", uploadDest)
+	if err := c.putObject(bucketName, uploadDest, data, contentType); err != nil {
+		return err
+	}
+	if sig != nil {
+		c.logf("Uploading signature to %s", uploadDest+signatureExt)
+		return c.putObject(bucketName, uploadDest+signatureExt, sig, "application/octet-stream")
+	}
 	return nil
 }
 
+// putObject uploads data to key, retrying transient errors
+func (c *Client) putObject(bucketName string, key string, data []byte, contentType string) error {
+	ctx := c.context()
+	err := c.withRetry(ctx, "uploading "+key, func() error {
+		_, err := c.svc.PutObjectWithContext(ctx, &s3.PutObjectInput{
+			Bucket:        aws.String(bucketName),
+			Key:           aws.String(key),
+			CacheControl:  aws.String(defaultCacheControl),
+			ACL:           aws.String(c.cannedACL()),
+			Body:          c.bandwidth.ReadSeeker(bytes.NewReader(data)),
+			ContentLength: aws.Int64(int64(len(data))),
+			ContentType:   aws.String(contentType),
+		}, noSDKRetries)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	metrics.uploaded(int64(len(data)))
+	return nil
+}
+
+func writeFile(path string, data []byte) error {
+	if err := makeParentDirs(path); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// WriteJSONForLinks writes a machine readable summary for a set of releases
+func WriteJSONForLinks(sections []Section, writer io.Writer) error {
+	return WriteJSON(writer, struct {
+		Sections []Section `json:"sections"`
+	}{Sections: sections})
+}
+
 var htmlTemplate = `
 <!doctype html>
 <html lang="en">
@@ -195,7 +662,29 @@ var htmlTemplate = `
 		<h3>{{ $sec.Header }}</h3>
 		<ul>
 		{{ range $index2, $rel := $sec.Releases }}
-		<li><a href="{{ $rel.URL }}">{{ $rel.Name }}</a> <strong>{{ $rel.Version }}</strong> <em>{{ $rel.Date }}</em> <a href="https://github.com/keybase/client/commit/{{ $rel.Commit }}"">{{ $rel.Commit }}</a></li>
+		<li><a href="{{ $rel.URL }}">{{ $rel.Name }}</a> <strong>{{ $rel.Version }}</strong>{{ range $rel.Channels }} [{{ . }}]{{ end }}{{ if $rel.Latest }} <mark>latest</mark>{{ end }}{{ if $rel.Size }} ({{ $rel.SizeString }}){{ end }} <em>{{ $rel.Date }}</em> <a href="https://github.com/keybase/client/commit/{{ $rel.Commit }}"">{{ $rel.Commit }}</a></li>
+		{{ end }}
+		</ul>
+	{{ end }}
+</body>
+</html>
+`
+
+var htmlVersionTemplate = `
+<!doctype html>
+<html lang="en">
+<head>
+  <title>{{ .Title }}</title>
+	<style>
+  body { font-family: monospace; }
+  </style>
+</head>
+<body>
+	{{ range $index, $sec := .Sections }}
+		<h3>{{ $sec.Header }}</h3>
+		<ul>
+		{{ range $index2, $rel := $sec.Releases }}
+		<li>{{ $rel.Prefix }} <a href="{{ $rel.URL }}">{{ $rel.Name }}</a>{{ range $rel.Channels }} [{{ . }}]{{ end }}{{ if $rel.Latest }} <mark>latest</mark>{{ end }}{{ if $rel.Size }} ({{ $rel.SizeString }}){{ end }} <em>{{ $rel.Date }}</em> <a href="https://github.com/keybase/client/commit/{{ $rel.Commit }}">{{ $rel.Commit }}</a></li>
 		{{ end }}
 		</ul>
 	{{ end }}
@@ -205,12 +694,22 @@ var htmlTemplate = `
 
 // WriteHTMLForLinks writes a summary document for a set of releases
 func WriteHTMLForLinks(title string, sections []Section, writer io.Writer) error {
+	return writeHTMLTemplate(htmlTemplate, title, sections, writer)
+}
+
+// WriteHTMLForVersions writes a summary document for sections keyed by
+// version, where each release is listed with its prefix
+func WriteHTMLForVersions(title string, sections []Section, writer io.Writer) error {
+	return writeHTMLTemplate(htmlVersionTemplate, title, sections, writer)
+}
+
+func writeHTMLTemplate(text string, title string, sections []Section, writer io.Writer) error {
 	vars := map[string]interface{}{
 		"Title":    title,
 		"Sections": sections,
 	}
 
-	t, err := template.New("t").Parse(htmlTemplate)
+	t, err := template.New("t").Parse(text)
 	if err != nil {
 		return err
 	}
@@ -218,6 +717,51 @@ func WriteHTMLForLinks(title string, sections []Section, writer io.Writer) error
 	return t.Execute(writer, vars)
 }
 
+// sectionsByVersion re-pivots prefix sections into a section per version,
+// newest first. Releases we couldn't get a version for are skipped.
+func sectionsByVersion(sections []Section) []Section {
+	var releases []Release
+	for _, section := range sections {
+		releases = append(releases, section.Releases...)
+	}
+	sort.Stable(ByRelease(releases))
+
+	var versions []Section
+	indexes := map[string]int{}
+	for _, release := range releases {
+		if release.Version == "" {
+			continue
+		}
+		i, ok := indexes[release.Version]
+		if !ok {
+			i = len(versions)
+			indexes[release.Version] = i
+			versions = append(versions, Section{Header: release.Version})
+		}
+		versions[i].Releases = append(versions[i].Releases, release)
+	}
+	return versions
+}
+
+// reverseSections reverses the (newest first) order of releases in each
+// section, and of the sections themselves if they're ordered by version
+func reverseSections(sections []Section, sectionsOrdered bool) []Section {
+	reversed := make([]Section, len(sections))
+	for i, section := range sections {
+		releases := make([]Release, len(section.Releases))
+		for j, release := range section.Releases {
+			releases[len(releases)-1-j] = release
+		}
+		section.Releases = releases
+		if sectionsOrdered {
+			reversed[len(reversed)-1-i] = section
+		} else {
+			reversed[i] = section
+		}
+	}
+	return reversed
+}
+
 // Platform defines where platform specific files are (in darwin, linux, windows)
 type Platform struct {
 	Name          string
@@ -225,15 +769,23 @@ type Platform struct {
 	PrefixSupport string
 	Suffix        string
 	LatestName    string
+	// OS (darwin, linux, windows) and Arch (amd64, arm64) the platform is for
+	OS   string
+	Arch string
 }
 
 // CopyLatest copies latest release to a fixed path
 func CopyLatest(bucketName string, platform string, dryRun bool) error {
+	return CopyLatestWithContext(context.Background(), bucketName, platform, dryRun)
+}
+
+// CopyLatestWithContext is CopyLatest with a context for its S3 requests
+func CopyLatestWithContext(ctx context.Context, bucketName string, platform string, dryRun bool) error {
 	client, err := NewClient()
 	if err != nil {
 		return err
 	}
-	return client.CopyLatest(bucketName, platform, dryRun)
+	return client.WithContext(ctx).CopyLatest(bucketName, platform, dryRun)
 }
 
 const (
@@ -246,11 +798,18 @@ const (
 	PlatformTypeWindows = "windows"
 )
 
-var platformDarwin = Platform{Name: PlatformTypeDarwin, Prefix: "darwin/", PrefixSupport: "darwin-support/", LatestName: "Keybase.dmg"}
-var platformDarwinArm64 = Platform{Name: PlatformTypeDarwinArm64, Prefix: "darwin-arm64/", PrefixSupport: "darwin-arm64-support/", LatestName: "Keybase-arm64.dmg"}
-var platformLinuxDeb = Platform{Name: "deb", Prefix: "linux_binaries/deb/", Suffix: "_amd64.deb", LatestName: "keybase_amd64.deb"}
-var platformLinuxRPM = Platform{Name: "rpm", Prefix: "linux_binaries/rpm/", Suffix: ".x86_64.rpm", LatestName: "keybase_amd64.rpm"}
-var platformWindows = Platform{Name: PlatformTypeWindows, Prefix: "windows/", PrefixSupport: "windows-support/", LatestName: "keybase_setup_amd64.msi"}
+const (
+	// ArchAmd64 is the arch for 64-bit x86
+	ArchAmd64 = "amd64"
+	// ArchArm64 is the arch for 64-bit ARM
+	ArchArm64 = "arm64"
+)
+
+var platformDarwin = Platform{Name: PlatformTypeDarwin, Prefix: "darwin/", PrefixSupport: "darwin-support/", LatestName: "Keybase.dmg", OS: PlatformTypeDarwin, Arch: ArchAmd64}
+var platformDarwinArm64 = Platform{Name: PlatformTypeDarwinArm64, Prefix: "darwin-arm64/", PrefixSupport: "darwin-arm64-support/", LatestName: "Keybase-arm64.dmg", OS: PlatformTypeDarwin, Arch: ArchArm64}
+var platformLinuxDeb = Platform{Name: "deb", Prefix: "linux_binaries/deb/", PrefixSupport: "linux_binaries/deb-support/", Suffix: "_amd64.deb", LatestName: "keybase_amd64.deb", OS: PlatformTypeLinux, Arch: ArchAmd64}
+var platformLinuxRPM = Platform{Name: "rpm", Prefix: "linux_binaries/rpm/", PrefixSupport: "linux_binaries/rpm-support/", Suffix: ".x86_64.rpm", LatestName: "keybase_amd64.rpm", OS: PlatformTypeLinux, Arch: ArchAmd64}
+var platformWindows = Platform{Name: PlatformTypeWindows, Prefix: "windows/", PrefixSupport: "windows-support/", LatestName: "keybase_setup_amd64.msi", OS: PlatformTypeWindows, Arch: ArchAmd64}
 
 var platformsAll = []Platform{
 	platformDarwin,
@@ -260,15 +819,20 @@ var platformsAll = []Platform{
 	platformWindows,
 }
 
-// Platforms returns platforms for a name (linux may have multiple platforms) or all platforms is "" is specified
+// Platforms returns platforms for a name (darwin and linux have multiple
+// platforms) or all platforms is "" is specified
 func Platforms(name string) ([]Platform, error) {
 	switch name {
 	case PlatformTypeDarwin:
-		return []Platform{platformDarwin}, nil
+		return []Platform{platformDarwin, platformDarwinArm64}, nil
 	case PlatformTypeDarwinArm64:
 		return []Platform{platformDarwinArm64}, nil
 	case PlatformTypeLinux:
 		return []Platform{platformLinuxDeb, platformLinuxRPM}, nil
+	case platformLinuxDeb.Name:
+		return []Platform{platformLinuxDeb}, nil
+	case platformLinuxRPM.Name:
+		return []Platform{platformLinuxRPM}, nil
 	case PlatformTypeWindows:
 		return []Platform{platformWindows}, nil
 	case "":
@@ -278,21 +842,151 @@ func Platforms(name string) ([]Platform, error) {
 	}
 }
 
-func listAllObjects(bucketName string, prefix string) ([]*s3.Object, error) {
-	client, err := NewClient()
+// platformsFor returns the platforms for a name (see Platforms), logging
+// which they are if there's more than one, since darwin is also darwin-arm64
+func (c *Client) platformsFor(name string) ([]Platform, error) {
+	platforms, err := Platforms(name)
 	if err != nil {
 		return nil, err
 	}
+	if len(platforms) > 1 {
+		names := []string{}
+		for _, platform := range platforms {
+			names = append(names, platform.Name)
+		}
+		c.logf("Platform %q is %s", name, strings.Join(names, ", "))
+	}
+	return platforms, nil
+}
+
+// PlatformNamed returns the single platform with a name. Unlike Platforms,
+// darwin is only the Intel platform (darwin-arm64 is Apple Silicon).
+func PlatformNamed(name string) (Platform, error) {
+	platforms, err := Platforms(name)
+	if err != nil {
+		return Platform{}, err
+	}
+	for _, platform := range platforms {
+		if platform.Name == name {
+			return platform, nil
+		}
+	}
+	return Platform{}, fmt.Errorf("Unsupported for this platform: %s", name)
+}
+
+// exactPlatforms returns the platform with a name (see PlatformNamed), or the
+// platforms for linux (deb and rpm). It's for commands that move or delete
+// files, so darwin doesn't also affect darwin-arm64 as it does with Platforms.
+func exactPlatforms(name string) ([]Platform, error) {
+	if name == PlatformTypeLinux {
+		return Platforms(name)
+	}
+	platform, err := PlatformNamed(name)
+	if err != nil {
+		return nil, err
+	}
+	return []Platform{platform}, nil
+}
+
+// PlatformsForOS returns all platforms (arches) for an os (darwin, linux,
+// windows)
+func PlatformsForOS(osName string) ([]Platform, error) {
+	platforms := filterPlatforms(func(p Platform) bool { return p.OS == osName })
+	if len(platforms) == 0 {
+		return nil, fmt.Errorf("Invalid os %s", osName)
+	}
+	return platforms, nil
+}
+
+// PlatformsForArch returns all platforms for an arch (amd64, arm64)
+func PlatformsForArch(arch string) ([]Platform, error) {
+	platforms := filterPlatforms(func(p Platform) bool { return p.Arch == arch })
+	if len(platforms) == 0 {
+		return nil, fmt.Errorf("Invalid arch %s", arch)
+	}
+	return platforms, nil
+}
+
+// AllPrefixes returns the prefixes (and support prefixes) of all platforms,
+// for indexing everything we publish
+func AllPrefixes() []string {
+	prefixes := []string{}
+	seen := map[string]bool{}
+	for _, platform := range platformsAll {
+		for _, prefix := range []string{platform.Prefix, platform.PrefixSupport} {
+			if prefix == "" || seen[prefix] {
+				continue
+			}
+			seen[prefix] = true
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return prefixes
+}
 
+func filterPlatforms(f func(p Platform) bool) []Platform {
+	platforms := []Platform{}
+	for _, platform := range platformsAll {
+		if f(platform) {
+			platforms = append(platforms, platform)
+		}
+	}
+	return platforms
+}
+
+func (c *Client) listAllObjects(bucketName string, prefix string) ([]*s3.Object, error) {
+	return c.listObjects(bucketName, prefix, "/")
+}
+
+// maxListConcurrency is how many prefixes listPrefixes lists at once
+const maxListConcurrency = 4
+
+// listPrefixes lists the objects at each prefix (as listAllObjects), up to
+// concurrency prefixes at once. All prefixes are attempted, and any errors
+// are combined.
+func (c *Client) listPrefixes(bucketName string, prefixes []string, concurrency int) ([][]*s3.Object, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	objs := make([][]*s3.Object, len(prefixes))
+	errs := make([]error, len(prefixes))
+	var g errgroup.Group
+	g.SetLimit(concurrency)
+	for i, prefix := range prefixes {
+		i, prefix := i, prefix
+		g.Go(func() error {
+			prefixObjs, err := c.listAllObjects(bucketName, prefix)
+			if err != nil {
+				errs[i] = fmt.Errorf("%s: %s", prefix, err)
+				return nil
+			}
+			objs[i] = prefixObjs
+			return nil
+		})
+	}
+	_ = g.Wait()
+	return objs, CombineErrors(errs...)
+}
+
+// listObjectsRecursive lists all objects at prefix, including those in its
+// "subdirectories"
+func (c *Client) listObjectsRecursive(bucketName string, prefix string) ([]*s3.Object, error) {
+	return c.listObjects(bucketName, prefix, "")
+}
+
+func (c *Client) listObjects(bucketName string, prefix string, delimiter string) ([]*s3.Object, error) {
 	marker := ""
 	objs := make([]*s3.Object, 0, 1000)
 	for {
-		resp, err := client.svc.ListObjects(&s3.ListObjectsInput{
-			Bucket:    aws.String(bucketName),
-			Delimiter: aws.String("/"),
-			Prefix:    aws.String(prefix),
-			Marker:    aws.String(marker),
-		})
+		input := &s3.ListObjectsInput{
+			Bucket: aws.String(bucketName),
+			Prefix: aws.String(prefix),
+			Marker: aws.String(marker),
+		}
+		if delimiter != "" {
+			input.Delimiter = aws.String(delimiter)
+		}
+		resp, err := c.svc.ListObjects(input)
 		if err != nil {
 			return nil, err
 		}
@@ -314,8 +1008,13 @@ func listAllObjects(bucketName string, prefix string) ([]*s3.Object, error) {
 		if !truncated {
 			break
 		}
+		// NextMarker is only returned with a delimiter, otherwise it's the
+		// last key
+		if nextMarker == "" && len(out.Contents) > 0 {
+			nextMarker = aws.StringValue(out.Contents[len(out.Contents)-1].Key)
+		}
 
-		log.Printf("Response is truncated, next marker is %s\n", nextMarker)
+		c.logf("Response is truncated, next marker is %s\n", nextMarker)
 		marker = nextMarker
 	}
 
@@ -324,7 +1023,17 @@ func listAllObjects(bucketName string, prefix string) ([]*s3.Object, error) {
 
 // FindRelease searches for a release matching a predicate
 func (p *Platform) FindRelease(bucketName string, f func(r Release) bool) (*Release, error) {
-	contents, err := listAllObjects(bucketName, p.Prefix)
+	client, err := NewClient()
+	if err != nil {
+		return nil, err
+	}
+	return client.FindRelease(*p, bucketName, f)
+}
+
+// FindRelease searches for a release of a platform matching a predicate for
+// the Client
+func (c *Client) FindRelease(p Platform, bucketName string, f func(r Release) bool) (*Release, error) {
+	contents, err := c.listAllObjects(bucketName, p.Prefix)
 	if err != nil {
 		return nil, err
 	}
@@ -341,20 +1050,58 @@ func (p *Platform) FindRelease(bucketName string, f func(r Release) bool) (*Rele
 	return nil, nil
 }
 
-// Files returns all files associated with this platforms release
+// isRelease returns true if r is the platform's release of version
+func (p Platform) isRelease(r Release, version string) bool {
+	switch p.Name {
+	case PlatformTypeDarwin, PlatformTypeDarwinArm64:
+		return r.Name == fmt.Sprintf("Keybase-%s.dmg", version)
+	case PlatformTypeWindows:
+		return r.Name == fmt.Sprintf("Keybase_%s.amd64.msi", version)
+	default:
+		// Linux package names vary, so go by the version in the name
+		return r.Version == version && strings.HasSuffix(r.Name, p.Suffix)
+	}
+}
+
+// releaseFiles returns all files associated with a platform's release, like
+// Platform.Files. Linux package names vary, so for deb and rpm the package is
+// found in the bucket.
+func (c *Client) releaseFiles(p Platform, bucketName string, releaseName string) ([]string, error) {
+	if p.OS != PlatformTypeLinux {
+		return p.Files(releaseName)
+	}
+	release, err := c.FindRelease(p, bucketName, func(r Release) bool {
+		return p.isRelease(r, releaseName)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if release == nil {
+		return nil, fmt.Errorf("No %s release %s", p.Name, releaseName)
+	}
+	return []string{release.Key, versionedUpdateJSONKey(p, EnvProd, releaseName)}, nil
+}
+
+// Files returns all files associated with this platforms release. Linux
+// package names vary, so deb and rpm aren't supported.
 func (p Platform) Files(releaseName string) ([]string, error) {
 	switch p.Name {
 	case PlatformTypeDarwin:
 		return []string{
 			fmt.Sprintf("darwin/Keybase-%s.dmg", releaseName),
 			fmt.Sprintf("darwin-updates/Keybase-%s.zip", releaseName),
-			fmt.Sprintf("darwin-support/update-darwin-prod-%s.json", releaseName),
+			versionedUpdateJSONKey(p, EnvProd, releaseName),
 		}, nil
 	case PlatformTypeDarwinArm64:
 		return []string{
 			fmt.Sprintf("darwin-arm64/Keybase-%s.dmg", releaseName),
 			fmt.Sprintf("darwin-arm64-updates/Keybase-%s.zip", releaseName),
-			fmt.Sprintf("darwin-arm64-support/update-darwin-prod-%s.json", releaseName),
+			versionedUpdateJSONKey(p, EnvProd, releaseName),
+		}, nil
+	case PlatformTypeWindows:
+		return []string{
+			fmt.Sprintf("windows/Keybase_%s.amd64.msi", releaseName),
+			versionedUpdateJSONKey(p, EnvProd, releaseName),
 		}, nil
 	default:
 		return nil, fmt.Errorf("Unsupported for this platform: %s", p.Name)
@@ -363,52 +1110,135 @@ func (p Platform) Files(releaseName string) ([]string, error) {
 
 // WriteHTML will generate index.html for the platform
 func (p Platform) WriteHTML(bucketName string) error {
-	return WriteHTML(bucketName, p.Prefix, "", "", p.Prefix+"/index.html")
+	client, err := NewClient()
+	if err != nil {
+		return err
+	}
+	return client.writePlatformHTML(bucketName, p)
+}
+
+func (c *Client) writePlatformHTML(bucketName string, p Platform) error {
+	return c.WriteHTML(bucketName, p.Prefix, "", "", p.Prefix+"/index.html", WriteHTMLOptions{Limit: DefaultHTMLLimit})
 }
 
 // CopyLatest copies latest release to a fixed path for the Client
 func (c *Client) CopyLatest(bucketName string, platform string, dryRun bool) error {
-	platforms, err := Platforms(platform)
+	platforms, err := c.platformsFor(platform)
 	if err != nil {
 		return err
 	}
 	for _, platform := range platforms {
-		var url string
-		// Use update json to look for current DMG (for darwin)
-		// TODO: Fix for linux
-		switch platform.Name {
-		case PlatformTypeDarwin, PlatformTypeDarwinArm64, PlatformTypeWindows:
-			url, err = c.copyFromUpdate(platform, bucketName)
-		default:
-			_, url, err = c.copyFromReleases(platform, bucketName)
-		}
+		key, err := c.latestKey(platform, bucketName)
 		if err != nil {
 			return err
 		}
-		if url == "" {
+		if key == "" {
 			continue
 		}
-
 		if dryRun {
-			log.Printf("DRYRUN: Would copy latest %s to %s\n", url, platform.LatestName)
-			return nil
+			c.logf("DRYRUN: Would copy latest %s to %s\n", key, platform.LatestName)
+			continue
 		}
 
-		_, err := c.svc.CopyObject(&s3.CopyObjectInput{
-			Bucket:       aws.String(bucketName),
-			CopySource:   aws.String(url),
-			Key:          aws.String(platform.LatestName),
-			CacheControl: aws.String(defaultCacheControl),
-			ACL:          aws.String("public-read"),
-		})
+		c.logf("Copying latest %s to %s\n", key, platform.LatestName)
+		start := time.Now()
+		_, err = c.copyObject(&s3.CopyObjectInput{
+			Bucket:       aws.String(bucketName),
+			CopySource:   aws.String(copySource(bucketName, key)),
+			Key:          aws.String(platform.LatestName),
+			CacheControl: aws.String(defaultCacheControl),
+			ACL:          aws.String(c.cannedACL()),
+		})
+		if err != nil {
+			metrics.promotion(platform.Name, "latest", time.Since(start), false)
+			return err
+		}
+		size, err := c.verifySame(bucketName, key, platform.LatestName)
+		metrics.promotion(platform.Name, "latest", time.Since(start), err == nil)
+		if err != nil {
+			return err
+		}
+		metrics.copied(size)
+		latestVersion, _, _, _, _ := version.Parse(strings.TrimPrefix(key, platform.Prefix))
+		c.notify(Notification{Platform: platform.Name, Channel: "latest", Version: latestVersion, URL: urlString(bucketName, "", platform.LatestName)})
+	}
+	return nil
+}
+
+// latestKey returns the key of the release that should be at the platform's
+// LatestName, or "" if there isn't one
+func (c *Client) latestKey(platform Platform, bucketName string) (key string, err error) {
+	// Use update json to look for current DMG (for darwin)
+	// TODO: Fix for linux
+	switch platform.Name {
+	case PlatformTypeDarwin, PlatformTypeDarwinArm64, PlatformTypeWindows:
+		key, err = c.copyFromUpdate(platform, bucketName)
+	default:
+		_, key, err = c.copyFromReleases(platform, bucketName)
+	}
+	return key, err
+}
+
+// verifySame checks that a copy (destKey) is the same size as its source, and
+// has the same ETag unless either was a multipart upload (whose ETags differ)
+func (c *Client) verifySame(bucketName string, sourceKey string, destKey string) (int64, error) {
+	source, err := c.svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(sourceKey),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("Error getting %s: %s", sourceKey, err)
+	}
+	dest, err := c.svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(destKey),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("Error getting %s: %s", destKey, err)
+	}
+	sourceSize, destSize := aws.Int64Value(source.ContentLength), aws.Int64Value(dest.ContentLength)
+	if sourceSize != destSize {
+		return 0, fmt.Errorf("Size of %s (%d) doesn't match %s (%d)", destKey, destSize, sourceKey, sourceSize)
+	}
+	sourceETag, destETag := aws.StringValue(source.ETag), aws.StringValue(dest.ETag)
+	if sourceETag != "" && destETag != "" && !strings.Contains(sourceETag, "-") && !strings.Contains(destETag, "-") && sourceETag != destETag {
+		return 0, fmt.Errorf("ETag of %s (%s) doesn't match %s (%s)", destKey, destETag, sourceKey, sourceETag)
+	}
+	return destSize, nil
+}
+
+// CheckLatestConsistency checks that each platform's LatestName (like
+// Keybase.dmg) is the same as the release that's promoted, so direct
+// downloads get the same version as the updater. It returns a description
+// for each platform that doesn't match.
+func CheckLatestConsistency(bucketName string) ([]string, error) {
+	client, err := NewClient()
+	if err != nil {
+		return nil, err
+	}
+	return client.CheckLatestConsistency(bucketName)
+}
+
+// CheckLatestConsistency checks each platform's LatestName for the Client
+func (c *Client) CheckLatestConsistency(bucketName string) ([]string, error) {
+	mismatches := []string{}
+	for _, platform := range platformsAll {
+		key, err := c.latestKey(platform, bucketName)
 		if err != nil {
-			return err
+			mismatches = append(mismatches, fmt.Sprintf("%s: %s", platform.Name, err))
+			continue
+		}
+		if key == "" {
+			continue
+		}
+		if _, err := c.verifySame(bucketName, key, platform.LatestName); err != nil {
+			mismatches = append(mismatches, fmt.Sprintf("%s: %s", platform.Name, err))
 		}
 	}
-	return nil
+	return mismatches, nil
 }
 
-func (c *Client) copyFromUpdate(platform Platform, bucketName string) (url string, err error) {
+func (c *Client) copyFromUpdate(platform Platform, bucketName string) (key string, err error) {
 	currentUpdate, path, err := c.CurrentUpdate(bucketName, defaultChannel, platform.Name, "prod")
 	if err != nil {
 		err = fmt.Errorf("Error getting current public update: %s", err)
@@ -420,103 +1250,227 @@ func (c *Client) copyFromUpdate(platform Platform, bucketName string) (url strin
 	}
 	switch platform.Name {
 	case PlatformTypeDarwin, PlatformTypeDarwinArm64:
-		url = urlString(bucketName, platform.Prefix, fmt.Sprintf("Keybase-%s.dmg", currentUpdate.Version))
+		key = platform.Prefix + fmt.Sprintf("Keybase-%s.dmg", currentUpdate.Version)
 	case PlatformTypeWindows:
-		url = urlString(bucketName, platform.Prefix, fmt.Sprintf("Keybase_%s.amd64.msi", currentUpdate.Version))
+		key = platform.Prefix + fmt.Sprintf("Keybase_%s.amd64.msi", currentUpdate.Version)
 	default:
 		err = fmt.Errorf("Unsupported platform for copyFromUpdate")
+		return
 	}
+	err = c.validateUpdate(bucketName, currentUpdate, path, key)
 	return
 }
 
-func (c *Client) copyFromReleases(platform Platform, bucketName string) (release *Release, url string, err error) {
-	release, err = platform.FindRelease(bucketName, func(r Release) bool { return true })
+// validateUpdate checks that an update JSON (at path) is consistent with the
+// key we're about to copy for it: its asset must be for the same version and
+// exist in the bucket, and so must key.
+func (c *Client) validateUpdate(bucketName string, upd *Update, path string, key string) error {
+	if upd.Asset == nil {
+		return fmt.Errorf("No asset in update JSON at %s", path)
+	}
+	if !strings.Contains(upd.Asset.Name, upd.Version) {
+		return fmt.Errorf("Asset %s doesn't match version %s in update JSON at %s", upd.Asset.Name, upd.Version, path)
+	}
+	assetKey, err := keyForURL(bucketName, upd.Asset.URL)
+	if err != nil {
+		return fmt.Errorf("Invalid asset in update JSON at %s: %s", path, err)
+	}
+	for _, k := range []string{assetKey, key} {
+		_, err := c.svc.HeadObject(&s3.HeadObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String(k),
+		})
+		if err != nil {
+			return fmt.Errorf("Couldn't find %s for update JSON at %s: %s", k, path, err)
+		}
+	}
+	return nil
+}
+
+func (c *Client) copyFromReleases(platform Platform, bucketName string) (release *Release, key string, err error) {
+	release, err = c.FindRelease(platform, bucketName, func(r Release) bool { return true })
 	if err != nil || release == nil {
 		return
 	}
-	url, _ = urlStringForKey(release.Key, bucketName, platform.Prefix)
+	key = release.Key
 	return
 }
 
-// CurrentUpdate returns current update for a platform
+// CurrentUpdate returns current update for a platform. It's only fetched in
+// full if it changed since the Client last fetched it (see ClearUpdateCache).
 func (c *Client) CurrentUpdate(bucketName string, channel string, platformName string, env string) (currentUpdate *Update, path string, err error) {
-	path = updateJSONName(channel, platformName, env)
-	log.Printf("Fetching current update at %s", path)
-	resp, err := c.svc.GetObject(&s3.GetObjectInput{
-		Bucket: aws.String(bucketName),
-		Key:    aws.String(path),
-	})
+	path = c.updateJSONKey(channel, platformName, env)
+	c.logf("Fetching current update at %s", path)
+	currentUpdate, err = c.getUpdate(bucketName, path)
+	return
+}
+
+// DefaultUpdateJSONNameTemplate is the default naming scheme for a channel's
+// update JSON, like update-darwin-prod-v2.json
+const DefaultUpdateJSONNameTemplate = `update-{{.Platform}}-{{.Env}}{{if .Channel}}-{{.Channel}}{{end}}.json`
+
+// updateJSONNameFields are the fields for an update JSON name template
+type updateJSONNameFields struct {
+	Platform string
+	Env      string
+	Channel  string
+}
+
+var updateJSONNameTemplate = template.Must(template.New("name").Parse(DefaultUpdateJSONNameTemplate))
+
+// SetUpdateJSONNameTemplate sets the naming scheme for channels' update JSON,
+// a template with .Platform, .Env and .Channel (which is empty for the
+// default linux channel), like {{or .Channel "stable"}}/{{.Platform}}.json.
+// It must produce a valid key for any channel.
+func SetUpdateJSONNameTemplate(text string) error {
+	t, err := template.New("name").Parse(text)
 	if err != nil {
-		return
+		return fmt.Errorf("Invalid update JSON name template: %s", err)
 	}
-	defer func() { _ = resp.Body.Close() }()
-	currentUpdate, err = DecodeJSON(resp.Body)
-	return
+	for _, channel := range []string{"", defaultChannel} {
+		name, err := executeUpdateJSONName(t, channel, PlatformTypeDarwin, EnvProd)
+		if err != nil {
+			return fmt.Errorf("Invalid update JSON name template: %s", err)
+		}
+		if err := validateKey(name); err != nil {
+			return fmt.Errorf("Invalid update JSON name template (for channel %q): %s", channel, err)
+		}
+	}
+	updateJSONNameTemplate = t
+	return nil
+}
+
+// validateKey checks a key is non-empty with no empty, . or .. segments
+func validateKey(key string) error {
+	if key == "" {
+		return fmt.Errorf("Empty key")
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return fmt.Errorf("Invalid key %q", key)
+		}
+	}
+	return nil
+}
+
+func executeUpdateJSONName(t *template.Template, channel string, platformName string, env string) (string, error) {
+	var buf bytes.Buffer
+	err := t.Execute(&buf, updateJSONNameFields{Platform: platformName, Env: env, Channel: channel})
+	return buf.String(), err
+}
+
+// updateJSONName is the name of a channel's update JSON, from the name template
+func updateJSONName(channel string, platformName string, env string) string {
+	name, err := executeUpdateJSONName(updateJSONNameTemplate, channel, platformName, env)
+	if err != nil {
+		// The template was validated when set, so this shouldn't happen
+		log.Printf("Error naming update JSON: %s", err)
+	}
+	return name
 }
 
-func promoteRelease(bucketName string, delay time.Duration, hourEastern int, toChannel string, platform Platform, env string, allowDowngrade bool, release string) (*Release, error) {
+// versionedUpdateJSONKey is the key for the update JSON of a specific version
+func versionedUpdateJSONKey(platform Platform, env string, version string) string {
+	return fmt.Sprintf("%supdate-%s-%s-%s.json", platform.PrefixSupport, platform.Name, env, version)
+}
+
+// FindReleaseByCommit returns the release for a platform built from commit,
+// which can be a short or full SHA
+func FindReleaseByCommit(bucketName string, platformName string, commit string) (*Release, error) {
 	client, err := NewClient()
 	if err != nil {
 		return nil, err
 	}
-	return client.PromoteRelease(bucketName, delay, hourEastern, toChannel, platform, env, allowDowngrade, release)
+	return client.FindReleaseByCommit(bucketName, platformName, commit)
 }
 
-func updateJSONName(channel string, platformName string, env string) string {
-	if channel == "" {
-		return fmt.Sprintf("update-%s-%s.json", platformName, env)
+// FindReleaseByCommit returns the release built from commit for the Client.
+// It's an error if no release or multiple releases match.
+func (c *Client) FindReleaseByCommit(bucketName string, platformName string, commit string) (*Release, error) {
+	if commit == "" {
+		return nil, fmt.Errorf("No commit specified")
+	}
+	platform, err := PlatformNamed(platformName)
+	if err != nil {
+		return nil, err
+	}
+	contents, err := c.listAllObjects(bucketName, platform.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	var matches []Release
+	for _, release := range loadReleases(contents, bucketName, platform.Prefix, platform.Suffix, 0) {
+		// Release names have short SHAs, and commit may be short or full
+		if release.Commit != "" && (strings.HasPrefix(release.Commit, commit) || strings.HasPrefix(commit, release.Commit)) {
+			matches = append(matches, release)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("No %s release found for commit %s", platform.Name, commit)
+	case 1:
+		return &matches[0], nil
+	default:
+		names := []string{}
+		for _, release := range matches {
+			names = append(names, release.Name)
+		}
+		return nil, fmt.Errorf("Multiple %s releases found for commit %s: %s", platform.Name, commit, strings.Join(names, ", "))
 	}
-	return fmt.Sprintf("update-%s-%s-%s.json", platformName, env, channel)
 }
 
-// PromoteARelease promotes a specific release to Prod.
-func PromoteARelease(releaseName string, bucketName string, platform string, dryRun bool) (release *Release, err error) {
-	switch platform {
-	case PlatformTypeDarwin, PlatformTypeDarwinArm64, PlatformTypeWindows:
-		// pass
-	default:
-		return nil, fmt.Errorf("Promoting releases is only supported for darwin or windows")
+// updateJSONKey is the key for a channel's update JSON, with the Client's
+// destPrefix
+func (c *Client) updateJSONKey(channel string, platformName string, env string) string {
+	return c.destPrefix + updateJSONName(channel, platformName, env)
+}
 
-	}
+// PromoteARelease promotes a specific release to Prod.
+func PromoteARelease(releaseName string, bucketName string, platform string, env string, dryRun bool) (release *Release, err error) {
+	return PromoteAReleaseWithContext(context.Background(), releaseName, bucketName, platform, env, dryRun)
+}
 
+// PromoteAReleaseWithContext is PromoteARelease with a context for its S3
+// requests
+func PromoteAReleaseWithContext(ctx context.Context, releaseName string, bucketName string, platform string, env string, dryRun bool) (release *Release, err error) {
 	client, err := NewClient()
 	if err != nil {
 		return nil, err
 	}
+	return client.WithContext(ctx).PromoteARelease(releaseName, bucketName, platform, env, dryRun)
+}
 
-	platformRes, err := Platforms(platform)
+// PromoteARelease promotes a specific release to Prod for the Client
+func (c *Client) PromoteARelease(releaseName string, bucketName string, platform string, env string, dryRun bool) (release *Release, err error) {
+	platformType, err := supportPlatform(platform)
 	if err != nil {
 		return nil, err
 	}
-	if len(platformRes) != 1 {
-		return nil, fmt.Errorf("Promoting on multiple platforms is not supported")
-	}
 
-	platformType := platformRes[0]
-	release, err = client.promoteAReleaseToProd(releaseName, bucketName, platformType, "prod", defaultChannel, dryRun)
+	channel := defaultChannel
+	if platformType.OS == PlatformTypeLinux {
+		// Linux update JSON has no channel (update-deb-prod.json)
+		channel = ""
+	}
+	release, err = c.promoteAReleaseToProd(releaseName, bucketName, platformType, env, channel, dryRun)
 	if err != nil {
 		return nil, err
 	}
 	if dryRun {
 		return release, nil
 	}
-	log.Printf("Promoted %s release: %s\n", platform, releaseName)
+	c.logf("Promoted %s release: %s\n", platform, releaseName)
 	return release, nil
 }
 
 func (c *Client) promoteAReleaseToProd(releaseName string, bucketName string, platform Platform, env string, toChannel string, dryRun bool) (release *Release, err error) {
-	var filePath string
-	switch platform.Name {
-	case PlatformTypeDarwin, PlatformTypeDarwinArm64:
-		filePath = fmt.Sprintf("Keybase-%s.dmg", releaseName)
-	case PlatformTypeWindows:
-		filePath = fmt.Sprintf("Keybase_%s.amd64.msi", releaseName)
-	default:
+	start := time.Now()
+	if platform.PrefixSupport == "" {
 		return nil, fmt.Errorf("Unsupported for this platform: %s", platform.Name)
 	}
 
-	release, err = platform.FindRelease(bucketName, func(r Release) bool {
-		return r.Name == filePath
+	release, err = c.FindRelease(platform, bucketName, func(r Release) bool {
+		return platform.isRelease(r, releaseName)
 	})
 	if err != nil {
 		return nil, err
@@ -524,44 +1478,67 @@ func (c *Client) promoteAReleaseToProd(releaseName string, bucketName string, pl
 	if release == nil {
 		return nil, fmt.Errorf("No matching release found")
 	}
-	log.Printf("Found %s release %s (%s), %s", platform.Name, release.Name, time.Since(release.Date), release.Version)
-	jsonName := updateJSONName(toChannel, platform.Name, env)
-	jsonURL := urlString(bucketName, platform.PrefixSupport, fmt.Sprintf("update-%s-%s-%s.json", platform.Name, env, release.Version))
+	c.logf("Found %s release %s (%s), %s", platform.Name, release.Name, time.Since(release.Date), release.Version)
+	if c.check != nil {
+		if err := c.check(*release); err != nil {
+			return nil, fmt.Errorf("Not promoting %s: %s", release.Version, err)
+		}
+	}
+	if err := c.checkComplete(bucketName, platform, release.Version); err != nil {
+		return nil, err
+	}
+	jsonName := c.updateJSONKey(toChannel, platform.Name, env)
+	jsonKey := versionedUpdateJSONKey(platform, env, release.Version)
 
 	if dryRun {
-		log.Printf("DRYRUN: Would PutCopy %s to %s\n", jsonURL, jsonName)
+		c.logf("DRYRUN: Would PutCopy %s to %s\n", jsonKey, jsonName)
 		return release, nil
 	}
-	log.Printf("PutCopying %s to %s\n", jsonURL, jsonName)
-	_, err = c.svc.CopyObject(&s3.CopyObjectInput{
-		Bucket:       aws.String(bucketName),
-		CopySource:   aws.String(jsonURL),
-		Key:          aws.String(jsonName),
-		CacheControl: aws.String(defaultCacheControl),
-		ACL:          aws.String("public-read"),
-	})
-	return release, err
+	err = c.promoteUpdateJSON(bucketName, jsonKey, jsonName)
+	metrics.promotion(platform.Name, toChannel, time.Since(start), err == nil)
+	if err != nil {
+		return release, err
+	}
+	c.notify(Notification{Platform: platform.Name, Channel: toChannel, Version: release.Version, URL: release.URL})
+	return release, nil
+}
+
+// PromoteOptions are the options for PromoteRelease. The zero value promotes
+// the latest release, if it's newer than the current one.
+type PromoteOptions struct {
+	// AllowDowngrade promotes the release even if it's older than the current
+	// version
+	AllowDowngrade bool
+	// Force promotes the release even if it's the current (or an older) version
+	Force bool
+	// ReleaseName is the release to promote, instead of the latest
+	ReleaseName string
+	// DryRun returns the release that would be promoted without changing
+	// anything
+	DryRun bool
 }
 
-// PromoteRelease promotes a release to a channel
-func (c *Client) PromoteRelease(bucketName string, delay time.Duration, beforeHourEastern int, toChannel string, platform Platform, env string, allowDowngrade bool, releaseName string) (*Release, error) {
-	log.Printf("Finding release to promote to %q (%s delay) in env %s", toChannel, delay, env)
+// PromoteRelease promotes a release to a channel. If beforeHour is set, only
+// releases from before that hour (in the timezone, see SetTimezone) are
+// promoted.
+func (c *Client) PromoteRelease(bucketName string, delay time.Duration, beforeHour int, toChannel string, platform Platform, env string, opts PromoteOptions) (*Release, error) {
+	start := time.Now()
+	c.logf("Finding release to promote to %q (%s delay) in env %s", toChannel, delay, env)
 	var release *Release
 	var err error
 
-	if releaseName != "" {
-		releaseName = fmt.Sprintf("Keybase-%s.dmg", releaseName)
-		release, err = platform.FindRelease(bucketName, func(r Release) bool {
-			return r.Name == releaseName
+	if opts.ReleaseName != "" {
+		release, err = c.FindRelease(platform, bucketName, func(r Release) bool {
+			return platform.isRelease(r, opts.ReleaseName)
 		})
 	} else {
-		release, err = platform.FindRelease(bucketName, func(r Release) bool {
-			log.Printf("Checking release date %s", r.Date)
+		release, err = c.FindRelease(platform, bucketName, func(r Release) bool {
+			c.logf("Checking release date %s", r.Date)
 			if delay != 0 && time.Since(r.Date) < delay {
 				return false
 			}
 			hour, _, _ := r.Date.Clock()
-			if beforeHourEastern != 0 && hour >= beforeHourEastern {
+			if beforeHour != 0 && hour >= beforeHour {
 				return false
 			}
 			return true
@@ -573,17 +1550,17 @@ func (c *Client) PromoteRelease(bucketName string, delay time.Duration, beforeHo
 	}
 
 	if release == nil {
-		log.Printf("No matching release found")
+		c.logf("No matching release found")
 		return nil, nil
 	}
-	log.Printf("Found release %s (%s), %s", release.Name, time.Since(release.Date), release.Version)
+	c.logf("Found release %s (%s), %s", release.Name, time.Since(release.Date), release.Version)
 
 	currentUpdate, _, err := c.CurrentUpdate(bucketName, toChannel, platform.Name, env)
 	if err != nil {
-		log.Printf("Error looking for current update: %s (%s)", err, platform.Name)
+		c.logf("Error looking for current update: %s (%s)", err, platform.Name)
 	}
 	if currentUpdate != nil {
-		log.Printf("Found current update: %s", currentUpdate.Version)
+		c.logf("Found current update: %s", currentUpdate.Version)
 		var currentVer semver.Version
 		currentVer, err = semver.Make(currentUpdate.Version)
 		if err != nil {
@@ -596,128 +1573,305 @@ func (c *Client) PromoteRelease(bucketName string, delay time.Duration, beforeHo
 		}
 
 		if releaseVer.Equals(currentVer) {
-			log.Printf("Release unchanged")
-			return nil, nil
+			if !opts.Force {
+				c.logf("Release unchanged")
+				return nil, nil
+			}
+			c.logf("WARNING: Forcing promotion of unchanged release %s", release.Version)
 		} else if releaseVer.LT(currentVer) {
-			if !allowDowngrade {
-				log.Printf("Release older than current update")
+			switch {
+			case opts.Force:
+				c.logf("WARNING: Forcing downgrade from %s to %s", currentVer, releaseVer)
+			case opts.AllowDowngrade:
+				c.logf("Allowing downgrade")
+			default:
+				c.logf("Release older than current update")
 				return nil, nil
 			}
-			log.Printf("Allowing downgrade")
 		}
 	}
 
-	jsonURL := urlString(bucketName, platform.PrefixSupport, fmt.Sprintf("update-%s-%s-%s.json", platform.Name, env, release.Version))
-	jsonName := updateJSONName(toChannel, platform.Name, env)
-	log.Printf("PutCopying %s to %s\n", jsonURL, jsonName)
-	_, err = c.svc.CopyObject(&s3.CopyObjectInput{
-		Bucket:       aws.String(bucketName),
-		CopySource:   aws.String(jsonURL),
-		Key:          aws.String(jsonName),
-		CacheControl: aws.String(defaultCacheControl),
-		ACL:          aws.String("public-read"),
-	})
-
+	if c.check != nil {
+		if err = c.check(*release); err != nil {
+			return nil, fmt.Errorf("Not promoting %s: %s", release.Version, err)
+		}
+	}
+	if err = c.checkComplete(bucketName, platform, release.Version); err != nil {
+		return nil, err
+	}
+	jsonKey := versionedUpdateJSONKey(platform, env, release.Version)
+	jsonName := c.updateJSONKey(toChannel, platform.Name, env)
+	if opts.DryRun {
+		c.logf("DRYRUN: Would PutCopy %s to %s\n", jsonKey, jsonName)
+		return release, nil
+	}
+	err = c.promoteUpdateJSON(bucketName, jsonKey, jsonName)
+	metrics.promotion(platform.Name, toChannel, time.Since(start), err == nil)
 	if err != nil {
 		return nil, err
 	}
+	c.notify(Notification{Platform: platform.Name, Channel: toChannel, Version: release.Version, URL: release.URL})
 	return release, nil
 }
 
-func copyUpdateJSON(bucketName string, fromChannel string, toChannel string, platformName string, env string) error {
-	client, err := NewClient()
-	if err != nil {
-		return err
-	}
-	jsonNameDest := updateJSONName(toChannel, platformName, env)
-	jsonURLSource := urlString(bucketName, "", updateJSONName(fromChannel, platformName, env))
+func (c *Client) copyUpdateJSON(bucketName string, fromChannel string, toChannel string, platformName string, env string) error {
+	jsonNameDest := c.updateJSONKey(toChannel, platformName, env)
+	jsonNameSource := c.updateJSONKey(fromChannel, platformName, env)
 
-	log.Printf("PutCopying %s to %s\n", jsonURLSource, jsonNameDest)
-	_, err = client.svc.CopyObject(&s3.CopyObjectInput{
+	c.logf("PutCopying %s to %s\n", jsonNameSource, jsonNameDest)
+	_, err := c.copyObject(&s3.CopyObjectInput{
 		Bucket:       aws.String(bucketName),
-		CopySource:   aws.String(jsonURLSource),
+		CopySource:   aws.String(copySource(bucketName, jsonNameSource)),
 		Key:          aws.String(jsonNameDest),
 		CacheControl: aws.String(defaultCacheControl),
-		ACL:          aws.String("public-read"),
+		ACL:          aws.String(c.cannedACL()),
 	})
 	return err
 }
 
-func (c *Client) report(tw io.Writer, bucketName string, channel string, platformName string) {
-	update, jsonPath, err := c.CurrentUpdate(bucketName, channel, platformName, "prod")
-	fmt.Fprintf(tw, "%s\t%s\t", platformName, channel)
+// CopyAcrossEnv copies a channel's update JSON for a platform from one env to
+// another (like staging to prod), after checking the asset it refers to
+// exists
+func CopyAcrossEnv(bucketName string, channel string, platformName string, fromEnv string, toEnv string) error {
+	client, err := NewClient()
+	if err != nil {
+		return err
+	}
+	return client.CopyAcrossEnv(bucketName, channel, platformName, fromEnv, toEnv)
+}
+
+// CopyAcrossEnv copies update JSON from one env to another for the Client
+func (c *Client) CopyAcrossEnv(bucketName string, channel string, platformName string, fromEnv string, toEnv string) error {
+	for _, env := range []string{fromEnv, toEnv} {
+		if !isValidEnv(env) {
+			return fmt.Errorf("Invalid env %s", env)
+		}
+	}
+	if fromEnv == toEnv {
+		return fmt.Errorf("Can't copy update JSON to the same env (%s)", fromEnv)
+	}
+	jsonNameSource := c.updateJSONKey(channel, platformName, fromEnv)
+	jsonNameDest := c.updateJSONKey(channel, platformName, toEnv)
+
+	upd, err := c.updateAt(bucketName, jsonNameSource)
+	if err != nil {
+		return err
+	}
+	if upd.Asset != nil {
+		assetKey, err := keyForURL(bucketName, upd.Asset.URL)
+		if err != nil {
+			return fmt.Errorf("Invalid asset in update JSON at %s: %s", jsonNameSource, err)
+		}
+		exists, err := c.objectExists(bucketName, assetKey)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("Asset %s for update JSON at %s doesn't exist", assetKey, jsonNameSource)
+		}
+	}
+	c.logf("Copying %s update JSON (%s) to %s", fromEnv, upd.Version, toEnv)
+	return c.promoteUpdateJSON(bucketName, jsonNameSource, jsonNameDest)
+}
+
+// ReportEntry is the current update for a platform and channel
+type ReportEntry struct {
+	Platform  string     `json:"platform"`
+	Channel   string     `json:"channel"`
+	Version   string     `json:"version,omitempty"`
+	Published *time.Time `json:"published,omitempty"`
+	Source    string     `json:"source"`
+	Error     string     `json:"error,omitempty"`
+}
+
+func (c *Client) reportEntry(bucketName string, channel string, platformName string, env string) ReportEntry {
+	update, jsonPath, err := c.CurrentUpdate(bucketName, channel, platformName, env)
+	entry := ReportEntry{Platform: platformName, Channel: channel, Source: jsonPath}
 	if err != nil {
-		fmt.Fprintln(tw, "Error")
+		entry.Error = err.Error()
 	} else if update != nil {
-		published := ""
+		entry.Version = update.Version
 		if update.PublishedAt != nil {
-			published = convertEastern(FromTime(*update.PublishedAt)).Format(time.UnixDate)
+			published := inTimezone(FromTime(*update.PublishedAt))
+			entry.Published = &published
+		}
+	}
+	return entry
+}
+
+// reportPlatforms are the platforms in the updates report
+var reportPlatforms = []string{PlatformTypeDarwin, PlatformTypeDarwinArm64, PlatformTypeLinux}
+
+// ReportEntries returns the current updates for each platform and channel,
+// including any channels found in the bucket (see ListChannels)
+func ReportEntries(bucketName string, env string) ([]ReportEntry, error) {
+	client, err := NewClient()
+	if err != nil {
+		return nil, err
+	}
+	return client.ReportEntries(bucketName, env)
+}
+
+// ReportEntries returns the current updates for each platform and channel for
+// the Client
+func (c *Client) ReportEntries(bucketName string, env string) ([]ReportEntry, error) {
+	entries := []ReportEntry{}
+	for _, platformName := range reportPlatforms {
+		found, err := c.ListChannels(bucketName, platformName, env)
+		if err != nil {
+			return nil, err
+		}
+		channels := knownChannels(platformName)
+		known := map[string]bool{}
+		for _, channel := range channels {
+			known[channel] = true
+		}
+		for _, channel := range found {
+			if !known[channel] {
+				channels = append(channels, channel)
+			}
+		}
+		for _, channel := range channels {
+			entries = append(entries, c.reportEntry(bucketName, channel, platformName, env))
 		}
-		fmt.Fprintf(tw, "%s\t%s\t%s\n", update.Version, published, jsonPath)
-	} else {
-		fmt.Fprintln(tw, "None")
 	}
+	return entries, nil
 }
 
 // Report returns a summary of releases
-func Report(bucketName string, writer io.Writer) error {
+func Report(bucketName string, env string, writer io.Writer) error {
+	entries, err := ReportEntries(bucketName, env)
+	if err != nil {
+		return err
+	}
+	return WriteReport(entries, writer)
+}
+
+// ReportJSON writes the current updates as JSON, like updates-report
+// --format json, for scripts and dashboards. Published times are RFC3339.
+func ReportJSON(bucketName string, env string, writer io.Writer) error {
 	client, err := NewClient()
 	if err != nil {
 		return err
 	}
+	return client.ReportJSON(bucketName, env, writer)
+}
+
+// ReportJSON writes the current updates as JSON for the Client
+func (c *Client) ReportJSON(bucketName string, env string, writer io.Writer) error {
+	entries, err := c.ReportEntries(bucketName, env)
+	if err != nil {
+		return err
+	}
+	return WriteJSON(writer, entries)
+}
 
+// WriteReport writes report entries as a table
+func WriteReport(entries []ReportEntry, writer io.Writer) error {
 	tw := tabwriter.NewWriter(writer, 5, 0, 3, ' ', 0)
 	fmt.Fprintln(tw, "Platform\tChannel\tVersion\tCreated\tSource")
-	client.report(tw, bucketName, "test-v2", PlatformTypeDarwin)
-	client.report(tw, bucketName, "v2", PlatformTypeDarwin)
-	client.report(tw, bucketName, "test-v2", PlatformTypeDarwinArm64)
-	client.report(tw, bucketName, "v2", PlatformTypeDarwinArm64)
-	client.report(tw, bucketName, "test", PlatformTypeLinux)
-	client.report(tw, bucketName, "", PlatformTypeLinux)
+	for _, entry := range entries {
+		fmt.Fprintf(tw, "%s\t%s\t", entry.Platform, entry.Channel)
+		if entry.Error != "" {
+			fmt.Fprintln(tw, "Error")
+		} else if entry.Version != "" {
+			published := ""
+			if entry.Published != nil {
+				published = entry.Published.Format(time.UnixDate)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", entry.Version, published, entry.Source)
+		} else {
+			fmt.Fprintln(tw, "None")
+		}
+	}
 	return tw.Flush()
 }
 
 // promoteTestReleaseForDarwin creates a test release for darwin
-func promoteTestReleaseForDarwin(bucketName string, release string) (*Release, error) {
-	return promoteRelease(bucketName, time.Duration(0), 0, "test-v2", platformDarwin, "prod", true, release)
+func (c *Client) promoteTestReleaseForDarwin(bucketName string, env string, release string) (*Release, error) {
+	return c.PromoteRelease(bucketName, time.Duration(0), 0, "test-v2", platformDarwin, env, PromoteOptions{AllowDowngrade: true, ReleaseName: release})
 }
 
-func promoteTestReleaseForDarwinArm64(bucketName string, release string) (*Release, error) {
-	return promoteRelease(bucketName, time.Duration(0), 0, "test-v2", platformDarwinArm64, "prod", true, release)
+func (c *Client) promoteTestReleaseForDarwinArm64(bucketName string, env string, release string) (*Release, error) {
+	return c.PromoteRelease(bucketName, time.Duration(0), 0, "test-v2", platformDarwinArm64, env, PromoteOptions{AllowDowngrade: true, ReleaseName: release})
 }
 
-// promoteTestReleaseForLinux creates a test release for linux
-func promoteTestReleaseForLinux(bucketName string) error {
-	// This just copies public to test since we don't do promotion on this platform yet
-	return copyUpdateJSON(bucketName, "", "test", PlatformTypeLinux, "prod")
+// promoteTestReleaseForLinux copies public to test for each linux platform
+// (deb, rpm), since we don't do promotion on linux yet. All platforms are
+// attempted, and any errors are combined.
+func (c *Client) promoteTestReleaseForLinux(bucketName string, env string) error {
+	platforms, err := Platforms(PlatformTypeLinux)
+	if err != nil {
+		return err
+	}
+	errs := []error{}
+	for _, platform := range platforms {
+		if err := c.copyUpdateJSON(bucketName, "", "test", platform.Name, env); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", platform.Name, err))
+		}
+	}
+	return CombineErrors(errs...)
 }
 
 // promoteTestReleaseForWindows creates a test release for windows
-func promoteTestReleaseForWindows(bucketName string) error {
+func (c *Client) promoteTestReleaseForWindows(bucketName string, env string) error {
 	// This just copies public to test since we don't do promotion on this platform yet
-	return copyUpdateJSON(bucketName, "", "test", PlatformTypeWindows, "prod")
+	return c.copyUpdateJSON(bucketName, "", "test", PlatformTypeWindows, env)
 }
 
 // PromoteTestReleases creates test releases for a platform
-func PromoteTestReleases(bucketName string, platformName string, release string) error {
+func PromoteTestReleases(bucketName string, platformName string, env string, release string) error {
+	return PromoteTestReleasesWithContext(context.Background(), bucketName, platformName, env, release)
+}
+
+// PromoteTestReleasesWithContext is PromoteTestReleases with a context for
+// its S3 requests
+func PromoteTestReleasesWithContext(ctx context.Context, bucketName string, platformName string, env string, release string) error {
+	client, err := NewClient()
+	if err != nil {
+		return err
+	}
+	return client.WithContext(ctx).PromoteTestReleases(bucketName, platformName, env, release)
+}
+
+// PromoteTestReleases creates test releases for a platform for the Client
+func (c *Client) PromoteTestReleases(bucketName string, platformName string, env string, release string) error {
 	switch platformName {
 	case PlatformTypeDarwin:
-		_, err := promoteTestReleaseForDarwin(bucketName, release)
+		_, err := c.promoteTestReleaseForDarwin(bucketName, env, release)
 		return err
 	case PlatformTypeDarwinArm64:
-		_, err := promoteTestReleaseForDarwinArm64(bucketName, release)
+		_, err := c.promoteTestReleaseForDarwinArm64(bucketName, env, release)
 		return err
 	case PlatformTypeLinux:
-		return promoteTestReleaseForLinux(bucketName)
+		return c.promoteTestReleaseForLinux(bucketName, env)
 	case PlatformTypeWindows:
-		return promoteTestReleaseForWindows(bucketName)
+		return c.promoteTestReleaseForWindows(bucketName, env)
 	default:
 		return fmt.Errorf("Invalid platform %s", platformName)
 	}
 }
 
-// PromoteReleases creates releases for a platform
-func PromoteReleases(bucketName string, platformType string) (release *Release, err error) {
+// PromoteReleases creates releases for a platform. If force is set, the
+// release is promoted even if it's unchanged or older than the current one.
+// If dryRun is set, the release that would be promoted is returned without
+// changing anything.
+func PromoteReleases(bucketName string, platformType string, env string, force bool, dryRun bool) (release *Release, err error) {
+	return PromoteReleasesWithContext(context.Background(), bucketName, platformType, env, force, dryRun)
+}
+
+// PromoteReleasesWithContext is PromoteReleases with a context for its S3
+// requests
+func PromoteReleasesWithContext(ctx context.Context, bucketName string, platformType string, env string, force bool, dryRun bool) (release *Release, err error) {
+	client, err := NewClient()
+	if err != nil {
+		return nil, err
+	}
+	return client.WithContext(ctx).PromoteReleases(bucketName, platformType, env, force, dryRun)
+}
+
+// PromoteReleases creates releases for a platform for the Client
+func (c *Client) PromoteReleases(bucketName string, platformType string, env string, force bool, dryRun bool) (release *Release, err error) {
 	var platform Platform
 	switch platformType {
 	case PlatformTypeDarwin:
@@ -725,55 +1879,100 @@ func PromoteReleases(bucketName string, platformType string) (release *Release,
 	case PlatformTypeDarwinArm64:
 		platform = platformDarwinArm64
 	default:
-		log.Printf("Promoting releases is unsupported for %s", platformType)
+		c.logf("Promoting releases is unsupported for %s", platformType)
 		return
 	}
-	release, err = promoteRelease(bucketName, time.Hour*27, 10, defaultChannel, platform, "prod", false, "")
+	release, err = c.PromoteRelease(bucketName, time.Hour*27, 10, defaultChannel, platform, env, PromoteOptions{Force: force, DryRun: dryRun})
 	if err != nil {
 		return nil, err
 	}
-	if release != nil {
-		log.Printf("Promoted (darwin) release: %s\n", release.Name)
+	if release != nil && !dryRun {
+		c.logf("Promoted (%s) release: %s\n", platformType, release.Name)
 	}
 	return release, nil
 }
 
+// maxPlatformConcurrency is how many platforms ForPlatforms runs at once
+const maxPlatformConcurrency = 4
+
+// ForPlatforms runs f for each platform type, concurrently if parallel is set.
+// Each platform gets a Client that prefixes its log lines with the platform.
+// All platforms are attempted, and any errors are combined.
+func (c *Client) ForPlatforms(platformTypes []string, parallel bool, f func(client *Client, platformType string) error) error {
+	errs := make([]error, len(platformTypes))
+	run := func(i int, platformType string) {
+		client := c.withLogPrefix(fmt.Sprintf("[%s] ", platformType))
+		if err := f(client, platformType); err != nil {
+			errs[i] = fmt.Errorf("%s: %s", platformType, err)
+		}
+	}
+
+	if !parallel {
+		for i, platformType := range platformTypes {
+			run(i, platformType)
+		}
+		return CombineErrors(errs...)
+	}
+
+	var g errgroup.Group
+	g.SetLimit(maxPlatformConcurrency)
+	for i, platformType := range platformTypes {
+		i, platformType := i, platformType
+		g.Go(func() error {
+			run(i, platformType)
+			return nil
+		})
+	}
+	_ = g.Wait()
+	return CombineErrors(errs...)
+}
+
 // ReleaseBroken marks a release as broken. The releaseName is the version,
 // for example, 1.2.3+400-deadbeef.
 func ReleaseBroken(releaseName string, bucketName string, platformName string) ([]string, error) {
+	return ReleaseBrokenWithContext(context.Background(), releaseName, bucketName, platformName)
+}
+
+// ReleaseBrokenWithContext is ReleaseBroken with a context for its S3
+// requests
+func ReleaseBrokenWithContext(ctx context.Context, releaseName string, bucketName string, platformName string) ([]string, error) {
 	client, err := NewClient()
 	if err != nil {
 		return nil, err
 	}
-	platforms, err := Platforms(platformName)
+	return client.WithContext(ctx).ReleaseBroken(releaseName, bucketName, platformName)
+}
+
+// ReleaseBroken marks a release as broken for the Client
+func (c *Client) ReleaseBroken(releaseName string, bucketName string, platformName string) ([]string, error) {
+	platforms, err := exactPlatforms(platformName)
 	if err != nil {
 		return nil, err
 	}
 	removed := []string{}
 	for _, platform := range platforms {
-		files, err := platform.Files(releaseName)
+		files, err := c.releaseFiles(platform, bucketName, releaseName)
 		if err != nil {
 			return nil, err
 		}
 		for _, path := range files {
-			sourceURL := urlString(bucketName, "", path)
-			brokenPath := fmt.Sprintf("broken/%s", path)
-			log.Printf("Copying %s to %s", sourceURL, brokenPath)
+			brokenPath := BrokenPrefix + path
+			log.Printf("Copying %s to %s", path, brokenPath)
 
-			_, err := client.svc.CopyObject(&s3.CopyObjectInput{
+			_, err := c.copyObject(&s3.CopyObjectInput{
 				Bucket:       aws.String(bucketName),
-				CopySource:   aws.String(sourceURL),
+				CopySource:   aws.String(copySource(bucketName, path)),
 				Key:          aws.String(brokenPath),
 				CacheControl: aws.String(defaultCacheControl),
-				ACL:          aws.String("public-read"),
+				ACL:          aws.String(c.cannedACL()),
 			})
 			if err != nil {
-				log.Printf("There was an error trying to (put) copy %s: %s", sourceURL, err)
+				log.Printf("There was an error trying to (put) copy %s: %s", path, err)
 				continue
 			}
 
 			log.Printf("Deleting: %s", path)
-			_, err = client.svc.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String(bucketName), Key: aws.String(path)})
+			_, err = c.svc.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String(bucketName), Key: aws.String(path)})
 			if err != nil {
 				return removed, err
 			}
@@ -781,12 +1980,12 @@ func ReleaseBroken(releaseName string, bucketName string, platformName string) (
 		}
 
 		// Update html for platform
-		if err := platform.WriteHTML(bucketName); err != nil {
+		if err := c.writePlatformHTML(bucketName, platform); err != nil {
 			log.Printf("Error updating html: %s", err)
 		}
 
 		// Fix test releases if needed
-		if err := PromoteTestReleases(bucketName, platform.Name, ""); err != nil {
+		if err := c.PromoteTestReleases(bucketName, platform.Name, EnvProd, ""); err != nil {
 			log.Printf("Error fixing test releases: %s", err)
 		}
 	}
@@ -798,14 +1997,122 @@ func ReleaseBroken(releaseName string, bucketName string, platformName string) (
 	return removed, nil
 }
 
+// DeleteRelease permanently deletes a release's files (see Platform.Files),
+// unlike ReleaseBroken which keeps them under BrokenPrefix. The releaseName is
+// the version, for example, 1.2.3-400+deadbeef. A release that's the current
+// update for a public or test channel isn't deleted, unless force is set.
+func DeleteRelease(releaseName string, bucketName string, platformName string, force bool) error {
+	client, err := NewClient()
+	if err != nil {
+		return err
+	}
+	return client.DeleteRelease(releaseName, bucketName, platformName, force)
+}
+
+// DeleteRelease permanently deletes a release's files for the Client. Every
+// file is attempted, and any errors are combined.
+func (c *Client) DeleteRelease(releaseName string, bucketName string, platformName string, force bool) error {
+	platforms, err := exactPlatforms(platformName)
+	if err != nil {
+		return err
+	}
+	if !force {
+		for _, platform := range platforms {
+			paths, err := c.currentUpdatePaths(bucketName, platform, releaseName)
+			if err != nil {
+				return err
+			}
+			if len(paths) > 0 {
+				return fmt.Errorf("Not deleting %s, it's the current update at %s", releaseName, strings.Join(paths, ", "))
+			}
+		}
+	}
+	found := false
+	errs := []error{}
+	for _, platform := range platforms {
+		release, err := c.FindRelease(platform, bucketName, func(r Release) bool {
+			return platform.isRelease(r, releaseName)
+		})
+		if err != nil {
+			return err
+		}
+		if release == nil {
+			c.logf("No %s release %s", platform.Name, releaseName)
+			continue
+		}
+		found = true
+		files, err := c.releaseFiles(platform, bucketName, releaseName)
+		if err != nil {
+			return err
+		}
+
+		deleted, missing := []string{}, []string{}
+		for _, path := range files {
+			exists, err := c.objectExists(bucketName, path)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %s", path, err))
+				continue
+			}
+			if !exists {
+				missing = append(missing, path)
+				continue
+			}
+			c.logf("Deleting %s", path)
+			_, err = c.svc.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String(bucketName), Key: aws.String(path)})
+			if err != nil {
+				errs = append(errs, fmt.Errorf("Error deleting %s: %s", path, err))
+				continue
+			}
+			deleted = append(deleted, path)
+		}
+		c.logf("Deleted %d files for %s release %s", len(deleted), platform.Name, releaseName)
+		if len(missing) > 0 {
+			c.logf("Missing (not deleted) for %s release %s: %s", platform.Name, releaseName, strings.Join(missing, ", "))
+		}
+
+		// Update html for platform
+		if err := c.writePlatformHTML(bucketName, platform); err != nil {
+			c.logf("Error updating html: %s", err)
+		}
+	}
+	if !found {
+		return fmt.Errorf("No release %s found for %s", releaseName, platformName)
+	}
+	return CombineErrors(errs...)
+}
+
+// currentUpdatePaths returns the update JSON paths of a platform's public and
+// test channels (in prod) whose current update is the release
+func (c *Client) currentUpdatePaths(bucketName string, platform Platform, releaseName string) ([]string, error) {
+	paths := []string{}
+	for _, channel := range knownChannels(updatePlatformName(platform)) {
+		currentUpdate, path, err := c.CurrentUpdate(bucketName, channel, platform.Name, EnvProd)
+		if isNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Error getting update at %s: %s", path, err)
+		}
+		if currentUpdate.Version == releaseName {
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
+
 // SaveLog saves log to S3 bucket (last maxNumBytes) and returns the URL.
-// The log is publicly readable on S3 but the url is not discoverable.
+// The log is publicly readable on S3 (with the default ACL) but the url is not
+// discoverable.
 func SaveLog(bucketName string, localPath string, maxNumBytes int64) (string, error) {
 	client, err := NewClient()
 	if err != nil {
 		return "", err
 	}
+	return client.SaveLog(bucketName, localPath, maxNumBytes)
+}
 
+// SaveLog saves a log to the S3 bucket for the Client
+func (c *Client) SaveLog(bucketName string, localPath string, maxNumBytes int64) (string, error) {
 	file, err := os.Open(localPath)
 	if err != nil {
 		return "", fmt.Errorf("Error opening: %s", err)
@@ -834,16 +2141,7 @@ func SaveLog(bucketName string, localPath string, maxNumBytes int64) (string, er
 	}
 	uploadDest := filepath.ToSlash(filepath.Join("logs", fmt.Sprintf("%s-%s%s", filename, logID, ".txt")))
 
-	_, err = client.svc.PutObject(&s3.PutObjectInput{
-		Bucket:        aws.String(bucketName),
-		Key:           aws.String(uploadDest),
-		CacheControl:  aws.String(defaultCacheControl),
-		ACL:           aws.String("public-read"),
-		Body:          bytes.NewReader(data),
-		ContentLength: aws.Int64(int64(len(data))),
-		ContentType:   aws.String("text/plain"),
-	})
-	if err != nil {
+	if err := c.putObject(bucketName, uploadDest, data, "text/plain"); err != nil {
 		return "", err
 	}
 
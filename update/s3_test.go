@@ -4,8 +4,33 @@
 package update
 
 import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -19,3 +44,1885 @@ func testFindRelease(t *testing.T) { // nolint
 	t.Logf("Release: %#v", release)
 	assert.NotEqual(t, "", release.URL)
 }
+
+const testBucket = "test.keybase.io"
+
+type fakeObject struct {
+	body         []byte
+	lastModified time.Time
+}
+
+// fakeS3 is an in-memory S3 for a single bucket that records mutations
+type fakeS3 struct {
+	s3iface.S3API
+	objects map[string]fakeObject
+	puts    []*s3.PutObjectInput
+	copies  []*s3.CopyObjectInput
+	deletes []string
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: map[string]fakeObject{}}
+}
+
+func newTestClient(svc *fakeS3) *Client {
+	return &Client{svc: svc}
+}
+
+func (f *fakeS3) add(key string, body string) {
+	f.objects[key] = fakeObject{body: []byte(body), lastModified: time.Now()}
+}
+
+func (f *fakeS3) notFound(key string) error {
+	return awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist: "+key, nil)
+}
+
+func (f *fakeS3) ListObjects(input *s3.ListObjectsInput) (*s3.ListObjectsOutput, error) {
+	prefix := aws.StringValue(input.Prefix)
+	delimiter := aws.StringValue(input.Delimiter)
+	keys := []string{}
+	for key := range f.objects {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if delimiter != "" && strings.Contains(key[len(prefix):], delimiter) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	out := &s3.ListObjectsOutput{IsTruncated: aws.Bool(false)}
+	for _, key := range keys {
+		obj := f.objects[key]
+		out.Contents = append(out.Contents, &s3.Object{
+			Key:          aws.String(key),
+			Size:         aws.Int64(int64(len(obj.body))),
+			LastModified: aws.Time(obj.lastModified),
+		})
+	}
+	return out, nil
+}
+
+func (f *fakeS3) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	key := aws.StringValue(input.Key)
+	obj, ok := f.objects[key]
+	if !ok {
+		return nil, f.notFound(key)
+	}
+	etag := fmt.Sprintf(`"%x"`, md5.Sum(obj.body))
+	if aws.StringValue(input.IfNoneMatch) == etag {
+		return nil, awserr.NewRequestFailure(awserr.New("NotModified", "Not Modified", nil), http.StatusNotModified, "")
+	}
+	return &s3.GetObjectOutput{
+		Body:          io.NopCloser(bytes.NewReader(obj.body)),
+		ContentLength: aws.Int64(int64(len(obj.body))),
+		ETag:          aws.String(etag),
+		LastModified:  aws.Time(obj.lastModified),
+	}, nil
+}
+
+func (f *fakeS3) HeadObject(input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	key := aws.StringValue(input.Key)
+	obj, ok := f.objects[key]
+	if !ok {
+		return nil, awserr.New("NotFound", "Not Found", nil)
+	}
+	return &s3.HeadObjectOutput{
+		ContentLength: aws.Int64(int64(len(obj.body))),
+		ETag:          aws.String(fmt.Sprintf(`"%x"`, md5.Sum(obj.body))),
+		LastModified:  aws.Time(obj.lastModified),
+	}, nil
+}
+
+func (f *fakeS3) PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	f.puts = append(f.puts, input)
+	body, err := io.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+	f.objects[aws.StringValue(input.Key)] = fakeObject{body: body, lastModified: time.Now()}
+	return &s3.PutObjectOutput{}, nil
+}
+
+// copySourceKey returns the key for a copy source (bucket/key), or "" if it
+// isn't well-formed
+func (f *fakeS3) copySourceKey(source string) string {
+	if !strings.HasPrefix(source, testBucket+"/") {
+		return ""
+	}
+	key, err := url.PathUnescape(strings.TrimPrefix(source, testBucket+"/"))
+	if err != nil {
+		return ""
+	}
+	return key
+}
+
+func (f *fakeS3) CopyObject(input *s3.CopyObjectInput) (*s3.CopyObjectOutput, error) {
+	f.copies = append(f.copies, input)
+	sourceKey := f.copySourceKey(aws.StringValue(input.CopySource))
+	obj, ok := f.objects[sourceKey]
+	if !ok {
+		return nil, f.notFound(sourceKey)
+	}
+	f.objects[aws.StringValue(input.Key)] = fakeObject{body: obj.body, lastModified: time.Now()}
+	return &s3.CopyObjectOutput{}, nil
+}
+
+func (f *fakeS3) DeleteObject(input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	key := aws.StringValue(input.Key)
+	f.deletes = append(f.deletes, key)
+	delete(f.objects, key)
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+// canceled returns the error the SDK returns for a done context, if it is
+func (f *fakeS3) canceled(ctx aws.Context) error {
+	if err := ctx.Err(); err != nil {
+		return awserr.New(request.CanceledErrorCode, "request context canceled", err)
+	}
+	return nil
+}
+
+func (f *fakeS3) ListObjectsWithContext(ctx aws.Context, input *s3.ListObjectsInput, _ ...request.Option) (*s3.ListObjectsOutput, error) {
+	if err := f.canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.ListObjects(input)
+}
+
+func (f *fakeS3) GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, _ ...request.Option) (*s3.GetObjectOutput, error) {
+	if err := f.canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.GetObject(input)
+}
+
+func (f *fakeS3) HeadObjectWithContext(ctx aws.Context, input *s3.HeadObjectInput, _ ...request.Option) (*s3.HeadObjectOutput, error) {
+	if err := f.canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.HeadObject(input)
+}
+
+func (f *fakeS3) PutObjectWithContext(ctx aws.Context, input *s3.PutObjectInput, _ ...request.Option) (*s3.PutObjectOutput, error) {
+	if err := f.canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.PutObject(input)
+}
+
+func (f *fakeS3) CopyObjectWithContext(ctx aws.Context, input *s3.CopyObjectInput, _ ...request.Option) (*s3.CopyObjectOutput, error) {
+	if err := f.canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.CopyObject(input)
+}
+
+func (f *fakeS3) DeleteObjectWithContext(ctx aws.Context, input *s3.DeleteObjectInput, _ ...request.Option) (*s3.DeleteObjectOutput, error) {
+	if err := f.canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DeleteObject(input)
+}
+
+func TestWriteHTMLDryRun(t *testing.T) {
+	svc := newFakeS3()
+	svc.add("darwin/Keybase-1.0.14-20160312013917+cd6f696.dmg", "dmg")
+	svc.add("darwin/Keybase-1.0.15-20160401013917+abcdef0.dmg", "dmg")
+	client := newTestClient(svc)
+	outPath := filepath.Join(t.TempDir(), "index.html")
+
+	err := client.WriteHTML(testBucket, "darwin/", "", outPath, "darwin/index.html", WriteHTMLOptions{DryRun: true})
+	require.NoError(t, err)
+	assert.Empty(t, svc.puts)
+	data, err := os.ReadFile(outPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "1.0.15-20160401013917+abcdef0")
+
+	var out bytes.Buffer
+	err = client.WriteHTML(testBucket, "darwin/", "", "", "darwin/index.html", WriteHTMLOptions{Writer: &out})
+	require.NoError(t, err)
+	require.Len(t, svc.puts, 1)
+	assert.Equal(t, "darwin/index.html", aws.StringValue(svc.puts[0].Key))
+	assert.Empty(t, out.String())
+
+	// Neither outPath nor uploadDest, so the html goes to the writer
+	err = client.WriteHTML(testBucket, "darwin/", "", "", "", WriteHTMLOptions{Writer: &out})
+	require.NoError(t, err)
+	require.Len(t, svc.puts, 1)
+	assert.Equal(t, string(data), out.String())
+}
+
+// listS3 is a fake S3 whose listings are slow, or fail for some prefixes
+type listS3 struct {
+	*fakeS3
+	delay time.Duration
+	fail  map[string]bool
+}
+
+func (l listS3) ListObjects(input *s3.ListObjectsInput) (*s3.ListObjectsOutput, error) {
+	time.Sleep(l.delay)
+	if l.fail[aws.StringValue(input.Prefix)] {
+		return nil, awserr.New("AccessDenied", "Access Denied", nil)
+	}
+	return l.fakeS3.ListObjects(input)
+}
+
+func TestWriteHTMLPrefixes(t *testing.T) {
+	svc := newFakeS3()
+	svc.add("darwin/Keybase-1.0.15-20160401013917+abcdef0.dmg", "dmg")
+	svc.add("windows/Keybase_1.0.14-20160312013917+cd6f696.amd64.msi", "msi")
+	client := &Client{svc: listS3{fakeS3: svc, delay: time.Millisecond}}
+
+	// Sections are in the order of the prefixes, however they're listed
+	var out bytes.Buffer
+	err := client.WriteHTML(testBucket, "windows/,darwin/,linux_binaries/deb/", "", "", "", WriteHTMLOptions{Writer: &out})
+	require.NoError(t, err)
+	html := out.String()
+	windows, darwin, deb := strings.Index(html, "windows/"), strings.Index(html, "darwin/"), strings.Index(html, "linux_binaries/deb/")
+	assert.True(t, windows >= 0 && windows < darwin && darwin < deb, html)
+
+	// All prefixes are attempted, and the errors combined
+	client = &Client{svc: listS3{fakeS3: svc, fail: map[string]bool{"darwin/": true, "windows/": true}}}
+	err = client.WriteHTML(testBucket, "windows/,darwin/,linux_binaries/deb/", "", "", "", WriteHTMLOptions{Writer: &out})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "windows/: AccessDenied")
+	assert.Contains(t, err.Error(), "darwin/: AccessDenied")
+}
+
+func BenchmarkListPrefixes(b *testing.B) {
+	svc := newFakeS3()
+	prefixes := []string{}
+	for i := 0; i < 10; i++ {
+		prefix := fmt.Sprintf("prefix%d/", i)
+		svc.add(prefix+"Keybase-1.0.15-20160401013917+abcdef0.dmg", "dmg")
+		prefixes = append(prefixes, prefix)
+	}
+	// Like the latency of a request to S3
+	client := &Client{svc: listS3{fakeS3: svc, delay: 10 * time.Millisecond}}
+	for _, concurrency := range []int{1, maxListConcurrency} {
+		b.Run(fmt.Sprintf("concurrency=%d", concurrency), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := client.listPrefixes(testBucket, prefixes, concurrency); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteHTMLSignedJSON(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	svc := newFakeS3()
+	svc.add("darwin/Keybase-1.0.15-20160401013917+abcdef0.dmg", "dmg")
+	client := newTestClient(svc)
+	jsonPath := filepath.Join(t.TempDir(), "index.json")
+
+	err = client.WriteHTML(testBucket, "darwin/", "", "", "", WriteHTMLOptions{
+		JSONOutPath:    jsonPath,
+		JSONUploadDest: "index.json",
+		Signer:         KeySigner{Key: priv},
+	})
+	require.NoError(t, err)
+
+	served := svc.objects["index.json"].body
+	require.NotEmpty(t, served)
+	assert.Contains(t, string(served), "1.0.15-20160401013917+abcdef0")
+	sig, err := base64.StdEncoding.DecodeString(string(svc.objects["index.json.sig"].body))
+	require.NoError(t, err)
+	assert.True(t, ed25519.Verify(pub, served, sig))
+
+	local, err := os.ReadFile(jsonPath)
+	require.NoError(t, err)
+	assert.Equal(t, served, local)
+	localSig, err := os.ReadFile(jsonPath + ".sig")
+	require.NoError(t, err)
+	assert.Equal(t, svc.objects["index.json.sig"].body, localSig)
+
+	// A command signer is given exactly the served bytes
+	err = client.WriteHTML(testBucket, "darwin/", "", "", "", WriteHTMLOptions{
+		JSONUploadDest: "index.json",
+		Signer:         CommandSigner{Command: "cat"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, svc.objects["index.json"].body, svc.objects["index.json.sig"].body)
+}
+
+func TestCopyLatest(t *testing.T) {
+	svc := newFakeS3()
+	svc.add("update-darwin-prod-v2.json", testUpdateJSON("1.0.15-20160401013917+abcdef0"))
+	svc.add("darwin-updates/Keybase-1.0.15-20160401013917+abcdef0.zip", "zip")
+	svc.add("darwin/Keybase-1.0.15-20160401013917+abcdef0.dmg", "new dmg")
+	svc.add("darwin/Keybase-1.0.14-20160312013917+cd6f696.dmg", "old dmg")
+	svc.add("Keybase.dmg", "old dmg")
+	svc.add("update-darwin-arm64-prod-v2.json", fmt.Sprintf(`{"version": "1.0.15-20160401013917+abcdef0", "asset": {"name": "Keybase-1.0.15-20160401013917+abcdef0.zip", "url": "https://%s/darwin-arm64-updates/Keybase-1.0.15-20160401013917%%2Babcdef0.zip"}}`, testBucket))
+	svc.add("darwin-arm64-updates/Keybase-1.0.15-20160401013917+abcdef0.zip", "zip")
+	svc.add("darwin-arm64/Keybase-1.0.15-20160401013917+abcdef0.dmg", "new arm64 dmg")
+	client := newTestClient(svc)
+	var logs bytes.Buffer
+	client.logger = log.New(&logs, "", 0)
+
+	require.NoError(t, client.CopyLatest(testBucket, PlatformTypeDarwin, true))
+	assert.Empty(t, svc.copies)
+	assert.Contains(t, logs.String(), `Platform "darwin" is darwin, darwin-arm64`)
+
+	// Both the Intel and Apple Silicon DMGs are copied for darwin
+	require.NoError(t, client.CopyLatest(testBucket, PlatformTypeDarwin, false))
+	require.Len(t, svc.copies, 2)
+	assert.Equal(t, testBucket+"/darwin/Keybase-1.0.15-20160401013917%2Babcdef0.dmg", *svc.copies[0].CopySource)
+	assert.Equal(t, "new dmg", string(svc.objects["Keybase.dmg"].body))
+	assert.Equal(t, testBucket+"/darwin-arm64/Keybase-1.0.15-20160401013917%2Babcdef0.dmg", *svc.copies[1].CopySource)
+	assert.Equal(t, "new arm64 dmg", string(svc.objects["Keybase-arm64.dmg"].body))
+}
+
+// testUpdateJSON is a darwin update JSON for version
+func testUpdateJSON(version string) string {
+	return fmt.Sprintf(`{"version": %q, "asset": {"name": "Keybase-%s.zip", "url": "https://%s/darwin-updates/Keybase-%s.zip"}}`,
+		version, version, testBucket, url.QueryEscape(version))
+}
+
+func TestCopyLatestValidatesUpdate(t *testing.T) {
+	svc := newFakeS3()
+	svc.add("darwin/Keybase-1.0.15-20160401013917+abcdef0.dmg", "dmg")
+	svc.add("Keybase.dmg", "old dmg")
+	client := newTestClient(svc)
+
+	// Asset doesn't exist
+	svc.add("update-darwin-prod-v2.json", testUpdateJSON("1.0.15-20160401013917+abcdef0"))
+	err := client.CopyLatest(testBucket, PlatformTypeDarwin, false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "darwin-updates/Keybase-1.0.15-20160401013917+abcdef0.zip")
+
+	// Asset for a different version
+	svc.add("update-darwin-prod-v2.json", `{"version": "1.0.15-20160401013917+abcdef0", "asset": {"name": "Keybase-1.0.14.zip", "url": "https://test.keybase.io/darwin-updates/Keybase-1.0.14.zip"}}`)
+	err = client.CopyLatest(testBucket, PlatformTypeDarwin, false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "doesn't match version")
+
+	// DMG doesn't exist
+	svc.add("update-darwin-prod-v2.json", testUpdateJSON("1.0.16-20160501013917+abcdef0"))
+	svc.add("darwin-updates/Keybase-1.0.16-20160501013917+abcdef0.zip", "zip")
+	err = client.CopyLatest(testBucket, PlatformTypeDarwin, false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "darwin/Keybase-1.0.16-20160501013917+abcdef0.dmg")
+
+	assert.Empty(t, svc.copies)
+	assert.Equal(t, "old dmg", string(svc.objects["Keybase.dmg"].body))
+}
+
+func TestNewClientWithConfig(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Requests are path style, with the bucket in the path
+		if r.URL.Path != "/"+testBucket+"/index.html" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte("index"))
+	}))
+	defer server.Close()
+
+	t.Setenv(EnvS3Endpoint, server.URL)
+	t.Setenv(EnvS3Region, "us-west-2")
+	cfg := ConfigFromEnv()
+	assert.Equal(t, Config{Endpoint: server.URL, Region: "us-west-2"}, cfg)
+
+	client, err := NewClientWithConfig(cfg)
+	require.NoError(t, err)
+	resp, err := client.svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String("index.html"),
+	})
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "index", string(body))
+
+	_, err = NewClientWithConfig(Config{Endpoint: "localhost:9000"})
+	require.Error(t, err)
+}
+
+func TestKeyForURL(t *testing.T) {
+	key, err := keyForURL(testBucket, "https://test.keybase.io/darwin-updates/Keybase-1.0.15%2Babcdef0.zip")
+	require.NoError(t, err)
+	assert.Equal(t, "darwin-updates/Keybase-1.0.15+abcdef0.zip", key)
+	key, err = keyForURL(testBucket, "https://s3.amazonaws.com/test.keybase.io/windows/Keybase.msi")
+	require.NoError(t, err)
+	assert.Equal(t, "windows/Keybase.msi", key)
+	_, err = keyForURL(testBucket, "https://other.keybase.io/darwin-updates/Keybase.zip")
+	require.Error(t, err)
+}
+
+func TestPromoteReleaseCopySource(t *testing.T) {
+	svc := newFakeS3()
+	svc.add("darwin/Keybase-1.0.15-20160401013917+abcdef0.dmg", "dmg")
+	svc.add("darwin-support/update-darwin-prod-1.0.15-20160401013917+abcdef0.json", `{"version": "1.0.15-20160401013917+abcdef0"}`)
+	client := newTestClient(svc)
+	platform, err := supportPlatform(PlatformTypeDarwin)
+	require.NoError(t, err)
+
+	release, err := client.PromoteRelease(testBucket, 0, 0, "v2", platform, EnvProd, PromoteOptions{})
+	require.NoError(t, err)
+	require.NotNil(t, release)
+	require.Len(t, svc.copies, 1)
+	assert.Equal(t, testBucket+"/darwin-support/update-darwin-prod-1.0.15-20160401013917%2Babcdef0.json", *svc.copies[0].CopySource)
+	assert.Equal(t, "update-darwin-prod-v2.json", *svc.copies[0].Key)
+}
+
+func TestPromoteReleaseDryRun(t *testing.T) {
+	svc := newFakeS3()
+	svc.add("darwin/Keybase-1.0.15-20160401133917+abcdef0.dmg", "dmg")
+	svc.add("darwin-support/update-darwin-prod-1.0.15-20160401133917+abcdef0.json", `{"version": "1.0.15-20160401133917+abcdef0"}`)
+	svc.add("update-darwin-prod-v2.json", testUpdateJSON("1.0.14-20160312013917+cd6f696"))
+	client := newTestClient(svc)
+	platform, err := supportPlatform(PlatformTypeDarwin)
+	require.NoError(t, err)
+	assertUnchanged := func() {
+		assert.Empty(t, svc.copies)
+		assert.Empty(t, svc.puts)
+		assert.Empty(t, svc.deletes)
+		assert.Contains(t, string(svc.objects["update-darwin-prod-v2.json"].body), "1.0.14-20160312013917+cd6f696")
+	}
+
+	release, err := client.PromoteRelease(testBucket, 0, 0, "v2", platform, EnvProd, PromoteOptions{DryRun: true})
+	require.NoError(t, err)
+	require.NotNil(t, release)
+	assert.Equal(t, "1.0.15-20160401133917+abcdef0", release.Version)
+	assertUnchanged()
+
+	release, err = client.PromoteReleases(testBucket, PlatformTypeDarwin, EnvProd, true, true)
+	require.NoError(t, err)
+	require.NotNil(t, release)
+	assert.Equal(t, "1.0.15-20160401133917+abcdef0", release.Version)
+	assertUnchanged()
+
+	release, err = client.promoteAReleaseToProd("1.0.15-20160401133917+abcdef0", testBucket, platform, EnvProd, "v2", true)
+	require.NoError(t, err)
+	require.NotNil(t, release)
+	assertUnchanged()
+}
+
+func TestPromoteReleasesCheckFails(t *testing.T) {
+	svc := newFakeS3()
+	svc.add("darwin/Keybase-1.0.15-20160401133917+abcdef0.dmg", "dmg")
+	svc.add("darwin-support/update-darwin-prod-1.0.15-20160401133917+abcdef0.json", `{"version": "1.0.15-20160401133917+abcdef0"}`)
+	client := newTestClient(svc)
+	client.check = func(release Release) error { return fmt.Errorf("CI failed for %s", release.Commit) }
+
+	release, err := client.PromoteReleases(testBucket, PlatformTypeDarwin, EnvProd, false, false)
+	require.Error(t, err)
+	assert.Nil(t, release)
+	assert.Contains(t, err.Error(), "Not promoting 1.0.15-20160401133917+abcdef0: CI failed for abcdef0")
+	assert.Empty(t, svc.copies)
+}
+
+func TestCopySource(t *testing.T) {
+	assert.Equal(t, "bucket/Keybase.dmg", copySource("bucket", "Keybase.dmg"))
+	assert.Equal(t, "bucket/dir/a%20b%2Bc.json", copySource("bucket", "dir/a b+c.json"))
+}
+
+func TestForPlatformsAttemptsAll(t *testing.T) {
+	client := newTestClient(newFakeS3())
+	platforms := []string{PlatformTypeDarwin, PlatformTypeDarwinArm64, PlatformTypeLinux, PlatformTypeWindows}
+	for _, parallel := range []bool{false, true} {
+		var mtx sync.Mutex
+		attempted := []string{}
+		err := client.ForPlatforms(platforms, parallel, func(client *Client, platformType string) error {
+			mtx.Lock()
+			attempted = append(attempted, platformType)
+			mtx.Unlock()
+			if platformType == PlatformTypeDarwinArm64 {
+				return fmt.Errorf("failed")
+			}
+			return nil
+		})
+		require.Error(t, err)
+		assert.Equal(t, "darwin-arm64: failed", err.Error())
+		assert.ElementsMatch(t, platforms, attempted)
+	}
+}
+
+func TestWriteHTMLChannels(t *testing.T) {
+	svc := newFakeS3()
+	svc.add("darwin/Keybase-1.0.14-20160312013917+cd6f696.dmg", "dmg")
+	svc.add("darwin/Keybase-1.0.15-20160401013917+abcdef0.dmg", "dmg")
+	svc.add("update-darwin-prod-v2.json", `{"version": "1.0.14-20160312013917+cd6f696"}`)
+	svc.add("update-darwin-prod-test-v2.json", `{"version": "1.0.15-20160401013917+abcdef0"}`)
+	client := newTestClient(svc)
+	outPath := filepath.Join(t.TempDir(), "index.html")
+
+	err := client.WriteHTML(testBucket, "darwin/", "", outPath, "", WriteHTMLOptions{
+		JSONUploadDest: "index.json",
+		ShowChannels:   true,
+	})
+	require.NoError(t, err)
+
+	var index struct {
+		Sections []Section `json:"sections"`
+	}
+	require.NoError(t, json.Unmarshal(svc.objects["index.json"].body, &index))
+	require.Len(t, index.Sections, 1)
+	releases := index.Sections[0].Releases
+	require.Len(t, releases, 2)
+	assert.Equal(t, "1.0.15-20160401013917+abcdef0", releases[0].Version)
+	assert.Equal(t, []string{"test"}, releases[0].Channels)
+	assert.Equal(t, []string{"public"}, releases[1].Channels)
+
+	html, err := os.ReadFile(outPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(html), "[test]")
+}
+
+func TestValidateNames(t *testing.T) {
+	svc := newFakeS3()
+	svc.add("darwin/Keybase-1.0.15-20160401013917+abcdef0.dmg", "dmg")
+	svc.add("darwin/Keybase.dmg", "dmg")
+	svc.add("darwin/index.html", "html")
+	client := newTestClient(svc)
+
+	parsed, failed, err := client.ValidateNames(testBucket, "darwin/")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Keybase-1.0.15-20160401013917+abcdef0.dmg"}, parsed)
+	assert.Equal(t, []string{"Keybase.dmg"}, failed)
+}
+
+func TestLoadReleasesSize(t *testing.T) {
+	modified := time.Date(2016, 4, 1, 1, 39, 17, 0, time.UTC)
+	objs := []*s3.Object{{
+		Key:          aws.String("darwin/Keybase-1.0.15-20160401013917+abcdef0.dmg"),
+		Size:         aws.Int64(182 * 1000 * 1000),
+		LastModified: aws.Time(modified),
+	}}
+	releases := loadReleases(objs, testBucket, "darwin/", "", 0)
+	require.Len(t, releases, 1)
+	assert.Equal(t, int64(182*1000*1000), releases[0].Size)
+	assert.Equal(t, "182 MB", releases[0].SizeString)
+	assert.True(t, modified.Equal(releases[0].LastModified))
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteHTMLForLinks(testBucket, []Section{{Header: "darwin/", Releases: releases}}, &buf))
+	assert.Contains(t, buf.String(), "(182 MB)")
+
+	assert.Equal(t, "512 B", humanSize(512))
+	assert.Equal(t, "1.5 KB", humanSize(1500))
+	assert.Equal(t, "2.1 GB", humanSize(2100*1000*1000))
+}
+
+func TestWriteHTMLFromDir(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"darwin/Keybase-1.0.14-20160312013917+cd6f696.dmg",
+		"darwin/Keybase-1.0.15-20160401013917+abcdef0.dmg",
+		"darwin/notes.txt",
+		"windows/Keybase_1.0.15-20160401013917+abcdef0.386.msi",
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, makeParentDirs(path))
+		require.NoError(t, os.WriteFile(path, []byte("release"), 0644))
+	}
+	outPath := filepath.Join(t.TempDir(), "index.html")
+
+	require.NoError(t, WriteHTMLFromDir(dir, "", outPath))
+	data, err := os.ReadFile(outPath)
+	require.NoError(t, err)
+	html := string(data)
+	assert.Contains(t, html, `<h3>darwin/</h3>`)
+	assert.Contains(t, html, `<h3>windows/</h3>`)
+	assert.Contains(t, html, `href="darwin/Keybase-1.0.15-20160401013917%2Babcdef0.dmg"`)
+	assert.Contains(t, html, `<strong>1.0.15-20160401013917+abcdef0</strong>`)
+	assert.Less(t, strings.Index(html, "1.0.15-20160401013917+abcdef0.dmg"), strings.Index(html, "1.0.14-20160312013917+cd6f696.dmg"))
+
+	require.NoError(t, WriteHTMLFromDir(dir, ".dmg", outPath))
+	data, err = os.ReadFile(outPath)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "notes.txt")
+	assert.NotContains(t, string(data), "windows/")
+}
+
+func TestPromoteReleaseForce(t *testing.T) {
+	svc := newFakeS3()
+	svc.add("darwin/Keybase-1.0.14-20160312013917+cd6f696.dmg", "dmg")
+	svc.add("darwin-support/update-darwin-prod-1.0.14-20160312013917+cd6f696.json", `{"version": "1.0.14-20160312013917+cd6f696"}`)
+	svc.add("update-darwin-prod-v2.json", `{"version": "1.0.14-20160312013917+cd6f696"}`)
+	client := newTestClient(svc)
+	platform, err := supportPlatform(PlatformTypeDarwin)
+	require.NoError(t, err)
+
+	release, err := client.PromoteRelease(testBucket, 0, 0, "v2", platform, EnvProd, PromoteOptions{})
+	require.NoError(t, err)
+	assert.Nil(t, release)
+	assert.Empty(t, svc.copies)
+
+	release, err = client.PromoteRelease(testBucket, 0, 0, "v2", platform, EnvProd, PromoteOptions{Force: true})
+	require.NoError(t, err)
+	require.NotNil(t, release)
+	require.Len(t, svc.copies, 1)
+	assert.Equal(t, "update-darwin-prod-v2.json", *svc.copies[0].Key)
+
+	// Older than current requires force
+	svc.copies = nil
+	svc.add("update-darwin-prod-v2.json", `{"version": "1.0.15-20160401013917+abcdef0"}`)
+	release, err = client.PromoteRelease(testBucket, 0, 0, "v2", platform, EnvProd, PromoteOptions{})
+	require.NoError(t, err)
+	assert.Nil(t, release)
+	assert.Empty(t, svc.copies)
+
+	release, err = client.PromoteRelease(testBucket, 0, 0, "v2", platform, EnvProd, PromoteOptions{Force: true})
+	require.NoError(t, err)
+	require.NotNil(t, release)
+	require.Len(t, svc.copies, 1)
+}
+
+func TestPlatformsForOSAndArch(t *testing.T) {
+	names := func(platforms []Platform) []string {
+		names := []string{}
+		for _, p := range platforms {
+			names = append(names, p.Name)
+		}
+		return names
+	}
+
+	platforms, err := PlatformsForOS(PlatformTypeDarwin)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"darwin", "darwin-arm64"}, names(platforms))
+	platforms, err = PlatformsForOS(PlatformTypeLinux)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"deb", "rpm"}, names(platforms))
+	platforms, err = PlatformsForArch(ArchAmd64)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"darwin", "deb", "rpm", "windows"}, names(platforms))
+	platforms, err = PlatformsForArch(ArchArm64)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"darwin-arm64"}, names(platforms))
+
+	_, err = PlatformsForOS("plan9")
+	require.Error(t, err)
+	_, err = PlatformsForArch("386")
+	require.Error(t, err)
+}
+
+func TestPlatformsDarwin(t *testing.T) {
+	platforms, err := Platforms(PlatformTypeDarwin)
+	require.NoError(t, err)
+	require.Len(t, platforms, 2)
+	assert.Equal(t, PlatformTypeDarwin, platforms[0].Name)
+	assert.Equal(t, PlatformTypeDarwinArm64, platforms[1].Name)
+
+	// PlatformNamed is the exact platform, not every darwin arch
+	platform, err := PlatformNamed(PlatformTypeDarwin)
+	require.NoError(t, err)
+	assert.Equal(t, "darwin/", platform.Prefix)
+	platform, err = PlatformNamed(PlatformTypeDarwinArm64)
+	require.NoError(t, err)
+	assert.Equal(t, "darwin-arm64/", platform.Prefix)
+	files, err := platform.Files("1.0.0")
+	require.NoError(t, err)
+	assert.Contains(t, files, "darwin-arm64-support/update-darwin-arm64-prod-1.0.0.json")
+	platform, err = PlatformNamed("deb")
+	require.NoError(t, err)
+	assert.Equal(t, "linux_binaries/deb/", platform.Prefix)
+	_, err = PlatformNamed("plan9")
+	require.Error(t, err)
+}
+
+func TestAllPrefixes(t *testing.T) {
+	assert.Equal(t, []string{
+		"darwin/", "darwin-support/",
+		"darwin-arm64/", "darwin-arm64-support/",
+		"linux_binaries/deb/", "linux_binaries/deb-support/",
+		"linux_binaries/rpm/", "linux_binaries/rpm-support/",
+		"windows/", "windows-support/",
+	}, AllPrefixes())
+}
+
+func TestReconstructSupportJSON(t *testing.T) {
+	svc := newFakeS3()
+	svc.add("update-darwin-prod-v2.json", `{"version": "1.0.14"}`)
+	svc.add("update-darwin-prod-test-v2.json", `{"version": "1.0.15"}`)
+	client := newTestClient(svc)
+
+	err := client.ReconstructSupportJSON(testBucket, PlatformTypeDarwin, EnvProd, "1.0.16")
+	require.Error(t, err)
+	assert.Empty(t, svc.copies)
+
+	require.NoError(t, client.ReconstructSupportJSON(testBucket, PlatformTypeDarwin, EnvProd, "1.0.15"))
+	assert.Equal(t, `{"version": "1.0.15"}`, string(svc.objects["darwin-support/update-darwin-prod-1.0.15.json"].body))
+
+	err = client.ReconstructSupportJSON(testBucket, PlatformTypeDarwin, EnvProd, "1.0.15")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "already exists")
+}
+
+func TestCheckLatestConsistency(t *testing.T) {
+	svc := newFakeS3()
+	svc.add("update-darwin-prod-v2.json", testUpdateJSON("1.0.15-20160401013917+abcdef0"))
+	svc.add("darwin-updates/Keybase-1.0.15-20160401013917+abcdef0.zip", "zip")
+	svc.add("darwin/Keybase-1.0.15-20160401013917+abcdef0.dmg", "new dmg")
+	svc.add("Keybase.dmg", "new dmg")
+	client := newTestClient(svc)
+
+	mismatches, err := client.CheckLatestConsistency(testBucket)
+	require.NoError(t, err)
+	for _, mismatch := range mismatches {
+		assert.NotContains(t, mismatch, "darwin:")
+	}
+
+	svc.add("Keybase.dmg", "old dmg")
+	mismatches, err = client.CheckLatestConsistency(testBucket)
+	require.NoError(t, err)
+	assert.Contains(t, mismatches, `darwin: ETag of Keybase.dmg ("`+fmt.Sprintf("%x", md5.Sum([]byte("old dmg")))+`") doesn't match darwin/Keybase-1.0.15-20160401013917+abcdef0.dmg ("`+fmt.Sprintf("%x", md5.Sum([]byte("new dmg")))+`")`)
+}
+
+func TestFindReleaseByCommit(t *testing.T) {
+	svc := newFakeS3()
+	svc.add("darwin/Keybase-1.0.14-20160312013917+cd6f696.dmg", "dmg")
+	svc.add("darwin/Keybase-1.0.15-20160401013917+abcdef0.dmg", "dmg")
+	svc.add("darwin/Keybase-1.0.16-20160501013917+abc1234.dmg", "dmg")
+	client := newTestClient(svc)
+
+	release, err := client.FindReleaseByCommit(testBucket, PlatformTypeDarwin, "cd6f696")
+	require.NoError(t, err)
+	assert.Equal(t, "1.0.14-20160312013917+cd6f696", release.Version)
+
+	release, err = client.FindReleaseByCommit(testBucket, PlatformTypeDarwin, "abcdef0123456789abcdef0123456789abcdef01")
+	require.NoError(t, err)
+	assert.Equal(t, "1.0.15-20160401013917+abcdef0", release.Version)
+
+	_, err = client.FindReleaseByCommit(testBucket, PlatformTypeDarwin, "abc")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Multiple")
+
+	_, err = client.FindReleaseByCommit(testBucket, PlatformTypeDarwin, "fff")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "No darwin release")
+}
+
+func TestLoadReleasesFourPartSort(t *testing.T) {
+	objs := []*s3.Object{}
+	for _, name := range []string{
+		"Keybase_6.1.0.12345-20230312013917+cd6f696.amd64.msi",
+		"Keybase_6.1.0.12346-20230312013917+cd6f696.amd64.msi",
+		"Keybase_6.0.9-20230101013917+abcdef0.amd64.msi",
+		"Keybase_6.1.1.2-20230401013917+1234567.amd64.msi",
+	} {
+		objs = append(objs, &s3.Object{Key: aws.String("windows/" + name)})
+	}
+	releases := loadReleases(objs, testBucket, "windows/", "", 0)
+	versions := []string{}
+	for _, release := range releases {
+		versions = append(versions, release.Version)
+	}
+	assert.Equal(t, []string{
+		"6.1.1-20230401013917+1234567.2",
+		"6.1.0-20230312013917+cd6f696.12346",
+		"6.1.0-20230312013917+cd6f696.12345",
+		"6.0.9-20230101013917+abcdef0",
+	}, versions)
+	assert.Equal(t, 12346, releases[1].Build)
+}
+
+func TestPromoteReleaseDestPrefix(t *testing.T) {
+	svc := newFakeS3()
+	svc.add("darwin/Keybase-1.0.15-20160401013917+abcdef0.dmg", "dmg")
+	svc.add("darwin-support/update-darwin-prod-1.0.15-20160401013917+abcdef0.json", `{"version": "1.0.15-20160401013917+abcdef0"}`)
+	client := newTestClient(svc)
+	client.destPrefix = "preprod/"
+	platform, err := supportPlatform(PlatformTypeDarwin)
+	require.NoError(t, err)
+
+	assert.Equal(t, "preprod/update-darwin-prod-v2.json", client.updateJSONKey("v2", PlatformTypeDarwin, EnvProd))
+	assert.Equal(t, "preprod/update-linux-prod.json", client.updateJSONKey("", PlatformTypeLinux, EnvProd))
+
+	_, err = client.PromoteRelease(testBucket, 0, 0, "v2", platform, EnvProd, PromoteOptions{})
+	require.NoError(t, err)
+	require.Len(t, svc.copies, 1)
+	assert.Equal(t, "preprod/update-darwin-prod-v2.json", *svc.copies[0].Key)
+	assert.Equal(t, testBucket+"/darwin-support/update-darwin-prod-1.0.15-20160401013917%2Babcdef0.json", *svc.copies[0].CopySource)
+}
+
+func TestPromoteTestReleaseForLinux(t *testing.T) {
+	svc := newFakeS3()
+	svc.add("update-deb-prod.json", `{"version": "1.0.15"}`)
+	svc.add("update-rpm-prod.json", `{"version": "1.0.15"}`)
+	client := newTestClient(svc)
+
+	require.NoError(t, client.promoteTestReleaseForLinux(testBucket, EnvProd))
+	require.Len(t, svc.copies, 2)
+	assert.Equal(t, "update-deb-prod-test.json", *svc.copies[0].Key)
+	assert.Equal(t, "update-rpm-prod-test.json", *svc.copies[1].Key)
+
+	// rpm is still attempted if deb fails
+	svc.copies = nil
+	delete(svc.objects, "update-deb-prod.json")
+	err := client.promoteTestReleaseForLinux(testBucket, EnvProd)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "deb: ")
+	require.Len(t, svc.copies, 2)
+}
+
+func TestPromoteReleaseTwoPhase(t *testing.T) {
+	svc := newFakeS3()
+	ver := "1.0.15-20160401013917+abcdef0"
+	svc.add("darwin/Keybase-"+ver+".dmg", "dmg")
+	svc.add("darwin-updates/Keybase-"+ver+".zip", "zip")
+	svc.add("update-darwin-prod-v2.json", `{"version": "1.0.14-20160312013917+cd6f696"}`)
+	zipDigest := fmt.Sprintf("%x", sha256.Sum256([]byte("zip")))
+	updateJSON := func(digest string, signature string) string {
+		return fmt.Sprintf(`{"version": %q, "asset": {"name": "Keybase-%s.zip", "url": "https://%s/darwin-updates/Keybase-%s.zip", "digest": %q, "signature": %q}}`,
+			ver, ver, testBucket, url.QueryEscape(ver), digest, signature)
+	}
+	client := newTestClient(svc)
+	client.twoPhase = true
+	platform, err := supportPlatform(PlatformTypeDarwin)
+	require.NoError(t, err)
+
+	// Validation fails, so the staged copy is removed and live is untouched
+	for _, bad := range []string{updateJSON("bad", "sig"), updateJSON(zipDigest, ""), testUpdateJSON(ver)} {
+		svc.add("darwin-support/update-darwin-prod-"+ver+".json", bad)
+		release, err := client.PromoteRelease(testBucket, 0, 0, "v2", platform, EnvProd, PromoteOptions{})
+		require.Error(t, err)
+		assert.Nil(t, release)
+		assert.Equal(t, `{"version": "1.0.14-20160312013917+cd6f696"}`, string(svc.objects["update-darwin-prod-v2.json"].body))
+		for key := range svc.objects {
+			assert.False(t, strings.HasPrefix(key, "staged/"), key)
+		}
+	}
+
+	svc.add("darwin-support/update-darwin-prod-"+ver+".json", updateJSON(zipDigest, "sig"))
+	release, err := client.PromoteRelease(testBucket, 0, 0, "v2", platform, EnvProd, PromoteOptions{})
+	require.NoError(t, err)
+	require.NotNil(t, release)
+	assert.Equal(t, updateJSON(zipDigest, "sig"), string(svc.objects["update-darwin-prod-v2.json"].body))
+	for key := range svc.objects {
+		assert.False(t, strings.HasPrefix(key, "staged/"), key)
+	}
+}
+
+func TestStagePromotionAbort(t *testing.T) {
+	svc := newFakeS3()
+	svc.add("darwin-updates/Keybase.zip", "zip")
+	svc.add("update-darwin-prod-v2.json", "live")
+	svc.add("source.json", fmt.Sprintf(`{"version": "1.0.15", "asset": {"name": "Keybase.zip", "url": "https://%s/darwin-updates/Keybase.zip", "digest": "%x", "signature": "sig"}}`,
+		testBucket, sha256.Sum256([]byte("zip"))))
+	client := newTestClient(svc)
+
+	staged, err := client.StagePromotion(testBucket, "source.json", "update-darwin-prod-v2.json")
+	require.NoError(t, err)
+	assert.Contains(t, svc.objects, staged)
+	assert.Equal(t, "live", string(svc.objects["update-darwin-prod-v2.json"].body))
+
+	require.NoError(t, client.AbortPromotion(testBucket, staged))
+	assert.NotContains(t, svc.objects, staged)
+	assert.Equal(t, "live", string(svc.objects["update-darwin-prod-v2.json"].body))
+}
+
+func TestFindDuplicateVersions(t *testing.T) {
+	svc := newFakeS3()
+	svc.add("darwin/Keybase-1.0.14-20160312013917+cd6f696.dmg", "dmg")
+	svc.add("darwin/Keybase-1.0.15-20160401013917+abcdef0.dmg", "dmg")
+	svc.add("darwin/Keybase-1.0.15.20160401013917+abcdef0.dmg", "reupload")
+	svc.add("darwin/notes.txt", "txt")
+	client := newTestClient(svc)
+
+	duplicates, err := client.FindDuplicateVersions(testBucket, "darwin/", ".dmg")
+	require.NoError(t, err)
+	require.Len(t, duplicates, 1)
+	releases := duplicates["1.0.15+abcdef0"]
+	require.Len(t, releases, 2)
+	keys := []string{releases[0].Key, releases[1].Key}
+	sort.Strings(keys)
+	assert.Equal(t, []string{"darwin/Keybase-1.0.15-20160401013917+abcdef0.dmg", "darwin/Keybase-1.0.15.20160401013917+abcdef0.dmg"}, keys)
+}
+
+func TestFindDuplicateVersionsReuploaded(t *testing.T) {
+	svc := newFakeS3()
+	svc.add("darwin/Keybase-1.0.15-20160401013917+abcdef0.dmg", "dmg")
+	svc.add("darwin/Keybase-1.0.15-20160402093000+abcdef0.dmg", "reupload")
+	svc.add("darwin/Keybase-1.0.15-20160403013917+1234567.dmg", "rebuild")
+	svc.add("darwin/Keybase-1.0.16-20160404013917+abcdef0.dmg", "dmg")
+	client := newTestClient(svc)
+
+	duplicates, err := client.FindDuplicateVersions(testBucket, "darwin/", ".dmg")
+	require.NoError(t, err)
+	require.Len(t, duplicates, 1)
+	releases := duplicates["1.0.15+abcdef0"]
+	require.Len(t, releases, 2)
+	keys := []string{releases[0].Key, releases[1].Key}
+	sort.Strings(keys)
+	assert.Equal(t, []string{"darwin/Keybase-1.0.15-20160401013917+abcdef0.dmg", "darwin/Keybase-1.0.15-20160402093000+abcdef0.dmg"}, keys)
+}
+
+func TestACL(t *testing.T) {
+	svc := newFakeS3()
+	svc.add("darwin/Keybase-1.0.15-20160401013917+abcdef0.dmg", "dmg")
+	svc.add("darwin-support/update-darwin-prod-1.0.15-20160401013917+abcdef0.json", `{"version": "1.0.15-20160401013917+abcdef0"}`)
+	client := newTestClient(svc)
+	platform, err := supportPlatform(PlatformTypeDarwin)
+	require.NoError(t, err)
+
+	// Default
+	require.NoError(t, client.putObject(testBucket, "index.html", []byte("html"), "text/html"))
+	assert.Equal(t, "public-read", aws.StringValue(svc.puts[0].ACL))
+
+	client.acl = s3.ObjectCannedACLBucketOwnerFullControl
+	require.NoError(t, client.putObject(testBucket, "index.html", []byte("html"), "text/html"))
+	assert.Equal(t, "bucket-owner-full-control", aws.StringValue(svc.puts[1].ACL))
+	_, err = client.PromoteRelease(testBucket, 0, 0, "v2", platform, EnvProd, PromoteOptions{})
+	require.NoError(t, err)
+	require.Len(t, svc.copies, 1)
+	assert.Equal(t, "bucket-owner-full-control", aws.StringValue(svc.copies[0].ACL))
+
+	client, err = NewClientWithOptions(ClientOptions{ACL: s3.ObjectCannedACLPrivate})
+	require.NoError(t, err)
+	assert.Equal(t, "private", client.cannedACL())
+	_, err = NewClientWithOptions(ClientOptions{ACL: "public"})
+	require.Error(t, err)
+}
+
+func TestNewClientWithOptions(t *testing.T) {
+	check := func(Release) error { return nil }
+	client, err := NewClientWithOptions(ClientOptions{
+		DestPrefix:     "preprod/",
+		TwoPhase:       true,
+		VerifyComplete: true,
+		VerifyCopy:     true,
+		PromotionCheck: check,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "preprod/", client.destPrefix)
+	assert.True(t, client.twoPhase)
+	assert.True(t, client.verify)
+	assert.True(t, client.verifyCopy)
+	assert.NotNil(t, client.check)
+	assert.Equal(t, "public-read", client.cannedACL())
+
+	// Options are per Client
+	client, err = NewClient()
+	require.NoError(t, err)
+	assert.Equal(t, "", client.destPrefix)
+	assert.False(t, client.twoPhase)
+	assert.Nil(t, client.check)
+}
+
+func TestPresignAsset(t *testing.T) {
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Credentials: credentials.NewStaticCredentials("AKID", "SECRET", ""),
+	})
+	require.NoError(t, err)
+	client := &Client{svc: s3.New(sess)}
+
+	presigned, err := client.PresignAsset(testBucket, "darwin/Keybase-1.0.15-20160401013917+abcdef0.dmg", time.Hour)
+	require.NoError(t, err)
+	u, err := url.Parse(presigned)
+	require.NoError(t, err)
+	assert.Equal(t, "s3.amazonaws.com", u.Host)
+	assert.True(t, strings.HasPrefix(u.Path, "/"+testBucket+"/"), u.Path)
+	assert.Contains(t, u.Path, "darwin/Keybase-1.0.15-20160401013917")
+	assert.Equal(t, "3600", u.Query().Get("X-Amz-Expires"))
+	assert.NotEmpty(t, u.Query().Get("X-Amz-Signature"))
+
+	_, err = client.PresignAsset(testBucket, "key", 0)
+	require.Error(t, err)
+	_, err = client.PresignAsset(testBucket, "key", 8*24*time.Hour)
+	require.Error(t, err)
+}
+
+func TestDiffUpdateJSON(t *testing.T) {
+	svc := newFakeS3()
+	svc.add("windows-support/update-windows-prod-1.0.14.json", `{"version": "1.0.14", "name": "v1.0.14", "props": [{"name": "DokanProductCodeX64", "value": "{A}"}],
+		"asset": {"name": "Keybase_1.0.14.msi", "url": "https://test.keybase.io/windows/Keybase_1.0.14.msi", "digest": "aaa", "signature": "sig"}}`)
+	svc.add("windows-support/update-windows-prod-1.0.15.json", `{"version": "1.0.15", "name": "v1.0.15", "props": [{"name": "DokanProductCodeX86", "value": "{B}"}],
+		"asset": {"name": "Keybase_1.0.15.msi", "url": "https://test.keybase.io/windows/Keybase_1.0.15.msi", "digest": "bbb", "signature": "sig"}}`)
+	client := newTestClient(svc)
+
+	diff, err := client.DiffUpdateJSON(testBucket, PlatformTypeWindows, EnvProd, "1.0.14", "1.0.15")
+	require.NoError(t, err)
+	assert.Equal(t, `asset.digest:
+  - aaa
+  + bbb
+asset.name:
+  - Keybase_1.0.14.msi
+  + Keybase_1.0.15.msi
+asset.url:
+  - https://test.keybase.io/windows/Keybase_1.0.14.msi
+  + https://test.keybase.io/windows/Keybase_1.0.15.msi
+name:
+  - v1.0.14
+  + v1.0.15
+props.DokanProductCodeX64:
+  - {A}
+props.DokanProductCodeX86:
+  + {B}
+version:
+  - 1.0.14
+  + 1.0.15
+`, diff)
+
+	diff, err = client.DiffUpdateJSON(testBucket, PlatformTypeWindows, EnvProd, "1.0.15", "1.0.15")
+	require.NoError(t, err)
+	assert.Empty(t, diff)
+
+	_, err = client.DiffUpdateJSON(testBucket, PlatformTypeWindows, EnvProd, "1.0.14", "1.0.16")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "No windows update JSON for version 1.0.16")
+}
+
+func TestReverifyDigests(t *testing.T) {
+	svc := newFakeS3()
+	zipDigest := fmt.Sprintf("%x", sha256.Sum256([]byte("zip")))
+	updateJSON := func(ver string, digest string) string {
+		return fmt.Sprintf(`{"version": %q, "asset": {"name": "Keybase-%s.zip", "url": "https://%s/darwin-updates/Keybase-%s.zip", "digest": %q}}`,
+			ver, ver, testBucket, ver, digest)
+	}
+	svc.add("darwin-updates/Keybase-1.0.15.zip", "zip")
+	svc.add("darwin-updates/Keybase-1.0.16.zip", "tampered")
+	svc.add("update-darwin-prod-v2.json", updateJSON("1.0.15", zipDigest))
+	svc.add("update-darwin-prod-test-v2.json", updateJSON("1.0.15", zipDigest))
+	client := newTestClient(svc)
+
+	mismatches, err := client.ReverifyDigests(testBucket, PlatformTypeDarwin, 2)
+	require.NoError(t, err)
+	assert.Empty(t, mismatches)
+
+	svc.add("update-darwin-prod-test-v2.json", updateJSON("1.0.16", zipDigest))
+	mismatches, err = client.ReverifyDigests(testBucket, PlatformTypeDarwin, 2)
+	require.NoError(t, err)
+	require.Len(t, mismatches, 1)
+	assert.Contains(t, mismatches[0], "update-darwin-prod-test-v2.json: Digest of darwin-updates/Keybase-1.0.16.zip")
+
+	_, err = client.ReverifyDigests(testBucket, "solaris", 2)
+	require.Error(t, err)
+}
+
+func TestUpdateJSONNameTemplate(t *testing.T) {
+	t.Cleanup(func() { require.NoError(t, SetUpdateJSONNameTemplate(DefaultUpdateJSONNameTemplate)) })
+
+	assert.Equal(t, "update-darwin-prod-v2.json", updateJSONName("v2", PlatformTypeDarwin, EnvProd))
+	assert.Equal(t, "update-linux-prod.json", updateJSONName("", PlatformTypeLinux, EnvProd))
+
+	require.NoError(t, SetUpdateJSONNameTemplate(`{{or .Channel "stable"}}/{{.Platform}}.json`))
+	assert.Equal(t, "v2/darwin.json", updateJSONName("v2", PlatformTypeDarwin, EnvProd))
+	assert.Equal(t, "stable/linux.json", updateJSONName("", PlatformTypeLinux, EnvProd))
+
+	svc := newFakeS3()
+	svc.add("darwin/Keybase-1.0.15-20160401013917+abcdef0.dmg", "dmg")
+	svc.add("darwin-support/update-darwin-prod-1.0.15-20160401013917+abcdef0.json", `{"version": "1.0.15-20160401013917+abcdef0"}`)
+	client := newTestClient(svc)
+	platform, err := supportPlatform(PlatformTypeDarwin)
+	require.NoError(t, err)
+	_, err = client.PromoteRelease(testBucket, 0, 0, "v2", platform, EnvProd, PromoteOptions{})
+	require.NoError(t, err)
+	require.Len(t, svc.copies, 1)
+	assert.Equal(t, "v2/darwin.json", *svc.copies[0].Key)
+
+	// Invalid templates leave the current one
+	for _, text := range []string{`{{.Channel}}/{{.Platform}}.json`, ``, `{{.Missing}}`, `{{.Platform`, `../{{.Platform}}.json`} {
+		require.Error(t, SetUpdateJSONNameTemplate(text), text)
+	}
+	assert.Equal(t, "v2/darwin.json", updateJSONName("v2", PlatformTypeDarwin, EnvProd))
+}
+
+func TestPromotionHistory(t *testing.T) {
+	t.Cleanup(func() { require.NoError(t, SetUpdateJSONNameTemplate(DefaultUpdateJSONNameTemplate)) })
+
+	for _, text := range []string{DefaultUpdateJSONNameTemplate, `{{or .Channel "stable"}}/{{.Platform}}.json`} {
+		require.NoError(t, SetUpdateJSONNameTemplate(text))
+		svc := newFakeS3()
+		svc.add("darwin-support/update-darwin-prod-1.0.14-20160312013917+cd6f696.json", `{"version": "1.0.14-20160312013917+cd6f696"}`)
+		svc.add("darwin-support/update-darwin-prod-1.0.15-20160401013917+abcdef0.json", `{"version": "1.0.15-20160401013917+abcdef0"}`)
+		svc.add("darwin-support/update-darwin-prod-1.0.16-20160402013917+1234567.json", `{"version": "1.0.16-20160402013917+1234567"}`)
+		svc.add("darwin-support/update-darwin-staging-1.0.13-20160301013917+fedcba9.json", `{"version": "1.0.13-20160301013917+fedcba9"}`)
+		svc.add("darwin-support/notes.json", `{}`)
+		svc.add(updateJSONName("v2", PlatformTypeDarwin, EnvProd), `{"version": "1.0.15-20160401013917+abcdef0"}`)
+		client := newTestClient(svc)
+
+		events, err := client.PromotionHistory(testBucket, PlatformTypeDarwin, "v2")
+		require.NoError(t, err, text)
+		require.Len(t, events, 2, text)
+		assert.Equal(t, "1.0.14-20160312013917+cd6f696", events[0].Version, text)
+		assert.False(t, events[0].Current, text)
+		assert.Equal(t, "1.0.15-20160401013917+abcdef0", events[1].Version, text)
+		assert.True(t, events[1].Current, text)
+	}
+}
+
+func TestLatestVersion(t *testing.T) {
+	svc := newFakeS3()
+	svc.add("update-darwin-prod-v2.json", `{"version": "1.0.15-20160401013917+abcdef0"}`)
+	svc.add("update-darwin-prod-test-v2.json", `{"version": "1.0.16-20160501013917+abcdef0"}`)
+	svc.add("update-linux-prod.json", `{"version": "1.0.14"}`)
+	client := newTestClient(svc)
+
+	ver, err := client.LatestVersion(testBucket, PlatformTypeDarwin, "", EnvProd)
+	require.NoError(t, err)
+	assert.Equal(t, "1.0.15-20160401013917+abcdef0", ver)
+	ver, err = client.LatestVersion(testBucket, PlatformTypeDarwin, "test-v2", EnvProd)
+	require.NoError(t, err)
+	assert.Equal(t, "1.0.16-20160501013917+abcdef0", ver)
+	ver, err = client.LatestVersion(testBucket, PlatformTypeLinux, "", EnvProd)
+	require.NoError(t, err)
+	assert.Equal(t, "1.0.14", ver)
+
+	_, err = client.LatestVersion(testBucket, PlatformTypeWindows, "", EnvProd)
+	require.Error(t, err)
+	_, err = client.LatestVersion(testBucket, "solaris", "", EnvProd)
+	require.Error(t, err)
+}
+
+func TestTimezone(t *testing.T) {
+	t.Cleanup(func() { timezone = nil })
+	name := "Keybase-1.0.15-20160401013917+abcdef0.dmg"
+
+	release := newRelease(name, "darwin/"+name, "darwin/", "", 0, time.Time{})
+	assert.Equal(t, "America/New_York", release.Date.Location().String())
+	assert.Equal(t, 21, release.Date.Hour())
+
+	require.NoError(t, SetTimezone("UTC"))
+	release = newRelease(name, "darwin/"+name, "darwin/", "", 0, time.Time{})
+	assert.Equal(t, time.UTC, release.Date.Location())
+	assert.Equal(t, time.Date(2016, 4, 1, 1, 39, 17, 0, time.UTC), release.Date)
+	assert.Equal(t, "Fri Apr  1 01:39:17 UTC 2016", release.DateString)
+
+	require.Error(t, SetTimezone("Mars/Olympus_Mons"))
+	assert.Equal(t, time.UTC, timezone)
+}
+
+func TestVerifyReleaseComplete(t *testing.T) {
+	svc := newFakeS3()
+	ver := "1.0.15-20160401013917+abcdef0"
+	svc.add("darwin/Keybase-"+ver+".dmg", "dmg")
+	svc.add("darwin-support/update-darwin-prod-"+ver+".json", testUpdateJSON(ver))
+	svc.add("update-darwin-prod-v2.json", `{"version": "1.0.14-20160312013917+cd6f696"}`)
+	client := newTestClient(svc)
+
+	missing, err := client.VerifyReleaseComplete(testBucket, PlatformTypeDarwin, ver)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"darwin-updates/Keybase-" + ver + ".zip"}, missing)
+
+	// An incomplete release isn't promoted when verifying
+	client.verify = true
+	platform, err := supportPlatform(PlatformTypeDarwin)
+	require.NoError(t, err)
+	release, err := client.PromoteRelease(testBucket, 0, 0, "v2", platform, EnvProd, PromoteOptions{})
+	require.Error(t, err)
+	assert.Nil(t, release)
+	assert.Contains(t, err.Error(), "darwin-updates/Keybase-"+ver+".zip")
+	assert.Empty(t, svc.copies)
+
+	svc.add("darwin-updates/Keybase-"+ver+".zip", "zip")
+	missing, err = client.VerifyReleaseComplete(testBucket, PlatformTypeDarwin, ver)
+	require.NoError(t, err)
+	assert.Empty(t, missing)
+	release, err = client.PromoteRelease(testBucket, 0, 0, "v2", platform, EnvProd, PromoteOptions{})
+	require.NoError(t, err)
+	require.NotNil(t, release)
+	assert.Equal(t, testUpdateJSON(ver), string(svc.objects["update-darwin-prod-v2.json"].body))
+
+	_, err = client.VerifyReleaseComplete(testBucket, "linux", ver)
+	require.Error(t, err)
+}
+
+func TestListBroken(t *testing.T) {
+	svc := newFakeS3()
+	svc.add("broken/darwin/Keybase-1.0.14-20160312013917+cd6f696.dmg", "dmg")
+	svc.add("broken/darwin-updates/Keybase-1.0.15-20160401013917+abcdef0.zip", "zip")
+	svc.add("darwin/Keybase-1.0.16-20160501013917+0123456.dmg", "dmg")
+	client := newTestClient(svc)
+
+	releases, err := client.ListBroken(testBucket)
+	require.NoError(t, err)
+	require.Len(t, releases, 2)
+	assert.Equal(t, "1.0.15-20160401013917+abcdef0", releases[0].Version)
+	assert.Equal(t, "broken/darwin-updates/", releases[0].Prefix)
+	assert.Equal(t, "Keybase-1.0.15-20160401013917+abcdef0.zip", releases[0].Name)
+	assert.Equal(t, "1.0.14-20160312013917+cd6f696", releases[1].Version)
+	assert.Equal(t, "darwin/Keybase-1.0.14-20160312013917+cd6f696.dmg", strings.TrimPrefix(releases[1].Key, BrokenPrefix))
+	assert.False(t, releases[1].LastModified.IsZero())
+}
+
+// corruptingS3 corrupts the first corruptions copies
+type corruptingS3 struct {
+	*fakeS3
+	corruptions int
+}
+
+func (c *corruptingS3) CopyObjectWithContext(ctx aws.Context, input *s3.CopyObjectInput, opts ...request.Option) (*s3.CopyObjectOutput, error) {
+	out, err := c.fakeS3.CopyObjectWithContext(ctx, input, opts...)
+	if err == nil && c.corruptions > 0 {
+		c.corruptions--
+		c.add(aws.StringValue(input.Key), `{"version": "0.0.1"}`)
+	}
+	return out, err
+}
+
+func TestPromoteReleaseVerifyCopy(t *testing.T) {
+	ver := "1.0.15-20160401013917+abcdef0"
+	platform, err := supportPlatform(PlatformTypeDarwin)
+	require.NoError(t, err)
+	for _, corruptions := range []int{0, 1, 2} {
+		svc := &corruptingS3{fakeS3: newFakeS3(), corruptions: corruptions}
+		svc.add("darwin/Keybase-"+ver+".dmg", "dmg")
+		svc.add("darwin-support/update-darwin-prod-"+ver+".json", testUpdateJSON(ver))
+		client := &Client{svc: svc, verifyCopy: true}
+
+		release, err := client.PromoteRelease(testBucket, 0, 0, "v2", platform, EnvProd, PromoteOptions{})
+		if corruptions > 1 {
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "doesn't match")
+			assert.Len(t, svc.copies, 2)
+			continue
+		}
+		require.NoError(t, err, corruptions)
+		require.NotNil(t, release)
+		assert.Equal(t, testUpdateJSON(ver), string(svc.objects["update-darwin-prod-v2.json"].body))
+		assert.Len(t, svc.copies, corruptions+1)
+	}
+}
+
+func TestCopyAcrossEnv(t *testing.T) {
+	ver := "1.0.15-20160401013917+abcdef0"
+	svc := newFakeS3()
+	svc.add("update-darwin-staging-v2.json", testUpdateJSON(ver))
+	client := newTestClient(svc)
+
+	// The asset doesn't exist yet
+	err := client.CopyAcrossEnv(testBucket, "v2", PlatformTypeDarwin, EnvStaging, EnvProd)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "doesn't exist")
+	assert.Empty(t, svc.copies)
+
+	svc.add("darwin-updates/Keybase-"+ver+".zip", "zip")
+	require.NoError(t, client.CopyAcrossEnv(testBucket, "v2", PlatformTypeDarwin, EnvStaging, EnvProd))
+	require.Len(t, svc.copies, 1)
+	assert.Equal(t, copySource(testBucket, "update-darwin-staging-v2.json"), aws.StringValue(svc.copies[0].CopySource))
+	assert.Equal(t, "update-darwin-prod-v2.json", aws.StringValue(svc.copies[0].Key))
+	assert.Equal(t, testUpdateJSON(ver), string(svc.objects["update-darwin-prod-v2.json"].body))
+
+	require.Error(t, client.CopyAcrossEnv(testBucket, "v2", PlatformTypeDarwin, EnvProd, EnvProd))
+	require.Error(t, client.CopyAcrossEnv(testBucket, "v2", PlatformTypeDarwin, "qa", EnvProd))
+}
+
+func TestListChannels(t *testing.T) {
+	svc := newFakeS3()
+	for _, key := range []string{
+		"update-darwin-prod.json",
+		"update-darwin-prod-v2.json",
+		"update-darwin-prod-test-v2.json",
+		"update-darwin-prod-beta.json",
+		"update-darwin-prod-v2.json.bak",
+		"update-darwin-staging-v2.json",
+		"update-darwin-arm64-prod-v2.json",
+	} {
+		svc.add(key, `{"version": "1.0.15-20160401013917+abcdef0"}`)
+	}
+	client := newTestClient(svc)
+
+	channels, err := client.ListChannels(testBucket, PlatformTypeDarwin, EnvProd)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"", "beta", "test-v2", "v2"}, channels)
+
+	// The report includes the discovered channels, after the known ones
+	entries, err := client.ReportEntries(testBucket, EnvProd)
+	require.NoError(t, err)
+	darwinChannels := []string{}
+	for _, entry := range entries {
+		if entry.Platform == PlatformTypeDarwin {
+			darwinChannels = append(darwinChannels, entry.Channel)
+		}
+	}
+	assert.Equal(t, []string{"test-v2", "v2", "", "beta"}, darwinChannels)
+}
+
+func TestListChannelsNameTemplate(t *testing.T) {
+	t.Cleanup(func() { require.NoError(t, SetUpdateJSONNameTemplate(DefaultUpdateJSONNameTemplate)) })
+	require.NoError(t, SetUpdateJSONNameTemplate(`{{or .Channel "stable"}}/{{.Platform}}.json`))
+
+	svc := newFakeS3()
+	for _, key := range []string{"stable/darwin.json", "v2/darwin.json", "v2/darwin-arm64.json", "v2/darwin.json.bak", "darwin-support/update-darwin-prod-1.0.15.json"} {
+		svc.add(key, `{"version": "1.0.15-20160401013917+abcdef0"}`)
+	}
+	client := newTestClient(svc)
+
+	channels, err := client.ListChannels(testBucket, PlatformTypeDarwin, EnvProd)
+	require.NoError(t, err)
+	// stable/darwin.json is the default channel
+	assert.Equal(t, []string{"", "v2"}, channels)
+}
+
+func TestReportJSON(t *testing.T) {
+	svc := newFakeS3()
+	svc.add("update-darwin-prod-v2.json", `{"version": "1.0.15-20160401013917+abcdef0", "publishedAt": 1459474757000}`)
+	client := newTestClient(svc)
+
+	var out bytes.Buffer
+	require.NoError(t, client.ReportJSON(testBucket, EnvProd, &out))
+	var entries []map[string]interface{}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &entries))
+	var darwin map[string]interface{}
+	for _, entry := range entries {
+		if entry["platform"] == PlatformTypeDarwin && entry["channel"] == "v2" {
+			darwin = entry
+		}
+	}
+	require.NotNil(t, darwin)
+	assert.Equal(t, "1.0.15-20160401013917+abcdef0", darwin["version"])
+	published, err := time.Parse(time.RFC3339, darwin["published"].(string))
+	require.NoError(t, err)
+	assert.True(t, published.Equal(time.Unix(1459474757, 0)), published.String())
+}
+
+func TestWriteHTMLStrict(t *testing.T) {
+	svc := newFakeS3()
+	svc.add("darwin/Keybase-1.0.15-20160401013917+abcdef0.dmg", "dmg")
+	svc.add("darwin/Keybase-latest.dmg", "dmg")
+	svc.add("darwin/index.html", "html")
+	svc.add("darwin-support/update-darwin-prod-1.0.15-20160401013917+abcdef0.json", "{}")
+	svc.add("darwin-support/notes.txt", "txt")
+	client := newTestClient(svc)
+
+	// Lenient by default
+	var out bytes.Buffer
+	err := client.WriteHTML(testBucket, "darwin/,darwin-support/", "", "", "", WriteHTMLOptions{Writer: &out})
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), "Keybase-latest.dmg")
+
+	out.Reset()
+	err = client.WriteHTML(testBucket, "darwin/,darwin-support/", "", "", "", WriteHTMLOptions{Writer: &out, Strict: true})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "darwin/Keybase-latest.dmg, darwin-support/notes.txt")
+	assert.Empty(t, out.String())
+
+	err = client.WriteHTML(testBucket, "darwin/", ".dmg", "", "", WriteHTMLOptions{Writer: &out, Strict: true})
+	require.Error(t, err)
+	delete(svc.objects, "darwin/Keybase-latest.dmg")
+	err = client.WriteHTML(testBucket, "darwin/", ".dmg", "", "", WriteHTMLOptions{Writer: &out, Strict: true})
+	require.NoError(t, err)
+}
+
+func TestPromoteReleaseCheck(t *testing.T) {
+	svc := newFakeS3()
+	ver := "1.0.15-20160401013917+abcdef0"
+	svc.add("darwin/Keybase-"+ver+".dmg", "dmg")
+	svc.add("darwin-support/update-darwin-prod-"+ver+".json", testUpdateJSON(ver))
+	platform, err := supportPlatform(PlatformTypeDarwin)
+	require.NoError(t, err)
+	passed := map[string]bool{}
+	client := newTestClient(svc)
+	client.check = func(release Release) error {
+		if !passed[release.Commit] {
+			return fmt.Errorf("CI hasn't passed for %s", release.Commit)
+		}
+		return nil
+	}
+
+	// Fails if the check fails
+	release, err := client.PromoteRelease(testBucket, 0, 0, "v2", platform, EnvProd, PromoteOptions{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Not promoting "+ver+": CI hasn't passed for abcdef0")
+	assert.Nil(t, release)
+	assert.Empty(t, svc.copies)
+	_, err = client.promoteAReleaseToProd(ver, testBucket, platform, EnvProd, "v2", false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "CI hasn't passed for abcdef0")
+	assert.Empty(t, svc.copies)
+
+	passed["abcdef0"] = true
+	release, err = client.PromoteRelease(testBucket, 0, 0, "v2", platform, EnvProd, PromoteOptions{})
+	require.NoError(t, err)
+	require.NotNil(t, release)
+	assert.Len(t, svc.copies, 1)
+}
+
+func TestVersionedUpdateJSONKey(t *testing.T) {
+	ver := "1.0.15-20160401013917+abcdef0"
+	for _, test := range []struct {
+		platform Platform
+		key      string
+	}{
+		{platformDarwin, "darwin-support/update-darwin-prod-" + ver + ".json"},
+		{platformDarwinArm64, "darwin-arm64-support/update-darwin-arm64-prod-" + ver + ".json"},
+		{platformLinuxDeb, "linux_binaries/deb-support/update-deb-prod-" + ver + ".json"},
+		{platformLinuxRPM, "linux_binaries/rpm-support/update-rpm-prod-" + ver + ".json"},
+		{platformWindows, "windows-support/update-windows-prod-" + ver + ".json"},
+	} {
+		assert.Equal(t, test.key, versionedUpdateJSONKey(test.platform, EnvProd, ver))
+	}
+}
+
+func TestPromoteAReleaseToProdLinuxAndWindows(t *testing.T) {
+	ver := "1.0.15-20160401013917+abcdef0"
+	svc := newFakeS3()
+	svc.add("linux_binaries/deb/keybase_1.0.14-20160312013917.cd6f696_amd64.deb", "old deb")
+	svc.add("linux_binaries/deb/keybase_1.0.15-20160401013917.abcdef0_amd64.deb", "deb")
+	svc.add("linux_binaries/deb-support/update-deb-prod-"+ver+".json", `{"version": "`+ver+`"}`)
+	svc.add("linux_binaries/rpm/keybase-1.0.15-20160401013917.abcdef0-1.x86_64.rpm", "rpm")
+	svc.add("linux_binaries/rpm-support/update-rpm-prod-"+ver+".json", `{"version": "`+ver+`"}`)
+	svc.add("windows/Keybase_"+ver+".amd64.msi", "msi")
+	svc.add("windows-support/update-windows-prod-"+ver+".json", `{"version": "`+ver+`"}`)
+	client := newTestClient(svc)
+
+	// Linux update JSON has no channel
+	for _, platform := range []Platform{platformLinuxDeb, platformLinuxRPM} {
+		release, err := client.promoteAReleaseToProd(ver, testBucket, platform, EnvProd, "", false)
+		require.NoError(t, err)
+		require.NotNil(t, release)
+		assert.Equal(t, ver, release.Version)
+		assert.Contains(t, string(svc.objects["update-"+platform.Name+"-prod.json"].body), ver)
+	}
+
+	release, err := client.promoteAReleaseToProd(ver, testBucket, platformWindows, EnvProd, "v2", false)
+	require.NoError(t, err)
+	require.NotNil(t, release)
+	assert.Contains(t, string(svc.objects["update-windows-prod-v2.json"].body), ver)
+
+	_, err = client.promoteAReleaseToProd("1.0.16-20160501013917+0123456", testBucket, platformLinuxDeb, EnvProd, "", false)
+	require.Error(t, err)
+
+	// linux is both deb and rpm, so isn't a single platform to promote
+	_, err = PromoteARelease(ver, testBucket, PlatformTypeLinux, EnvProd, false)
+	require.Error(t, err)
+}
+
+func TestPromoteAReleaseDebAndRPM(t *testing.T) {
+	ver := "1.0.15-20160401013917+abcdef0"
+	svc := newFakeS3()
+	svc.add("linux_binaries/deb/keybase_1.0.15-20160401013917.abcdef0_amd64.deb", "deb")
+	svc.add("linux_binaries/deb-support/update-deb-prod-"+ver+".json", `{"version": "`+ver+`"}`)
+	svc.add("linux_binaries/rpm/keybase-1.0.15-20160401013917.abcdef0-1.x86_64.rpm", "rpm")
+	svc.add("linux_binaries/rpm-support/update-rpm-prod-"+ver+".json", `{"version": "`+ver+`"}`)
+	client := newTestClient(svc)
+
+	for _, platform := range []string{"deb", "rpm"} {
+		release, err := client.PromoteARelease(ver, testBucket, platform, EnvProd, false)
+		require.NoError(t, err)
+		require.NotNil(t, release)
+		assert.Contains(t, string(svc.objects["update-"+platform+"-prod.json"].body), ver)
+	}
+
+	// The newest deb is copied for copy-latest
+	require.NoError(t, client.CopyLatest(testBucket, "deb", false))
+	assert.Equal(t, "deb", string(svc.objects["keybase_amd64.deb"].body))
+}
+
+func TestCurrentUpdateCache(t *testing.T) {
+	svc := newFakeS3()
+	svc.add("update-darwin-prod-v2.json", testUpdateJSON("1.0.14-20160312013917+cd6f696"))
+	client := newTestClient(svc)
+	client.updates = newUpdateCache()
+
+	first, _, err := client.CurrentUpdate(testBucket, "v2", PlatformTypeDarwin, EnvProd)
+	require.NoError(t, err)
+	// Unchanged, so the cached update is returned (not decoded again)
+	second, _, err := client.CurrentUpdate(testBucket, "v2", PlatformTypeDarwin, EnvProd)
+	require.NoError(t, err)
+	assert.Same(t, first, second)
+
+	svc.add("update-darwin-prod-v2.json", testUpdateJSON("1.0.15-20160401013917+abcdef0"))
+	third, _, err := client.CurrentUpdate(testBucket, "v2", PlatformTypeDarwin, EnvProd)
+	require.NoError(t, err)
+	assert.NotSame(t, first, third)
+	assert.Equal(t, "1.0.15-20160401013917+abcdef0", third.Version)
+
+	client.ClearUpdateCache()
+	fourth, _, err := client.CurrentUpdate(testBucket, "v2", PlatformTypeDarwin, EnvProd)
+	require.NoError(t, err)
+	assert.NotSame(t, third, fourth)
+	assert.True(t, third.Equal(fourth))
+}
+
+func TestRenameRelease(t *testing.T) {
+	oldVer, newVer := "1.0.15-20160401013917+abcdef0", "1.0.16-20160401013917+abcdef0"
+	svc := newFakeS3()
+	svc.add("darwin/Keybase-"+oldVer+".dmg", "dmg")
+	svc.add("darwin-updates/Keybase-"+oldVer+".zip", "zip")
+	svc.add("darwin-support/update-darwin-prod-"+oldVer+".json", testUpdateJSON(oldVer))
+	client := newTestClient(svc)
+
+	require.NoError(t, client.RenameRelease(testBucket, PlatformTypeDarwin, oldVer, newVer))
+	pairs := [][2]string{}
+	for _, copied := range svc.copies {
+		pairs = append(pairs, [2]string{svc.copySourceKey(aws.StringValue(copied.CopySource)), aws.StringValue(copied.Key)})
+	}
+	assert.Equal(t, [][2]string{
+		{"darwin/Keybase-" + oldVer + ".dmg", "darwin/Keybase-" + newVer + ".dmg"},
+		{"darwin-updates/Keybase-" + oldVer + ".zip", "darwin-updates/Keybase-" + newVer + ".zip"},
+	}, pairs)
+	// The update JSON is rewritten for the new version and asset
+	upd, err := DecodeJSON(bytes.NewReader(svc.objects["darwin-support/update-darwin-prod-"+newVer+".json"].body))
+	require.NoError(t, err)
+	assert.Equal(t, newVer, upd.Version)
+	require.NotNil(t, upd.Asset)
+	assert.Equal(t, "Keybase-"+newVer+".zip", upd.Asset.Name)
+	assetKey, err := keyForURL(testBucket, upd.Asset.URL)
+	require.NoError(t, err)
+	assert.Equal(t, "darwin-updates/Keybase-"+newVer+".zip", assetKey)
+	assert.Equal(t, []string{
+		"darwin/Keybase-" + oldVer + ".dmg",
+		"darwin-updates/Keybase-" + oldVer + ".zip",
+		"darwin-support/update-darwin-prod-" + oldVer + ".json",
+	}, svc.deletes)
+	assert.Equal(t, "dmg", string(svc.objects["darwin/Keybase-"+newVer+".dmg"].body))
+
+	// Refuses to overwrite
+	svc.copies, svc.deletes = nil, nil
+	svc.add("darwin/Keybase-"+oldVer+".dmg", "dmg")
+	err = client.RenameRelease(testBucket, PlatformTypeDarwin, oldVer, newVer)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "already exists")
+	assert.Empty(t, svc.copies)
+	assert.Empty(t, svc.deletes)
+}
+
+func TestRenameReleaseUnrewritableJSON(t *testing.T) {
+	oldVer, newVer := "1.0.15-20160401013917+abcdef0", "1.0.16-20160401013917+abcdef0"
+	svc := newFakeS3()
+	svc.add("darwin/Keybase-"+oldVer+".dmg", "dmg")
+	svc.add("darwin-updates/Keybase-"+oldVer+".zip", "zip")
+	client := newTestClient(svc)
+
+	// The update JSON is for another version, or its asset isn't moved
+	for _, updateJSON := range []string{testUpdateJSON("1.0.14-20160312013917+cd6f696"), fmt.Sprintf(`{"version": %q, "asset": {"name": "Keybase.zip", "url": "https://%s/other/Keybase.zip"}}`, oldVer, testBucket)} {
+		svc.add("darwin-support/update-darwin-prod-"+oldVer+".json", updateJSON)
+		client.ClearUpdateCache()
+		err := client.RenameRelease(testBucket, PlatformTypeDarwin, oldVer, newVer)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "Can't rewrite")
+		assert.Empty(t, svc.copies)
+		assert.Empty(t, svc.puts)
+		assert.Empty(t, svc.deletes)
+	}
+}
+
+// deleteFailS3 fails to delete some keys
+type deleteFailS3 struct {
+	*fakeS3
+	fail map[string]bool
+}
+
+func (d deleteFailS3) DeleteObject(input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	if d.fail[aws.StringValue(input.Key)] {
+		return nil, awserr.New("AccessDenied", "Access Denied", nil)
+	}
+	return d.fakeS3.DeleteObject(input)
+}
+
+func TestDeleteRelease(t *testing.T) {
+	ver := "1.0.15-20160401013917+abcdef0"
+	svc := newFakeS3()
+	svc.add("darwin/Keybase-"+ver+".dmg", "dmg")
+	svc.add("darwin-updates/Keybase-"+ver+".zip", "zip")
+	svc.add("darwin/Keybase-1.0.14-20160312013917+cd6f696.dmg", "dmg")
+	client := newTestClient(svc)
+
+	var logs bytes.Buffer
+	client.logger = log.New(&logs, "", 0)
+
+	// The update JSON is missing, and darwin is only Intel
+	require.NoError(t, client.DeleteRelease(ver, testBucket, PlatformTypeDarwin, false))
+	assert.NotContains(t, logs.String(), "darwin-arm64")
+	assert.Equal(t, []string{"darwin/Keybase-" + ver + ".dmg", "darwin-updates/Keybase-" + ver + ".zip"}, svc.deletes)
+	assert.Contains(t, svc.objects, "darwin/Keybase-1.0.14-20160312013917+cd6f696.dmg")
+
+	err := client.DeleteRelease(ver, testBucket, PlatformTypeDarwin, false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "No release")
+
+	// No linux release
+	require.Error(t, client.DeleteRelease(ver, testBucket, PlatformTypeLinux, false))
+
+	// The deb package is found in the bucket
+	svc.add("linux_binaries/deb/keybase_1.0.15-20160401013917.abcdef0_amd64.deb", "deb")
+	svc.add("linux_binaries/deb-support/update-deb-prod-"+ver+".json", `{"version": "`+ver+`"}`)
+	svc.deletes = nil
+	require.NoError(t, client.DeleteRelease(ver, testBucket, "deb", false))
+	assert.Equal(t, []string{"linux_binaries/deb/keybase_1.0.15-20160401013917.abcdef0_amd64.deb", "linux_binaries/deb-support/update-deb-prod-" + ver + ".json"}, svc.deletes)
+}
+
+func TestReleaseBrokenDarwin(t *testing.T) {
+	ver := "1.0.15-20160401013917+abcdef0"
+	svc := newFakeS3()
+	svc.add("darwin/Keybase-"+ver+".dmg", "dmg")
+	svc.add("darwin-arm64/Keybase-"+ver+".dmg", "arm64 dmg")
+	client := newTestClient(svc)
+	var logs bytes.Buffer
+	client.logger = log.New(&logs, "", 0)
+
+	// darwin is only the Intel release
+	removed, err := client.ReleaseBroken(ver, testBucket, PlatformTypeDarwin)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"darwin/Keybase-" + ver + ".dmg"}, removed)
+	assert.Contains(t, svc.objects, "darwin-arm64/Keybase-"+ver+".dmg")
+	assert.NotContains(t, logs.String(), "darwin-arm64")
+
+	// darwin-arm64 is only Apple Silicon
+	removed, err = client.ReleaseBroken(ver, testBucket, PlatformTypeDarwinArm64)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"darwin-arm64/Keybase-" + ver + ".dmg"}, removed)
+	assert.Contains(t, svc.objects, BrokenPrefix+"darwin-arm64/Keybase-"+ver+".dmg")
+
+	_, err = client.ReleaseBroken(ver, testBucket, "")
+	require.Error(t, err)
+}
+
+func TestDeleteReleaseContinues(t *testing.T) {
+	ver := "1.0.15-20160401013917+abcdef0"
+	svc := newFakeS3()
+	svc.add("darwin/Keybase-"+ver+".dmg", "dmg")
+	svc.add("darwin-updates/Keybase-"+ver+".zip", "zip")
+	svc.add("darwin-support/update-darwin-prod-"+ver+".json", testUpdateJSON(ver))
+	client := &Client{svc: deleteFailS3{fakeS3: svc, fail: map[string]bool{"darwin/Keybase-" + ver + ".dmg": true}}}
+
+	err := client.DeleteRelease(ver, testBucket, PlatformTypeDarwin, false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "darwin/Keybase-"+ver+".dmg")
+	assert.Equal(t, []string{"darwin-updates/Keybase-" + ver + ".zip", "darwin-support/update-darwin-prod-" + ver + ".json"}, svc.deletes)
+}
+
+func TestDeleteReleaseCurrent(t *testing.T) {
+	ver := "1.0.15-20160401013917+abcdef0"
+	for _, key := range []string{"update-darwin-prod-v2.json", "update-darwin-prod-test-v2.json"} {
+		svc := newFakeS3()
+		svc.add("darwin/Keybase-"+ver+".dmg", "dmg")
+		svc.add("darwin-updates/Keybase-"+ver+".zip", "zip")
+		svc.add(key, testUpdateJSON(ver))
+		client := newTestClient(svc)
+
+		err := client.DeleteRelease(ver, testBucket, PlatformTypeDarwin, false)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), key)
+		assert.Empty(t, svc.deletes)
+
+		require.NoError(t, client.DeleteRelease(ver, testBucket, PlatformTypeDarwin, true))
+		assert.Len(t, svc.deletes, 2)
+	}
+}
+
+func TestWriteHTMLSince(t *testing.T) {
+	svc := newFakeS3()
+	svc.add("darwin/Keybase-1.0.13-20160201013917+0123456.dmg", "dmg")
+	svc.add("darwin/Keybase-1.0.14-20160312013917+cd6f696.dmg", "dmg")
+	svc.add("darwin/Keybase-1.0.15-20160401013917+abcdef0.dmg", "dmg")
+	svc.add("darwin/Keybase-1.0.16-20160410013917+789abcd.dmg", "dmg")
+	client := newTestClient(svc)
+	jsonPath := filepath.Join(t.TempDir(), "index.json")
+
+	since := time.Date(2016, time.March, 25, 0, 0, 0, 0, time.UTC)
+	err := client.WriteHTML(testBucket, "darwin/", "", "", "", WriteHTMLOptions{Since: since, JSONOutPath: jsonPath, Writer: &bytes.Buffer{}})
+	require.NoError(t, err)
+	data, err := os.ReadFile(jsonPath)
+	require.NoError(t, err)
+	var index struct {
+		Sections []Section `json:"sections"`
+	}
+	require.NoError(t, json.Unmarshal(data, &index))
+	require.Len(t, index.Sections, 1)
+	versions := []string{}
+	for _, release := range index.Sections[0].Releases {
+		versions = append(versions, release.Version)
+	}
+	assert.Equal(t, []string{"1.0.16-20160410013917+789abcd", "1.0.15-20160401013917+abcdef0"}, versions)
+}
+
+func TestWriteHTMLMarkLatest(t *testing.T) {
+	svc := newFakeS3()
+	svc.add("darwin/Keybase-1.0.14-20160312013917+cd6f696.dmg", "dmg")
+	svc.add("darwin/Keybase-1.0.15-20160401013917+abcdef0.dmg", "dmg")
+	svc.add("darwin/Keybase-1.0.16-20160501013917+0123456.dmg", "dmg")
+	svc.add("darwin-arm64/Keybase-1.0.15-20160401013917+abcdef0.dmg", "dmg")
+	svc.add("darwin-arm64/Keybase-1.0.16-20160501013917+0123456.dmg", "dmg")
+	// 1.0.16 isn't promoted for darwin yet, and nothing is for darwin-arm64
+	svc.add("update-darwin-prod-v2.json", testUpdateJSON("1.0.15-20160401013917+abcdef0"))
+	client := newTestClient(svc)
+
+	var out bytes.Buffer
+	err := client.WriteHTML(testBucket, "darwin/,darwin-arm64/", "", "", "", WriteHTMLOptions{MarkLatest: true, Writer: &out})
+	require.NoError(t, err)
+	sections := strings.Split(out.String(), "<h3>")[1:]
+	require.Len(t, sections, 2)
+	for _, section := range sections {
+		assert.Equal(t, 1, strings.Count(section, "<mark>latest</mark>"), section)
+	}
+	latest := func(section string) string {
+		for _, line := range strings.Split(section, "\n") {
+			if strings.Contains(line, "<mark>latest</mark>") {
+				return line
+			}
+		}
+		return ""
+	}
+	assert.Contains(t, latest(sections[0]), "1.0.15-20160401013917+abcdef0")
+	assert.Contains(t, latest(sections[1]), "1.0.16-20160501013917+0123456")
+
+	out.Reset()
+	require.NoError(t, client.WriteHTML(testBucket, "darwin/", "", "", "", WriteHTMLOptions{Writer: &out}))
+	assert.NotContains(t, out.String(), "<mark>latest</mark>")
+}
+
+func TestWriteHTMLLimit(t *testing.T) {
+	svc := newFakeS3()
+	for day := 1; day <= 60; day++ {
+		date := time.Date(2016, time.March, 1, 1, 39, 17, 0, time.UTC).AddDate(0, 0, day)
+		svc.add(fmt.Sprintf("darwin/Keybase-1.0.%d-%s+abcdef0.dmg", day, date.Format("20060102150405")), "dmg")
+	}
+	client := newTestClient(svc)
+
+	count := func(limit int) int {
+		var out bytes.Buffer
+		require.NoError(t, client.WriteHTML(testBucket, "darwin/", "", "", "", WriteHTMLOptions{Limit: limit, Writer: &out}))
+		return strings.Count(out.String(), "<li>")
+	}
+	assert.Equal(t, 5, count(5))
+	assert.Equal(t, DefaultHTMLLimit, count(DefaultHTMLLimit))
+	assert.Equal(t, 60, count(0))
+}
+
+func TestWriteHTMLOrder(t *testing.T) {
+	svc := newFakeS3()
+	svc.add("darwin/Keybase-1.0.14-20160312013917+cd6f696.dmg", "dmg")
+	svc.add("darwin/Keybase-1.0.15-20160401013917+abcdef0.dmg", "dmg")
+	svc.add("darwin/Keybase-1.0.16-20160501013917+0123456.dmg", "dmg")
+	client := newTestClient(svc)
+	dir := t.TempDir()
+
+	versions := func(order string, groupBy string) ([]string, string) {
+		jsonPath := filepath.Join(dir, order+groupBy+".json")
+		var out bytes.Buffer
+		err := client.WriteHTML(testBucket, "darwin/", "", "", "", WriteHTMLOptions{Order: order, GroupBy: groupBy, JSONOutPath: jsonPath, Writer: &out})
+		require.NoError(t, err)
+		data, err := os.ReadFile(jsonPath)
+		require.NoError(t, err)
+		var index struct {
+			Sections []Section `json:"sections"`
+		}
+		require.NoError(t, json.Unmarshal(data, &index))
+		versions := []string{}
+		for _, section := range index.Sections {
+			for _, release := range section.Releases {
+				versions = append(versions, release.Version)
+			}
+		}
+		return versions, out.String()
+	}
+
+	desc := []string{"1.0.16-20160501013917+0123456", "1.0.15-20160401013917+abcdef0", "1.0.14-20160312013917+cd6f696"}
+	asc := []string{"1.0.14-20160312013917+cd6f696", "1.0.15-20160401013917+abcdef0", "1.0.16-20160501013917+0123456"}
+	for _, groupBy := range []string{GroupByPrefix, GroupByVersion} {
+		got, _ := versions("", groupBy)
+		assert.Equal(t, desc, got, groupBy)
+		got, _ = versions(OrderDesc, groupBy)
+		assert.Equal(t, desc, got, groupBy)
+		got, html := versions(OrderAsc, groupBy)
+		assert.Equal(t, asc, got, groupBy)
+		assert.Less(t, strings.Index(html, asc[0]), strings.Index(html, asc[2]), groupBy)
+	}
+	_, html := versions("", GroupByVersion)
+	assert.Contains(t, html, `<a href="https://github.com/keybase/client/commit/cd6f696">cd6f696</a>`)
+
+	err := client.WriteHTML(testBucket, "darwin/", "", "", "", WriteHTMLOptions{Order: "random", Writer: &bytes.Buffer{}})
+	require.Error(t, err)
+}
+
+func TestDownloadObject(t *testing.T) {
+	svc := newFakeS3()
+	svc.add("darwin/Keybase-1.0.15-20160401013917+abcdef0.dmg", "dmg")
+	client := newTestClient(svc)
+	path := filepath.Join(t.TempDir(), "mirror", "Keybase.dmg")
+
+	require.NoError(t, client.DownloadObject(testBucket, "darwin/Keybase-1.0.15-20160401013917+abcdef0.dmg", path))
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "dmg", string(data))
+
+	require.Error(t, client.DownloadObject(testBucket, "darwin/missing.dmg", path))
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestVerifyReplication(t *testing.T) {
+	const replicaBucket = "replica.keybase.io"
+	ver := "1.0.15-20160401013917+abcdef0"
+	primarySvc, replicaSvc := newFakeS3(), newFakeS3()
+	for _, svc := range []*fakeS3{primarySvc, replicaSvc} {
+		svc.add("update-darwin-prod-v2.json", testUpdateJSON(ver))
+		svc.add("darwin-updates/Keybase-"+ver+".zip", "zip")
+	}
+	primary, replica := newTestClient(primarySvc), newTestClient(replicaSvc)
+	require.NoError(t, primary.VerifyReplication(replica, testBucket, replicaBucket, PlatformTypeDarwin, "v2", EnvProd))
+
+	// The asset hasn't replicated
+	delete(replicaSvc.objects, "darwin-updates/Keybase-"+ver+".zip")
+	err := primary.VerifyReplication(replica, testBucket, replicaBucket, PlatformTypeDarwin, "v2", EnvProd)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), replicaBucket)
+
+	// The update JSON hasn't replicated
+	newer := "1.0.16-20160501013917+0123456"
+	replicaSvc.add("darwin-updates/Keybase-"+ver+".zip", "zip")
+	primarySvc.add("update-darwin-prod-v2.json", testUpdateJSON(newer))
+	primarySvc.add("darwin-updates/Keybase-"+newer+".zip", "zip")
+	err = primary.VerifyReplication(replica, testBucket, replicaBucket, PlatformTypeDarwin, "v2", EnvProd)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), ver)
+	assert.Contains(t, err.Error(), newer)
+}
+
+func TestGenerateCombinedManifest(t *testing.T) {
+	svc := newFakeS3()
+	svc.add("update-darwin-prod-v2.json", `{"version": "1.0.15-20160401013917+abcdef0", "publishedAt": 1459474757000,
+		"asset": {"name": "Keybase.zip", "url": "https://prerelease.keybase.io/darwin-updates/Keybase.zip", "digest": "abc"}}`)
+	svc.add("update-darwin-arm64-prod-v2.json", `{"version": "1.0.15-20160401013917+abcdef0",
+		"asset": {"name": "Keybase.zip", "url": "https://prerelease.keybase.io/darwin-arm64-updates/Keybase.zip", "digest": "def"}}`)
+	svc.add("update-linux-prod.json", `{"version": "1.0.14-20160312013917+cd6f696"}`)
+	client := newTestClient(svc)
+
+	data, err := client.GenerateCombinedManifest(testBucket, EnvProd, "")
+	require.NoError(t, err)
+	var manifest map[string]map[string]map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &manifest))
+	require.Contains(t, manifest, "platforms")
+	platforms := manifest["platforms"]
+	// No update JSON for windows
+	assert.Len(t, platforms, 3)
+	assert.Equal(t, map[string]interface{}{
+		"version":     "1.0.15-20160401013917+abcdef0",
+		"url":         "https://prerelease.keybase.io/darwin-updates/Keybase.zip",
+		"digest":      "abc",
+		"publishedAt": "2016-04-01T01:39:17Z",
+	}, platforms["darwin-amd64"])
+	assert.Equal(t, "def", platforms["darwin-arm64"]["digest"])
+	assert.Equal(t, map[string]interface{}{"version": "1.0.14-20160312013917+cd6f696"}, platforms["linux-amd64"])
+
+	_, err = client.GenerateCombinedManifest(testBucket, EnvStaging, "")
+	require.Error(t, err)
+}
+
+// getFailS3 fails to get some keys
+type getFailS3 struct {
+	*fakeS3
+	fail map[string]bool
+}
+
+func (g getFailS3) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	if g.fail[aws.StringValue(input.Key)] {
+		return nil, awserr.NewRequestFailure(awserr.New("SlowDown", "Please reduce your request rate", nil), http.StatusServiceUnavailable, "")
+	}
+	return g.fakeS3.GetObject(input)
+}
+
+func TestGenerateCombinedManifestGetError(t *testing.T) {
+	svc := newFakeS3()
+	svc.add("update-darwin-prod-v2.json", `{"version": "1.0.15-20160401013917+abcdef0"}`)
+	svc.add("update-darwin-arm64-prod-v2.json", `{"version": "1.0.15-20160401013917+abcdef0"}`)
+	svc.add("update-linux-prod.json", `{"version": "1.0.14-20160312013917+cd6f696"}`)
+	client := &Client{svc: getFailS3{fakeS3: svc, fail: map[string]bool{"update-darwin-arm64-prod-v2.json": true}}}
+
+	_, err := client.GenerateCombinedManifest(testBucket, EnvProd, "")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "update-darwin-arm64-prod-v2.json")
+	assert.Contains(t, err.Error(), "SlowDown")
+}
@@ -0,0 +1,78 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package update
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// signatureExt is the extension for a detached signature of a file
+const signatureExt = ".sig"
+
+// Signer creates detached signatures
+type Signer interface {
+	Sign(data []byte) ([]byte, error)
+}
+
+// CommandSigner signs by running a shell command with the data on stdin,
+// using its output as the signature, for example
+// "keybase sign --detached" or "gpg --detach-sign --armor".
+type CommandSigner struct {
+	Command string
+}
+
+// Sign signs data with the command
+func (s CommandSigner) Sign(data []byte) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("sh", "-c", s.Command)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s failed: %s (%s)", s.Command, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	if stdout.Len() == 0 {
+		return nil, fmt.Errorf("%s produced an empty signature", s.Command)
+	}
+	return stdout.Bytes(), nil
+}
+
+// KeySigner signs with an ed25519 key. The signature is base64 encoded.
+type KeySigner struct {
+	Key ed25519.PrivateKey
+}
+
+// NewKeySigner loads a KeySigner from a PEM (PKCS #8) ed25519 private key file
+func NewKeySigner(path string) (*KeySigner, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("No PEM data in %s", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("Key in %s is not an ed25519 key", path)
+	}
+	return &KeySigner{Key: edKey}, nil
+}
+
+// Sign signs data with the key
+func (s KeySigner) Sign(data []byte) ([]byte, error) {
+	sig := ed25519.Sign(s.Key, data)
+	return []byte(base64.StdEncoding.EncodeToString(sig)), nil
+}
@@ -0,0 +1,188 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package update
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// stagedKey is the (temporary) key a promotion to destKey is staged at
+func stagedKey(destKey string) (string, error) {
+	id, err := RandomID()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("staged/%s.%s", destKey, id), nil
+}
+
+// StagePromotion copies the update JSON at sourceKey to a temporary key and
+// validates it: its asset must exist and match its digest, and it must be
+// signed. The staged key is returned for CommitPromotion or AbortPromotion.
+// If validation fails, the staged copy is removed.
+func (c *Client) StagePromotion(bucketName string, sourceKey string, destKey string) (string, error) {
+	staged, err := stagedKey(destKey)
+	if err != nil {
+		return "", err
+	}
+	c.logf("Staging %s at %s", sourceKey, staged)
+	_, err = c.copyObject(&s3.CopyObjectInput{
+		Bucket:     aws.String(bucketName),
+		CopySource: aws.String(copySource(bucketName, sourceKey)),
+		Key:        aws.String(staged),
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := c.validateStaged(bucketName, staged); err != nil {
+		if abortErr := c.AbortPromotion(bucketName, staged); abortErr != nil {
+			c.logf("Error removing %s: %s", staged, abortErr)
+		}
+		return "", err
+	}
+	return staged, nil
+}
+
+// CommitPromotion copies a staged update JSON to destKey, and removes the
+// staged copy
+func (c *Client) CommitPromotion(bucketName string, staged string, destKey string) error {
+	c.logf("PutCopying %s to %s\n", staged, destKey)
+	_, err := c.copyObject(&s3.CopyObjectInput{
+		Bucket:       aws.String(bucketName),
+		CopySource:   aws.String(copySource(bucketName, staged)),
+		Key:          aws.String(destKey),
+		CacheControl: aws.String(defaultCacheControl),
+		ACL:          aws.String(c.cannedACL()),
+	})
+	if err != nil {
+		return err
+	}
+	if err := c.AbortPromotion(bucketName, staged); err != nil {
+		c.logf("Error removing %s: %s", staged, err)
+	}
+	return nil
+}
+
+// AbortPromotion removes a staged update JSON, leaving the channel untouched
+func (c *Client) AbortPromotion(bucketName string, staged string) error {
+	_, err := c.svc.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(staged),
+	})
+	return err
+}
+
+// validateStaged checks a staged update JSON's asset
+func (c *Client) validateStaged(bucketName string, staged string) error {
+	resp, err := c.svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(staged),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	upd, err := DecodeJSON(resp.Body)
+	if err != nil {
+		return fmt.Errorf("Invalid update JSON at %s: %s", staged, err)
+	}
+	if upd.Asset == nil {
+		return fmt.Errorf("No asset in update JSON at %s", staged)
+	}
+	if upd.Asset.Signature == "" {
+		return fmt.Errorf("No signature for asset in update JSON at %s", staged)
+	}
+	if upd.Asset.Digest == "" {
+		return fmt.Errorf("No digest for asset in update JSON at %s", staged)
+	}
+	assetKey, err := keyForURL(bucketName, upd.Asset.URL)
+	if err != nil {
+		return fmt.Errorf("Invalid asset in update JSON at %s: %s", staged, err)
+	}
+	assetDigest, err := c.objectDigest(bucketName, assetKey)
+	if err != nil {
+		return fmt.Errorf("Couldn't get %s for update JSON at %s: %s", assetKey, staged, err)
+	}
+	if assetDigest != upd.Asset.Digest {
+		return fmt.Errorf("Digest of %s (%s) doesn't match update JSON at %s (%s)", assetKey, assetDigest, staged, upd.Asset.Digest)
+	}
+	return nil
+}
+
+// promoteUpdateJSON copies a versioned update JSON (jsonKey) to a channel's
+// key (jsonName), in two phases if the Client is set to, and verifies the
+// copy if the Client is set to
+func (c *Client) promoteUpdateJSON(bucketName string, jsonKey string, jsonName string) error {
+	if c.twoPhase {
+		staged, err := c.StagePromotion(bucketName, jsonKey, jsonName)
+		if err != nil {
+			return err
+		}
+		if err := c.CommitPromotion(bucketName, staged, jsonName); err != nil {
+			return err
+		}
+	} else if err := c.copyUpdateJSONKey(bucketName, jsonKey, jsonName); err != nil {
+		return err
+	}
+	if !c.verifyCopy {
+		return nil
+	}
+	err := c.verifyUpdateJSONCopy(bucketName, jsonKey, jsonName)
+	if err == nil {
+		return nil
+	}
+	c.logf("Copy of %s doesn't match, copying again: %s", jsonKey, err)
+	if err := c.copyUpdateJSONKey(bucketName, jsonKey, jsonName); err != nil {
+		return err
+	}
+	return c.verifyUpdateJSONCopy(bucketName, jsonKey, jsonName)
+}
+
+func (c *Client) copyUpdateJSONKey(bucketName string, sourceKey string, destKey string) error {
+	c.logf("PutCopying %s to %s\n", sourceKey, destKey)
+	_, err := c.copyObject(&s3.CopyObjectInput{
+		Bucket:       aws.String(bucketName),
+		CopySource:   aws.String(copySource(bucketName, sourceKey)),
+		Key:          aws.String(destKey),
+		CacheControl: aws.String(defaultCacheControl),
+		ACL:          aws.String(c.cannedACL()),
+	})
+	return err
+}
+
+// verifyUpdateJSONCopy checks that the update JSON at destKey decodes to the
+// same update as at sourceKey
+func (c *Client) verifyUpdateJSONCopy(bucketName string, sourceKey string, destKey string) error {
+	source, err := c.updateAt(bucketName, sourceKey)
+	if err != nil {
+		return err
+	}
+	dest, err := c.updateAt(bucketName, destKey)
+	if err != nil {
+		return err
+	}
+	if !source.Equal(dest) {
+		return fmt.Errorf("Update JSON at %s doesn't match %s", destKey, sourceKey)
+	}
+	return nil
+}
+
+// updateAt returns the update JSON at key
+func (c *Client) updateAt(bucketName string, key string) (*Update, error) {
+	resp, err := c.svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error getting %s: %s", key, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	upd, err := DecodeJSON(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid update JSON at %s: %s", key, err)
+	}
+	return upd, nil
+}
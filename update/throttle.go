@@ -0,0 +1,227 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package update
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/keybase/release/bandwidth"
+)
+
+// Throttle limits the S3 requests a Client makes, to avoid S3 throttling us
+// on bulk operations. The zero value is unlimited.
+type Throttle struct {
+	// Concurrency is the maximum number of requests at once (0 for unlimited)
+	Concurrency int
+	// MaxRPS is the maximum requests per second (0 for unlimited)
+	MaxRPS float64
+}
+
+// throttle applies to Clients created by NewClient
+var throttle Throttle
+
+// SetThrottle sets the throttle for Clients created by NewClient after this
+func SetThrottle(t Throttle) {
+	throttle = t
+}
+
+// bandwidthLimiter applies to Clients created by NewClient
+var bandwidthLimiter *bandwidth.Limiter
+
+// SetMaxBandwidth limits the bytes per second that Clients created by
+// NewClient after this upload and download (0 for unlimited)
+func SetMaxBandwidth(bytesPerSecond int64) {
+	bandwidthLimiter = bandwidth.NewLimiter(bytesPerSecond)
+}
+
+// throttledS3 is an S3 service that limits the requests we make with it
+type throttledS3 struct {
+	s3iface.S3API
+	sem     chan struct{}
+	limiter *rateLimiter
+}
+
+func newThrottledS3(svc s3iface.S3API, t Throttle) s3iface.S3API {
+	if t.Concurrency <= 0 && t.MaxRPS <= 0 {
+		return svc
+	}
+	ts := &throttledS3{S3API: svc}
+	if t.Concurrency > 0 {
+		ts.sem = make(chan struct{}, t.Concurrency)
+	}
+	if t.MaxRPS > 0 {
+		ts.limiter = newRateLimiter(t.MaxRPS)
+	}
+	return ts
+}
+
+// acquire waits until a request is allowed, returning a func to call when
+// the request is done, or ctx's error if it's done first
+func (t *throttledS3) acquire(ctx context.Context) (func(), error) {
+	if t.sem != nil {
+		select {
+		case t.sem <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	release := func() {
+		if t.sem != nil {
+			<-t.sem
+		}
+	}
+	if t.limiter != nil {
+		if err := t.limiter.wait(ctx); err != nil {
+			release()
+			return nil, err
+		}
+	}
+	return release, nil
+}
+
+func (t *throttledS3) ListObjects(input *s3.ListObjectsInput) (*s3.ListObjectsOutput, error) {
+	release, err := t.acquire(aws.BackgroundContext())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return t.S3API.ListObjects(input)
+}
+
+func (t *throttledS3) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	release, err := t.acquire(aws.BackgroundContext())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return t.S3API.GetObject(input)
+}
+
+func (t *throttledS3) HeadObject(input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	release, err := t.acquire(aws.BackgroundContext())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return t.S3API.HeadObject(input)
+}
+
+func (t *throttledS3) PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	release, err := t.acquire(aws.BackgroundContext())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return t.S3API.PutObject(input)
+}
+
+func (t *throttledS3) CopyObject(input *s3.CopyObjectInput) (*s3.CopyObjectOutput, error) {
+	release, err := t.acquire(aws.BackgroundContext())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return t.S3API.CopyObject(input)
+}
+
+func (t *throttledS3) DeleteObject(input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	release, err := t.acquire(aws.BackgroundContext())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return t.S3API.DeleteObject(input)
+}
+
+func (t *throttledS3) ListObjectsWithContext(ctx aws.Context, input *s3.ListObjectsInput, opts ...request.Option) (*s3.ListObjectsOutput, error) {
+	release, err := t.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return t.S3API.ListObjectsWithContext(ctx, input, opts...)
+}
+
+func (t *throttledS3) GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	release, err := t.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return t.S3API.GetObjectWithContext(ctx, input, opts...)
+}
+
+func (t *throttledS3) HeadObjectWithContext(ctx aws.Context, input *s3.HeadObjectInput, opts ...request.Option) (*s3.HeadObjectOutput, error) {
+	release, err := t.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return t.S3API.HeadObjectWithContext(ctx, input, opts...)
+}
+
+func (t *throttledS3) PutObjectWithContext(ctx aws.Context, input *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, error) {
+	release, err := t.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return t.S3API.PutObjectWithContext(ctx, input, opts...)
+}
+
+func (t *throttledS3) CopyObjectWithContext(ctx aws.Context, input *s3.CopyObjectInput, opts ...request.Option) (*s3.CopyObjectOutput, error) {
+	release, err := t.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return t.S3API.CopyObjectWithContext(ctx, input, opts...)
+}
+
+func (t *throttledS3) DeleteObjectWithContext(ctx aws.Context, input *s3.DeleteObjectInput, opts ...request.Option) (*s3.DeleteObjectOutput, error) {
+	release, err := t.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return t.S3API.DeleteObjectWithContext(ctx, input, opts...)
+}
+
+// rateLimiter spaces out events to a maximum rate
+type rateLimiter struct {
+	mtx      sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func newRateLimiter(perSecond float64) *rateLimiter {
+	return &rateLimiter{interval: time.Duration(float64(time.Second) / perSecond)}
+}
+
+// wait blocks until the next event is allowed, or returns ctx's error if
+// it's done first
+func (r *rateLimiter) wait(ctx context.Context) error {
+	r.mtx.Lock()
+	now := time.Now()
+	if r.next.Before(now) {
+		r.next = now
+	}
+	delay := r.next.Sub(now)
+	r.next = r.next.Add(r.interval)
+	r.mtx.Unlock()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
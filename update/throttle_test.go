@@ -0,0 +1,94 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package update
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// slowS3 records the most HeadObject requests it had at once
+type slowS3 struct {
+	s3iface.S3API
+	mtx      sync.Mutex
+	inFlight int
+	max      int
+}
+
+func (s *slowS3) HeadObject(input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	s.mtx.Lock()
+	s.inFlight++
+	if s.inFlight > s.max {
+		s.max = s.inFlight
+	}
+	s.mtx.Unlock()
+	time.Sleep(5 * time.Millisecond)
+	s.mtx.Lock()
+	s.inFlight--
+	s.mtx.Unlock()
+	return &s3.HeadObjectOutput{}, nil
+}
+
+func headConcurrently(t *testing.T, svc s3iface.S3API, n int) {
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := svc.HeadObject(&s3.HeadObjectInput{Key: aws.String("key")})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestThrottleConcurrency(t *testing.T) {
+	slow := &slowS3{}
+	headConcurrently(t, newThrottledS3(slow, Throttle{Concurrency: 2}), 10)
+	assert.Equal(t, 2, slow.max)
+
+	// Unlimited by default
+	slow = &slowS3{}
+	svc := newThrottledS3(slow, Throttle{})
+	assert.Equal(t, slow, svc)
+}
+
+func TestThrottleMaxRPS(t *testing.T) {
+	svc := newThrottledS3(&slowS3{}, Throttle{MaxRPS: 100})
+	start := time.Now()
+	headConcurrently(t, svc, 5)
+	// The first request is immediate, then one every 10ms
+	assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+}
+
+func TestThrottleContext(t *testing.T) {
+	// Waiting for a request slot
+	svc := newThrottledS3(&slowS3{}, Throttle{Concurrency: 1}).(*throttledS3)
+	release, err := svc.acquire(context.Background())
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = svc.HeadObjectWithContext(ctx, &s3.HeadObjectInput{Key: aws.String("key")})
+	assert.Equal(t, context.Canceled, err)
+	release()
+
+	// Waiting for the rate limit
+	svc = newThrottledS3(&slowS3{}, Throttle{MaxRPS: 0.001}).(*throttledS3)
+	_, err = svc.HeadObject(&s3.HeadObjectInput{Key: aws.String("key")})
+	require.NoError(t, err)
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = svc.HeadObjectWithContext(ctx, &s3.HeadObjectInput{Key: aws.String("key")})
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
@@ -0,0 +1,59 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package update
+
+import "sort"
+
+// DefaultUnannouncedLimit is the default number of recent releases (per
+// platform) FindUnannounced checks
+const DefaultUnannouncedLimit = 20
+
+// FindUnannounced returns the most recent releases (up to limit per
+// platform, 0 for all) that are in the bucket but were never announced to
+// the API server, so aren't available for smoke testing
+func FindUnannounced(keybaseToken string, bucketName string, platformName string, limit int) ([]Release, error) {
+	client, err := NewClient()
+	if err != nil {
+		return nil, err
+	}
+	return client.FindUnannounced(keybaseToken, bucketName, platformName, limit)
+}
+
+// FindUnannounced returns the recent releases never announced for the Client
+func (c *Client) FindUnannounced(keybaseToken string, bucketName string, platformName string, limit int) ([]Release, error) {
+	platforms, err := Platforms(platformName)
+	if err != nil {
+		return nil, err
+	}
+	// Platforms (like linux deb and rpm) can share a name with the API server
+	announced := map[string]map[string]bool{}
+	unannounced := []Release{}
+	for _, platform := range platforms {
+		if announced[platform.Name] == nil {
+			builds, err := ListAnnouncedBuilds(keybaseToken, platform.Name)
+			if err != nil {
+				return nil, err
+			}
+			announced[platform.Name] = map[string]bool{}
+			for _, build := range builds {
+				announced[platform.Name][build.VersionA] = true
+			}
+		}
+
+		objs, err := c.listAllObjects(bucketName, platform.Prefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, release := range loadReleases(objs, bucketName, platform.Prefix, platform.Suffix, limit) {
+			if release.Version == "" || announced[platform.Name][release.Version] {
+				continue
+			}
+			// Don't report the same version again (for another file type)
+			announced[platform.Name][release.Version] = true
+			unannounced = append(unannounced, release)
+		}
+	}
+	sort.Sort(ByRelease(unannounced))
+	return unannounced, nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package update
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindUnannouncedOnly(t *testing.T) {
+	testKbwebServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "windows", r.URL.Query().Get("platform"))
+		_, _ = w.Write([]byte(`{"status": {"code": 0, "name": "OK"}, "builds": [{"version_a": "1.0.14-20160312013917+cd6f696", "platform": "windows"}]}`))
+	}))
+	svc := newFakeS3()
+	svc.add("windows/Keybase_1.0.14-20160312013917+cd6f696.amd64.msi", "msi")
+	svc.add("windows/Keybase_1.0.15-20160401013917+abcdef0.amd64.msi", "msi")
+	client := newTestClient(svc)
+
+	releases, err := client.FindUnannounced("token", testBucket, PlatformTypeWindows, 0)
+	require.NoError(t, err)
+	require.Len(t, releases, 1)
+	assert.Equal(t, "1.0.15-20160401013917+abcdef0", releases[0].Version)
+	assert.Equal(t, "windows/Keybase_1.0.15-20160401013917+abcdef0.amd64.msi", releases[0].Key)
+}
+
+func TestFindUnannounced(t *testing.T) {
+	found := true
+	testKbwebServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+		assert.Equal(t, "/_/api/1.0/pkg/get_builds.json", r.URL.Path)
+		switch platform := r.URL.Query().Get("platform"); platform {
+		case "darwin":
+			_, _ = w.Write([]byte(`{"status": {"code": 0, "name": "OK"}, "builds": [{"version_a": "1.0.14-20160312013917+cd6f696", "platform": "darwin"}]}`))
+		case "darwin-arm64":
+			_, _ = w.Write([]byte(`{"status": {"code": 0, "name": "OK"}, "builds": []}`))
+		default:
+			t.Errorf("Unexpected platform %q", platform)
+		}
+	}))
+	svc := newFakeS3()
+	svc.add("darwin/Keybase-1.0.13-20160301013917+0123456.dmg", "dmg")
+	svc.add("darwin/Keybase-1.0.14-20160312013917+cd6f696.dmg", "dmg")
+	svc.add("darwin/Keybase-1.0.15-20160401013917+abcdef0.dmg", "dmg")
+	client := newTestClient(svc)
+
+	releases, err := client.FindUnannounced("token", testBucket, PlatformTypeDarwin, 0)
+	require.NoError(t, err)
+	versions := []string{}
+	for _, release := range releases {
+		versions = append(versions, release.Version)
+	}
+	assert.Equal(t, []string{"1.0.15-20160401013917+abcdef0", "1.0.13-20160301013917+0123456"}, versions)
+
+	// Only the most recent
+	releases, err = client.FindUnannounced("token", testBucket, PlatformTypeDarwin, 2)
+	require.NoError(t, err)
+	require.Len(t, releases, 1)
+	assert.Equal(t, "1.0.15-20160401013917+abcdef0", releases[0].Version)
+
+	found = false
+	_, err = client.FindUnannounced("token", testBucket, PlatformTypeDarwin, 0)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not found")
+}
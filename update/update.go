@@ -4,29 +4,99 @@
 package update
 
 import (
+	"bytes"
 	"crypto/sha256"
-	"encoding/hex"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/url"
 	"os"
 	"path"
+	"sort"
 	"strings"
+	"time"
 
 	releaseVersion "github.com/keybase/release/version"
 )
 
-// EncodeJSON returns JSON (as bytes) for an update
-func EncodeJSON(version string, name string, descriptionPath string, props []string, src string, uri fmt.Stringer, signaturePath string) ([]byte, error) {
+const (
+	// DigestSHA256 is the default asset digest algorithm, which the updater
+	// verifies
+	DigestSHA256 = "sha256"
+	// DigestSHA512 adds a SHA-512 digest to the asset, as well as SHA-256
+	DigestSHA512 = "sha512"
+)
+
+// DigestAlgorithms are the valid digest algorithms for EncodeJSON
+var DigestAlgorithms = []string{DigestSHA256, DigestSHA512}
+
+// FullRollout is the rollout percentage for an update to everyone
+const FullRollout = 100
+
+// EncodeOptions are the optional fields for EncodeJSON. The zero value
+// leaves out the asset's OS and arch, derives publishedAt, uses a SHA-256
+// digest and is a full rollout.
+type EncodeOptions struct {
+	// OS and Arch of the asset, if specified
+	OS   string
+	Arch string
+	// PublishedAt, if zero, is derived from the date in the version, or else
+	// the src file modification time
+	PublishedAt time.Time
+	// DigestAlgorithm DigestSHA512 adds a SHA-512 digest to the asset's
+	// SHA-256 one ("" is DigestSHA256)
+	DigestAlgorithm string
+	// RolloutPercent below FullRollout makes it a staged rollout (see
+	// Update.ShouldRollout). Nil is FullRollout.
+	RolloutPercent *int
+}
+
+// EncodeJSON returns JSON (as bytes) for an update, with the optional fields
+// in opts
+func EncodeJSON(version string, name string, descriptionPath string, props []string, src string, uri fmt.Stringer, signaturePath string, opts EncodeOptions) ([]byte, error) {
+	return encodeJSON(version, name, descriptionPath, props, src, uri, signaturePath, opts, nil)
+}
+
+// PlaceholderDigest is the asset digest in update JSON generated by
+// PreviewJSON, which mustn't be published
+const PlaceholderDigest = "placeholder-digest-do-not-publish"
+
+// PreviewJSON returns JSON (as bytes) for an update like EncodeJSON, but with
+// PlaceholderDigest instead of hashing src, for previewing the JSON quickly
+func PreviewJSON(version string, name string, descriptionPath string, props []string, src string, uri fmt.Stringer, signaturePath string, opts EncodeOptions) ([]byte, error) {
+	return encodeJSON(version, name, descriptionPath, props, src, uri, signaturePath, opts, map[string]string{src: PlaceholderDigest})
+}
+
+// encodeJSON returns JSON for an update, using the (SHA-256) digest for src
+// from digests if there is one (see DigestAll). A placeholder digest is used
+// for the SHA-512 digest too.
+func encodeJSON(version string, name string, descriptionPath string, props []string, src string, uri fmt.Stringer, signaturePath string, opts EncodeOptions, digests map[string]string) ([]byte, error) {
+	switch opts.DigestAlgorithm {
+	case "", DigestSHA256, DigestSHA512:
+	default:
+		return nil, fmt.Errorf("Invalid digest algorithm %q, must be one of %s", opts.DigestAlgorithm, strings.Join(DigestAlgorithms, ", "))
+	}
 	upd := Update{
 		Version: version,
 		Name:    name,
 	}
+	if opts.RolloutPercent != nil {
+		rolloutPercent := *opts.RolloutPercent
+		if rolloutPercent < 0 || rolloutPercent > FullRollout {
+			return nil, fmt.Errorf("Invalid rollout percent %d, must be 0-%d", rolloutPercent, FullRollout)
+		}
+		if rolloutPercent < FullRollout {
+			upd.RolloutPercent = &rolloutPercent
+		}
+	}
 
-	// Get published at from version string
+	// Use published at if specified, otherwise get it from version string
 	_, _, date, _, err := releaseVersion.Parse(version)
-	if err == nil {
+	if !opts.PublishedAt.IsZero() {
+		t := ToTime(opts.PublishedAt)
+		upd.PublishedAt = &t
+	} else if err == nil && !date.IsZero() {
 		t := ToTime(date)
 		upd.PublishedAt = &t
 	}
@@ -49,13 +119,25 @@ func EncodeJSON(version string, name string, descriptionPath string, props []str
 		asset := Asset{
 			Name: fileName,
 			URL:  urlString,
+			OS:   opts.OS,
+			Arch: opts.Arch,
 		}
 
-		digest, err := digest(src)
-		if err != nil {
-			return nil, fmt.Errorf("Error creating digest: %s", err)
+		srcDigest, ok := digests[src]
+		if !ok {
+			srcDigest, err = Digest(src, DigestSHA256)
+			if err != nil {
+				return nil, fmt.Errorf("Error creating digest: %s", err)
+			}
+		}
+		asset.Digest = srcDigest
+		if opts.DigestAlgorithm == DigestSHA512 {
+			if srcDigest == PlaceholderDigest {
+				asset.DigestSHA512 = PlaceholderDigest
+			} else if asset.DigestSHA512, err = Digest(src, DigestSHA512); err != nil {
+				return nil, fmt.Errorf("Error creating digest: %s", err)
+			}
 		}
-		asset.Digest = digest
 
 		if signaturePath != "" {
 			sig, err := readFile(signaturePath)
@@ -92,6 +174,56 @@ func EncodeJSON(version string, name string, descriptionPath string, props []str
 	return json.MarshalIndent(upd, "", "  ")
 }
 
+// Canonical returns the update as JSON with sorted keys (and props sorted by
+// name), so updates that only differ in field or prop order or whitespace
+// have the same canonical form
+func (u *Update) Canonical() []byte {
+	if u == nil {
+		return nil
+	}
+	upd := *u
+	if upd.Props != nil {
+		upd.Props = append([]Property{}, u.Props...)
+		sort.SliceStable(upd.Props, func(i, j int) bool { return upd.Props[i].Name < upd.Props[j].Name })
+	}
+	data, err := json.Marshal(upd)
+	if err != nil {
+		return nil
+	}
+	// Unmarshaling into a map and marshaling again sorts the keys
+	var obj map[string]interface{}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil
+	}
+	data, err = json.Marshal(obj)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// Equal returns true if the updates have the same canonical form
+func (u *Update) Equal(other *Update) bool {
+	if u == nil || other == nil {
+		return u == other
+	}
+	return bytes.Equal(u.Canonical(), other.Canonical())
+}
+
+// ShouldRollout returns true if the user with userSeed (some stable ID) should
+// get the update. Each seed hashes to a bucket from 0 to 99, which is in the
+// rollout if it's below the update's RolloutPercent, so the decision for a
+// user is the same every time, and users in a smaller rollout stay in as it
+// grows.
+func (u *Update) ShouldRollout(userSeed string) bool {
+	if u.RolloutPercent == nil {
+		return true
+	}
+	sum := sha256.Sum256([]byte(userSeed))
+	bucket := binary.BigEndian.Uint64(sum[:8]) % FullRollout
+	return bucket < uint64(*u.RolloutPercent)
+}
+
 // DecodeJSON returns an update object from JSON (bytes)
 func DecodeJSON(r io.Reader) (*Update, error) {
 	var obj Update
@@ -113,18 +245,3 @@ func readFile(path string) (string, error) {
 	}
 	return string(data), nil
 }
-
-func digest(p string) (digest string, err error) {
-	hasher := sha256.New()
-	f, err := os.Open(p)
-	if err != nil {
-		return
-	}
-	defer func() { _ = f.Close() }()
-	if _, ioerr := io.Copy(hasher, f); ioerr != nil {
-		err = ioerr
-		return
-	}
-	digest = hex.EncodeToString(hasher.Sum(nil))
-	return
-}
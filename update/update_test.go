@@ -0,0 +1,203 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package update
+
+import (
+	"bytes"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeJSONAssetPlatform(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "Keybase-1.0.15-20160401013917+abcdef0.zip")
+	require.NoError(t, os.WriteFile(src, []byte("zip"), 0644))
+	uri, err := url.Parse("https://prerelease.keybase.io/darwin-arm64-updates")
+	require.NoError(t, err)
+
+	data, err := EncodeJSON("1.0.15-20160401013917+abcdef0", "v1.0.15", "", nil, src, uri, "", EncodeOptions{OS: PlatformTypeDarwin, Arch: ArchArm64})
+	require.NoError(t, err)
+	upd, err := DecodeJSON(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.NotNil(t, upd.Asset)
+	assert.Equal(t, PlatformTypeDarwin, upd.Asset.OS)
+	assert.Equal(t, ArchArm64, upd.Asset.Arch)
+
+	// Without them, the JSON is as before
+	data, err = EncodeJSON("1.0.15-20160401013917+abcdef0", "v1.0.15", "", nil, src, uri, "", EncodeOptions{})
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), `"os"`)
+	assert.NotContains(t, string(data), `"arch"`)
+	upd, err = DecodeJSON(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Empty(t, upd.Asset.OS)
+	assert.Empty(t, upd.Asset.Arch)
+}
+
+func TestEncodeJSONDigestSHA512(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "Keybase-1.0.15-20160401013917+abcdef0.zip")
+	require.NoError(t, os.WriteFile(src, []byte("abc"), 0644))
+	uri, err := url.Parse("https://prerelease.keybase.io/darwin-updates")
+	require.NoError(t, err)
+
+	// SHA-256 only by default
+	data, err := EncodeJSON("1.0.15-20160401013917+abcdef0", "v1.0.15", "", nil, src, uri, "", EncodeOptions{})
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "digestSHA512")
+
+	data, err = EncodeJSON("1.0.15-20160401013917+abcdef0", "v1.0.15", "", nil, src, uri, "", EncodeOptions{DigestAlgorithm: DigestSHA512})
+	require.NoError(t, err)
+	upd, err := DecodeJSON(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad", upd.Asset.Digest)
+	assert.Equal(t, "ddaf35a193617abacc417349ae20413112e6fa4e89a97ea20a9eeee64b55d39a2192992a274fc1a836ba3c23a3feebbd454d4423643ce80e2a9ac94fa54ca49f", upd.Asset.DigestSHA512)
+
+	data, err = PreviewJSON("1.0.15-20160401013917+abcdef0", "v1.0.15", "", nil, src, uri, "", EncodeOptions{DigestAlgorithm: DigestSHA512})
+	require.NoError(t, err)
+	upd, err = DecodeJSON(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, PlaceholderDigest, upd.Asset.DigestSHA512)
+
+	_, err = EncodeJSON("1.0.15-20160401013917+abcdef0", "v1.0.15", "", nil, src, uri, "", EncodeOptions{DigestAlgorithm: "md5"})
+	require.Error(t, err)
+}
+
+func TestEncodeJSONPublishedAt(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "Keybase.zip")
+	require.NoError(t, os.WriteFile(src, []byte("zip"), 0644))
+	modTime := time.Date(2017, time.March, 4, 5, 6, 7, 0, time.UTC)
+	require.NoError(t, os.Chtimes(src, modTime, modTime))
+	uri, err := url.Parse("https://prerelease.keybase.io/darwin-updates")
+	require.NoError(t, err)
+	publishedAt := time.Date(2015, time.June, 7, 8, 9, 10, 0, time.UTC)
+
+	published := func(version string, publishedAt time.Time) time.Time {
+		data, err := EncodeJSON(version, "v"+version, "", nil, src, uri, "", EncodeOptions{PublishedAt: publishedAt})
+		require.NoError(t, err)
+		upd, err := DecodeJSON(bytes.NewReader(data))
+		require.NoError(t, err)
+		require.NotNil(t, upd.PublishedAt)
+		return FromTime(*upd.PublishedAt).UTC()
+	}
+
+	// Derived from the version, or the src modification time
+	assert.Equal(t, time.Date(2016, time.April, 1, 1, 39, 17, 0, time.UTC), published("1.0.15-20160401013917+abcdef0", time.Time{}))
+	assert.Equal(t, modTime, published("1.0.15", time.Time{}))
+
+	// The override wins over both
+	assert.Equal(t, publishedAt, published("1.0.15-20160401013917+abcdef0", publishedAt))
+	assert.Equal(t, publishedAt, published("1.0.15", publishedAt))
+}
+
+func TestPreviewJSON(t *testing.T) {
+	// src doesn't exist, so hashing it would fail
+	src := filepath.Join(t.TempDir(), "Keybase-1.0.15-20160401013917+abcdef0.dmg")
+	uri, err := url.Parse("https://prerelease.keybase.io/darwin")
+	require.NoError(t, err)
+
+	_, err = EncodeJSON("1.0.15-20160401013917+abcdef0", "v1.0.15", "", nil, src, uri, "", EncodeOptions{})
+	require.Error(t, err)
+
+	data, err := PreviewJSON("1.0.15-20160401013917+abcdef0", "v1.0.15", "", nil, src, uri, "", EncodeOptions{})
+	require.NoError(t, err)
+	upd, err := DecodeJSON(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.NotNil(t, upd.Asset)
+	assert.Equal(t, PlaceholderDigest, upd.Asset.Digest)
+	assert.Equal(t, "Keybase-1.0.15-20160401013917+abcdef0.dmg", upd.Asset.Name)
+}
+
+func TestUpdateEqual(t *testing.T) {
+	decode := func(s string) *Update {
+		upd, err := DecodeJSON(strings.NewReader(s))
+		require.NoError(t, err)
+		return upd
+	}
+	a := decode(`{"version": "1.0.15", "name": "v1.0.15", "props": [{"name": "a", "value": "1"}, {"name": "b", "value": "2"}],
+		"asset": {"name": "Keybase.zip", "url": "https://prerelease.keybase.io/Keybase.zip", "digest": "abc", "signature": "sig"}}`)
+	b := decode(`{"asset":{"signature":"sig","digest":"abc","url":"https://prerelease.keybase.io/Keybase.zip","name":"Keybase.zip"},
+		"props":[{"value":"2","name":"b"},{"value":"1","name":"a"}],"name":"v1.0.15","version":"1.0.15"}`)
+	assert.True(t, a.Equal(b))
+	assert.Equal(t, string(a.Canonical()), string(b.Canonical()))
+	assert.Equal(t, "b", b.Props[0].Name, "Canonical shouldn't reorder the props")
+
+	c := decode(`{"version": "1.0.15", "name": "v1.0.15", "props": [{"name": "a", "value": "1"}, {"name": "b", "value": "3"}],
+		"asset": {"name": "Keybase.zip", "url": "https://prerelease.keybase.io/Keybase.zip", "digest": "abc", "signature": "sig"}}`)
+	assert.False(t, a.Equal(c))
+	d := decode(`{"version": "1.0.15", "name": "v1.0.15", "props": [{"name": "a", "value": "1"}, {"name": "b", "value": "2"}]}`)
+	assert.False(t, a.Equal(d))
+
+	var none *Update
+	assert.True(t, none.Equal(nil))
+	assert.False(t, a.Equal(nil))
+	assert.False(t, none.Equal(a))
+}
+
+func TestEncodeJSONRollout(t *testing.T) {
+	encode := func(rolloutPercent int) *Update {
+		data, err := EncodeJSON("1.0.15-20160401013917+abcdef0", "v1.0.15", "", nil, "", nil, "", EncodeOptions{RolloutPercent: &rolloutPercent})
+		require.NoError(t, err)
+		upd, err := DecodeJSON(bytes.NewReader(data))
+		require.NoError(t, err)
+		return upd
+	}
+
+	assert.Nil(t, encode(FullRollout).RolloutPercent)
+	// The zero value is a full rollout
+	data, err := EncodeJSON("1.0.15-20160401013917+abcdef0", "v1.0.15", "", nil, "", nil, "", EncodeOptions{})
+	require.NoError(t, err)
+	upd, err := DecodeJSON(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Nil(t, upd.RolloutPercent)
+	for _, rolloutPercent := range []int{0, 25, 99} {
+		upd := encode(rolloutPercent)
+		require.NotNil(t, upd.RolloutPercent)
+		assert.Equal(t, rolloutPercent, *upd.RolloutPercent)
+	}
+
+	for _, rolloutPercent := range []int{-1, 101} {
+		_, err := EncodeJSON("1.0.15-20160401013917+abcdef0", "v1.0.15", "", nil, "", nil, "", EncodeOptions{RolloutPercent: &rolloutPercent})
+		require.Error(t, err)
+	}
+}
+
+func TestShouldRollout(t *testing.T) {
+	seeds := []string{}
+	for i := 0; i < 1000; i++ {
+		seeds = append(seeds, "user"+strconv.Itoa(i))
+	}
+	rollout := func(rolloutPercent *int) int {
+		upd := &Update{RolloutPercent: rolloutPercent}
+		count := 0
+		for _, seed := range seeds {
+			if upd.ShouldRollout(seed) {
+				count++
+			}
+		}
+		return count
+	}
+	percent := func(p int) *int { return &p }
+
+	assert.Equal(t, len(seeds), rollout(nil))
+	assert.Equal(t, len(seeds), rollout(percent(100)))
+	assert.Equal(t, 0, rollout(percent(0)))
+	assert.InDelta(t, len(seeds)/2, rollout(percent(50)), 100)
+
+	// Deterministic, and users stay in as the rollout grows
+	upd := &Update{RolloutPercent: percent(10)}
+	bigger := &Update{RolloutPercent: percent(20)}
+	for _, seed := range seeds {
+		assert.Equal(t, upd.ShouldRollout(seed), upd.ShouldRollout(seed))
+		if upd.ShouldRollout(seed) {
+			assert.True(t, bigger.ShouldRollout(seed))
+		}
+	}
+}
@@ -25,12 +25,70 @@ func urlString(bucketName string, prefix string, name string) string {
 	return fmt.Sprintf("https://s3.amazonaws.com/%s/%s%s", bucketName, prefix, url.QueryEscape(name))
 }
 
+// BucketURL returns the (https) URL for the location of files at prefix in a
+// bucket, suitable as the URI for EncodeJSON
+func BucketURL(bucketName string, prefix string) (*url.URL, error) {
+	if bucketName == "" {
+		return nil, fmt.Errorf("No bucket name specified")
+	}
+	base := strings.TrimSuffix(urlString(bucketName, prefix, ""), "/")
+	u, err := url.Parse(base)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "https" || u.Host == "" || strings.Contains(u.Path, "//") {
+		return nil, fmt.Errorf("Invalid URL for bucket %q and prefix %q: %s", bucketName, prefix, base)
+	}
+	return u, nil
+}
+
+// keyForURL returns the key in a bucket for a URL, which can be for the bucket
+// as a host (https://prerelease.keybase.io/key) or a path on S3
+// (https://s3.amazonaws.com/prerelease.keybase.io/key)
+func keyForURL(bucketName string, urlString string) (string, error) {
+	u, err := url.Parse(urlString)
+	if err != nil {
+		return "", err
+	}
+	path := strings.TrimPrefix(u.Path, "/")
+	switch {
+	case u.Host == bucketName:
+	case u.Host == "s3.amazonaws.com" && strings.HasPrefix(path, bucketName+"/"):
+		path = strings.TrimPrefix(path, bucketName+"/")
+	default:
+		return "", fmt.Errorf("%s is not in bucket %s", urlString, bucketName)
+	}
+	if path == "" {
+		return "", fmt.Errorf("%s has no key", urlString)
+	}
+	return path, nil
+}
+
+// copySource returns the CopySource for copying an object in a bucket, which
+// is the bucket name and URL encoded key (not an https URL)
+func copySource(bucketName string, key string) string {
+	return bucketName + "/" + escapeKey(key)
+}
+
+// escapeKey URL encodes each path segment of a key
+func escapeKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		// PathEscape allows '+', which S3 would decode as a space
+		segments[i] = strings.ReplaceAll(url.PathEscape(segment), "+", "%2B")
+	}
+	return strings.Join(segments, "/")
+}
+
 func urlStringNoEscape(bucketName string, name string) string {
 	return fmt.Sprintf("https://s3.amazonaws.com/%s/%s", bucketName, name)
 }
 
 func makeParentDirs(filename string) error {
 	dir, _ := filepath.Split(filename)
+	if dir == "" {
+		return nil
+	}
 	exists, err := fileExists(dir)
 	if err != nil {
 		return err
@@ -0,0 +1,185 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package update
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/keybase/release/version"
+)
+
+// ValidateNames checks which object names at prefix in a bucket can be parsed
+// by version.Parse, for checking changes to the parser against existing names.
+func ValidateNames(bucketName string, prefix string) (parsed []string, failed []string, err error) {
+	client, err := NewClient()
+	if err != nil {
+		return nil, nil, err
+	}
+	return client.ValidateNames(bucketName, prefix)
+}
+
+// ValidateNames checks which object names can be parsed for the Client
+func (c *Client) ValidateNames(bucketName string, prefix string) (parsed []string, failed []string, err error) {
+	objs, err := c.listAllObjects(bucketName, prefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	parsed = []string{}
+	failed = []string{}
+	for _, obj := range objs {
+		name := strings.TrimPrefix(*obj.Key, prefix)
+		if name == "" || name == "index.html" {
+			continue
+		}
+		if _, _, _, _, err := version.Parse(name); err != nil {
+			failed = append(failed, name)
+			continue
+		}
+		parsed = append(parsed, name)
+	}
+	return parsed, failed, nil
+}
+
+// FindDuplicateVersions returns the releases at prefix in a bucket (with
+// suffix) for each version that more than one object has, which makes which
+// is latest nondeterministic. Versions are compared without their date, so a
+// re-upload of a version and commit with a new timestamp is a duplicate.
+func FindDuplicateVersions(bucketName string, prefix string, suffix string) (map[string][]Release, error) {
+	client, err := NewClient()
+	if err != nil {
+		return nil, err
+	}
+	return client.FindDuplicateVersions(bucketName, prefix, suffix)
+}
+
+// FindDuplicateVersions returns duplicate versions for the Client
+func (c *Client) FindDuplicateVersions(bucketName string, prefix string, suffix string) (map[string][]Release, error) {
+	objs, err := c.listAllObjects(bucketName, prefix)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[string][]Release{}
+	for _, release := range loadReleases(objs, bucketName, prefix, suffix, 0) {
+		ver := duplicateVersionKey(release)
+		if ver == "" {
+			continue
+		}
+		byVersion[ver] = append(byVersion[ver], release)
+	}
+	duplicates := map[string][]Release{}
+	for ver, releases := range byVersion {
+		if len(releases) > 1 {
+			duplicates[ver] = releases
+		}
+	}
+	return duplicates, nil
+}
+
+// duplicateVersionKey is a release's version without its date, like
+// 1.0.15+abcdef0, or empty if its name doesn't have a version
+func duplicateVersionKey(release Release) string {
+	_, versionShort, _, commit, err := version.Parse(release.Name)
+	if err != nil {
+		return ""
+	}
+	ver := versionShort
+	if commit != "" {
+		ver = fmt.Sprintf("%s+%s", ver, commit)
+	}
+	if release.Build != 0 {
+		ver = fmt.Sprintf("%s.%d", ver, release.Build)
+	}
+	return ver
+}
+
+// PromotionCheck returns an error if a release shouldn't be promoted, like if
+// CI didn't pass for its commit
+type PromotionCheck func(release Release) error
+
+// VerifyReleaseComplete returns the files (from Platform.Files) for a release
+// version that are missing from a bucket, so a release whose upload partially
+// failed isn't promoted.
+func VerifyReleaseComplete(bucketName string, platformName string, version string) ([]string, error) {
+	client, err := NewClient()
+	if err != nil {
+		return nil, err
+	}
+	return client.VerifyReleaseComplete(bucketName, platformName, version)
+}
+
+// VerifyReleaseComplete returns a release's missing files for the Client
+func (c *Client) VerifyReleaseComplete(bucketName string, platformName string, version string) ([]string, error) {
+	platform, err := PlatformNamed(platformName)
+	if err != nil {
+		return nil, err
+	}
+	return c.missingFiles(bucketName, platform, version)
+}
+
+func (c *Client) missingFiles(bucketName string, platform Platform, version string) ([]string, error) {
+	files, err := platform.Files(version)
+	if err != nil {
+		return nil, err
+	}
+	missing := []string{}
+	for _, key := range files {
+		exists, err := c.objectExists(bucketName, key)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			missing = append(missing, key)
+		}
+	}
+	return missing, nil
+}
+
+// checkComplete returns an error if the Client verifies releases and any of
+// the release's files are missing
+func (c *Client) checkComplete(bucketName string, platform Platform, version string) error {
+	if !c.verify {
+		return nil
+	}
+	missing, err := c.missingFiles(bucketName, platform, version)
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("Release %s is incomplete, missing: %s", version, strings.Join(missing, ", "))
+	}
+	c.logf("Verified all files for %s release %s", platform.Name, version)
+	return nil
+}
+
+// objectExists returns true if there's an object at key
+func (c *Client) objectExists(bucketName string, key string) (bool, error) {
+	_, err := c.svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(key),
+	})
+	if isNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("Couldn't check %s: %s", key, err)
+	}
+	return true, nil
+}
+
+// isNotFound returns true if err is from a (Head) request for a key that
+// doesn't exist
+func isNotFound(err error) bool {
+	if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() == http.StatusNotFound {
+		return true
+	}
+	if awsErr, ok := err.(awserr.Error); ok {
+		return awsErr.Code() == "NotFound" || awsErr.Code() == s3.ErrCodeNoSuchKey
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package update
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// verifyTimeout is how long to wait to download an asset when verifying
+const verifyTimeout = 10 * time.Minute
+
+// VerifyOptions are how VerifyUpdate checks an update's asset
+type VerifyOptions struct {
+	// LocalPath is the asset file to check, instead of downloading its URL
+	LocalPath string
+	// PublicKey, if set, is the key the asset's signature must verify with.
+	// Only (base64) ed25519 signatures, as KeySigner makes, can be checked.
+	PublicKey ed25519.PublicKey
+}
+
+// VerifyUpdate checks that an update's asset matches its digest (and SHA-512
+// digest, if it has one), and its signature if there's a public key
+func VerifyUpdate(u *Update, opts VerifyOptions) error {
+	if u == nil || u.Asset == nil {
+		return fmt.Errorf("No asset in update")
+	}
+	asset := u.Asset
+	if asset.Digest == "" {
+		return fmt.Errorf("No digest for asset %s", asset.Name)
+	}
+
+	source := opts.LocalPath
+	var r io.ReadCloser
+	if source != "" {
+		f, err := os.Open(source)
+		if err != nil {
+			return err
+		}
+		r = f
+	} else {
+		source = asset.URL
+		client := &http.Client{Timeout: verifyTimeout}
+		resp, err := client.Get(source)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			_ = resp.Body.Close()
+			return fmt.Errorf("Couldn't download %s: %s", source, resp.Status)
+		}
+		r = resp.Body
+	}
+	defer func() { _ = r.Close() }()
+
+	sha256Hasher, sha512Hasher := sha256.New(), sha512.New()
+	writers := []io.Writer{sha256Hasher, sha512Hasher}
+	// The whole asset is needed to check its signature
+	var data bytes.Buffer
+	if opts.PublicKey != nil {
+		writers = append(writers, &data)
+	}
+	if _, err := io.Copy(io.MultiWriter(writers...), r); err != nil {
+		return fmt.Errorf("Error reading %s: %s", source, err)
+	}
+
+	if assetDigest := hex.EncodeToString(sha256Hasher.Sum(nil)); assetDigest != asset.Digest {
+		return fmt.Errorf("Digest of %s (%s) doesn't match update (%s)", source, assetDigest, asset.Digest)
+	}
+	if asset.DigestSHA512 != "" {
+		if assetDigest := hex.EncodeToString(sha512Hasher.Sum(nil)); assetDigest != asset.DigestSHA512 {
+			return fmt.Errorf("SHA-512 digest of %s (%s) doesn't match update (%s)", source, assetDigest, asset.DigestSHA512)
+		}
+	}
+
+	if opts.PublicKey != nil {
+		if asset.Signature == "" {
+			return fmt.Errorf("No signature for asset %s", asset.Name)
+		}
+		sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(asset.Signature))
+		if err != nil {
+			return fmt.Errorf("Invalid signature for asset %s (not an ed25519 signature?): %s", asset.Name, err)
+		}
+		if !ed25519.Verify(opts.PublicKey, data.Bytes(), sig) {
+			return fmt.Errorf("Signature for asset %s doesn't verify with the public key", asset.Name)
+		}
+	}
+	return nil
+}
+
+// VerifyCurrentUpdate checks the asset of a platform's current update in a
+// channel (its public channel if empty), as VerifyUpdate
+func VerifyCurrentUpdate(bucketName string, platformName string, channel string, env string, opts VerifyOptions) error {
+	client, err := NewClient()
+	if err != nil {
+		return err
+	}
+	return client.VerifyCurrentUpdate(bucketName, platformName, channel, env, opts)
+}
+
+// VerifyCurrentUpdate checks the asset of a current update for the Client
+func (c *Client) VerifyCurrentUpdate(bucketName string, platformName string, channel string, env string, opts VerifyOptions) error {
+	if channel == "" {
+		var found bool
+		if channel, found = publicChannel(platformName); !found {
+			return fmt.Errorf("Invalid platform %s", platformName)
+		}
+	}
+	upd, path, err := c.CurrentUpdate(bucketName, channel, platformName, env)
+	if err != nil {
+		return fmt.Errorf("Error getting current update at %s: %s", path, err)
+	}
+	if err := VerifyUpdate(upd, opts); err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	return nil
+}
+
+// ReadPublicKey loads an ed25519 public key from a PEM (PKIX) file, like one
+// for the private key of a KeySigner
+func ReadPublicKey(path string) (ed25519.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("No PEM data in %s", path)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	edKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("Key in %s is not an ed25519 key", path)
+	}
+	return edKey, nil
+}
@@ -0,0 +1,138 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package update
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testVerifyAsset(t *testing.T, data []byte) (path string, digest string, digestSHA512 string) {
+	path = filepath.Join(t.TempDir(), "Keybase.zip")
+	require.NoError(t, os.WriteFile(path, data, 0600))
+	sum := sha256.Sum256(data)
+	sum512 := sha512.Sum512(data)
+	return path, hex.EncodeToString(sum[:]), hex.EncodeToString(sum512[:])
+}
+
+func TestVerifyUpdate(t *testing.T) {
+	data := []byte("zip data")
+	path, digest, digestSHA512 := testVerifyAsset(t, data)
+	opts := VerifyOptions{LocalPath: path}
+
+	upd := &Update{Asset: &Asset{Name: "Keybase.zip", Digest: digest}}
+	require.NoError(t, VerifyUpdate(upd, opts))
+
+	upd.Asset.DigestSHA512 = digestSHA512
+	require.NoError(t, VerifyUpdate(upd, opts))
+
+	upd.Asset.DigestSHA512 = digest
+	err := VerifyUpdate(upd, opts)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "SHA-512 digest")
+
+	upd.Asset.DigestSHA512 = ""
+	upd.Asset.Digest = digestSHA512[:64]
+	err = VerifyUpdate(upd, opts)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "doesn't match update")
+
+	upd.Asset.Digest = ""
+	require.Error(t, VerifyUpdate(upd, opts))
+	require.Error(t, VerifyUpdate(&Update{}, opts))
+}
+
+func TestVerifyUpdateURL(t *testing.T) {
+	data := []byte("zip data")
+	_, digest, _ := testVerifyAsset(t, data)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/Keybase.zip" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(data)
+	}))
+	defer server.Close()
+
+	upd := &Update{Asset: &Asset{Name: "Keybase.zip", URL: server.URL + "/Keybase.zip", Digest: digest}}
+	require.NoError(t, VerifyUpdate(upd, VerifyOptions{}))
+
+	upd.Asset.URL = server.URL + "/missing.zip"
+	err := VerifyUpdate(upd, VerifyOptions{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "404")
+}
+
+func TestVerifyUpdateSignature(t *testing.T) {
+	data := []byte("zip data")
+	path, digest, _ := testVerifyAsset(t, data)
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	sig, err := KeySigner{Key: priv}.Sign(data)
+	require.NoError(t, err)
+
+	upd := &Update{Asset: &Asset{Name: "Keybase.zip", Digest: digest, Signature: string(sig)}}
+	require.NoError(t, VerifyUpdate(upd, VerifyOptions{LocalPath: path, PublicKey: pub}))
+
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	err = VerifyUpdate(upd, VerifyOptions{LocalPath: path, PublicKey: otherPub})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "doesn't verify")
+
+	// A saltpack signature can't be checked with an ed25519 key
+	upd.Asset.Signature = "BEGIN KEYBASE SALTPACK DETACHED SIGNATURE. kXR7VktZdyH7rvq v5weRa0zkSjiJmm. END KEYBASE SALTPACK DETACHED SIGNATURE."
+	require.Error(t, VerifyUpdate(upd, VerifyOptions{LocalPath: path, PublicKey: pub}))
+
+	upd.Asset.Signature = ""
+	require.Error(t, VerifyUpdate(upd, VerifyOptions{LocalPath: path, PublicKey: pub}))
+	// No public key, so the signature isn't needed
+	require.NoError(t, VerifyUpdate(upd, VerifyOptions{LocalPath: path}))
+}
+
+func TestVerifyCurrentUpdate(t *testing.T) {
+	data := []byte("zip data")
+	path, digest, _ := testVerifyAsset(t, data)
+	svc := newFakeS3()
+	svc.add("update-darwin-prod-v2.json", fmt.Sprintf(`{"version": "1.0.15", "asset": {"name": "Keybase.zip", "digest": %q}}`, digest))
+	client := newTestClient(svc)
+
+	require.NoError(t, client.VerifyCurrentUpdate(testBucket, PlatformTypeDarwin, "", EnvProd, VerifyOptions{LocalPath: path}))
+
+	err := client.VerifyCurrentUpdate(testBucket, PlatformTypeDarwin, "test-v2", EnvProd, VerifyOptions{LocalPath: path})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "update-darwin-prod-test-v2.json")
+
+	require.Error(t, client.VerifyCurrentUpdate(testBucket, "plan9", "", EnvProd, VerifyOptions{LocalPath: path}))
+}
+
+func TestReadPublicKey(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "key.pub")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600))
+
+	key, err := ReadPublicKey(path)
+	require.NoError(t, err)
+	assert.Equal(t, pub, key)
+
+	require.NoError(t, os.WriteFile(path, []byte("not a key"), 0600))
+	_, err = ReadPublicKey(path)
+	require.Error(t, err)
+}
@@ -0,0 +1,121 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package update
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// warmTimeout is how long to wait to download an asset when warming
+const warmTimeout = 5 * time.Minute
+
+// PromotedAssetURLs returns the asset URLs from the update JSON of the public
+// channel of each platform (comma-separated), or all platforms if empty
+func PromotedAssetURLs(bucketName string, platformNames string) ([]string, error) {
+	client, err := NewClient()
+	if err != nil {
+		return nil, err
+	}
+	return client.PromotedAssetURLs(bucketName, platformNames)
+}
+
+// PromotedAssetURLs returns the promoted asset URLs for the Client
+func (c *Client) PromotedAssetURLs(bucketName string, platformNames string) ([]string, error) {
+	valid := map[string]bool{}
+	for _, pc := range publicChannels {
+		valid[pc.platform] = true
+	}
+	names := map[string]bool{}
+	if platformNames != "" {
+		for _, name := range strings.Split(platformNames, ",") {
+			if !valid[name] {
+				return nil, fmt.Errorf("Invalid platform %s", name)
+			}
+			names[name] = true
+		}
+	}
+	urls := []string{}
+	for _, pc := range publicChannels {
+		if len(names) > 0 && !names[pc.platform] {
+			continue
+		}
+		upd, path, err := c.CurrentUpdate(bucketName, pc.channel, pc.platform, "prod")
+		if err != nil {
+			return nil, err
+		}
+		if upd == nil || upd.Asset == nil {
+			return nil, fmt.Errorf("No asset in update JSON at %s", path)
+		}
+		urls = append(urls, upd.Asset.URL)
+	}
+	return urls, nil
+}
+
+// WarmCDN downloads each URL (discarding it) so the CDN caches it before
+// users ask for it. Regions are DNS resolvers (host or host:port) to resolve
+// with, so each resolver's nearest edge is warmed; without any, the default
+// resolver is used. It's best effort: every URL is attempted, its status
+// logged, and any errors are combined.
+func WarmCDN(urls []string, regions ...string) error {
+	clients := map[string]*http.Client{}
+	if len(regions) == 0 {
+		clients["default"] = &http.Client{Timeout: warmTimeout}
+	}
+	for _, region := range regions {
+		clients[region] = &http.Client{Timeout: warmTimeout, Transport: transportForResolver(region)}
+	}
+	errs := []error{}
+	for _, u := range urls {
+		for region, client := range clients {
+			if err := warmURL(client, u); err != nil {
+				log.Printf("Error warming %s (%s): %s", u, region, err)
+				errs = append(errs, fmt.Errorf("%s (%s): %s", u, region, err))
+				continue
+			}
+			log.Printf("Warmed %s (%s)", u, region)
+		}
+	}
+	return CombineErrors(errs...)
+}
+
+func warmURL(client *http.Client, u string) error {
+	resp, err := client.Get(u)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Server returned %s", resp.Status)
+	}
+	_, err = io.Copy(io.Discard, resp.Body)
+	return err
+}
+
+// transportForResolver returns a transport that resolves hosts with a DNS
+// server (host or host:port)
+func transportForResolver(resolver string) *http.Transport {
+	if _, _, err := net.SplitHostPort(resolver); err != nil {
+		resolver = net.JoinHostPort(resolver, "53")
+	}
+	dialer := &net.Dialer{
+		Timeout: 30 * time.Second,
+		Resolver: &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network string, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, resolver)
+			},
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	return transport
+}
@@ -0,0 +1,51 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package update
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWarmCDN(t *testing.T) {
+	var mtx sync.Mutex
+	requested := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		requested = append(requested, r.URL.Path)
+		mtx.Unlock()
+		if r.URL.Path == "/missing.zip" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte("asset"))
+	}))
+	defer server.Close()
+
+	err := WarmCDN([]string{server.URL + "/missing.zip", server.URL + "/Keybase.zip"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "/missing.zip")
+	assert.Equal(t, []string{"/missing.zip", "/Keybase.zip"}, requested)
+}
+
+func TestPromotedAssetURLs(t *testing.T) {
+	svc := newFakeS3()
+	svc.add("update-darwin-prod-v2.json", testUpdateJSON("1.0.15"))
+	svc.add("update-windows-prod-v2.json", `{"version": "1.0.15", "asset": {"url": "https://test.keybase.io/windows/Keybase_1.0.15.msi"}}`)
+	client := newTestClient(svc)
+
+	urls, err := client.PromotedAssetURLs(testBucket, "darwin,windows")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"https://test.keybase.io/darwin-updates/Keybase-1.0.15.zip", "https://test.keybase.io/windows/Keybase_1.0.15.msi"}, urls)
+
+	_, err = client.PromotedAssetURLs(testBucket, "linux")
+	require.Error(t, err)
+	_, err = client.PromotedAssetURLs(testBucket, "solaris")
+	require.Error(t, err)
+}
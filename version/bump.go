@@ -0,0 +1,73 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package version
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/blang/semver"
+)
+
+// Bump types for Bump
+const (
+	BumpMajor      = "major"
+	BumpMinor      = "minor"
+	BumpPatch      = "patch"
+	BumpPrerelease = "prerelease"
+	BumpBuild      = "build"
+)
+
+// BumpTypes are the valid bump types
+var BumpTypes = []string{BumpMajor, BumpMinor, BumpPatch, BumpPrerelease, BumpBuild}
+
+// Bump returns the next version after a (semantic) version for a bump type.
+// Bumping major, minor or patch drops any prerelease and build. Bumping
+// prerelease increments a numeric prerelease, or if there isn't one, rolls
+// over to the next patch with prerelease 0 (1.2.3 to 1.2.4-0). Bumping build
+// increments a numeric build, or adds build 1, keeping the prerelease.
+// Versions with multiple prerelease (or build) identifiers are rejected for
+// those bumps, since it's ambiguous which to increment.
+func Bump(version string, bump string) (string, error) {
+	v, err := semver.Make(version)
+	if err != nil {
+		return "", fmt.Errorf("Invalid version %s: %s", version, err)
+	}
+	switch bump {
+	case BumpMajor:
+		v = semver.Version{Major: v.Major + 1}
+	case BumpMinor:
+		v = semver.Version{Major: v.Major, Minor: v.Minor + 1}
+	case BumpPatch:
+		v = semver.Version{Major: v.Major, Minor: v.Minor, Patch: v.Patch + 1}
+	case BumpPrerelease:
+		switch {
+		case len(v.Pre) == 0:
+			v = semver.Version{Major: v.Major, Minor: v.Minor, Patch: v.Patch + 1, Pre: []semver.PRVersion{{VersionNum: 0, IsNum: true}}}
+		case len(v.Pre) > 1:
+			return "", fmt.Errorf("Multiple prerelease identifiers in %s", version)
+		case !v.Pre[0].IsNum:
+			return "", fmt.Errorf("Prerelease isn't numeric in %s", version)
+		default:
+			v.Pre = []semver.PRVersion{{VersionNum: v.Pre[0].VersionNum + 1, IsNum: true}}
+			v.Build = nil
+		}
+	case BumpBuild:
+		switch len(v.Build) {
+		case 0:
+			v.Build = []string{"1"}
+		case 1:
+			build, err := strconv.ParseUint(v.Build[0], 10, 64)
+			if err != nil {
+				return "", fmt.Errorf("Build isn't numeric in %s", version)
+			}
+			v.Build = []string{strconv.FormatUint(build+1, 10)}
+		default:
+			return "", fmt.Errorf("Multiple build identifiers in %s", version)
+		}
+	default:
+		return "", fmt.Errorf("Invalid bump %s", bump)
+	}
+	return v.String(), nil
+}
@@ -0,0 +1,57 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package version
+
+import "testing"
+
+func TestBump(t *testing.T) {
+	cases := []struct {
+		version  string
+		bump     string
+		expected string
+	}{
+		{"1.2.3", BumpMajor, "2.0.0"},
+		{"1.2.3-4+5", BumpMajor, "2.0.0"},
+		{"1.2.3", BumpMinor, "1.3.0"},
+		{"1.2.3-4+5", BumpMinor, "1.3.0"},
+		{"1.2.3", BumpPatch, "1.2.4"},
+		{"1.2.3-4+5", BumpPatch, "1.2.4"},
+		// Rolls over to the next patch
+		{"1.2.3", BumpPrerelease, "1.2.4-0"},
+		{"1.2.3+5", BumpPrerelease, "1.2.4-0"},
+		{"1.2.4-0", BumpPrerelease, "1.2.4-1"},
+		{"1.2.4-9+5", BumpPrerelease, "1.2.4-10"},
+		{"1.2.3", BumpBuild, "1.2.3+1"},
+		{"1.2.3-4+9", BumpBuild, "1.2.3-4+10"},
+	}
+	for _, c := range cases {
+		next, err := Bump(c.version, c.bump)
+		if err != nil {
+			t.Errorf("Bump(%s, %s): %s", c.version, c.bump, err)
+			continue
+		}
+		if next != c.expected {
+			t.Errorf("Bump(%s, %s) = %s, expected %s", c.version, c.bump, next, c.expected)
+		}
+	}
+}
+
+func TestBumpInvalid(t *testing.T) {
+	cases := []struct {
+		version string
+		bump    string
+	}{
+		{"1.2", BumpPatch},
+		{"1.2.3", "huge"},
+		{"1.2.3-rc.1", BumpPrerelease},
+		{"1.2.3-rc", BumpPrerelease},
+		{"1.2.3+abc", BumpBuild},
+		{"1.2.3-20160312013917+cd6f696.12345", BumpBuild},
+	}
+	for _, c := range cases {
+		if next, err := Bump(c.version, c.bump); err == nil {
+			t.Errorf("Bump(%s, %s) = %s, expected error", c.version, c.bump, next)
+		}
+	}
+}
@@ -6,21 +6,101 @@ package version
 import (
 	"fmt"
 	"regexp"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/blang/semver"
 )
 
-// Parse parses version, time and commit info from string
+// versionRegex matches major.minor.patch with an optional fourth (build)
+// component, like 6.1.0.12345, then the date and commit
+var versionRegex = regexp.MustCompile(`(\d+\.\d+\.\d+)(?:\.(\d+))?[-.](\d+)([+.])([[:alnum:]]+)`)
+
+// notCommits are the file extensions and arches that can follow a version
+// after a ., which aren't its commit (like in Keybase-1.2.3-20240101000000.dmg)
+var notCommits = map[string]bool{
+	"amd64": true, "arm64": true, "i386": true, "x86": true,
+	"deb": true, "dmg": true, "exe": true, "json": true, "msi": true, "rpm": true, "sig": true, "zip": true,
+}
+
+// shortVersionRegex matches a version without a commit: major.minor.patch
+// with an optional fourth (build) component and an optional -date
+var shortVersionRegex = regexp.MustCompile(`(\d+\.\d+\.\d+)(?:\.(\d+))?(?:-(\d+))?`)
+
+// dateFormat is the format of the date in a version
+const dateFormat = "20060102150405"
+
+// match returns the version, build, date and commit in name. Only the version
+// is required; the others are empty if they're not in name.
+func match(name string) (versionShort string, build string, date string, commit string, ok bool) {
+	if parts := versionRegex.FindStringSubmatch(name); parts != nil && !(parts[4] == "." && notCommits[strings.ToLower(parts[5])]) {
+		return parts[1], parts[2], parts[3], parts[5], true
+	}
+	if parts := shortVersionRegex.FindStringSubmatch(name); parts != nil {
+		build, date := parts[2], parts[3]
+		// A date after a . (like 1.2.3.20240101000000) isn't a build
+		if date == "" && len(build) == len(dateFormat) {
+			build, date = "", build
+		}
+		return parts[1], build, date, "", true
+	}
+	return "", "", "", "", false
+}
+
+// Parse parses version, time and commit info from string. If there's a
+// fourth (build) component, it's included in version as build metadata. The
+// date and commit are optional, like in 1.2.3 or 1.2.3-20240101000000, in
+// which case t is zero and commit is empty.
 func Parse(name string) (version string, versionShort string, t time.Time, commit string, err error) {
-	versionRegex := regexp.MustCompile(`(\d+\.\d+\.\d+)[-.](\d+)[+.]([[:alnum:]]+)`)
-	parts := versionRegex.FindAllStringSubmatch(name, -1)
-	if len(parts) == 0 || len(parts[0]) < 4 {
+	versionShort, build, date, commit, ok := match(name)
+	if !ok {
 		err = fmt.Errorf("Unable to parse: %s", name)
 		return
 	}
-	versionShort = parts[0][1]
-	date := parts[0][2]
-	commit = parts[0][3]
-	version = fmt.Sprintf("%s-%s+%s", versionShort, date, commit)
-	t, _ = time.Parse("20060102150405", date)
+	version = versionShort
+	if date != "" {
+		version = fmt.Sprintf("%s-%s", version, date)
+	}
+	switch {
+	case commit != "" && build != "":
+		version = fmt.Sprintf("%s+%s.%s", version, commit, build)
+	case commit != "":
+		version = fmt.Sprintf("%s+%s", version, commit)
+	case build != "":
+		version = fmt.Sprintf("%s+%s", version, build)
+	}
+	if date != "" {
+		t, _ = time.Parse(dateFormat, date)
+	}
 	return
 }
+
+// ParseBuild returns the fourth (build) component of a version in name, like
+// 12345 in 6.1.0.12345, or 0 if there isn't one
+func ParseBuild(name string) int {
+	_, buildString, _, _, ok := match(name)
+	if !ok || buildString == "" {
+		return 0
+	}
+	build, err := strconv.Atoi(buildString)
+	if err != nil {
+		return 0
+	}
+	return build
+}
+
+// Compare returns -1, 0 or 1 if (semantic) version a is less than, equal to
+// or greater than b. Prereleases are less than their release (1.2.3-4 <
+// 1.2.3) and build metadata is ignored, as semver defines.
+func Compare(a string, b string) (int, error) {
+	va, err := semver.Make(a)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid version %s: %s", a, err)
+	}
+	vb, err := semver.Make(b)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid version %s: %s", b, err)
+	}
+	return va.Compare(vb), nil
+}
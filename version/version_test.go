@@ -28,3 +28,139 @@ func TestParse(t *testing.T) {
 		t.Errorf("Failed to parse commit properly: %s", commit)
 	}
 }
+
+func TestParseFullAndShort(t *testing.T) {
+	version, versionShort, _, commit, err := Parse("1.2.3-400+abcdef")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != "1.2.3-400+abcdef" {
+		t.Errorf("Failed to parse version properly: %s", version)
+	}
+	if versionShort != "1.2.3" {
+		t.Errorf("Failed to parse short version properly: %s", versionShort)
+	}
+	if commit != "abcdef" {
+		t.Errorf("Failed to parse commit properly: %s", commit)
+	}
+}
+
+func TestParseFourPart(t *testing.T) {
+	input := "Keybase_6.1.0.12345-20230312013917+cd6f696.amd64.msi"
+	version, versionShort, versionTime, commit, err := Parse(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != "6.1.0-20230312013917+cd6f696.12345" {
+		t.Errorf("Failed to parse version properly: %s", version)
+	}
+	if versionShort != "6.1.0" {
+		t.Errorf("Failed to parse version properly: %s", versionShort)
+	}
+	timeCheck, _ := time.Parse("20060102150405", "20230312013917")
+	if versionTime != timeCheck {
+		t.Errorf("Failed to parse time properly: %s", versionTime)
+	}
+	if commit != "cd6f696" {
+		t.Errorf("Failed to parse commit properly: %s", commit)
+	}
+	if build := ParseBuild(input); build != 12345 {
+		t.Errorf("Failed to parse build properly: %d", build)
+	}
+}
+
+func TestParseThreePart(t *testing.T) {
+	for _, input := range []string{
+		"Keybase-1.0.14-20160312013917+cd6f696.zip",
+		"keybase_1.0.14.20160312013917.cd6f696_amd64.deb",
+	} {
+		version, _, _, _, err := Parse(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version != "1.0.14-20160312013917+cd6f696" {
+			t.Errorf("Failed to parse version properly for %s: %s", input, version)
+		}
+		if build := ParseBuild(input); build != 0 {
+			t.Errorf("Unexpected build for %s: %d", input, build)
+		}
+	}
+}
+
+func TestParseOptionalDateAndCommit(t *testing.T) {
+	date, _ := time.Parse("20060102150405", "20240101000000")
+	cases := []struct {
+		name         string
+		version      string
+		versionShort string
+		date         time.Time
+		commit       string
+		build        int
+	}{
+		{"Keybase-1.2.3.dmg", "1.2.3", "1.2.3", time.Time{}, "", 0},
+		{"Keybase-1.2.3-20240101000000.dmg", "1.2.3-20240101000000", "1.2.3", date, "", 0},
+		{"Keybase-1.2.3-20240101000000+cd6f696.dmg", "1.2.3-20240101000000+cd6f696", "1.2.3", date, "cd6f696", 0},
+		{"keybase_1.2.3.20240101000000.cd6f696_amd64.deb", "1.2.3-20240101000000+cd6f696", "1.2.3", date, "cd6f696", 0},
+		// A short commit after a .
+		{"keybase-1.2.3.20240101000000.cd6f69.dmg", "1.2.3-20240101000000+cd6f69", "1.2.3", date, "cd6f69", 0},
+		{"keybase-1.0.14.20160312013917.cd6f69.dmg", "1.0.14-20160312013917+cd6f69", "1.0.14", time.Date(2016, time.March, 12, 1, 39, 17, 0, time.UTC), "cd6f69", 0},
+		{"keybase-1.2.3.20240101000000.x86_64.rpm", "1.2.3-20240101000000", "1.2.3", date, "", 0},
+		{"Keybase_6.1.0.12345.amd64.msi", "6.1.0+12345", "6.1.0", time.Time{}, "", 12345},
+		{"Keybase_6.1.0.12345-20240101000000.amd64.msi", "6.1.0-20240101000000+12345", "6.1.0", date, "", 12345},
+	}
+	for _, c := range cases {
+		version, versionShort, versionTime, commit, err := Parse(c.name)
+		if err != nil {
+			t.Errorf("Parse(%s): %s", c.name, err)
+			continue
+		}
+		if version != c.version || versionShort != c.versionShort || !versionTime.Equal(c.date) || commit != c.commit {
+			t.Errorf("Parse(%s) = %s, %s, %s, %s; expected %s, %s, %s, %s", c.name,
+				version, versionShort, versionTime, commit, c.version, c.versionShort, c.date, c.commit)
+		}
+		if build := ParseBuild(c.name); build != c.build {
+			t.Errorf("ParseBuild(%s) = %d, expected %d", c.name, build, c.build)
+		}
+	}
+
+	if _, _, _, _, err := Parse("Keybase.dmg"); err == nil {
+		t.Errorf("Expected error for name without a version")
+	}
+}
+
+func TestCompare(t *testing.T) {
+	cases := []struct {
+		a        string
+		b        string
+		expected int
+	}{
+		{"1.2.3", "1.2.3", 0},
+		{"1.2.4", "1.2.3", 1},
+		{"1.2.3", "1.10.0", -1},
+		{"2.0.0", "1.99.99", 1},
+		// Prereleases are before their release
+		{"1.2.3-4", "1.2.3", -1},
+		{"1.2.3", "1.2.3-4", 1},
+		{"1.0.15-20160401013917+abcdef0", "1.0.15-20160312013917+cd6f696", 1},
+		{"1.2.3-alpha", "1.2.3-1", 1},
+		// Build metadata is ignored
+		{"1.2.3+abc", "1.2.3+def", 0},
+	}
+	for _, c := range cases {
+		result, err := Compare(c.a, c.b)
+		if err != nil {
+			t.Errorf("Compare(%s, %s): %s", c.a, c.b, err)
+			continue
+		}
+		if result != c.expected {
+			t.Errorf("Compare(%s, %s) = %d, expected %d", c.a, c.b, result, c.expected)
+		}
+	}
+
+	if _, err := Compare("1.2", "1.2.3"); err == nil {
+		t.Errorf("Expected error for invalid version")
+	}
+	if _, err := Compare("1.2.3", "v1.2.3"); err == nil {
+		t.Errorf("Expected error for invalid version")
+	}
+}
@@ -7,11 +7,10 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 )
 
-const (
-	buildNumAPIUrl = "https://keybase.io/_/api/1.0/pkg/build_number.json"
-)
+var buildNumAPIUrl = "https://keybase.io/_/api/1.0/pkg/build_number.json"
 
 type buildNumberResponse struct {
 	Status struct {
@@ -21,39 +20,55 @@ type buildNumberResponse struct {
 	BuildNumber int `json:"build_number"`
 }
 
-func GetNextBuildNumber(keybaseToken string, version string, botId string, platform string) error {
+// GetNextBuildNumber retrieves and increments the build number for a version,
+// bot and platform, and prints it
+func GetNextBuildNumber(keybaseToken string, version string, botID int, platformID int) error {
+	buildNumber, err := nextBuildNumber(keybaseToken, version, botID, platformID)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d\n", buildNumber)
+	return nil
+}
+
+func nextBuildNumber(keybaseToken string, version string, botID int, platformID int) (int, error) {
+	if botID <= 0 {
+		return 0, fmt.Errorf("invalid bot ID %d, must be positive", botID)
+	}
+	if platformID <= 0 {
+		return 0, fmt.Errorf("invalid platform ID %d, must be positive", platformID)
+	}
 
 	form := url.Values{}
 	form.Set("version", version)
-	form.Add("bot_id", botId)
-	form.Add("platform", platform)
+	form.Add("bot_id", strconv.Itoa(botID))
+	form.Add("platform", strconv.Itoa(platformID))
 	req, err := http.NewRequest("POST", buildNumAPIUrl, bytes.NewBufferString(form.Encode()))
 	if err != nil {
-		return fmt.Errorf("newrequest failed, %v", err)
+		return 0, fmt.Errorf("newrequest failed, %v", err)
 	}
 	req.Header.Add("X-keybase-admin-token", keybaseToken)
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("request failed, %v", err)
+		return 0, fmt.Errorf("request failed, %v", err)
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("body err, %v", err)
+		return 0, fmt.Errorf("body err, %v", err)
 	}
 
 	var reply buildNumberResponse
 	if err := json.Unmarshal(body, &reply); err != nil {
-		return fmt.Errorf("json reply err, %v", err)
+		return 0, fmt.Errorf("json reply err, %v", err)
 	}
 
 	if reply.Status.Code != 0 {
-		return fmt.Errorf("Server returned failure, %s", body)
+		return 0, fmt.Errorf("Server returned failure, %s", body)
 	}
 
-	fmt.Printf("%d\n", reply.BuildNumber)
-	return nil
+	return reply.BuildNumber, nil
 }
@@ -0,0 +1,36 @@
+package winbuild
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNextBuildNumber(t *testing.T) {
+	var form url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "token", r.Header.Get("X-keybase-admin-token"))
+		require.NoError(t, r.ParseForm())
+		form = r.PostForm
+		_, _ = w.Write([]byte(`{"status": {"code": 0, "name": "OK"}, "build_number": 42}`))
+	}))
+	defer server.Close()
+	defer func(u string) { buildNumAPIUrl = u }(buildNumAPIUrl)
+	buildNumAPIUrl = server.URL
+
+	buildNumber, err := nextBuildNumber("token", "1.0.30", 3, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 42, buildNumber)
+	assert.Equal(t, url.Values{"version": {"1.0.30"}, "bot_id": {"3"}, "platform": {"2"}}, form)
+
+	form = nil
+	for _, ids := range [][2]int{{0, 1}, {1, 0}, {-1, 1}} {
+		_, err := nextBuildNumber("token", "1.0.30", ids[0], ids[1])
+		require.Error(t, err)
+	}
+	assert.Nil(t, form)
+}